@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const adminPath = "/api/v1/admin"
+
+type RecordProblem struct {
+	Offset int64  `json:"offset"`
+	Line   int    `json:"line,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type FileReport struct {
+	File        string          `json:"file"`
+	Records     int             `json:"records"`
+	Header      string          `json:"header,omitempty"`
+	Problems    []RecordProblem `json:"problems,omitempty"`
+	Quarantined int             `json:"quarantined,omitempty"`
+}
+
+type FsckResult struct {
+	Files   []FileReport `json:"files"`
+	Corrupt int          `json:"corrupt"`
+}
+
+// Stats returns the runtime statistics of the server storage layer as decoded JSON.
+func (c *Client) Stats(ctx context.Context) (map[string]any, error) {
+	var stats map[string]any
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: adminPath + "/stats"}, &stats)
+	return stats, err
+}
+
+// Fsck verifies every node file of the server, moving corrupt records into
+// quarantine files when quarantine is set.
+func (c *Client) Fsck(ctx context.Context, quarantine bool) (*FsckResult, error) {
+	result := &FsckResult{}
+	query := url.Values{"quarantine": {strconv.FormatBool(quarantine)}}
+	if _, err := c.do(ctx, &request{method: http.MethodPost, path: adminPath + "/fsck", query: query}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Backup streams a tar.gz archive of a consistent snapshot of the server into w.
+// It is not retried, as part of the archive may already be written.
+func (c *Client) Backup(ctx context.Context, w io.Writer) (int64, error) {
+	httpReq, err := c.newHTTPRequest(ctx, &request{method: http.MethodPost, path: adminPath + "/backup"}, nil, "")
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return 0, responseError(resp.StatusCode, data)
+	}
+	return io.Copy(w, resp.Body)
+}
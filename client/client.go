@@ -0,0 +1,258 @@
+// Package client talks to a jamesdb server over its HTTP API. Mutating requests carry
+// an idempotency key that is kept across retries, so a retried write is applied once.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/uuid"
+)
+
+type Node = graph.Node
+
+// AnyVersion skips the version check of updates and deletes.
+const AnyVersion int64 = 0
+
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+
+	idempotencyKeyHeader = "Idempotency-Key"
+	nextPageTokenHeader  = "Next-Page-Token"
+)
+
+var (
+	ErrInvalidRequest  = errors.New("invalid request")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrVersionMismatch = errors.New("node version does not match")
+	ErrUnknownEdge     = errors.New("edge references an unknown node")
+	ErrUnavailable     = errors.New("server is unavailable")
+)
+
+// Error is a response of the server with an error status. It matches the sentinel
+// error of its status with errors.Is.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jamesdb: %d %s", e.Status, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch e.Status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return target == ErrInvalidRequest
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusPreconditionFailed:
+		return target == ErrVersionMismatch
+	case http.StatusUnprocessableEntity:
+		return target == ErrUnknownEdge
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable
+	}
+	return false
+}
+
+// Options configure a client. The zero value uses http.DefaultClient and retries
+// failed requests DefaultMaxRetries times.
+type Options struct {
+	HTTPClient *http.Client
+	// MaxRetries is the number of retries of a request after a network error or an
+	// unavailable server; a negative value disables retries.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled for every retry after it.
+	RetryBackoff time.Duration
+	// Token is sent as a bearer token, for servers behind an authenticating proxy.
+	Token string
+}
+
+type Client struct {
+	baseURL      string
+	graphPath    string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+	token        string
+}
+
+// New creates a client of the server at baseURL, such as http://localhost:8080.
+func New(baseURL string, opts *Options) *Client {
+	c := &Client{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		graphPath:    "/api/v1/graph",
+		httpClient:   http.DefaultClient,
+		maxRetries:   DefaultMaxRetries,
+		retryBackoff: DefaultRetryBackoff,
+	}
+	if opts == nil {
+		return c
+	}
+	if opts.HTTPClient != nil {
+		c.httpClient = opts.HTTPClient
+	}
+	if opts.MaxRetries != 0 {
+		c.maxRetries = max(opts.MaxRetries, 0)
+	}
+	if opts.RetryBackoff > 0 {
+		c.retryBackoff = opts.RetryBackoff
+	}
+	c.token = opts.Token
+	return c
+}
+
+// Database returns a client of the graph of a named database.
+func (c *Client) Database(name string) *Client {
+	db := *c
+	db.graphPath = "/api/v1/db/" + url.PathEscape(name) + "/graph"
+	return &db
+}
+
+type request struct {
+	method  string
+	path    string
+	query   url.Values
+	body    any
+	ifMatch int64
+}
+
+// do sends the request, retrying it after network errors and unavailable servers,
+// and decodes the response body into out when out is not nil.
+func (c *Client) do(ctx context.Context, req *request, out any) (http.Header, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	var key string
+	if req.method != http.MethodGet {
+		var err error
+		if key, err = uuid.GenerateUUID(); err != nil {
+			return nil, err
+		}
+	}
+
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		header, err := c.send(ctx, req, body, key, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(err) {
+			return header, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, req *request, body []byte, key string, out any) (http.Header, error) {
+	httpReq, err := c.newHTTPRequest(ctx, req, body, key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.Header, responseError(resp.StatusCode, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return resp.Header, nil
+}
+
+func (c *Client) newHTTPRequest(ctx context.Context, req *request, body []byte, key string) (*http.Request, error) {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		httpReq.Header.Set(idempotencyKeyHeader, key)
+	}
+	if req.ifMatch != AnyVersion {
+		httpReq.Header.Set("If-Match", strconv.Quote(strconv.FormatInt(req.ifMatch, 10)))
+	}
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return httpReq, nil
+}
+
+func responseError(status int, data []byte) error {
+	var body struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	message := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &body) == nil {
+		message = body.Error
+		if message == "" {
+			message = body.Message
+		}
+	}
+	return &Error{Status: status, Message: message}
+}
+
+// retryable reports whether a request can succeed when sent again. Requests canceled
+// by their context are not retried.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var respErr *Error
+	if errors.As(err, &respErr) {
+		switch respErr.Status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// Transport errors, such as a refused or dropped connection.
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
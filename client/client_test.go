@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/internal/database"
+	"github.com/zmjung/jamesdb/internal/handler"
+	"github.com/zmjung/jamesdb/internal/idempotency"
+	"github.com/zmjung/jamesdb/internal/instance"
+	"github.com/zmjung/jamesdb/internal/router"
+)
+
+// newTestServer serves the real routes over a database in a temporary folder.
+func newTestServer(t *testing.T) (http.Handler, *database.Manager) {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+	cfg.Server.IdempotencyWindow = time.Hour
+
+	db, err := instance.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := idempotency.NewStore(db.Handles, cfg.Database.RootPath, cfg.Server.IdempotencyWindow, db.Keys)
+	require.NoError(t, err)
+
+	databases, err := database.NewManager(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { databases.Close() })
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	router.NewRouter(
+		handler.NewGraphHandler(cfg, db.Grapher),
+		handler.NewAdminHandler(db.Handles, db.Grapher),
+		handler.NewDatabaseHandler(databases),
+		store,
+	).SetupRoutes(engine)
+	return engine, databases
+}
+
+func newTestClient(t *testing.T) (*Client, *database.Manager) {
+	engine, databases := newTestServer(t)
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+	return New(server.URL, &Options{RetryBackoff: time.Millisecond}), databases
+}
+
+func TestNodes(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	node := &Node{Type: "person", Name: "alice"}
+	require.NoError(t, c.CreateNode(ctx, node))
+	require.NotEmpty(t, node.ID)
+	require.Equal(t, int64(1), node.Version)
+
+	stored, err := c.GetNode(ctx, "person", node.ID)
+	require.NoError(t, err)
+	require.Equal(t, *node, *stored)
+
+	node.Name = "alice smith"
+	require.NoError(t, c.UpdateNode(ctx, node, 1))
+	require.Equal(t, int64(2), node.Version)
+
+	stale := &Node{ID: node.ID, Type: "person", Name: "alice jones"}
+	require.ErrorIs(t, c.UpdateNode(ctx, stale, 1), ErrVersionMismatch)
+
+	require.NoError(t, c.DeleteNode(ctx, "person", node.ID, 2))
+	_, err = c.GetNode(ctx, "person", node.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	types, err := c.ListTypes(ctx)
+	require.NoError(t, err)
+	require.Len(t, types, 1)
+	require.Equal(t, "person", types[0].Name)
+}
+
+func TestCreateNodes(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	results, err := c.CreateNodes(ctx, []Node{
+		{Type: "person", Name: "alice"},
+		{Type: "person"},
+		{Type: "pet", Name: "rex"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, 200, results[0].Status)
+	require.Equal(t, 400, results[1].Status)
+	require.Equal(t, "rex", results[2].Node.Name)
+}
+
+func TestQueryNodesPages(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	tx := c.Begin()
+	for i := range 25 {
+		color := "red"
+		if i%5 == 0 {
+			color = "blue"
+		}
+		node := &Node{ID: fmt.Sprintf("n%02d", 24-i), Type: "ball", Name: "ball", Traits: map[string]string{"color": color}}
+		require.NoError(t, tx.Insert(node))
+	}
+	require.NoError(t, tx.Commit(ctx))
+
+	nodes, err := c.QueryNodes(ctx, "ball", &Query{PageSize: 10}).All()
+	require.NoError(t, err)
+	require.Len(t, nodes, 25)
+	for i, node := range nodes {
+		require.Equal(t, fmt.Sprintf("n%02d", i), node.ID)
+	}
+
+	blue, err := c.QueryNodes(ctx, "ball", &Query{Traits: map[string]string{"color": "blue"}, PageSize: 2}).All()
+	require.NoError(t, err)
+	require.Len(t, blue, 5)
+	require.Equal(t, "n24", blue[4].ID)
+
+	empty, err := c.ListNodes(ctx, "unknown").All()
+	require.NoError(t, err)
+	require.Empty(t, empty)
+}
+
+func TestEdges(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	pet := &Node{Type: "pet", Name: "rex"}
+	require.NoError(t, c.CreateNode(ctx, pet))
+	owner := &Node{Type: "person", Name: "alice", Edges: []string{pet.ID}}
+	require.NoError(t, c.CreateNode(ctx, owner))
+
+	edges, err := c.Edges(ctx, "person", owner.ID)
+	require.NoError(t, err)
+	require.Len(t, edges, 1)
+	require.Equal(t, "rex", edges[0].Name)
+
+	_, err = c.Edges(ctx, "person", "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestTransact(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	alice := &Node{Type: "person", Name: "alice"}
+	rex := &Node{Type: "pet", Name: "rex"}
+	err := c.Transact(ctx, func(tx *Tx) error {
+		if err := tx.Insert(alice); err != nil {
+			return err
+		}
+		return tx.Insert(rex)
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, alice.ID)
+	require.Equal(t, int64(1), rex.Version)
+
+	tx := c.Begin()
+	alice.Name = "alice smith"
+	require.NoError(t, tx.Update(alice, 1))
+	require.NoError(t, tx.Delete("pet", "missing", AnyVersion))
+	require.ErrorIs(t, tx.Commit(ctx), ErrNotFound)
+	require.ErrorIs(t, tx.Commit(ctx), ErrTxDone)
+
+	stored, err := c.GetNode(ctx, "person", alice.ID)
+	require.NoError(t, err)
+	require.Equal(t, "alice", stored.Name)
+}
+
+func TestRetriesReuseIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	engine, _ := newTestServer(t)
+
+	// The first attempt of every request is applied but its response is lost.
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	keys := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempt := r.Method + " " + r.URL.String()
+		seen[attempt]++
+		first := seen[attempt] == 1
+		keys[r.Header.Get(idempotencyKeyHeader)] = true
+		mu.Unlock()
+
+		if first {
+			engine.ServeHTTP(httptest.NewRecorder(), r)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		engine.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	c := New(server.URL, &Options{RetryBackoff: time.Millisecond})
+
+	node := &Node{Type: "person", Name: "alice"}
+	require.NoError(t, c.CreateNode(ctx, node))
+	require.Len(t, keys, 1)
+
+	nodes, err := c.ListNodes(ctx, "person").All()
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, node.ID, nodes[0].ID)
+
+	c = New(server.URL, &Options{MaxRetries: -1})
+	_, err = c.GetNode(ctx, "person", "other")
+	require.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestDatabase(t *testing.T) {
+	ctx := context.Background()
+	c, databases := newTestClient(t)
+
+	_, err := databases.Create("sales", database.Settings{})
+	require.NoError(t, err)
+
+	sales := c.Database("sales")
+	node := &Node{Type: "order", Name: "first"}
+	require.NoError(t, sales.CreateNode(ctx, node))
+
+	_, err = c.GetNode(ctx, "order", node.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = sales.GetNode(ctx, "order", node.ID)
+	require.NoError(t, err)
+
+	_, err = c.Database("missing").GetNode(ctx, "order", node.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type TypeInfo struct {
+	Name  string `json:"name"`
+	Nodes int    `json:"nodes"`
+	Size  int64  `json:"size"`
+}
+
+// BatchResult is the outcome of one node of CreateNodes.
+type BatchResult struct {
+	Status int    `json:"status"`
+	Node   *Node  `json:"node,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type nodeResponse struct {
+	Node Node `json:"node"`
+}
+
+func (c *Client) nodePath(nodeType string, id string) string {
+	path := c.graphPath + "/node/" + url.PathEscape(nodeType)
+	if id != "" {
+		path += "/" + url.PathEscape(id)
+	}
+	return path
+}
+
+// CreateNode stores a new node and sets the ID and version given to it by the server.
+func (c *Client) CreateNode(ctx context.Context, node *Node) error {
+	var resp nodeResponse
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: c.graphPath + "/node", body: node}, &resp)
+	if err != nil {
+		return err
+	}
+	*node = resp.Node
+	return nil
+}
+
+// CreateNodes stores many nodes of mixed types and returns the outcome of every node,
+// in the same order.
+func (c *Client) CreateNodes(ctx context.Context, nodes []Node) ([]BatchResult, error) {
+	var resp struct {
+		Results []BatchResult `json:"results"`
+	}
+	body := struct {
+		Nodes []Node `json:"nodes"`
+	}{nodes}
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: c.graphPath + "/nodes:batch", body: body}, &resp)
+	return resp.Results, err
+}
+
+// GetNode returns a node, or an error matching ErrNotFound.
+func (c *Client) GetNode(ctx context.Context, nodeType string, id string) (*Node, error) {
+	node := &Node{}
+	if _, err := c.do(ctx, &request{method: http.MethodGet, path: c.nodePath(nodeType, id)}, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// UpdateNode replaces a node that must be at the expected version and sets its new version.
+func (c *Client) UpdateNode(ctx context.Context, node *Node, expectedVersion int64) error {
+	var resp nodeResponse
+	req := &request{method: http.MethodPut, path: c.nodePath(node.Type, node.ID), body: node, ifMatch: expectedVersion}
+	if _, err := c.do(ctx, req, &resp); err != nil {
+		return err
+	}
+	node.Version = resp.Node.Version
+	return nil
+}
+
+// DeleteNode removes a node that must be at the expected version.
+func (c *Client) DeleteNode(ctx context.Context, nodeType string, id string, expectedVersion int64) error {
+	req := &request{method: http.MethodDelete, path: c.nodePath(nodeType, id), ifMatch: expectedVersion}
+	_, err := c.do(ctx, req, nil)
+	return err
+}
+
+// Edges returns the stored nodes the edges of a node point at.
+func (c *Client) Edges(ctx context.Context, nodeType string, id string) ([]Node, error) {
+	var nodes []Node
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: c.nodePath(nodeType, id) + "/edges"}, &nodes)
+	return nodes, err
+}
+
+func (c *Client) ListTypes(ctx context.Context) ([]TypeInfo, error) {
+	var types []TypeInfo
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: c.graphPath + "/types"}, &types)
+	return types, err
+}
+
+func (c *Client) DescribeType(ctx context.Context, nodeType string) (*TypeInfo, error) {
+	info := &TypeInfo{}
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: c.graphPath + "/types/" + url.PathEscape(nodeType)}, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Query selects nodes of a type. Nodes match when they have the name, if set, and
+// every trait.
+type Query struct {
+	Name   string
+	Traits map[string]string
+	// PageSize is the number of nodes fetched per request; 0 uses DefaultPageSize.
+	PageSize int
+}
+
+// DefaultPageSize is the number of nodes fetched per request by iterators.
+const DefaultPageSize = 100
+
+// ListNodes iterates over every node of a type, ordered by ID.
+func (c *Client) ListNodes(ctx context.Context, nodeType string) *NodeIterator {
+	return c.QueryNodes(ctx, nodeType, &Query{})
+}
+
+// QueryNodes iterates over the nodes of a type matching the query, ordered by ID.
+// Pages are fetched as the iterator advances.
+func (c *Client) QueryNodes(ctx context.Context, nodeType string, q *Query) *NodeIterator {
+	return &NodeIterator{ctx: ctx, client: c, path: c.nodePath(nodeType, ""), query: q.values()}
+}
+
+// values returns the query parameters of the first page.
+func (q *Query) values() url.Values {
+	query := url.Values{}
+	if q.Name != "" {
+		query.Set("name", q.Name)
+	}
+	for key, value := range q.Traits {
+		query.Add("trait", key+":"+value)
+	}
+	pageSize := q.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	query.Set("limit", strconv.Itoa(pageSize))
+	return query
+}
+
+// Plan describes how the server answers a read, one step per line.
+type Plan struct {
+	Steps []string `json:"steps"`
+}
+
+// explain asks the server how it answers a read, without running it.
+func (c *Client) explain(ctx context.Context, path string, query url.Values) (*Plan, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("explain", "true")
+	plan := &Plan{}
+	if _, err := c.do(ctx, &request{method: http.MethodGet, path: path, query: query}, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ExplainQuery describes how the server reads the first page of QueryNodes.
+func (c *Client) ExplainQuery(ctx context.Context, nodeType string, q *Query) (*Plan, error) {
+	return c.explain(ctx, c.nodePath(nodeType, ""), q.values())
+}
+
+// ExplainGetNode describes how the server reads a node for GetNode.
+func (c *Client) ExplainGetNode(ctx context.Context, nodeType string, id string) (*Plan, error) {
+	return c.explain(ctx, c.nodePath(nodeType, id), nil)
+}
+
+// ExplainEdges describes how the server finds the nodes returned by Edges.
+func (c *Client) ExplainEdges(ctx context.Context, nodeType string, id string) (*Plan, error) {
+	return c.explain(ctx, c.nodePath(nodeType, id)+"/edges", nil)
+}
+
+// ExplainListTypes describes how the server lists the types for ListTypes.
+func (c *Client) ExplainListTypes(ctx context.Context) (*Plan, error) {
+	return c.explain(ctx, c.graphPath+"/types", nil)
+}
+
+// NodeIterator walks the pages of a listing:
+//
+//	it := c.ListNodes(ctx, "person")
+//	for it.Next() {
+//		use(it.Node())
+//	}
+//	err := it.Err()
+type NodeIterator struct {
+	ctx    context.Context
+	client *Client
+	path   string
+	query  url.Values
+
+	page  []Node
+	index int
+	token string
+	last  bool
+	err   error
+}
+
+// Next advances to the next node, fetching the next page when needed. It returns
+// false after the last node or an error.
+func (it *NodeIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.index++
+	for it.index >= len(it.page) {
+		if it.last {
+			return false
+		}
+		if it.err = it.fetch(); it.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (it *NodeIterator) fetch() error {
+	if it.token != "" {
+		it.query.Set("pageToken", it.token)
+	}
+
+	var page []Node
+	header, err := it.client.do(it.ctx, &request{method: http.MethodGet, path: it.path, query: it.query}, &page)
+	if err != nil {
+		return err
+	}
+	it.page = page
+	it.index = 0
+	it.token = header.Get(nextPageTokenHeader)
+	it.last = it.token == ""
+	return nil
+}
+
+// Node returns the current node.
+func (it *NodeIterator) Node() Node {
+	return it.page[it.index]
+}
+
+func (it *NodeIterator) Err() error {
+	return it.err
+}
+
+// All reads the remaining nodes.
+func (it *NodeIterator) All() ([]Node, error) {
+	var nodes []Node
+	for it.Next() {
+		nodes = append(nodes, it.Node())
+	}
+	return nodes, it.Err()
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrTxDone = errors.New("transaction is already committed or rolled back")
+	// ErrTxPending tells that the server failed partway through a transaction. It is
+	// committed all the same, and the rest of it is applied before the next write.
+	ErrTxPending = errors.New("transaction is committed in part")
+)
+
+type txOp struct {
+	Op              string `json:"op"`
+	Node            Node   `json:"node"`
+	ExpectedVersion int64  `json:"expectedVersion,omitempty"`
+}
+
+// Tx collects changes that are sent to the server together on Commit, where either
+// all of them are applied or none.
+type Tx struct {
+	client *Client
+	ops    []txOp
+	nodes  []*Node
+	done   bool
+}
+
+// Begin starts a transaction.
+func (c *Client) Begin() *Tx {
+	return &Tx{client: c}
+}
+
+// Transact runs fn in a transaction and commits it when fn returns no error.
+func (c *Client) Transact(ctx context.Context, fn func(tx *Tx) error) error {
+	tx := c.Begin()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (tx *Tx) add(op string, node *Node, expectedVersion int64) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.ops = append(tx.ops, txOp{Op: op, Node: *node, ExpectedVersion: expectedVersion})
+	tx.nodes = append(tx.nodes, node)
+	return nil
+}
+
+// Insert adds a new node. The server generates its ID when it has none.
+func (tx *Tx) Insert(node *Node) error {
+	return tx.add("insert", node, AnyVersion)
+}
+
+// Update replaces a node that must be at the expected version.
+func (tx *Tx) Update(node *Node, expectedVersion int64) error {
+	return tx.add("update", node, expectedVersion)
+}
+
+// Delete removes a node that must be at the expected version.
+func (tx *Tx) Delete(nodeType string, id string, expectedVersion int64) error {
+	return tx.add("delete", &Node{ID: id, Type: nodeType}, expectedVersion)
+}
+
+// Commit sends the changes and sets the IDs and new versions of inserted and updated
+// nodes. It fails with ErrTxPending, after setting them, when the server applied the
+// changes in part; the rest are applied before its next write.
+func (tx *Tx) Commit(ctx context.Context) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	var resp struct {
+		Nodes   []Node `json:"nodes"`
+		Pending bool   `json:"pending"`
+	}
+	body := struct {
+		Ops []txOp `json:"ops"`
+	}{tx.ops}
+	if _, err := tx.client.do(ctx, &request{method: http.MethodPost, path: tx.client.graphPath + "/tx", body: body}, &resp); err != nil {
+		return err
+	}
+	for i, op := range tx.ops {
+		if op.Op != "delete" && i < len(resp.Nodes) {
+			tx.nodes[i].ID = resp.Nodes[i].ID
+			tx.nodes[i].Version = resp.Nodes[i].Version
+		}
+	}
+	if resp.Pending {
+		return ErrTxPending
+	}
+	return nil
+}
+
+// Rollback drops the changes. It does nothing after Commit.
+func (tx *Tx) Rollback() {
+	tx.done = true
+}
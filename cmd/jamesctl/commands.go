@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zmjung/jamesdb/client"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// importBatchSize is the number of nodes inserted per transaction, the most the
+// server accepts. Tests lower it.
+var importBatchSize = 1000
+
+// listFlag collects every value of a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseTraits reads key=value pairs.
+func parseTraits(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	traits := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("trait %q is not key=value", pair)
+		}
+		traits[key] = value
+	}
+	return traits, nil
+}
+
+func runCommand(c *cli, args []string) error {
+	switch args[0] {
+	case "node":
+		return c.node(args[1:])
+	case "type":
+		return c.types(args[1:])
+	case "query":
+		return c.query(args[1:])
+	case "import":
+		return c.importNodes(args[1:])
+	case "export":
+		return c.exportNodes(args[1:])
+	case "backup":
+		return c.backup(args[1:])
+	case "fsck":
+		return c.fsck(args[1:])
+	case "stats":
+		return c.stats(args[1:])
+	case "shell":
+		return c.shellCommand(args[1:])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func (c *cli) node(args []string) error {
+	if len(args) == 0 {
+		return errors.New("node needs a subcommand: create, get or list")
+	}
+	switch args[0] {
+	case "create":
+		return c.createNode(args[1:])
+	case "get":
+		if len(args) != 3 {
+			return errors.New("usage: node get TYPE ID")
+		}
+		node, err := c.client.GetNode(c.ctx, args[1], args[2])
+		if err != nil {
+			return err
+		}
+		return writeNodes(c.out, c.format, []client.Node{*node})
+	case "list":
+		if len(args) != 2 {
+			return errors.New("usage: node list TYPE")
+		}
+		nodes, err := c.client.ListNodes(c.ctx, args[1]).All()
+		if err != nil {
+			return err
+		}
+		return writeNodes(c.out, c.format, nodes)
+	}
+	return fmt.Errorf("unknown node subcommand %q", args[0])
+}
+
+func (c *cli) createNode(args []string) error {
+	flags := flag.NewFlagSet("node create", flag.ContinueOnError)
+	nodeType := flags.String("type", "", "node type")
+	name := flags.String("name", "", "node name")
+	var traits, edges listFlag
+	flags.Var(&traits, "trait", "trait as key=value, repeatable")
+	flags.Var(&edges, "edge", "ID of a node to point at, repeatable")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *nodeType == "" || *name == "" {
+		return errors.New("node create needs -type and -name")
+	}
+
+	node := &client.Node{Type: *nodeType, Name: *name, Edges: edges}
+	var err error
+	if node.Traits, err = parseTraits(traits); err != nil {
+		return err
+	}
+	if err := c.client.CreateNode(c.ctx, node); err != nil {
+		return err
+	}
+	return writeNodes(c.out, c.format, []client.Node{*node})
+}
+
+func (c *cli) types(args []string) error {
+	if len(args) != 1 || args[0] != "ls" {
+		return errors.New("usage: type ls")
+	}
+	types, err := c.client.ListTypes(c.ctx)
+	if err != nil {
+		return err
+	}
+	return writeTypes(c.out, c.format, types)
+}
+
+func (c *cli) query(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	name := flags.String("name", "", "node name to match")
+	var traits listFlag
+	flags.Var(&traits, "trait", "trait to match as key=value, repeatable")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: query [-name N] [-trait k=v]... TYPE")
+	}
+
+	query := &client.Query{Name: *name}
+	var err error
+	if query.Traits, err = parseTraits(traits); err != nil {
+		return err
+	}
+	nodes, err := c.client.QueryNodes(c.ctx, flags.Arg(0), query).All()
+	if err != nil {
+		return err
+	}
+	return writeNodes(c.out, c.format, nodes)
+}
+
+// importNodes inserts the nodes of a JSON array or CSV file, as written by export,
+// keeping their IDs. The nodes are inserted without their edges first and given them
+// after, so edges may point at nodes anywhere in the file under any integrity mode.
+// Each batch is a transaction, so a failed batch changes nothing.
+func (c *cli) importNodes(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "file format: json or csv (default from the file extension)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: import [-format json|csv] FILE")
+	}
+	path := flags.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var nodes []client.Node
+	switch cmp.Or(*format, strings.TrimPrefix(filepath.Ext(path), ".")) {
+	case outputJSON:
+		err = json.Unmarshal(data, &nodes)
+	case outputCSV:
+		err = disk.ReadCsv(c.ctx, bytes.NewReader(data), &nodes)
+	default:
+		return fmt.Errorf("cannot tell the format of %s, use -format", path)
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var linked []client.Node
+	inserts := make([]client.Node, len(nodes))
+	for i, node := range nodes {
+		if len(node.Edges) > 0 {
+			linked = append(linked, node)
+		}
+		node.Edges = nil
+		inserts[i] = node
+	}
+
+	imported, err := c.importBatches(inserts, func(tx *client.Tx, node *client.Node) error {
+		return tx.Insert(node)
+	})
+	if err != nil {
+		return fmt.Errorf("imported %d of %d nodes: %w", imported, len(nodes), err)
+	}
+	// Every node is inserted at version 1 before its edges are set.
+	linkedCount, err := c.importBatches(linked, func(tx *client.Tx, node *client.Node) error {
+		return tx.Update(node, 1)
+	})
+	if err != nil {
+		return fmt.Errorf("imported %d nodes, set the edges of %d of %d: %w", imported, linkedCount, len(linked), err)
+	}
+	fmt.Fprintf(c.out, "Imported %d nodes\n", imported)
+	return nil
+}
+
+// importBatches adds the nodes to transactions of importBatchSize nodes and returns
+// the number of nodes committed.
+func (c *cli) importBatches(nodes []client.Node, add func(tx *client.Tx, node *client.Node) error) (int, error) {
+	committed := 0
+	for start := 0; start < len(nodes); start += importBatchSize {
+		batch := nodes[start:min(start+importBatchSize, len(nodes))]
+		err := c.client.Transact(c.ctx, func(tx *client.Tx) error {
+			for i := range batch {
+				if err := add(tx, &batch[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return committed, err
+		}
+		committed += len(batch)
+	}
+	return committed, nil
+}
+
+// exportNodes writes the nodes of one or every type as a JSON array or CSV.
+func (c *cli) exportNodes(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", outputJSON, "file format: json or csv")
+	nodeType := flags.String("type", "", "node type to export (default every type)")
+	out := flags.String("out", "", "file to write (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != outputJSON && *format != outputCSV {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	nodeTypes := []string{*nodeType}
+	if *nodeType == "" {
+		types, err := c.client.ListTypes(c.ctx)
+		if err != nil {
+			return err
+		}
+		nodeTypes = nodeTypes[:0]
+		for _, t := range types {
+			nodeTypes = append(nodeTypes, t.Name)
+		}
+	}
+
+	nodes := []client.Node{}
+	for _, t := range nodeTypes {
+		page, err := c.client.ListNodes(c.ctx, t).All()
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, page...)
+	}
+
+	if *out == "" {
+		return writeNodes(c.out, *format, nodes)
+	}
+	var buf bytes.Buffer
+	if err := writeNodes(&buf, *format, nodes); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Exported %d nodes to %s\n", len(nodes), *out)
+	return nil
+}
+
+func (c *cli) backup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := flags.String("out", "", "archive to write (default jamesdb-<time>.tar.gz)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	path := cmp.Or(*out, fmt.Sprintf("jamesdb-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z")))
+
+	// The archive is written next to its final name and only renamed once complete.
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	size, err := c.client.Backup(c.ctx, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Wrote %d bytes to %s\n", size, path)
+
+	// Without the change log a restore brings back the backup, and nothing after it.
+	stats, err := c.client.Stats(c.ctx)
+	if err != nil {
+		return err
+	}
+	if graph, ok := stats["graph"].(map[string]any); ok && graph["changeLog"] == false {
+		fmt.Fprintln(c.out, "Warning: the change log of the server is off, so restore -until cannot replay the changes made after this backup")
+	}
+	return nil
+}
+
+func (c *cli) fsck(args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	quarantine := flags.Bool("quarantine", false, "move corrupt records into quarantine files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	result, err := c.client.Fsck(c.ctx, *quarantine)
+	if err != nil {
+		return err
+	}
+	if err := writeFsck(c.out, c.format, result); err != nil {
+		return err
+	}
+	if result.Corrupt > 0 {
+		return fmt.Errorf("%d of %d node files failed the check", result.Corrupt, len(result.Files))
+	}
+	return nil
+}
+
+func (c *cli) stats(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: stats")
+	}
+	stats, err := c.client.Stats(c.ctx)
+	if err != nil {
+		return err
+	}
+	return writeStats(c.out, c.format, stats)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/zmjung/jamesdb/client"
+	"golang.org/x/term"
+)
+
+// traitSampleSize is the number of nodes read to learn the trait keys of a type.
+const traitSampleSize = client.DefaultPageSize
+
+var metaCommands = []string{":explain", ":timing", ":output", ":refresh", ":help", ":quit"}
+
+// completer suggests keywords, and node types and trait keys fetched from the server
+// the first time they are needed.
+type completer struct {
+	cli    *cli
+	types  []string
+	traits map[string][]string
+}
+
+func newCompleter(c *cli) *completer {
+	return &completer{cli: c, traits: make(map[string][]string)}
+}
+
+func (cp *completer) reset() {
+	cp.types = nil
+	cp.traits = make(map[string][]string)
+}
+
+// nodeTypes returns the node types of the server. Errors leave nothing to suggest.
+func (cp *completer) nodeTypes() []string {
+	if cp.types != nil {
+		return cp.types
+	}
+	types, err := cp.cli.client.ListTypes(cp.cli.ctx)
+	if err != nil {
+		return nil
+	}
+	cp.types = make([]string, len(types))
+	for i, t := range types {
+		cp.types[i] = t.Name
+	}
+	return cp.types
+}
+
+// traitKeys returns the trait keys found on a sample of the nodes of a type.
+func (cp *completer) traitKeys(nodeType string) []string {
+	if keys, ok := cp.traits[nodeType]; ok {
+		return keys
+	}
+	it := cp.cli.client.QueryNodes(cp.cli.ctx, nodeType, &client.Query{PageSize: traitSampleSize})
+	found := make(map[string]bool)
+	for n := 0; n < traitSampleSize && it.Next(); n++ {
+		for key := range it.Node().Traits {
+			found[key] = true
+		}
+	}
+	if it.Err() != nil {
+		return nil
+	}
+	keys := slices.Sorted(maps.Keys(found))
+	cp.traits[nodeType] = keys
+	return keys
+}
+
+// complete returns where the word before pos starts and the words it can become.
+func (cp *completer) complete(line string, pos int) (int, []string) {
+	before := line[:pos]
+	start := strings.LastIndexAny(before, " \t=") + 1
+	prefix := before[start:]
+	fields := strings.Fields(strings.ReplaceAll(before[:start], "=", " = "))
+
+	if len(fields) > 0 && fields[0] == ":explain" {
+		fields = fields[1:]
+	} else if len(fields) == 0 && strings.HasPrefix(prefix, ":") {
+		return start, matching(metaCommands, prefix)
+	}
+
+	var words []string
+	switch {
+	case len(fields) == 0:
+		words = statementKeywords
+	case len(fields) == 1 && slices.Contains([]string{stmtGet, stmtEdges, stmtFind}, strings.ToLower(fields[0])):
+		words = cp.nodeTypes()
+	case strings.ToLower(fields[0]) == stmtFind && len(fields) >= 2:
+		last := strings.ToLower(fields[len(fields)-1])
+		if last == "=" || last == "limit" {
+			return start, nil
+		}
+		if last == "where" || last == "and" {
+			words = []string{"name"}
+			for _, key := range cp.traitKeys(fields[1]) {
+				words = append(words, "trait."+key)
+			}
+		} else {
+			words = []string{"where", "and", "limit"}
+		}
+	}
+	return start, matching(words, prefix)
+}
+
+func matching(words []string, prefix string) []string {
+	var matches []string
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			matches = append(matches, word)
+		}
+	}
+	return matches
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// callback completes the word before the cursor on tab. A single match is completed
+// with a trailing space; several are completed to their common prefix, or listed
+// when that adds nothing.
+func (cp *completer) callback(t *term.Terminal) func(line string, pos int, key rune) (string, int, bool) {
+	return func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		start, words := cp.complete(line, pos)
+		if len(words) == 0 {
+			return line, pos, true
+		}
+
+		completion := commonPrefix(words)
+		if len(words) == 1 {
+			completion += " "
+		} else if len(completion) == pos-start {
+			fmt.Fprintln(t, strings.Join(words, "  "))
+			return line, pos, true
+		}
+		newLine := line[:start] + completion + line[pos:]
+		return newLine, start + len(completion), true
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configFileName is read from the home directory unless -config names another file.
+const configFileName = ".jamesctl.yml"
+
+type ctlConfig struct {
+	// Server is the base URL of the server, such as http://localhost:8080.
+	Server string `yaml:"server"`
+	// Token is sent as a bearer token with every request.
+	Token string `yaml:"token"`
+	// Database is the named database to use instead of the default one.
+	Database string `yaml:"database"`
+	// Output is table, json or csv.
+	Output string `yaml:"output"`
+}
+
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return configFileName
+	}
+	return filepath.Join(home, configFileName)
+}
+
+// loadCtlConfig reads the config file. A missing file is only an error when the
+// path was given explicitly.
+func loadCtlConfig(path string, explicit bool) (*ctlConfig, error) {
+	cfg := &ctlConfig{
+		Server: "http://localhost:8080",
+		Output: outputTable,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,93 @@
+// Command jamesctl manages a running jamesdb server through its HTTP API.
+//
+//	jamesctl [-config file] [-server url] [-db name] [-o table|json|csv] command [args]
+//
+// The server address, token, database and output format default to the values of
+// ~/.jamesctl.yml when it exists.
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/zmjung/jamesdb/client"
+)
+
+const usage = `Usage: jamesctl [flags] command [args]
+
+Commands:
+  node create -type T -name N [-trait k=v]... [-edge id]...
+  node get TYPE ID
+  node list TYPE
+  type ls
+  query [-name N] [-trait k=v]... TYPE
+  import [-format json|csv] FILE
+  export [-format json|csv] [-type T] [-out FILE]
+  backup [-out FILE]
+  fsck [-quarantine]
+  stats
+  shell [-history FILE]
+
+Flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "jamesctl:", err)
+		os.Exit(1)
+	}
+}
+
+// cli holds what every command needs.
+type cli struct {
+	ctx    context.Context
+	client *client.Client
+	in     io.Reader
+	out    io.Writer
+	format string
+}
+
+func run(ctx context.Context, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("jamesctl", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "config file (default ~/"+configFileName+")")
+	server := flags.String("server", "", "server base URL")
+	db := flags.String("db", "", "named database")
+	output := flags.String("o", "", "output format: table, json or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadCtlConfig(cmp.Or(*configPath, defaultConfigPath()), *configPath != "")
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	cfg.Server = cmp.Or(*server, cfg.Server)
+	cfg.Database = cmp.Or(*db, cfg.Database)
+	cfg.Output = cmp.Or(*output, cfg.Output)
+	if !validOutput(cfg.Output) {
+		return fmt.Errorf("unknown output format %q", cfg.Output)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("missing command")
+	}
+
+	c := client.New(cfg.Server, &client.Options{Token: cfg.Token})
+	if cfg.Database != "" {
+		c = c.Database(cfg.Database)
+	}
+	return runCommand(&cli{ctx: ctx, client: c, in: in, out: out, format: cfg.Output}, flags.Args())
+}
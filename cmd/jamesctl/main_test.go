@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/client"
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/internal/database"
+	"github.com/zmjung/jamesdb/internal/handler"
+	"github.com/zmjung/jamesdb/internal/idempotency"
+	"github.com/zmjung/jamesdb/internal/instance"
+	"github.com/zmjung/jamesdb/internal/router"
+)
+
+// newTestServer serves the real routes over a database in a temporary folder and
+// returns its URL, after applying the given changes to its configuration.
+func newTestServer(t *testing.T, configure ...func(cfg *config.Config)) string {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+	cfg.Server.IdempotencyWindow = time.Hour
+	for _, change := range configure {
+		change(cfg)
+	}
+
+	db, err := instance.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := idempotency.NewStore(db.Handles, cfg.Database.RootPath, cfg.Server.IdempotencyWindow, db.Keys)
+	require.NoError(t, err)
+	databases, err := database.NewManager(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { databases.Close() })
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	router.NewRouter(
+		handler.NewGraphHandler(cfg, db.Grapher),
+		handler.NewAdminHandler(db.Handles, db.Grapher),
+		handler.NewDatabaseHandler(databases),
+		store,
+	).SetupRoutes(engine)
+
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// jamesctl runs a command with a config file pointing at the server.
+func jamesctl(t *testing.T, server string, args ...string) (string, error) {
+	return jamesctlWithInput(t, server, "", args...)
+}
+
+func jamesctlWithInput(t *testing.T, server string, input string, args ...string) (string, error) {
+	configPath := filepath.Join(t.TempDir(), configFileName)
+	require.NoError(t, os.WriteFile(configPath, []byte("server: "+server+"\noutput: json\n"), 0o644))
+
+	var out bytes.Buffer
+	err := run(context.Background(), append([]string{"-config", configPath}, args...), strings.NewReader(input), &out)
+	return out.String(), err
+}
+
+func TestNodeCommands(t *testing.T) {
+	server := newTestServer(t)
+
+	out, err := jamesctl(t, server, "node", "create", "-type", "person", "-name", "alice", "-trait", "team=db")
+	require.NoError(t, err)
+	var created []client.Node
+	require.NoError(t, json.Unmarshal([]byte(out), &created))
+	require.Len(t, created, 1)
+	require.Equal(t, map[string]string{"team": "db"}, created[0].Traits)
+
+	_, err = jamesctl(t, server, "node", "create", "-type", "person", "-name", "bob")
+	require.NoError(t, err)
+
+	out, err = jamesctl(t, server, "node", "get", "person", created[0].ID)
+	require.NoError(t, err)
+	require.Contains(t, out, `"alice"`)
+
+	out, err = jamesctl(t, server, "query", "-trait", "team=db", "person")
+	require.NoError(t, err)
+	var matched []client.Node
+	require.NoError(t, json.Unmarshal([]byte(out), &matched))
+	require.Len(t, matched, 1)
+	require.Equal(t, created[0].ID, matched[0].ID)
+
+	out, err = jamesctl(t, server, "-o", "table", "type", "ls")
+	require.NoError(t, err)
+	require.Contains(t, out, "NAME")
+	require.Contains(t, out, "person")
+
+	out, err = jamesctl(t, server, "-o", "csv", "node", "list", "person")
+	require.NoError(t, err)
+	require.Contains(t, out, "id,type,name,edges,traits,version\n")
+
+	_, err = jamesctl(t, server, "node", "get", "person", "missing")
+	require.ErrorIs(t, err, client.ErrNotFound)
+}
+
+func TestExportImport(t *testing.T) {
+	source := newTestServer(t)
+	_, err := jamesctl(t, source, "node", "create", "-type", "pet", "-name", "rex")
+	require.NoError(t, err)
+	_, err = jamesctl(t, source, "node", "create", "-type", "person", "-name", "alice", "-trait", "team=db")
+	require.NoError(t, err)
+
+	for _, format := range []string{outputJSON, outputCSV} {
+		path := filepath.Join(t.TempDir(), "nodes."+format)
+		_, err = jamesctl(t, source, "export", "-format", format, "-out", path)
+		require.NoError(t, err)
+
+		target := newTestServer(t)
+		out, err := jamesctl(t, target, "import", path)
+		require.NoError(t, err)
+		require.Equal(t, "Imported 2 nodes\n", out)
+
+		out, err = jamesctl(t, target, "query", "-name", "alice", "person")
+		require.NoError(t, err)
+		var nodes []client.Node
+		require.NoError(t, json.Unmarshal([]byte(out), &nodes))
+		require.Len(t, nodes, 1)
+		require.Equal(t, "db", nodes[0].Traits["team"])
+	}
+}
+
+func TestImportLinkedNodes(t *testing.T) {
+	restrict := func(cfg *config.Config) { cfg.Database.Integrity = "restrict" }
+	source := newTestServer(t, restrict)
+	out, err := jamesctl(t, source, "node", "create", "-type", "pet", "-name", "rex")
+	require.NoError(t, err)
+	var rex []client.Node
+	require.NoError(t, json.Unmarshal([]byte(out), &rex))
+	// People are exported before the pets they point at.
+	_, err = jamesctl(t, source, "node", "create", "-type", "person", "-name", "alice", "-edge", rex[0].ID)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "nodes.json")
+	_, err = jamesctl(t, source, "export", "-out", path)
+	require.NoError(t, err)
+
+	// With a node per batch, the edge of alice points at a later batch.
+	defer func(size int) { importBatchSize = size }(importBatchSize)
+	importBatchSize = 1
+	target := newTestServer(t, restrict)
+	out, err = jamesctl(t, target, "import", path)
+	require.NoError(t, err)
+	require.Equal(t, "Imported 2 nodes\n", out)
+
+	out, err = jamesctl(t, target, "query", "-name", "alice", "person")
+	require.NoError(t, err)
+	var nodes []client.Node
+	require.NoError(t, json.Unmarshal([]byte(out), &nodes))
+	require.Len(t, nodes, 1)
+	require.Equal(t, []string{rex[0].ID}, nodes[0].Edges)
+}
+
+func TestAdminCommands(t *testing.T) {
+	server := newTestServer(t)
+	_, err := jamesctl(t, server, "node", "create", "-type", "pet", "-name", "rex")
+	require.NoError(t, err)
+
+	out, err := jamesctl(t, server, "-o", "table", "stats")
+	require.NoError(t, err)
+	require.Contains(t, out, "graph.workers")
+
+	out, err = jamesctl(t, server, "fsck")
+	require.NoError(t, err)
+	require.Contains(t, out, `"corrupt": 0`)
+
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	out, err = jamesctl(t, server, "backup", "-out", archive)
+	require.NoError(t, err)
+	require.Contains(t, out, "the change log of the server is off")
+	info, err := os.Stat(archive)
+	require.NoError(t, err)
+	require.Positive(t, info.Size())
+}
+
+func TestUnknownCommand(t *testing.T) {
+	_, err := jamesctl(t, "http://localhost:0", "frobnicate")
+	require.ErrorContains(t, err, "unknown command")
+
+	_, err = jamesctl(t, "http://localhost:0", "-o", "yaml", "stats")
+	require.ErrorContains(t, err, "unknown output format")
+}
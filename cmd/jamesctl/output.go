@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zmjung/jamesdb/client"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+var nodeColumns = []string{"id", "type", "name", "edges", "traits", "version"}
+
+func validOutput(format string) bool {
+	return format == outputTable || format == outputJSON || format == outputCSV
+}
+
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeRows prints a header and rows as an aligned table or as CSV.
+func writeRows(w io.Writer, format string, header []string, rows [][]string) error {
+	if format == outputCSV {
+		writer := csv.NewWriter(w)
+		writer.Write(header)
+		writer.WriteAll(rows)
+		return writer.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// nodeRow renders a node with the edges and traits encoded as JSON, the way node
+// files store them, so CSV output can be imported again.
+func nodeRow(node client.Node, format string) []string {
+	edges, traits := "", ""
+	if format == outputCSV {
+		if len(node.Edges) > 0 {
+			data, _ := json.Marshal(node.Edges)
+			edges = string(data)
+		}
+		if len(node.Traits) > 0 {
+			data, _ := json.Marshal(node.Traits)
+			traits = string(data)
+		}
+	} else {
+		edges = strings.Join(node.Edges, ",")
+		pairs := make([]string, 0, len(node.Traits))
+		for _, key := range slices.Sorted(maps.Keys(node.Traits)) {
+			pairs = append(pairs, key+"="+node.Traits[key])
+		}
+		traits = strings.Join(pairs, ",")
+	}
+	return []string{node.ID, node.Type, node.Name, edges, traits, strconv.FormatInt(node.Version, 10)}
+}
+
+func writeNodes(w io.Writer, format string, nodes []client.Node) error {
+	if format == outputJSON {
+		return writeJSON(w, nodes)
+	}
+	rows := make([][]string, len(nodes))
+	for i, node := range nodes {
+		rows[i] = nodeRow(node, format)
+	}
+	return writeRows(w, format, nodeColumns, rows)
+}
+
+func writeTypes(w io.Writer, format string, types []client.TypeInfo) error {
+	if format == outputJSON {
+		return writeJSON(w, types)
+	}
+	rows := make([][]string, len(types))
+	for i, t := range types {
+		rows[i] = []string{t.Name, strconv.Itoa(t.Nodes), strconv.FormatInt(t.Size, 10)}
+	}
+	return writeRows(w, format, []string{"name", "nodes", "size"}, rows)
+}
+
+func writeFsck(w io.Writer, format string, result *client.FsckResult) error {
+	if format == outputJSON {
+		return writeJSON(w, result)
+	}
+	rows := make([][]string, 0, len(result.Files))
+	for _, report := range result.Files {
+		reasons := make([]string, len(report.Problems))
+		for i, problem := range report.Problems {
+			reasons[i] = fmt.Sprintf("offset %d: %s", problem.Offset, problem.Reason)
+		}
+		rows = append(rows, []string{
+			report.File,
+			strconv.Itoa(report.Records),
+			strconv.Itoa(report.Quarantined),
+			strings.Join(reasons, "; "),
+		})
+	}
+	return writeRows(w, format, []string{"file", "records", "quarantined", "problems"}, rows)
+}
+
+// writeStats prints nested statistics as dotted keys, sorted.
+func writeStats(w io.Writer, format string, stats map[string]any) error {
+	if format == outputJSON {
+		return writeJSON(w, stats)
+	}
+	var rows [][]string
+	var flatten func(prefix string, v any)
+	flatten = func(prefix string, v any) {
+		if m, ok := v.(map[string]any); ok {
+			for _, key := range slices.Sorted(maps.Keys(m)) {
+				flatten(strings.TrimPrefix(prefix+"."+key, "."), m[key])
+			}
+			return
+		}
+		if f, ok := v.(float64); ok {
+			v = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		rows = append(rows, []string{prefix, fmt.Sprint(v)})
+	}
+	flatten("", stats)
+	return writeRows(w, format, []string{"stat", "value"}, rows)
+}
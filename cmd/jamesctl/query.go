@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zmjung/jamesdb/client"
+)
+
+// The shell understands these statements, each ending with a semicolon:
+//
+//	types;
+//	get TYPE ID;
+//	edges TYPE ID;
+//	find TYPE [where COND [and COND]...] [limit N];
+//
+// where COND is name = VALUE or trait.KEY = VALUE, and values may be double quoted.
+const (
+	stmtTypes = "types"
+	stmtGet   = "get"
+	stmtEdges = "edges"
+	stmtFind  = "find"
+)
+
+var statementKeywords = []string{stmtTypes, stmtGet, stmtEdges, stmtFind}
+
+var errSyntax = errors.New("syntax error")
+
+// statement is a parsed shell query.
+type statement struct {
+	kind     string
+	nodeType string
+	id       string
+	query    client.Query
+	limit    int
+}
+
+// tokenize splits a statement into words, "=" and double quoted strings, which may
+// contain escaped quotes.
+func tokenize(input string) ([]string, error) {
+	var tokens []string
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '=':
+			tokens = append(tokens, "=")
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated string", errSyntax)
+			}
+			value, err := strconv.Unquote(string(runes[i : end+1]))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", errSyntax, err)
+			}
+			// Quoted values are marked so they are never taken for keywords.
+			tokens = append(tokens, "\x00"+value)
+			i = end + 1
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '=' && runes[i] != '"' {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	return tokens, nil
+}
+
+// word returns a token without its quoted marker.
+func word(token string) string {
+	return strings.TrimPrefix(token, "\x00")
+}
+
+func isKeyword(token string, keyword string) bool {
+	return strings.EqualFold(token, keyword)
+}
+
+// parseStatement parses a statement without its trailing semicolon.
+func parseStatement(input string) (*statement, error) {
+	tokens, err := tokenize(strings.TrimSuffix(strings.TrimSpace(input), ";"))
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty statement", errSyntax)
+	}
+
+	stmt := &statement{kind: strings.ToLower(tokens[0])}
+	args := tokens[1:]
+	switch stmt.kind {
+	case stmtTypes:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%w: usage: types;", errSyntax)
+		}
+	case stmtGet, stmtEdges:
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: usage: %s TYPE ID;", errSyntax, stmt.kind)
+		}
+		stmt.nodeType, stmt.id = word(args[0]), word(args[1])
+	case stmtFind:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%w: usage: find TYPE [where COND [and COND]...] [limit N];", errSyntax)
+		}
+		stmt.nodeType = word(args[0])
+		if err := stmt.parseClauses(args[1:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown statement %q", errSyntax, tokens[0])
+	}
+	return stmt, nil
+}
+
+func (stmt *statement) parseClauses(tokens []string) error {
+	if len(tokens) > 0 && isKeyword(tokens[0], "where") {
+		tokens = tokens[1:]
+		for {
+			if len(tokens) < 3 || tokens[1] != "=" {
+				return fmt.Errorf("%w: conditions look like name = VALUE or trait.KEY = VALUE", errSyntax)
+			}
+			if err := stmt.addCondition(tokens[0], word(tokens[2])); err != nil {
+				return err
+			}
+			tokens = tokens[3:]
+			if len(tokens) == 0 || !isKeyword(tokens[0], "and") {
+				break
+			}
+			tokens = tokens[1:]
+		}
+	}
+
+	if len(tokens) > 0 && isKeyword(tokens[0], "limit") {
+		if len(tokens) < 2 {
+			return fmt.Errorf("%w: limit needs a number", errSyntax)
+		}
+		limit, err := strconv.Atoi(tokens[1])
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("%w: invalid limit %q", errSyntax, tokens[1])
+		}
+		stmt.limit = limit
+		tokens = tokens[2:]
+	}
+
+	if len(tokens) > 0 {
+		return fmt.Errorf("%w: unexpected %q", errSyntax, word(tokens[0]))
+	}
+	return nil
+}
+
+func (stmt *statement) addCondition(field string, value string) error {
+	if isKeyword(field, "name") {
+		stmt.query.Name = value
+		return nil
+	}
+	key, ok := strings.CutPrefix(field, "trait.")
+	if !ok || key == "" {
+		return fmt.Errorf("%w: unknown field %q", errSyntax, word(field))
+	}
+	if stmt.query.Traits == nil {
+		stmt.query.Traits = make(map[string]string)
+	}
+	stmt.query.Traits[key] = value
+	return nil
+}
+
+// pageSize is the number of nodes fetched per request, no more than the limit.
+func (stmt *statement) pageSize() int {
+	if stmt.limit > 0 {
+		return min(stmt.limit, client.DefaultPageSize)
+	}
+	return client.DefaultPageSize
+}
+
+// explain describes how the statement is answered: the server describes its reads,
+// followed by how the shell pages through the nodes of a find.
+func (c *cli) explain(ctx context.Context, stmt *statement) ([]string, error) {
+	var plan *client.Plan
+	var err error
+	switch stmt.kind {
+	case stmtTypes:
+		plan, err = c.client.ExplainListTypes(ctx)
+	case stmtGet:
+		plan, err = c.client.ExplainGetNode(ctx, stmt.nodeType, stmt.id)
+	case stmtEdges:
+		plan, err = c.client.ExplainEdges(ctx, stmt.nodeType, stmt.id)
+	default:
+		query := stmt.query
+		query.PageSize = stmt.pageSize()
+		plan, err = c.client.ExplainQuery(ctx, stmt.nodeType, &query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stmt.kind != stmtFind {
+		return plan.Steps, nil
+	}
+
+	steps := append(plan.Steps, fmt.Sprintf("fetch %d nodes per request", stmt.pageSize()))
+	if stmt.limit > 0 {
+		steps = append(steps, fmt.Sprintf("stop after %d nodes", stmt.limit))
+	} else {
+		steps = append(steps, "read every page")
+	}
+	return steps, nil
+}
+
+// result is what a statement returns: nodes, or node types for the types statement.
+type result struct {
+	nodes []client.Node
+	types []client.TypeInfo
+}
+
+func (r *result) rows() int {
+	if r.types != nil {
+		return len(r.types)
+	}
+	return len(r.nodes)
+}
+
+func (c *cli) execute(ctx context.Context, stmt *statement) (*result, error) {
+	switch stmt.kind {
+	case stmtTypes:
+		types, err := c.client.ListTypes(c.ctx)
+		if types == nil {
+			types = []client.TypeInfo{}
+		}
+		return &result{types: types}, err
+	case stmtGet:
+		node, err := c.client.GetNode(ctx, stmt.nodeType, stmt.id)
+		if err != nil {
+			return nil, err
+		}
+		return &result{nodes: []client.Node{*node}}, nil
+	case stmtEdges:
+		nodes, err := c.client.Edges(ctx, stmt.nodeType, stmt.id)
+		return &result{nodes: nodes}, err
+	}
+
+	query := stmt.query
+	query.PageSize = stmt.pageSize()
+	it := c.client.QueryNodes(ctx, stmt.nodeType, &query)
+	var nodes []client.Node
+	for (stmt.limit == 0 || len(nodes) < stmt.limit) && it.Next() {
+		nodes = append(nodes, it.Node())
+	}
+	return &result{nodes: nodes}, it.Err()
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	shellPrompt         = "jamesdb> "
+	shellContinuePrompt = "      -> "
+
+	// historyFileName is kept in the home directory unless -history names another file.
+	historyFileName = ".jamesctl_history"
+	// maxHistory is the number of statements kept in the history file.
+	maxHistory = 1000
+)
+
+const shellHelp = `Statements end with a semicolon and may span lines:
+  types;
+  get TYPE ID;
+  edges TYPE ID;
+  find TYPE [where COND [and COND]...] [limit N];
+    COND is name = VALUE or trait.KEY = VALUE; quote values with spaces.
+
+Meta-commands:
+  :explain STATEMENT   ask the server how it answers a statement, without running it
+  :timing [on|off]     print the time taken by every statement
+  :output FORMAT       print results as table, json or csv
+  :refresh             fetch node types and trait keys again for completion
+  :help                show this help
+  :quit                leave the shell
+
+Press tab to complete keywords, node types and trait keys. Ctrl-C cancels the
+running statement, or clears the statement being typed.
+`
+
+// lineReader reads input lines, showing the prompt when it is interactive.
+type lineReader interface {
+	ReadLine() (string, error)
+	SetPrompt(prompt string)
+}
+
+// scanReader reads lines from a pipe or file, without prompts or editing.
+type scanReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *scanReader) ReadLine() (string, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *scanReader) SetPrompt(string) {}
+
+type shell struct {
+	cli       *cli
+	lines     lineReader
+	history   *fileHistory
+	completer *completer
+	timing    bool
+	// interrupts is set at a terminal, where Ctrl-C reaches the shell as input.
+	interrupts *interruptReader
+}
+
+// interruptReader reads the terminal in the background and passes the input on to
+// the line editor, so Ctrl-C is seen while a statement runs too; raw mode delivers it
+// as a byte rather than a signal. Ctrl-C cancels the running statement, or at the
+// prompt ends the line being edited, which the shell drops with the pending lines.
+type interruptReader struct {
+	*io.PipeReader
+
+	lock sync.Mutex
+	// cancel cancels the running statement, if any.
+	cancel  context.CancelFunc
+	cleared bool
+}
+
+const keyCtrlC = 3
+
+func newInterruptReader(in io.Reader) *interruptReader {
+	pr, pw := io.Pipe()
+	r := &interruptReader{PipeReader: pr}
+	go r.copy(in, pw)
+	return r
+}
+
+func (r *interruptReader) copy(in io.Reader, w *io.PipeWriter) {
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		chunk := buf[:n]
+		for len(chunk) > 0 {
+			i := bytes.IndexByte(chunk, keyCtrlC)
+			if i < 0 {
+				i = len(chunk)
+			}
+			input := chunk[:i]
+			if i < len(chunk) && r.interrupt() {
+				// Enter ends the line being edited.
+				input = append(slices.Clip(input), '\r')
+			}
+			if len(input) > 0 {
+				if _, err := w.Write(input); err != nil {
+					return
+				}
+			}
+			chunk = chunk[min(i+1, len(chunk)):]
+		}
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+	}
+}
+
+// interrupt cancels the running statement. Without one it marks the line being
+// edited as cleared and reports that it must be ended.
+func (r *interruptReader) interrupt() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+		return false
+	}
+	r.cleared = true
+	return true
+}
+
+// watch makes Ctrl-C call cancel until the statement ends and watch is called with nil.
+func (r *interruptReader) watch(cancel context.CancelFunc) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cancel = cancel
+}
+
+// takeCleared reports whether Ctrl-C was pressed at the prompt since the last call.
+func (r *interruptReader) takeCleared() bool {
+	if r == nil {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cleared := r.cleared
+	r.cleared = false
+	return cleared
+}
+
+// shellCommand runs the interactive shell. Line editing, history recall and
+// completion need a terminal; piped input is read as a script.
+func (c *cli) shellCommand(args []string) error {
+	flags := flag.NewFlagSet("shell", flag.ContinueOnError)
+	historyPath := flags.String("history", "", "history file (default ~/"+historyFileName+")")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *historyPath == "" {
+		*historyPath = historyFileName
+		if home, err := os.UserHomeDir(); err == nil {
+			*historyPath = filepath.Join(home, historyFileName)
+		}
+	}
+
+	history, err := loadHistory(*historyPath)
+	if err != nil {
+		return fmt.Errorf("reading history: %w", err)
+	}
+	s := &shell{cli: c, history: history, completer: newCompleter(c)}
+
+	file, ok := c.in.(*os.File)
+	if !ok || !term.IsTerminal(int(file.Fd())) {
+		s.lines = &scanReader{scanner: bufio.NewScanner(c.in)}
+		return s.run()
+	}
+
+	state, err := term.MakeRaw(int(file.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(file.Fd()), state)
+
+	s.interrupts = newInterruptReader(file)
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{s.interrupts, c.out}, shellPrompt)
+	terminal.History = history
+	terminal.AutoCompleteCallback = s.completer.callback(terminal)
+	// The terminal translates newlines for the raw mode output.
+	c.out = terminal
+	s.lines = terminal
+	fmt.Fprintf(c.out, "Connected to jamesdb. Type :help for help.\n")
+	return s.run()
+}
+
+// run reads statements until :quit or the end of input.
+func (s *shell) run() error {
+	var pending []string
+	for {
+		if len(pending) == 0 {
+			s.lines.SetPrompt(shellPrompt)
+		} else {
+			s.lines.SetPrompt(shellContinuePrompt)
+		}
+		line, err := s.lines.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s.interrupts.takeCleared() {
+			pending = pending[:0]
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if len(pending) == 0 && strings.HasPrefix(trimmed, ":") {
+			s.history.record(trimmed)
+			if quit := s.meta(trimmed); quit {
+				return nil
+			}
+			continue
+		}
+		if trimmed == "" {
+			continue
+		}
+
+		pending = append(pending, trimmed)
+		if !strings.HasSuffix(trimmed, ";") {
+			continue
+		}
+		input := strings.Join(pending, " ")
+		pending = pending[:0]
+		s.history.record(input)
+		s.statement(input)
+	}
+}
+
+// statementContext returns the context of a statement, which Ctrl-C cancels at a
+// terminal. The returned function must be called when the statement ends.
+func (s *shell) statementContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(s.cli.ctx)
+	s.interrupts.watch(cancel)
+	return ctx, func() {
+		s.interrupts.watch(nil)
+		cancel()
+	}
+}
+
+// printError prints the error of a statement, which never ends the shell.
+func printError(ctx context.Context, out io.Writer, err error) {
+	if ctx.Err() != nil {
+		fmt.Fprintln(out, "Cancelled")
+		return
+	}
+	fmt.Fprintln(out, "Error:", err)
+}
+
+// statement runs a statement and prints its result or error.
+func (s *shell) statement(input string) {
+	out := s.cli.out
+	stmt, err := parseStatement(input)
+	if err != nil {
+		fmt.Fprintln(out, "Error:", err)
+		return
+	}
+
+	ctx, done := s.statementContext()
+	defer done()
+	start := time.Now()
+	res, err := s.cli.execute(ctx, stmt)
+	elapsed := time.Since(start)
+	if err != nil {
+		printError(ctx, out, err)
+		return
+	}
+
+	if res.types != nil {
+		err = writeTypes(out, s.cli.format, res.types)
+	} else {
+		err = writeNodes(out, s.cli.format, res.nodes)
+	}
+	if err != nil {
+		fmt.Fprintln(out, "Error:", err)
+		return
+	}
+	if s.timing {
+		fmt.Fprintf(out, "(%d rows, %s)\n", res.rows(), elapsed.Round(time.Microsecond))
+	}
+}
+
+// meta runs a meta-command and reports whether the shell should end.
+func (s *shell) meta(input string) bool {
+	out := s.cli.out
+	command, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch command {
+	case ":quit", ":exit", ":q":
+		return true
+	case ":help", ":h":
+		fmt.Fprint(out, shellHelp)
+	case ":explain":
+		stmt, err := parseStatement(arg)
+		if err != nil {
+			fmt.Fprintln(out, "Error:", err)
+			return false
+		}
+		ctx, done := s.statementContext()
+		defer done()
+		steps, err := s.cli.explain(ctx, stmt)
+		if err != nil {
+			printError(ctx, out, err)
+			return false
+		}
+		for _, step := range steps {
+			fmt.Fprintln(out, "-", step)
+		}
+	case ":timing":
+		switch arg {
+		case "":
+			s.timing = !s.timing
+		case "on":
+			s.timing = true
+		case "off":
+			s.timing = false
+		default:
+			fmt.Fprintln(out, "Error: usage: :timing [on|off]")
+			return false
+		}
+		if s.timing {
+			fmt.Fprintln(out, "Timing is on")
+		} else {
+			fmt.Fprintln(out, "Timing is off")
+		}
+	case ":output":
+		if !validOutput(arg) {
+			fmt.Fprintln(out, "Error: usage: :output table|json|csv")
+			return false
+		}
+		s.cli.format = arg
+	case ":refresh":
+		s.completer.reset()
+	default:
+		fmt.Fprintf(out, "Error: unknown meta-command %s, see :help\n", command)
+	}
+	return false
+}
+
+// fileHistory keeps statements in memory for recall and appends them to a file, so
+// they survive the shell. Multi-line statements are stored joined on one line.
+type fileHistory struct {
+	path    string
+	entries []string
+}
+
+func loadHistory(path string) (*fileHistory, error) {
+	h := &fileHistory{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if len(h.entries) > maxHistory {
+		h.entries = slices.Clone(h.entries[len(h.entries)-maxHistory:])
+		return h, h.rewrite()
+	}
+	return h, nil
+}
+
+// Add ignores the lines the terminal reads, as a statement may span several of them;
+// complete statements are recorded by the shell instead.
+func (h *fileHistory) Add(string) {}
+
+func (h *fileHistory) Len() int {
+	return len(h.entries)
+}
+
+func (h *fileHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+// record adds a statement unless it repeats the last one. Failing to write the file
+// only loses the statement on exit.
+func (h *fileHistory) record(entry string) {
+	if n := len(h.entries); n > 0 && h.entries[n-1] == entry {
+		return
+	}
+	h.entries = append(h.entries, entry)
+
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, entry)
+}
+
+// rewrite replaces the file with the entries kept in memory.
+func (h *fileHistory) rewrite() error {
+	tmpPath := h.path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strings.Join(h.entries, "\n")+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, h.path)
+}
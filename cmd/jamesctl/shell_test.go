@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/client"
+)
+
+func TestParseStatement(t *testing.T) {
+	stmt, err := parseStatement(`find person where name = "alice smith" and trait.team = db limit 5;`)
+	require.NoError(t, err)
+	require.Equal(t, stmtFind, stmt.kind)
+	require.Equal(t, "person", stmt.nodeType)
+	require.Equal(t, "alice smith", stmt.query.Name)
+	require.Equal(t, map[string]string{"team": "db"}, stmt.query.Traits)
+	require.Equal(t, 5, stmt.limit)
+
+	stmt, err = parseStatement(`GET person "where";`)
+	require.NoError(t, err)
+	require.Equal(t, stmtGet, stmt.kind)
+	require.Equal(t, "where", stmt.id)
+
+	for _, input := range []string{
+		"",
+		"drop person;",
+		"get person;",
+		"find person where name;",
+		"find person where color = red;",
+		"find person limit none;",
+		`find person where name = "alice;`,
+		"find person limit 1 where name = a;",
+	} {
+		_, err := parseStatement(input)
+		require.ErrorIs(t, err, errSyntax, input)
+	}
+}
+
+func TestShellRunsStatements(t *testing.T) {
+	server := newTestServer(t)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		_, err := jamesctl(t, server, "node", "create", "-type", "person", "-name", name, "-trait", "team=db")
+		require.NoError(t, err)
+	}
+
+	historyPath := filepath.Join(t.TempDir(), historyFileName)
+	script := strings.Join([]string{
+		":output table",
+		":timing on",
+		"find person",
+		"  where trait.team = db",
+		"  limit 2;",
+		"types;",
+		":explain find person where name = alice;",
+		"get person missing;",
+		"drop person;",
+		":quit",
+		"types;",
+	}, "\n")
+	out, err := jamesctlWithInput(t, server, script, "shell", "-history", historyPath)
+	require.NoError(t, err)
+
+	require.Contains(t, out, "Timing is on")
+	// The limit stops the query after two of the three nodes.
+	require.Equal(t, 2, strings.Count(out, "team=db"))
+	require.Contains(t, out, "(2 rows, ")
+	require.Contains(t, out, "NODES")
+	// The server describes its reads, and the shell how it pages through them.
+	require.Contains(t, out, `- keep the nodes matching name = "alice"`)
+	require.Contains(t, out, "- fetch 100 nodes per request\n- read every page")
+	require.Contains(t, out, "Error: jamesdb: 404")
+	require.Contains(t, out, `unknown statement "drop"`)
+	// Nothing runs after :quit.
+	require.Equal(t, 1, strings.Count(out, "(1 rows, "))
+
+	data, err := os.ReadFile(historyPath)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "find person where trait.team = db limit 2;\n")
+
+	history, err := loadHistory(historyPath)
+	require.NoError(t, err)
+	require.Equal(t, ":quit", history.At(0))
+	require.Equal(t, ":output table", history.At(history.Len()-1))
+}
+
+func TestInterruptReader(t *testing.T) {
+	in, typed := io.Pipe()
+	r := newInterruptReader(in)
+	read := func() string {
+		buf := make([]byte, 64)
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	// At the prompt Ctrl-C ends the line, which is then cleared.
+	go typed.Write([]byte("find per\x03"))
+	require.Equal(t, "find per\r", read())
+	require.True(t, r.takeCleared())
+	require.False(t, r.takeCleared())
+
+	// While a statement runs Ctrl-C cancels it and is not passed on.
+	ctx, cancel := context.WithCancel(context.Background())
+	r.watch(cancel)
+	go typed.Write([]byte("\x03types;"))
+	require.Equal(t, "types;", read())
+	require.Error(t, ctx.Err())
+	require.False(t, r.takeCleared())
+
+	typed.Close()
+	_, err := r.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyFileName)
+	var lines []string
+	for range maxHistory + 10 {
+		lines = append(lines, "types;", "types ;")
+	}
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600))
+
+	history, err := loadHistory(path)
+	require.NoError(t, err)
+	require.Equal(t, maxHistory, history.Len())
+
+	history.record("types ;")
+	require.Equal(t, maxHistory, history.Len())
+	history.record("get a b;")
+	require.Equal(t, "get a b;", history.At(0))
+
+	reloaded, err := loadHistory(path)
+	require.NoError(t, err)
+	require.Equal(t, maxHistory, reloaded.Len())
+	require.Equal(t, "get a b;", reloaded.At(0))
+}
+
+func TestComplete(t *testing.T) {
+	server := newTestServer(t)
+	_, err := jamesctl(t, server, "node", "create", "-type", "person", "-name", "alice", "-trait", "team=db", "-trait", "title=cto")
+	require.NoError(t, err)
+	_, err = jamesctl(t, server, "node", "create", "-type", "pet", "-name", "rex")
+	require.NoError(t, err)
+
+	cp := newCompleter(&cli{ctx: context.Background(), client: client.New(server, nil)})
+	complete := func(line string) []string {
+		_, words := cp.complete(line, len(line))
+		return words
+	}
+
+	require.Equal(t, []string{stmtFind}, complete("fi"))
+	require.Equal(t, []string{":timing"}, complete(":ti"))
+	require.Equal(t, []string{"person", "pet"}, complete("find p"))
+	require.Equal(t, []string{"person"}, complete(":explain get per"))
+	require.Equal(t, []string{"trait.team", "trait.title"}, complete("find person where trait.t"))
+	require.Equal(t, []string{"name", "trait.team", "trait.title"}, complete("find person where name = x and "))
+	require.Equal(t, []string{"limit"}, complete("find person where name=x l"))
+	require.Empty(t, complete("find person where name = "))
+
+	start, _ := cp.complete("find person where trait.t", len("find person where trait.t"))
+	require.Equal(t, len("find person where "), start)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/internal/backup"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+// runCommand runs an offline maintenance command instead of the server.
+// The server must not be running on the same root path meanwhile.
+func runCommand(cfg *config.Config, args []string) error {
+	switch args[0] {
+	case "convert":
+		return convert(cfg, args[1:])
+	case "fsck":
+		return fsck(cfg, args[1:])
+	case "restore":
+		return restore(cfg, args[1:])
+	case "compact":
+		return compact(cfg, args[1:])
+	case "migrate":
+		return migrate(cfg, args[1:])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// convert rewrites all node files from the configured storage format into another one.
+func convert(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	to := flags.String("to", disk.FormatBinary, "storage format to convert to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, fromFiles, nodePath, err := storage.OpenNodeFiles(cfg, disk.NewFileAccessor(), cfg.Database.Format)
+	if err != nil {
+		return err
+	}
+	target, f, _, err := storage.OpenNodeFiles(cfg, fromFiles, *to)
+	if err != nil {
+		return err
+	}
+	if from.FileExtension() == target.FileExtension() {
+		return fmt.Errorf("data is already stored as %s", *to)
+	}
+
+	count, err := disk.ConvertFiles(context.Background(), f, nodePath, from, target)
+	fmt.Printf("Converted %d node files to %s\n", count, *to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Set database.format to %q before starting the server\n", *to)
+	return nil
+}
+
+// fsck verifies every node file of the configured storage format and reports
+// corrupt or truncated records. With -quarantine they are moved out of the files.
+func fsck(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	quarantine := flags.Bool("quarantine", false, "move corrupt records into quarantine files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	codec, f, nodePath, err := storage.OpenNodeFiles(cfg, disk.NewFileAccessor(), cfg.Database.Format)
+	if err != nil {
+		return err
+	}
+	nodeTypes, err := f.ListFiles(nodePath, codec.FileExtension())
+	if err != nil {
+		return err
+	}
+
+	corrupt := 0
+	for _, nodeType := range nodeTypes {
+		report, err := codec.CheckFile(context.Background(), f.GetFilePath(nodePath, nodeType+codec.FileExtension()), *quarantine)
+		if err != nil {
+			return err
+		}
+		if report.Healthy() {
+			fmt.Printf("%s: ok, %d records\n", report.File, report.Records)
+			continue
+		}
+
+		corrupt++
+		fmt.Printf("%s: %d records, %d problems\n", report.File, report.Records, len(report.Problems))
+		if report.Header != "" {
+			fmt.Printf("  header: %s\n", report.Header)
+		}
+		for _, problem := range report.Problems {
+			if problem.Line > 0 {
+				fmt.Printf("  offset %d (line %d): %s\n", problem.Offset, problem.Line, problem.Reason)
+			} else {
+				fmt.Printf("  offset %d: %s\n", problem.Offset, problem.Reason)
+			}
+		}
+		if report.Quarantined > 0 {
+			fmt.Printf("  quarantined %d records into %s\n", report.Quarantined, report.File+disk.QuarantineExtension)
+		}
+	}
+
+	if corrupt > 0 {
+		return fmt.Errorf("%d of %d node files failed the check", corrupt, len(nodeTypes))
+	}
+	return nil
+}
+
+// restore verifies a backup archive and loads it into the configured storage engine,
+// then replays the change log of the backed up database when one is given.
+// The root path must be empty, so a restore never mixes with existing data.
+func restore(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	changeLog := flags.String("changelog", "", "change log folder of the backed up database to replay")
+	untilFlag := flags.String("until", "", "replay changes up to this RFC3339 time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: restore [-changelog folder [-until time]] <archive>")
+	}
+
+	var until time.Time
+	if *untilFlag != "" {
+		if *changeLog == "" {
+			return errors.New("-until needs the -changelog to replay")
+		}
+		var err error
+		if until, err = time.Parse(time.RFC3339, *untilFlag); err != nil {
+			return err
+		}
+	}
+
+	rootPath := cmp.Or(cfg.Database.RootPath, ".")
+	entries, err := os.ReadDir(rootPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("root path %q is not empty", rootPath)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	manifest, err := backup.VerifyArchive(file)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() && until.Before(manifest.CreatedAt) {
+		return fmt.Errorf("cannot restore to %s, the backup was taken at %s", until.Format(time.RFC3339), manifest.CreatedAt.Format(time.RFC3339))
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(rootPath, os.ModePerm); err != nil {
+		return err
+	}
+	// The restored database starts a change log of its own once the server runs,
+	// so the restore itself is not logged.
+	restoreCfg := *cfg
+	restoreCfg.Database.ChangeLog = false
+	f := disk.NewFileAccessor()
+	db, err := storage.Open(&restoreCfg, f)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	applied := 0
+	format, err := storage.BlockFormatOf(&restoreCfg, f)
+	if err == nil {
+		err = backup.RestoreArchive(ctx, file, manifest, db, format.Keys)
+	}
+	if err == nil && *changeLog != "" {
+		logFiles := storage.ChangeLogFiles(f, *changeLog, format.Keys)
+		applied, err = backup.ReplayChanges(ctx, logFiles, *changeLog, manifest, until, db)
+		if errors.Is(err, storage.ErrChangesPruned) {
+			err = fmt.Errorf("%w; database.changeLogRetention must cover the oldest backup kept", err)
+		}
+	}
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d node types from the backup of %s\n", len(manifest.Types), manifest.CreatedAt.Format(time.RFC3339))
+	if *changeLog != "" {
+		fmt.Printf("Replayed %d changes from %s\n", applied, *changeLog)
+	}
+	return nil
+}
+
+// compact rewrites every node file of the file engine, which drops the space of
+// replaced nodes and recompresses the file with the configured compression. It also
+// reseals the file with the active encryption key, which completes a key rotation.
+// The lsm engine recompresses and reseals its segments as it compacts them.
+func compact(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("compact", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	codec, f, nodePath, err := storage.OpenNodeFiles(cfg, disk.NewFileAccessor(), cfg.Database.Format)
+	if err != nil {
+		return err
+	}
+	nodeTypes, err := f.ListFiles(nodePath, codec.FileExtension())
+	if err != nil {
+		return err
+	}
+
+	for _, nodeType := range nodeTypes {
+		filePath := f.GetFilePath(nodePath, nodeType+codec.FileExtension())
+		before, err := f.FileSize(filePath)
+		if err != nil {
+			return err
+		}
+		nodes, err := codec.ReadNodesFromFile(ctx, filePath)
+		if err != nil {
+			return err
+		}
+		if err := codec.RewriteNodesToFile(ctx, filePath, nodes); err != nil {
+			return err
+		}
+		after, err := f.FileSize(filePath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d nodes, %d -> %d bytes\n", nodeType, len(nodes), before, after)
+	}
+	return nil
+}
+
+// migrate upgrades the stored data to the storage format of this binary, which the
+// server otherwise does when it starts. With -dry-run it lists the pending steps.
+func migrate(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the pending migrations without running them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	steps, err := storage.Migrate(context.Background(), cfg, disk.NewFileAccessor(), *dryRun)
+	for _, step := range steps {
+		fmt.Printf("format version %d: %s\n", step.Version, step.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(steps) == 0:
+		fmt.Printf("Storage format is up to date at version %d\n", storage.FormatVersion)
+	case *dryRun:
+		fmt.Printf("%d migrations pending to format version %d\n", len(steps), storage.FormatVersion)
+	default:
+		fmt.Printf("Migrated to format version %d\n", storage.FormatVersion)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/jamesdb"
+)
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	source := &config.Config{}
+	source.Database.RootPath = t.TempDir()
+	source.Database.ChangeLog = true
+
+	db, err := jamesdb.OpenConfig(source)
+	require.NoError(t, err)
+	alice := &jamesdb.Node{ID: "a", Type: "person", Name: "alice"}
+	require.NoError(t, db.Insert(ctx, alice))
+	archivePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+	archive, err := os.Create(archivePath)
+	require.NoError(t, err)
+	require.NoError(t, db.Backup(ctx, archive))
+	require.NoError(t, archive.Close())
+	// Changed after the backup, so only a replay of the change log brings it back.
+	require.NoError(t, db.Insert(ctx, &jamesdb.Node{ID: "b", Type: "person", Name: "bob"}))
+	require.NoError(t, db.Close())
+
+	target := &config.Config{}
+	target.Database.RootPath = t.TempDir()
+	require.NoError(t, restore(target, []string{archivePath}))
+	restored, err := jamesdb.OpenConfig(target)
+	require.NoError(t, err)
+	nodes, err := restored.List(ctx, "person")
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, "alice", nodes[0].Name)
+	require.NoError(t, restored.Close())
+
+	// A root path with data in it is never restored into.
+	require.ErrorContains(t, restore(target, []string{archivePath}), "not empty")
+
+	replayed := &config.Config{}
+	replayed.Database.RootPath = t.TempDir()
+	changeLog := filepath.Join(source.Database.RootPath, "changelog")
+	require.NoError(t, restore(replayed, []string{"-changelog", changeLog, archivePath}))
+	restored, err = jamesdb.OpenConfig(replayed)
+	require.NoError(t, err)
+	defer restored.Close()
+	nodes, err = restored.List(ctx, "person")
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+}
+
+func TestRestoreUsage(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+
+	require.ErrorContains(t, restore(cfg, nil), "usage")
+	require.ErrorContains(t, restore(cfg, []string{"-until", "2026-01-01T00:00:00Z", "backup.tar.gz"}), "-changelog")
+	require.Error(t, restore(cfg, []string{filepath.Join(t.TempDir(), "missing.tar.gz")}))
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
@@ -13,10 +14,39 @@ type Config struct {
 	Server struct {
 		Host string `yaml:"host" envconfig:"HOST"`
 		Port int    `yaml:"port" envconfig:"PORT"`
+		// GrpcPort serves the gRPC API next to the REST one; 0 disables it.
+		GrpcPort int `yaml:"grpcPort" envconfig:"GRPC_PORT"`
+
+		IdempotencyWindow time.Duration `yaml:"idempotencyWindow" envconfig:"IDEMPOTENCY_WINDOW"`
 	} `yaml:"server"`
 
 	Database struct {
-		RootPath string `yaml:"rootPath" envconfig:"ROOT_PATH"`
+		RootPath  string `yaml:"rootPath" envconfig:"ROOT_PATH"`
+		Integrity string `yaml:"integrity" envconfig:"INTEGRITY"`
+		Format    string `yaml:"format" envconfig:"FORMAT"`
+		Engine    string `yaml:"engine" envconfig:"ENGINE"`
+		// Durability is always, interval or none; see SyncInterval for interval.
+		Durability   string        `yaml:"durability" envconfig:"DURABILITY"`
+		SyncInterval time.Duration `yaml:"syncInterval" envconfig:"SYNC_INTERVAL"`
+		// Compression is none, snappy or zstd; TypeCompression overrides it per node type.
+		Compression     string            `yaml:"compression" envconfig:"COMPRESSION"`
+		TypeCompression map[string]string `yaml:"typeCompression" envconfig:"TYPE_COMPRESSION"`
+		// EncryptionKey is a comma separated list of base64 AES keys, the first one
+		// encrypting new data; EncryptionKeyFile holds more of them, one per line.
+		EncryptionKey     string `yaml:"encryptionKey" envconfig:"ENCRYPTION_KEY"`
+		EncryptionKeyFile string `yaml:"encryptionKeyFile" envconfig:"ENCRYPTION_KEY_FILE"`
+		// ChangeLog archives every change for point-in-time recovery, keeping the
+		// changes for ChangeLogRetention; 0 keeps them all. A backup can only be
+		// rolled forward while the changes after it are kept, so the retention must
+		// cover the oldest backup kept. The log is off by default.
+		ChangeLog          bool          `yaml:"changeLog" envconfig:"CHANGE_LOG"`
+		ChangeLogRetention time.Duration `yaml:"changeLogRetention" envconfig:"CHANGE_LOG_RETENTION"`
+
+		MaxOpenFiles      int           `yaml:"maxOpenFiles" envconfig:"MAX_OPEN_FILES"`
+		MaxWorkers        int           `yaml:"maxWorkers" envconfig:"MAX_WORKERS"`
+		WorkerIdleTimeout time.Duration `yaml:"workerIdleTimeout" envconfig:"WORKER_IDLE_TIMEOUT"`
+		// CacheSize is the number of bytes of nodes kept in memory; 0 disables the cache.
+		CacheSize int64 `yaml:"cacheSize" envconfig:"CACHE_SIZE"`
 	} `yaml:"database"`
 
 	Logging struct {
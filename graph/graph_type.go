@@ -5,7 +5,10 @@ type CsvHeader string
 // do not create this dynamically
 // need this to be this exact order for data sanity / backwards compatibility
 // I already know about `csvutil.Header(Node{}, "json")`
-const NodeCsvHeader = "id,type,name,edges,traits\n"
+const NodeCsvHeader = "id,type,name,edges,traits,version\n"
+
+// LegacyNodeCsvHeader is the header of files written before nodes were versioned.
+const LegacyNodeCsvHeader = "id,type,name,edges,traits\n"
 
 type Node struct {
 	ID     string            `json:"id"`
@@ -13,4 +16,6 @@ type Node struct {
 	Name   string            `json:"name" binding:"required"`
 	Edges  []string          `json:"edges,omitempty"`
 	Traits map[string]string `json:"traits,omitempty"`
+	// Version starts at 1 and is incremented by every update of the node.
+	Version int64 `json:"version"`
 }
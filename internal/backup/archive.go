@@ -0,0 +1,295 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+// An archive is a gzip compressed tar file holding one binary node file per type
+// under nodesDir, followed by the manifest describing them. The node files of an
+// encrypted database are written in blocks sealed with its active key.
+const (
+	ManifestFile    = "manifest.json"
+	ManifestVersion = 1
+
+	nodesDir = "nodes"
+	nodesExt = ".jdb"
+)
+
+var ErrInvalidArchive = errors.New("invalid backup archive")
+
+var validNodeType = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ChangeSeq is the last change of the change log contained in the backup for
+	// every type. Types are read one after another, so each has a ChangeSeq of its own.
+	ChangeSeq uint64      `json:"changeSeq,omitempty"`
+	Types     []TypeEntry `json:"types"`
+}
+
+type TypeEntry struct {
+	Type   string `json:"type"`
+	File   string `json:"file"`
+	Nodes  int    `json:"nodes"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// ChangeSeq is the last change of the change log contained in the nodes of the type.
+	ChangeSeq uint64 `json:"changeSeq,omitempty"`
+	// Sealed tells that the file is in sealed blocks; Size and SHA256 are of the blocks.
+	Sealed bool `json:"sealed,omitempty"`
+}
+
+// changeSeqOf returns the last change of a type contained in the backup. Types
+// missing from the backup only contain the changes before it started.
+func (m *Manifest) changeSeqOf(nodeType string) uint64 {
+	for _, entry := range m.Types {
+		if entry.Type == nodeType {
+			return max(entry.ChangeSeq, m.ChangeSeq)
+		}
+	}
+	return m.ChangeSeq
+}
+
+// ArchiveWriter writes an archive a type at a time, so no more than one type is
+// held in memory.
+type ArchiveWriter struct {
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	keys     *disk.Keyring
+	manifest *Manifest
+}
+
+// NewArchiveWriter starts an archive of a backup taken after the change changeSeq,
+// sealing the node files with keys unless they are nil.
+func NewArchiveWriter(w io.Writer, createdAt time.Time, changeSeq uint64, keys *disk.Keyring) *ArchiveWriter {
+	gz := gzip.NewWriter(w)
+	return &ArchiveWriter{
+		gz:       gz,
+		tw:       tar.NewWriter(gz),
+		keys:     keys,
+		manifest: &Manifest{Version: ManifestVersion, CreatedAt: createdAt, ChangeSeq: changeSeq, Types: []TypeEntry{}},
+	}
+}
+
+// WriteType adds the nodes of a type, read after the change changeSeq, to the archive.
+func (aw *ArchiveWriter) WriteType(nodeType string, nodes []graph.Node, changeSeq uint64) error {
+	var buf bytes.Buffer
+	if err := disk.WriteBinary(&buf, nodes); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if aw.keys != nil {
+		var sealed bytes.Buffer
+		bw, err := disk.NewBlockWriter(&sealed, disk.BlockFormat{Keys: aw.keys})
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+		if err := bw.Close(); err != nil {
+			return err
+		}
+		data = sealed.Bytes()
+	}
+
+	sum := sha256.Sum256(data)
+	entry := TypeEntry{
+		Type:      nodeType,
+		File:      path.Join(nodesDir, nodeType+nodesExt),
+		Nodes:     len(nodes),
+		Size:      int64(len(data)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		ChangeSeq: changeSeq,
+		Sealed:    aw.keys != nil,
+	}
+	if err := writeEntry(aw.tw, entry.File, aw.manifest.CreatedAt, data); err != nil {
+		return err
+	}
+	aw.manifest.Types = append(aw.manifest.Types, entry)
+	return nil
+}
+
+// Close ends the archive with its manifest and returns the manifest.
+func (aw *ArchiveWriter) Close() (*Manifest, error) {
+	data, err := json.MarshalIndent(aw.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(aw.tw, ManifestFile, aw.manifest.CreatedAt, data); err != nil {
+		return nil, err
+	}
+	if err := aw.tw.Close(); err != nil {
+		return nil, err
+	}
+	return aw.manifest, aw.gz.Close()
+}
+
+// WriteArchive writes an archive of the given types of the engine, sealed with keys
+// unless they are nil, and returns its manifest. The caller must keep writers away
+// until it returns for the archive to be consistent.
+func WriteArchive(ctx context.Context, w io.Writer, engine storage.Engine, nodeTypes []string, keys *disk.Keyring) (*Manifest, error) {
+	var changeSeq uint64
+	if logger, ok := storage.AsChangeLogger(engine); ok {
+		changeSeq = logger.ChangeSeq()
+	}
+
+	aw := NewArchiveWriter(w, time.Now().UTC(), changeSeq, keys)
+	for _, nodeType := range nodeTypes {
+		it, err := engine.Scan(ctx, nodeType)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := storage.ReadAll(it)
+		if err != nil {
+			return nil, err
+		}
+		if err := aw.WriteType(nodeType, nodes, changeSeq); err != nil {
+			return nil, err
+		}
+	}
+	return aw.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// VerifyArchive reads a whole archive and checks every node file against the manifest.
+func VerifyArchive(r io.Reader) (*Manifest, error) {
+	sums := make(map[string]TypeEntry)
+	var manifest *Manifest
+
+	err := walkArchive(r, func(header *tar.Header, tr io.Reader) error {
+		if header.Name == ManifestFile {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+			}
+			return nil
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(hash, tr)
+		if err != nil {
+			return err
+		}
+		sums[header.Name] = TypeEntry{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, ManifestFile)
+	}
+	if manifest.Version != ManifestVersion {
+		return nil, fmt.Errorf("%w: unsupported manifest version %d", ErrInvalidArchive, manifest.Version)
+	}
+	for _, entry := range manifest.Types {
+		if !validNodeType.MatchString(entry.Type) || entry.File != path.Join(nodesDir, entry.Type+nodesExt) {
+			return nil, fmt.Errorf("%w: bad entry for type %q", ErrInvalidArchive, entry.Type)
+		}
+		sum, exists := sums[entry.File]
+		if !exists {
+			return nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, entry.File)
+		}
+		if sum.Size != entry.Size || sum.SHA256 != entry.SHA256 {
+			return nil, fmt.Errorf("%w: checksum mismatch for %s", ErrInvalidArchive, entry.File)
+		}
+		delete(sums, entry.File)
+	}
+	for name := range sums {
+		return nil, fmt.Errorf("%w: unexpected file %s", ErrInvalidArchive, name)
+	}
+	return manifest, nil
+}
+
+// RestoreArchive inserts the nodes of an archive that passed VerifyArchive into the
+// engine, opening sealed node files with keys.
+func RestoreArchive(ctx context.Context, r io.Reader, manifest *Manifest, engine storage.Engine, keys *disk.Keyring) error {
+	types := make(map[string]TypeEntry, len(manifest.Types))
+	for _, entry := range manifest.Types {
+		types[entry.File] = entry
+	}
+
+	return walkArchive(r, func(header *tar.Header, tr io.Reader) error {
+		entry, exists := types[header.Name]
+		if !exists {
+			return nil
+		}
+		nodeType := entry.Type
+		if entry.Sealed {
+			if keys == nil {
+				return fmt.Errorf("%w: %s is sealed", disk.ErrMissingKey, header.Name)
+			}
+			var err error
+			if tr, err = disk.NewBlockReader(tr, disk.BlockFormat{Keys: keys}); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, header.Name, err)
+			}
+		}
+		nodes, err := disk.ReadBinary(bufio.NewReader(tr))
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, header.Name, err)
+		}
+		if len(nodes) == 0 {
+			return nil
+		}
+		return engine.Insert(ctx, nodeType, nodes)
+	})
+}
+
+func walkArchive(r io.Reader, fn func(header *tar.Header, tr io.Reader) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+func newSourceEngine(t *testing.T) storage.Engine {
+	ctx := context.Background()
+	engine := storage.NewMemoryEngine()
+	require.NoError(t, engine.Insert(ctx, "person", []graph.Node{
+		{ID: "a", Type: "person", Name: "alice", Edges: []string{"p"}, Version: 2},
+		{ID: "b", Type: "person", Name: "bob", Traits: map[string]string{"age": "30"}, Version: 1},
+	}))
+	require.NoError(t, engine.Insert(ctx, "pet", []graph.Node{{ID: "p", Type: "pet", Name: "rex", Version: 1}}))
+	return engine
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := newSourceEngine(t)
+
+	var buf bytes.Buffer
+	written, err := WriteArchive(ctx, &buf, source, []string{"person", "pet"}, nil)
+	require.NoError(t, err)
+	require.Len(t, written.Types, 2)
+	require.Equal(t, 2, written.Types[0].Nodes)
+
+	manifest, err := VerifyArchive(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, written.Types, manifest.Types)
+	require.True(t, written.CreatedAt.Equal(manifest.CreatedAt))
+
+	target := storage.NewMemoryEngine()
+	require.NoError(t, RestoreArchive(ctx, bytes.NewReader(buf.Bytes()), manifest, target, nil))
+	for _, nodeType := range []string{"person", "pet"} {
+		want, err := source.Scan(ctx, nodeType)
+		require.NoError(t, err)
+		got, err := target.Scan(ctx, nodeType)
+		require.NoError(t, err)
+		wantNodes, err := storage.ReadAll(want)
+		require.NoError(t, err)
+		gotNodes, err := storage.ReadAll(got)
+		require.NoError(t, err)
+		require.Equal(t, wantNodes, gotNodes)
+	}
+}
+
+func TestSealedArchive(t *testing.T) {
+	ctx := context.Background()
+	key := sha256.Sum256([]byte("key"))
+	keys, err := disk.LoadKeyring(base64.StdEncoding.EncodeToString(key[:]), "")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = WriteArchive(ctx, &buf, newSourceEngine(t), []string{"person"}, keys)
+	require.NoError(t, err)
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "alice")
+
+	// The archive is verified without the key, but restored only with it.
+	manifest, err := VerifyArchive(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.True(t, manifest.Types[0].Sealed)
+	err = RestoreArchive(ctx, bytes.NewReader(buf.Bytes()), manifest, storage.NewMemoryEngine(), nil)
+	require.ErrorIs(t, err, disk.ErrMissingKey)
+
+	target := storage.NewMemoryEngine()
+	require.NoError(t, RestoreArchive(ctx, bytes.NewReader(buf.Bytes()), manifest, target, keys))
+	node, err := target.Get(ctx, "person", "a")
+	require.NoError(t, err)
+	require.Equal(t, "alice", node.Name)
+}
+
+// rewriteArchive copies an archive, letting change replace the content of every file.
+func rewriteArchive(t *testing.T, data []byte, change func(name string, content []byte) []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err := walkArchive(bytes.NewReader(data), func(header *tar.Header, tr io.Reader) error {
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		return writeEntry(tw, header.Name, header.ModTime, change(header.Name, content))
+	})
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestVerifyArchiveDetectsTampering(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := WriteArchive(context.Background(), &buf, newSourceEngine(t), []string{"person"}, nil)
+	require.NoError(t, err)
+
+	tampered := rewriteArchive(t, buf.Bytes(), func(name string, content []byte) []byte {
+		if name == "nodes/person.jdb" {
+			content[len(content)-1] ^= 0xff
+		}
+		return content
+	})
+	_, err = VerifyArchive(bytes.NewReader(tampered))
+	require.ErrorIs(t, err, ErrInvalidArchive)
+	require.ErrorContains(t, err, "checksum mismatch")
+
+	escaping := rewriteArchive(t, buf.Bytes(), func(name string, content []byte) []byte {
+		return bytes.ReplaceAll(content, []byte(`"person"`), []byte(`"../person"`))
+	})
+	_, err = VerifyArchive(bytes.NewReader(escaping))
+	require.ErrorIs(t, err, ErrInvalidArchive)
+
+	_, err = VerifyArchive(bytes.NewReader([]byte("not an archive")))
+	require.ErrorIs(t, err, ErrInvalidArchive)
+}
@@ -0,0 +1,38 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+var errReplayDone = errors.New("replay done")
+
+// ReplayChanges applies the changes of the change log in logPath that follow the
+// backup described by the manifest, up to and including until. A change follows
+// the backup when it is newer than the read of its type. A zero until
+// replays the whole log. It returns the number of changes applied, or
+// storage.ErrChangesPruned when the log no longer reaches back to the backup.
+func ReplayChanges(ctx context.Context, f disk.FileAccessor, logPath string, manifest *Manifest, until time.Time, engine storage.Engine) (int, error) {
+	applied := 0
+	err := storage.ReadChangesAfter(f, logPath, manifest.ChangeSeq, func(c storage.Change) error {
+		if c.Seq <= manifest.changeSeqOf(c.Type) {
+			return nil
+		}
+		if !until.IsZero() && c.Time.After(until) {
+			return errReplayDone
+		}
+		if err := storage.ApplyChange(ctx, engine, c); err != nil {
+			return err
+		}
+		applied++
+		return nil
+	})
+	if errors.Is(err, errReplayDone) {
+		err = nil
+	}
+	return applied, err
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+func TestReplayChangesUntil(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	logPath := t.TempDir()
+
+	log, err := storage.OpenChangeLog(f, logPath, 0)
+	require.NoError(t, err)
+	source := storage.NewLoggingEngine(storage.NewMemoryEngine(), log)
+	defer source.Close()
+
+	require.NoError(t, source.Insert(ctx, "person", []graph.Node{{ID: "a", Type: "person", Name: "alice", Version: 1}}))
+	var archive bytes.Buffer
+	manifest, err := WriteArchive(ctx, &archive, source, []string{"person"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), manifest.ChangeSeq)
+
+	require.NoError(t, source.Insert(ctx, "person", []graph.Node{{ID: "b", Type: "person", Name: "bob", Version: 1}}))
+	time.Sleep(10 * time.Millisecond)
+	beforeDelete := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	// The accidental bulk delete to recover from.
+	require.NoError(t, source.DropType(ctx, "person"))
+
+	restore := func(until time.Time) []graph.Node {
+		target := storage.NewMemoryEngine()
+		require.NoError(t, RestoreArchive(ctx, bytes.NewReader(archive.Bytes()), manifest, target, nil))
+		_, err := ReplayChanges(ctx, f, logPath, manifest, until, target)
+		require.NoError(t, err)
+		it, err := target.Scan(ctx, "person")
+		require.NoError(t, err)
+		nodes, err := storage.ReadAll(it)
+		require.NoError(t, err)
+		return nodes
+	}
+
+	nodes := restore(beforeDelete)
+	require.Len(t, nodes, 2)
+	require.Empty(t, restore(time.Time{}))
+}
+
+func TestReplayChangesPerType(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	logPath := t.TempDir()
+
+	log, err := storage.OpenChangeLog(f, logPath, 0)
+	require.NoError(t, err)
+	source := storage.NewLoggingEngine(storage.NewMemoryEngine(), log)
+	defer source.Close()
+
+	// The pet type is read after a change that came after the read of the person type.
+	var archive bytes.Buffer
+	aw := NewArchiveWriter(&archive, time.Now().UTC(), 0, nil)
+	require.NoError(t, aw.WriteType("person", nil, 0))
+	require.NoError(t, source.Insert(ctx, "pet", []graph.Node{{ID: "p", Type: "pet", Name: "rex", Version: 1}}))
+	require.NoError(t, aw.WriteType("pet", []graph.Node{{ID: "p", Type: "pet", Name: "rex", Version: 1}}, 1))
+	require.NoError(t, source.Insert(ctx, "person", []graph.Node{{ID: "a", Type: "person", Name: "alice", Version: 1}}))
+	manifest, err := aw.Close()
+	require.NoError(t, err)
+
+	target := storage.NewMemoryEngine()
+	require.NoError(t, RestoreArchive(ctx, bytes.NewReader(archive.Bytes()), manifest, target, nil))
+	applied, err := ReplayChanges(ctx, f, logPath, manifest, time.Time{}, target)
+	require.NoError(t, err)
+	require.Equal(t, 1, applied)
+
+	for _, nodeType := range []string{"person", "pet"} {
+		it, err := target.Scan(ctx, nodeType)
+		require.NoError(t, err)
+		nodes, err := storage.ReadAll(it)
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+	}
+}
+
+func TestReplayChangesPrunedPastBackup(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	logPath := t.TempDir()
+	const retention = time.Millisecond
+
+	log, err := storage.OpenChangeLog(f, logPath, retention)
+	require.NoError(t, err)
+	source := storage.NewLoggingEngine(storage.NewMemoryEngine(), log)
+	require.NoError(t, source.Insert(ctx, "person", []graph.Node{{ID: "a", Type: "person", Name: "alice", Version: 1}}))
+	var archive bytes.Buffer
+	manifest, err := WriteArchive(ctx, &archive, source, []string{"person"}, nil)
+	require.NoError(t, err)
+
+	// The backup outlives the retention: every open starts a segment and prunes the
+	// ones holding only changes older than the retention.
+	for i := range 5 {
+		require.NoError(t, source.Insert(ctx, "person", []graph.Node{{ID: fmt.Sprint(i), Type: "person", Name: "bob", Version: 1}}))
+		require.NoError(t, source.Close())
+		time.Sleep(5 * retention)
+		log, err = storage.OpenChangeLog(f, logPath, retention)
+		require.NoError(t, err)
+		source = storage.NewLoggingEngine(storage.NewMemoryEngine(), log)
+	}
+	defer source.Close()
+
+	target := storage.NewMemoryEngine()
+	require.NoError(t, RestoreArchive(ctx, bytes.NewReader(archive.Bytes()), manifest, target, nil))
+	_, err = ReplayChanges(ctx, f, logPath, manifest, time.Time{}, target)
+	require.ErrorIs(t, err, storage.ErrChangesPruned)
+}
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/grapher"
+	"github.com/zmjung/jamesdb/internal/idempotency"
+	"github.com/zmjung/jamesdb/internal/instance"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+// Named databases live in folders of their own under the databases folder of the
+// root path, next to the data of the default database. Each folder holds the
+// settings of its database and is opened like a root path of its own.
+const (
+	FolderName   = "databases"
+	SettingsFile = "settings.json"
+)
+
+var (
+	ErrInvalidName      = errors.New("invalid database name")
+	ErrDatabaseExists   = errors.New("database already exists")
+	ErrDatabaseNotFound = errors.New("database not found")
+	ErrManagerClosed    = errors.New("database manager is closed")
+	ErrInvalidSettings  = errors.New("invalid database settings")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Settings override the server configuration for a named database. Unset fields
+// keep the configured value.
+type Settings struct {
+	Engine      string `json:"engine,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Integrity   string `json:"integrity,omitempty"`
+	Durability  string `json:"durability,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	ChangeLog   *bool  `json:"changeLog,omitempty"`
+	MaxWorkers  int    `json:"maxWorkers,omitempty"`
+	CacheSize   int64  `json:"cacheSize,omitempty"`
+}
+
+// Validate rejects settings the database could not be opened with.
+func (s Settings) Validate() error {
+	if !slices.Contains([]string{"", storage.EngineFile, storage.EngineLsm, storage.EngineMemory}, s.Engine) {
+		return fmt.Errorf("%w: unknown engine %q", ErrInvalidSettings, s.Engine)
+	}
+	if !slices.Contains([]string{"", disk.FormatCsv, disk.FormatBinary}, s.Format) {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidSettings, s.Format)
+	}
+	if s.Integrity != "" {
+		if _, err := grapher.ParseIntegrityMode(s.Integrity); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+		}
+	}
+	if !slices.Contains([]string{"", disk.DurabilityAlways, disk.DurabilityInterval, disk.DurabilityNone}, s.Durability) {
+		return fmt.Errorf("%w: unknown durability %q", ErrInvalidSettings, s.Durability)
+	}
+	if err := (disk.Compression{Default: s.Compression}).Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	if s.MaxWorkers < 0 || s.CacheSize < 0 {
+		return fmt.Errorf("%w: limits cannot be negative", ErrInvalidSettings)
+	}
+	return nil
+}
+
+// apply returns the configuration of a database in path with the settings applied.
+func (s Settings) apply(base *config.Config, path string) *config.Config {
+	cfg := *base
+	cfg.Database.RootPath = path
+	if s.Engine != "" {
+		cfg.Database.Engine = s.Engine
+	}
+	if s.Format != "" {
+		cfg.Database.Format = s.Format
+	}
+	if s.Integrity != "" {
+		cfg.Database.Integrity = s.Integrity
+	}
+	if s.Durability != "" {
+		cfg.Database.Durability = s.Durability
+	}
+	if s.Compression != "" {
+		cfg.Database.Compression = s.Compression
+	}
+	if s.ChangeLog != nil {
+		cfg.Database.ChangeLog = *s.ChangeLog
+	}
+	if s.MaxWorkers > 0 {
+		cfg.Database.MaxWorkers = s.MaxWorkers
+	}
+	if s.CacheSize > 0 {
+		cfg.Database.CacheSize = s.CacheSize
+	}
+	return &cfg
+}
+
+// Database is an open named database with its own files, workers and idempotency records.
+type Database struct {
+	Name     string
+	Settings Settings
+	DB       *instance.Instance
+	Store    *idempotency.Store
+
+	// users holds a read lock while a request uses the database, so dropping
+	// it waits for the requests in flight.
+	users sync.RWMutex
+}
+
+func (db *Database) close() error {
+	return db.DB.Close()
+}
+
+// Manager opens, creates and drops the named databases of the server.
+type Manager struct {
+	cfg  *config.Config
+	path string
+
+	lock      sync.RWMutex
+	databases map[string]*Database
+	// reserved holds the names of databases being created or dropped, which cannot be
+	// created meanwhile. Their files are handled outside the lock.
+	reserved map[string]bool
+	closed   bool
+}
+
+// NewManager opens every named database found under the root path.
+func NewManager(cfg *config.Config) (*Manager, error) {
+	path := filepath.Join(cfg.Database.RootPath, FolderName)
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		cfg:       cfg,
+		path:      path,
+		databases: make(map[string]*Database),
+		reserved:  make(map[string]bool),
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !namePattern.MatchString(entry.Name()) {
+			continue
+		}
+		settings, err := readSettings(filepath.Join(path, entry.Name()))
+		if os.IsNotExist(err) {
+			// Left behind by a create that failed halfway.
+			continue
+		}
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("database %s: %w", entry.Name(), err), m.Close())
+		}
+		db, err := m.open(entry.Name(), settings)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("database %s: %w", entry.Name(), err), m.Close())
+		}
+		m.databases[db.Name] = db
+	}
+	slog.Info("Opened named databases", "count", len(m.databases))
+	return m, nil
+}
+
+func readSettings(path string) (Settings, error) {
+	var settings Settings
+	data, err := os.ReadFile(filepath.Join(path, SettingsFile))
+	if err != nil {
+		return settings, err
+	}
+	err = json.Unmarshal(data, &settings)
+	return settings, err
+}
+
+func writeSettings(path string, settings Settings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	filePath := filepath.Join(path, SettingsFile)
+	if err := os.WriteFile(filePath+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(filePath+".tmp", filePath)
+}
+
+func (m *Manager) open(name string, settings Settings) (*Database, error) {
+	cfg := settings.apply(m.cfg, filepath.Join(m.path, name))
+	db, err := instance.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store, err := idempotency.NewStore(db.Handles, cfg.Database.RootPath, cfg.Server.IdempotencyWindow, db.Keys)
+	if err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+
+	return &Database{
+		Name:     name,
+		Settings: settings,
+		DB:       db,
+		Store:    store,
+	}, nil
+}
+
+// Create creates and opens a named database with the settings.
+func (m *Manager) Create(name string, settings Settings) (*Database, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+
+	// The name is reserved under the lock and the database opened outside it, so
+	// requests to other databases are not held up by the files being created.
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil, ErrManagerClosed
+	}
+	if _, exists := m.databases[name]; exists || m.reserved[name] {
+		m.lock.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseExists, name)
+	}
+	m.reserved[name] = true
+	m.lock.Unlock()
+
+	db, err := m.create(name, settings)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.reserved, name)
+	if err != nil {
+		return nil, err
+	}
+	if m.closed {
+		return nil, errors.Join(ErrManagerClosed, db.close())
+	}
+	slog.Info("Created database", "name", name)
+	m.databases[name] = db
+	return db, nil
+}
+
+// create writes the files of a new database and opens it.
+func (m *Manager) create(name string, settings Settings) (*Database, error) {
+	path := filepath.Join(m.path, name)
+	if err := os.RemoveAll(path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := m.open(name, settings)
+	if err == nil {
+		// The settings are written last, so a failed create leaves no database behind.
+		if err = writeSettings(path, settings); err != nil {
+			err = errors.Join(err, db.close())
+		}
+	}
+	if err != nil {
+		return nil, errors.Join(err, os.RemoveAll(path))
+	}
+	return db, nil
+}
+
+// Acquire returns the named database, which cannot be dropped until release is called.
+func (m *Manager) Acquire(name string) (*Database, func(), error) {
+	m.lock.RLock()
+	db, exists := m.databases[name]
+	if exists {
+		db.users.RLock()
+	}
+	m.lock.RUnlock()
+
+	if !exists {
+		return nil, nil, fmt.Errorf("%w: %s", ErrDatabaseNotFound, name)
+	}
+	return db, db.users.RUnlock, nil
+}
+
+// List returns the open named databases sorted by name.
+func (m *Manager) List() []*Database {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	databases := make([]*Database, 0, len(m.databases))
+	for _, db := range m.databases {
+		databases = append(databases, db)
+	}
+	slices.SortFunc(databases, func(a, b *Database) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return databases
+}
+
+// Drop closes the named database once no request uses it and deletes its data.
+func (m *Manager) Drop(name string) error {
+	m.lock.Lock()
+	db, exists := m.databases[name]
+	if exists {
+		delete(m.databases, name)
+		m.reserved[name] = true
+	}
+	m.lock.Unlock()
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrDatabaseNotFound, name)
+	}
+	defer func() {
+		m.lock.Lock()
+		delete(m.reserved, name)
+		m.lock.Unlock()
+	}()
+
+	db.users.Lock()
+	defer db.users.Unlock()
+	if err := db.close(); err != nil {
+		slog.Error("Error closing dropped database", "name", name, "error", err)
+	}
+	slog.Info("Dropped database", "name", name)
+	return os.RemoveAll(db.DB.Config.Database.RootPath)
+}
+
+// Close closes every named database.
+func (m *Manager) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.closed = true
+	var errs []error
+	for name, db := range m.databases {
+		db.users.Lock()
+		errs = append(errs, db.close())
+		db.users.Unlock()
+		delete(m.databases, name)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+func newTestManager(t *testing.T, rootPath string) *Manager {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = rootPath
+	m, err := NewManager(cfg)
+	require.NoError(t, err)
+	return m
+}
+
+func TestDatabasesAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, t.TempDir())
+	defer m.Close()
+
+	sales, err := m.Create("sales", Settings{})
+	require.NoError(t, err)
+	hr, err := m.Create("hr", Settings{Engine: storage.EngineLsm})
+	require.NoError(t, err)
+
+	require.NoError(t, sales.DB.Grapher.WriteNode(ctx, &graph.Node{ID: "a", Type: "customer", Name: "alice"}))
+	types, err := hr.DB.Grapher.ListTypes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, types)
+
+	require.Equal(t, []*Database{hr, sales}, m.List())
+	_, err = m.Create("sales", Settings{})
+	require.ErrorIs(t, err, ErrDatabaseExists)
+	_, err = m.Create("../sales", Settings{})
+	require.ErrorIs(t, err, ErrInvalidName)
+	_, err = m.Create("ops", Settings{Engine: "rocks"})
+	require.ErrorIs(t, err, ErrInvalidSettings)
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	defer m.Close()
+
+	// Only one of the creates of a name wins, while the others see it reserved or created.
+	errs := make(chan error, 4)
+	for range cap(errs) {
+		go func() {
+			_, err := m.Create("sales", Settings{})
+			errs <- err
+		}()
+	}
+	created := 0
+	for range cap(errs) {
+		err := <-errs
+		if err == nil {
+			created++
+			continue
+		}
+		require.ErrorIs(t, err, ErrDatabaseExists)
+	}
+	require.Equal(t, 1, created)
+	require.Len(t, m.List(), 1)
+}
+
+func TestDatabasesAreReopened(t *testing.T) {
+	ctx := context.Background()
+	rootPath := t.TempDir()
+	m := newTestManager(t, rootPath)
+	sales, err := m.Create("sales", Settings{Format: "binary"})
+	require.NoError(t, err)
+	require.NoError(t, sales.DB.Grapher.WriteNode(ctx, &graph.Node{ID: "a", Type: "customer", Name: "alice"}))
+	require.NoError(t, m.Close())
+
+	// A folder without settings is left over from a failed create and ignored.
+	require.NoError(t, os.MkdirAll(filepath.Join(rootPath, FolderName, "broken"), os.ModePerm))
+
+	m = newTestManager(t, rootPath)
+	defer m.Close()
+	require.Len(t, m.List(), 1)
+	sales, release, err := m.Acquire("sales")
+	require.NoError(t, err)
+	defer release()
+	require.Equal(t, Settings{Format: "binary"}, sales.Settings)
+	node, err := sales.DB.Grapher.ReadNode(ctx, "customer", "a")
+	require.NoError(t, err)
+	require.Equal(t, "alice", node.Name)
+}
+
+func TestDropDatabase(t *testing.T) {
+	rootPath := t.TempDir()
+	m := newTestManager(t, rootPath)
+	defer m.Close()
+	_, err := m.Create("sales", Settings{})
+	require.NoError(t, err)
+
+	_, release, err := m.Acquire("sales")
+	require.NoError(t, err)
+	dropped := make(chan error)
+	go func() { dropped <- m.Drop("sales") }()
+
+	// The drop waits for the request using the database.
+	select {
+	case <-dropped:
+		t.Fatal("database was dropped while in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	require.NoError(t, <-dropped)
+
+	_, err = os.Stat(filepath.Join(rootPath, FolderName, "sales"))
+	require.True(t, os.IsNotExist(err))
+	_, _, err = m.Acquire("sales")
+	require.ErrorIs(t, err, ErrDatabaseNotFound)
+	require.ErrorIs(t, m.Drop("sales"), ErrDatabaseNotFound)
+}
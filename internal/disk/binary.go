@@ -0,0 +1,370 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/zmjung/jamesdb/graph"
+)
+
+// A binary node file starts with a magic string and a format version byte,
+// followed by records. Every record is its length as an uvarint and the node:
+// id, type and name as length prefixed strings, the edge count and edges,
+// the trait count and sorted key/value pairs, and the version as a varint.
+// Since format version 2 every record ends with the CRC32C of the node as
+// 4 big endian bytes.
+const (
+	binaryMagic = "JDBN"
+
+	BinaryFormatVersion byte = 2
+
+	binaryHeaderSize = len(binaryMagic) + 1
+	recordSumSize    = 4
+)
+
+var ErrInvalidBinaryFile = errors.New("invalid binary node file")
+
+// maxRecordSize guards against allocating huge buffers for a corrupt length prefix.
+const maxRecordSize = 64 << 20
+
+type binaryService struct {
+	f FileAccessor
+}
+
+func NewBinaryAccessor(f FileAccessor) NodeAccessor {
+	return &binaryService{
+		f: f,
+	}
+}
+
+func (s *binaryService) FileExtension() string {
+	return ".jdb"
+}
+
+func (s *binaryService) ReadNodesFromFile(ctx context.Context, filePath string) ([]graph.Node, error) {
+	slog.InfoContext(ctx, "Reading from file", "filePath", filePath)
+
+	reader, err := s.f.GetFileReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ReadBinary(bufio.NewReader(reader))
+}
+
+func (s *binaryService) AppendNodesToFile(ctx context.Context, filePath string, nodes []graph.Node) error {
+	slog.InfoContext(ctx, "Writing to file", "filePath", filePath)
+
+	writer, err := s.f.GetFileWriter(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	// One write per commit keeps the records of a commit together.
+	if _, err := writer.Write(EncodeNodes(nodes)); err != nil {
+		return err
+	}
+	return s.f.Sync(filePath, writer)
+}
+
+func (s *binaryService) RewriteNodesToFile(ctx context.Context, filePath string, nodes []graph.Node) error {
+	slog.InfoContext(ctx, "Rewriting file", "filePath", filePath, "count", len(nodes))
+
+	tmpPath := filePath + ".tmp"
+	writer, err := s.f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(append(binaryHeader(), EncodeNodes(nodes)...))
+	if err == nil {
+		err = s.f.Sync(tmpPath, writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.f.RenameFile(tmpPath, filePath)
+}
+
+func (s *binaryService) CreateFileWithHeader(ctx context.Context, filePath string) error {
+	isFileEmpty, err := s.f.IsFileEmpty(filePath)
+	if err != nil || !isFileEmpty {
+		return err
+	}
+
+	writer, err := s.f.GetFileWriter(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(binaryHeader())
+	return err
+}
+
+// IsLegacyFile reports whether the file was written before records were checksummed.
+func (s *binaryService) IsLegacyFile(ctx context.Context, filePath string) (bool, error) {
+	reader, err := s.f.GetFileReader(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	header := make([]byte, binaryHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return false, nil
+	}
+	return string(header[:len(binaryMagic)]) == binaryMagic && header[len(binaryMagic)] < BinaryFormatVersion, nil
+}
+
+// CheckFile verifies the header and every record of a binary node file. A bad record
+// length makes the rest of the file unreadable, so it is reported and quarantined as one.
+func (s *binaryService) CheckFile(ctx context.Context, filePath string, quarantine bool) (*FileReport, error) {
+	data, err := readFile(s.f, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FileReport{File: filePath}
+	if err := checkTornBlock(ctx, s.f, filePath, quarantine, report); err != nil {
+		return report, err
+	}
+	if len(data) == 0 {
+		return report, nil
+	}
+	if len(data) < binaryHeaderSize || string(data[:len(binaryMagic)]) != binaryMagic {
+		report.Header = "bad magic"
+		return report, nil
+	}
+	version := data[len(binaryMagic)]
+	switch {
+	case version == BinaryFormatVersion:
+	case version == 1:
+		report.Header = "outdated format version 1, records are not checksummed"
+	default:
+		report.Header = fmt.Sprintf("unsupported format version %d", version)
+		return report, nil
+	}
+
+	var good, bad [][]byte
+	for offset := binaryHeaderSize; offset < len(data); {
+		report.Records++
+		size, n := binary.Uvarint(data[offset:])
+		if n <= 0 || size > maxRecordSize {
+			report.addProblem(int64(offset), 0, "bad record length")
+			bad = append(bad, data[offset:])
+			break
+		}
+		end := offset + n + int(size)
+		if version >= 2 {
+			end += recordSumSize
+		}
+		if end > len(data) {
+			report.addProblem(int64(offset), 0, "truncated record")
+			bad = append(bad, data[offset:])
+			break
+		}
+
+		record := data[offset+n : offset+n+int(size)]
+		if version >= 2 && binary.BigEndian.Uint32(data[end-recordSumSize:end]) != checksum(record) {
+			report.addProblem(int64(offset), 0, "%s", ErrChecksumMismatch)
+			bad = append(bad, data[offset:end])
+		} else if _, err := DecodeNode(record); err != nil {
+			report.addProblem(int64(offset), 0, "%v", err)
+			bad = append(bad, data[offset:end])
+		} else {
+			good = append(good, data[offset:end])
+		}
+		offset = end
+	}
+
+	if quarantine && len(bad) > 0 {
+		slog.WarnContext(ctx, "Quarantining corrupt records", "filePath", filePath, "count", len(bad))
+		if err := quarantineRecords(s.f, filePath, data[:binaryHeaderSize], good, bad); err != nil {
+			return report, err
+		}
+		report.Quarantined = len(bad)
+	}
+	return report, nil
+}
+
+func binaryHeader() []byte {
+	return append([]byte(binaryMagic), BinaryFormatVersion)
+}
+
+// ReadBinary decodes a whole binary node file, header included.
+func ReadBinary(r *bufio.Reader) ([]graph.Node, error) {
+	header := make([]byte, binaryHeaderSize)
+	if _, err := io.ReadFull(r, header); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBinaryFile, err)
+	}
+	if string(header[:len(binaryMagic)]) != binaryMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidBinaryFile)
+	}
+	version := header[len(binaryMagic)]
+	if version < 1 || version > BinaryFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidBinaryFile, version)
+	}
+
+	var nodes []graph.Node
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nodes, nil
+		}
+		if err != nil || size > maxRecordSize {
+			return nil, fmt.Errorf("%w: bad record length after %d records", ErrInvalidBinaryFile, len(nodes))
+		}
+
+		record := make([]byte, size)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, fmt.Errorf("%w: truncated record after %d records", ErrInvalidBinaryFile, len(nodes))
+		}
+		if version >= 2 {
+			var sum [recordSumSize]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				return nil, fmt.Errorf("%w: truncated record after %d records", ErrInvalidBinaryFile, len(nodes))
+			}
+			if binary.BigEndian.Uint32(sum[:]) != checksum(record) {
+				return nil, fmt.Errorf("%w: record %d", ErrChecksumMismatch, len(nodes))
+			}
+		}
+
+		node, err := DecodeNode(record)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+// WriteBinary encodes a whole binary node file, header included.
+func WriteBinary(w io.Writer, nodes []graph.Node) error {
+	_, err := w.Write(append(binaryHeader(), EncodeNodes(nodes)...))
+	return err
+}
+
+// EncodeNodes encodes the nodes as length prefixed, checksummed records.
+func EncodeNodes(nodes []graph.Node) []byte {
+	var buf []byte
+	for i := range nodes {
+		record := EncodeNode(&nodes[i])
+		buf = binary.AppendUvarint(buf, uint64(len(record)))
+		buf = append(buf, record...)
+		buf = binary.BigEndian.AppendUint32(buf, checksum(record))
+	}
+	return buf
+}
+
+func EncodeNode(node *graph.Node) []byte {
+	var buf []byte
+	buf = appendString(buf, node.ID)
+	buf = appendString(buf, node.Type)
+	buf = appendString(buf, node.Name)
+
+	buf = binary.AppendUvarint(buf, uint64(len(node.Edges)))
+	for _, edge := range node.Edges {
+		buf = appendString(buf, edge)
+	}
+
+	keys := make([]string, 0, len(node.Traits))
+	for k := range node.Traits {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		buf = appendString(buf, k)
+		buf = appendString(buf, node.Traits[k])
+	}
+
+	return binary.AppendVarint(buf, node.Version)
+}
+
+func DecodeNode(record []byte) (graph.Node, error) {
+	r := bytes.NewReader(record)
+	node := graph.Node{}
+
+	var err error
+	readString := func() string {
+		var s string
+		if err == nil {
+			s, err = decodeString(r)
+		}
+		return s
+	}
+	readCount := func() uint64 {
+		var n uint64
+		if err == nil {
+			n, err = binary.ReadUvarint(r)
+			if err == nil && n > uint64(r.Len()) {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		return n
+	}
+
+	node.ID = readString()
+	node.Type = readString()
+	node.Name = readString()
+
+	if count := readCount(); count > 0 {
+		node.Edges = make([]string, 0, count)
+		for i := uint64(0); i < count && err == nil; i++ {
+			node.Edges = append(node.Edges, readString())
+		}
+	}
+
+	if count := readCount(); count > 0 {
+		node.Traits = make(map[string]string, count)
+		for i := uint64(0); i < count && err == nil; i++ {
+			k := readString()
+			node.Traits[k] = readString()
+		}
+	}
+
+	if err == nil {
+		node.Version, err = binary.ReadVarint(r)
+	}
+	if err != nil {
+		return graph.Node{}, fmt.Errorf("%w: %v", ErrInvalidBinaryFile, err)
+	}
+	return node, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func decodeString(r *bytes.Reader) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
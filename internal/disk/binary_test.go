@@ -0,0 +1,114 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+)
+
+func Test_binaryRoundTrip(t *testing.T) {
+	nodes := getTwoNodes()
+	// values that break the CSV list and map encoding
+	nodes[0].Edges = []string{`a","b`, ""}
+	nodes[0].Traits = map[string]string{`k":"x`: `v","y`, "empty": ""}
+
+	data := append(binaryHeader(), EncodeNodes(nodes)...)
+	decoded, err := ReadBinary(bufio.NewReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.Equal(t, nodes, decoded)
+}
+
+func Test_binaryRejectsCorruptFiles(t *testing.T) {
+	data := append(binaryHeader(), EncodeNodes(getTwoNodes())...)
+
+	_, err := ReadBinary(bufio.NewReader(bytes.NewReader(data[:len(data)-3])))
+	require.ErrorIs(t, err, ErrInvalidBinaryFile)
+
+	future := bytes.Clone(data)
+	future[len(binaryMagic)] = BinaryFormatVersion + 1
+	_, err = ReadBinary(bufio.NewReader(bytes.NewReader(future)))
+	require.ErrorIs(t, err, ErrInvalidBinaryFile)
+}
+
+func Test_binaryRejectsBadChecksum(t *testing.T) {
+	data := append(binaryHeader(), EncodeNodes(getTwoNodes())...)
+	data[len(data)-1] ^= 0xff
+
+	_, err := ReadBinary(bufio.NewReader(bytes.NewReader(data)))
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func Test_CheckBinaryFile(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "type1.jdb")
+	s := NewBinaryAccessor(NewFileAccessor())
+
+	nodes := getTwoNodes()
+	first := EncodeNodes(nodes[:1])
+	second := EncodeNodes(nodes[1:])
+	second[len(second)-1] ^= 0xff
+	truncated := EncodeNodes(nodes[:1])[:5]
+	data := bytes.Join([][]byte{binaryHeader(), first, second, truncated}, nil)
+	require.NoError(t, os.WriteFile(filePath, data, 0644))
+
+	report, err := s.CheckFile(ctx, filePath, true)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Records)
+	require.Equal(t, []RecordProblem{
+		{Offset: int64(binaryHeaderSize + len(first)), Reason: ErrChecksumMismatch.Error()},
+		{Offset: int64(binaryHeaderSize + len(first) + len(second)), Reason: "truncated record"},
+	}, report.Problems)
+	require.Equal(t, 2, report.Quarantined)
+
+	read, err := s.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, nodes[:1], read)
+	quarantined, err := os.ReadFile(filePath + QuarantineExtension)
+	require.NoError(t, err)
+	require.Equal(t, append(second, truncated...), quarantined)
+}
+
+func Test_binaryAccessor(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "type1.jdb")
+	s := NewBinaryAccessor(NewFileAccessor())
+
+	require.NoError(t, s.CreateFileWithHeader(ctx, filePath))
+	nodes := getTwoNodes()
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, nodes[:1]))
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, nodes[1:]))
+
+	read, err := s.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, nodes, read)
+
+	require.NoError(t, s.RewriteNodesToFile(ctx, filePath, nodes[1:]))
+	read, err = s.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, nodes[1:], read)
+}
+
+func Test_ConvertFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := NewFileAccessor()
+	legacy := graph.LegacyNodeCsvHeader + `1,type1,node1,"[""edge1""]",` + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "type1.csv"), []byte(legacy), 0644))
+
+	count, err := ConvertFiles(ctx, f, dir, NewCsvAccessor(f), NewBinaryAccessor(f))
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	_, err = os.Stat(filepath.Join(dir, "type1.csv"))
+	require.True(t, os.IsNotExist(err))
+
+	nodes, err := NewBinaryAccessor(f).ReadNodesFromFile(ctx, filepath.Join(dir, "type1.jdb"))
+	require.NoError(t, err)
+	require.Equal(t, []graph.Node{{ID: "1", Type: "type1", Name: "node1", Edges: []string{"edge1"}, Version: 1}}, nodes)
+}
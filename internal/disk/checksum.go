@@ -0,0 +1,103 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+
+	"github.com/zmjung/jamesdb/graph"
+)
+
+// ChecksumColumn is the last column of a CSV node file. It holds the CRC32C of the
+// CSV encoding of the other columns of the row as 8 hex digits.
+const ChecksumColumn = "crc"
+
+// CsvFileHeader is the header of CSV node files with checksummed rows.
+var CsvFileHeader = strings.TrimSuffix(graph.NodeCsvHeader, "\n") + "," + ChecksumColumn + "\n"
+
+// QuarantineExtension is appended to the path of a node file to name the file
+// receiving its corrupt records.
+const QuarantineExtension = ".quarantine"
+
+var ErrChecksumMismatch = errors.New("record checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func checksum(data []byte) uint32 {
+	return crc32.Checksum(data, castagnoli)
+}
+
+// recordChecksum returns the checksum column of a CSV row with the given fields.
+func recordChecksum(record []string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(record)
+	w.Flush()
+	return fmt.Sprintf("%08x", checksum(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))))
+}
+
+// RecordProblem is a record of a node file that failed verification.
+type RecordProblem struct {
+	// Offset is the byte offset of the record in the file.
+	Offset int64  `json:"offset"`
+	Line   int    `json:"line,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// FileReport is the result of checking a node file.
+type FileReport struct {
+	File        string          `json:"file"`
+	Records     int             `json:"records"`
+	Header      string          `json:"header,omitempty"`
+	Problems    []RecordProblem `json:"problems,omitempty"`
+	Quarantined int             `json:"quarantined,omitempty"`
+}
+
+func (r *FileReport) addProblem(offset int64, line int, format string, args ...any) {
+	r.Problems = append(r.Problems, RecordProblem{Offset: offset, Line: line, Reason: fmt.Sprintf(format, args...)})
+}
+
+// Healthy reports whether the file has a current header and no corrupt records.
+func (r *FileReport) Healthy() bool {
+	return r.Header == "" && len(r.Problems) == 0
+}
+
+// quarantineRecords moves the bad chunks of a file into its quarantine file and rewrites the
+// file with the header and good chunks, keeping their bytes as they were.
+func quarantineRecords(f FileAccessor, filePath string, header []byte, good [][]byte, bad [][]byte) error {
+	writer, err := f.GetFileWriter(filePath+QuarantineExtension, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(bytes.Join(bad, nil))
+	if err == nil {
+		err = f.Sync(filePath+QuarantineExtension, writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".tmp"
+	writer, err = f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(bytes.Join(append([][]byte{header}, good...), nil))
+	if err == nil {
+		err = f.Sync(tmpPath, writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return f.RenameFile(tmpPath, filePath)
+}
@@ -0,0 +1,687 @@
+package disk
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+// Codec identifies the compression of a block. Every stored block starts with its codec,
+// so blocks of different codecs can live side by side until they are rewritten.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecSnappy
+	CodecZstd
+)
+
+// blockSealed marks a block sealed by a Keyring; it wraps a block encoded by EncodeBlock.
+const blockSealed = 0x80
+
+var ErrInvalidBlock = errors.New("invalid compressed block")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func codecByName(name string) (Codec, error) {
+	switch name {
+	case "", CompressionNone:
+		return CodecNone, nil
+	case CompressionSnappy:
+		return CodecSnappy, nil
+	case CompressionZstd:
+		return CodecZstd, nil
+	}
+	return CodecNone, fmt.Errorf("unknown compression %q", name)
+}
+
+// Compression selects the codec of stored node data, per type with a database wide default.
+type Compression struct {
+	Default string
+	Types   map[string]string
+}
+
+func (c Compression) Validate() error {
+	if _, err := codecByName(c.Default); err != nil {
+		return err
+	}
+	for nodeType, name := range c.Types {
+		if _, err := codecByName(name); err != nil {
+			return fmt.Errorf("type %s: %w", nodeType, err)
+		}
+	}
+	return nil
+}
+
+// CodecFor returns the codec new data of the node type is written with.
+func (c Compression) CodecFor(nodeType string) Codec {
+	name, exists := c.Types[nodeType]
+	if !exists {
+		name = c.Default
+	}
+	codec, _ := codecByName(name)
+	return codec
+}
+
+// EncodeBlock compresses a block and prefixes it with its codec.
+func EncodeBlock(codec Codec, raw []byte) []byte {
+	dst := []byte{byte(codec)}
+	switch codec {
+	case CodecSnappy:
+		return append(dst, snappy.Encode(nil, raw)...)
+	case CodecZstd:
+		return zstdEncoder.EncodeAll(raw, dst)
+	}
+	return append(dst, raw...)
+}
+
+// DecodeBlock decompresses a block written by EncodeBlock.
+func DecodeBlock(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidBlock)
+	}
+
+	var raw []byte
+	var err error
+	switch Codec(stored[0]) {
+	case CodecNone:
+		return stored[1:], nil
+	case CodecSnappy:
+		raw, err = snappy.Decode(nil, stored[1:])
+	case CodecZstd:
+		raw, err = zstdDecoder.DecodeAll(stored[1:], nil)
+	default:
+		return nil, fmt.Errorf("%w: unknown codec %d", ErrInvalidBlock, stored[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	return raw, nil
+}
+
+// BlockFormat encodes stored blocks: compressed as the compression selects and, when
+// keys are set, sealed with the active key.
+type BlockFormat struct {
+	Compression Compression
+	Keys        *Keyring
+}
+
+// framed reports whether new files of the node type are written in blocks.
+func (b BlockFormat) framed(nodeType string) bool {
+	return b.Keys != nil || b.Compression.CodecFor(nodeType) != CodecNone
+}
+
+// Encode compresses and seals a block of the node type.
+func (b BlockFormat) Encode(nodeType string, raw []byte) ([]byte, error) {
+	block := EncodeBlock(b.Compression.CodecFor(nodeType), raw)
+	if b.Keys == nil {
+		return block, nil
+	}
+	sealed, err := b.Keys.Seal(block)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{blockSealed}, sealed...), nil
+}
+
+// Stale reports whether a stored block is sealed otherwise than new blocks are: left
+// plain while keys are set, or sealed with a key other than the active one.
+func (b BlockFormat) Stale(stored []byte) bool {
+	if b.Keys == nil {
+		return false
+	}
+	return len(stored) == 0 || stored[0] != blockSealed || !b.Keys.isActive(stored[1:])
+}
+
+// Decode opens and decompresses a block written by Encode, whatever the codec or key.
+func (b BlockFormat) Decode(stored []byte) ([]byte, error) {
+	if len(stored) > 0 && stored[0] == blockSealed {
+		block, err := b.Keys.Open(stored[1:])
+		if err != nil {
+			return nil, err
+		}
+		stored = block
+	}
+	return DecodeBlock(stored)
+}
+
+// A compressed node file starts with compressedMagic, followed by blocks, each its
+// length as an uvarint and the encoded block. Writes are gathered into blocks of
+// blockSize bytes; a block cut short by a crash ends the file.
+const (
+	compressedMagic = "JDBZ"
+	blockSize       = 64 << 10
+)
+
+// compressedFiles compresses and seals the node files of a folder, with their
+// temporary copies and quarantine files. Other files pass through unchanged. Files are only compressed when they are created or rewritten, so
+// uncompressed files stay readable and appendable until their next rewrite. Plain
+// files are rewritten in blocks before their first append once keys are set, so no
+// new data reaches the disk unsealed.
+type compressedFiles struct {
+	FileAccessor
+	nodePath string
+	ext      string
+	format   BlockFormat
+
+	lock       sync.Mutex
+	compressed map[string]bool
+	// intact holds the files known to have no torn block at their end.
+	intact map[string]bool
+}
+
+// NewCompressedFileAccessor compresses the files with the extension in nodePath,
+// named after their node type, as the compression selects.
+func NewCompressedFileAccessor(f FileAccessor, nodePath string, ext string, compression Compression) FileAccessor {
+	return NewBlockFileAccessor(f, nodePath, ext, BlockFormat{Compression: compression})
+}
+
+// NewBlockFileAccessor writes the files with the extension in nodePath in blocks of the format.
+func NewBlockFileAccessor(f FileAccessor, nodePath string, ext string, format BlockFormat) FileAccessor {
+	return &compressedFiles{
+		FileAccessor: f,
+		nodePath:     nodePath,
+		ext:          ext,
+		format:       format,
+		compressed:   make(map[string]bool),
+		intact:       make(map[string]bool),
+	}
+}
+
+// nodeType returns the node type of a node file, its temporary copy or its quarantine file.
+func (c *compressedFiles) nodeType(filePath string) (string, bool) {
+	if filepath.Dir(filePath) != filepath.Clean(c.nodePath) {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filePath), ".tmp"), QuarantineExtension)
+	if filepath.Ext(name) != c.ext {
+		return "", false
+	}
+	return strings.TrimSuffix(name, c.ext), true
+}
+
+// isCompressed reports whether an existing file is compressed, remembering the answer.
+func (c *compressedFiles) isCompressed(filePath string) (bool, error) {
+	c.lock.Lock()
+	compressed, known := c.compressed[filePath]
+	c.lock.Unlock()
+	if known {
+		return compressed, nil
+	}
+
+	reader, err := c.FileAccessor.GetFileReader(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	magic := make([]byte, len(compressedMagic))
+	n, err := io.ReadFull(reader, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if n == 0 {
+		// Empty files are decided by their first writer.
+		return false, nil
+	}
+
+	compressed = string(magic[:n]) == compressedMagic
+	c.remember(filePath, compressed)
+	return compressed, nil
+}
+
+func (c *compressedFiles) remember(filePath string, compressed bool) {
+	c.lock.Lock()
+	c.compressed[filePath] = compressed
+	c.lock.Unlock()
+}
+
+func (c *compressedFiles) forget(filePaths ...string) {
+	c.lock.Lock()
+	for _, filePath := range filePaths {
+		delete(c.compressed, filePath)
+		delete(c.intact, filePath)
+	}
+	c.lock.Unlock()
+}
+
+func (c *compressedFiles) GetFileReader(filePath string) (io.ReadCloser, error) {
+	reader, err := c.FileAccessor.GetFileReader(filePath)
+	if _, managed := c.nodeType(filePath); !managed || err != nil {
+		return reader, err
+	}
+
+	r := bufio.NewReader(reader)
+	magic, err := r.Peek(len(compressedMagic))
+	if err != nil || string(magic) != compressedMagic {
+		// Short or uncompressed files are read as they are.
+		return &blockReader{r: r, closer: reader, filePath: filePath}, nil
+	}
+	r.Discard(len(compressedMagic))
+	return &blockReader{r: r, closer: reader, filePath: filePath, format: c.format, compressed: true}, nil
+}
+
+func (c *compressedFiles) GetFileWriter(filePath string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	nodeType, managed := c.nodeType(filePath)
+	if !managed {
+		return c.FileAccessor.GetFileWriter(filePath, flag, perm)
+	}
+
+	empty := flag&os.O_TRUNC != 0
+	if !empty {
+		size, err := c.FileAccessor.FileSize(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		empty = size == 0
+	}
+
+	// New content follows the configured format; appends keep the framing of the file,
+	// unless that would leave new data unsealed.
+	compressed := c.format.framed(nodeType)
+	if !empty {
+		var err error
+		if compressed, err = c.isCompressed(filePath); err != nil {
+			return nil, err
+		}
+		if !compressed && c.format.Keys != nil {
+			if err := c.seal(filePath, nodeType); err != nil {
+				return nil, err
+			}
+			compressed = true
+		}
+		// Blocks appended after a torn one would be read as part of it.
+		if compressed {
+			if err := c.dropTornBlock(filePath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	writer, err := c.FileAccessor.GetFileWriter(filePath, flag, perm)
+	if err != nil || !compressed {
+		if err == nil {
+			c.remember(filePath, false)
+		}
+		return writer, err
+	}
+	if empty {
+		if _, err := writer.Write([]byte(compressedMagic)); err != nil {
+			writer.Close()
+			return nil, err
+		}
+	}
+	c.remember(filePath, true)
+	return &blockWriter{w: writer, nodeType: nodeType, format: c.format}, nil
+}
+
+// seal rewrites a plain file in sealed blocks.
+func (c *compressedFiles) seal(filePath string, nodeType string) error {
+	data, err := readFile(c.FileAccessor, filePath)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".seal"
+	writer, err := c.FileAccessor.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	bw := &blockWriter{w: writer, nodeType: nodeType, format: c.format}
+	_, err = writer.Write([]byte(compressedMagic))
+	if err == nil {
+		_, err = bw.Write(data)
+	}
+	if err == nil {
+		err = bw.Sync()
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = c.FileAccessor.RenameFile(tmpPath, filePath)
+	}
+	if err != nil {
+		c.FileAccessor.RemoveFile(tmpPath)
+		return err
+	}
+	c.remember(filePath, true)
+	return nil
+}
+
+// SealFiles seals the plain files with the extension the accessor keeps in its
+// folder, so data written before the database was encrypted is not left in the clear
+// until the next append to its type. Accessors without keys have nothing to seal.
+func SealFiles(f FileAccessor) error {
+	c, ok := f.(*compressedFiles)
+	if !ok || c.format.Keys == nil {
+		return nil
+	}
+	names, err := c.FileAccessor.ListFiles(c.nodePath, c.ext)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		filePath := c.FileAccessor.GetFilePath(c.nodePath, name+c.ext)
+		size, err := c.FileAccessor.FileSize(filePath)
+		if err != nil {
+			return err
+		}
+		compressed, err := c.isCompressed(filePath)
+		if err != nil {
+			return err
+		}
+		if size > 0 && !compressed {
+			if err := c.seal(filePath, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *compressedFiles) RenameFile(oldPath string, newPath string) error {
+	c.forget(oldPath, newPath)
+	return c.FileAccessor.RenameFile(oldPath, newPath)
+}
+
+func (c *compressedFiles) RemoveFile(filePath string) error {
+	c.forget(filePath)
+	return c.FileAccessor.RemoveFile(filePath)
+}
+
+// tornBlockOffset returns the offset of a block cut short at the end of a file in
+// blocks, as a crash in the middle of a write leaves it, or -1 when there is none.
+func (c *compressedFiles) tornBlockOffset(filePath string) (int64, error) {
+	reader, err := c.FileAccessor.GetFileReader(filePath)
+	if os.IsNotExist(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	defer reader.Close()
+
+	r := bufio.NewReader(reader)
+	magic, err := r.Peek(len(compressedMagic))
+	if err != nil || string(magic) != compressedMagic {
+		return -1, nil
+	}
+	offset, _ := r.Discard(len(compressedMagic))
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return -1, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return int64(offset), nil
+		}
+		if err != nil || size > maxRecordSize {
+			// A bad length is corruption rather than a torn write; readers report it.
+			return -1, nil
+		}
+		n, err := r.Discard(int(size))
+		if err == io.EOF {
+			return int64(offset), nil
+		}
+		if err != nil {
+			return -1, err
+		}
+		offset += len(binary.AppendUvarint(nil, size)) + n
+	}
+}
+
+// dropTornBlock truncates a file in blocks before a torn block at its end, once per
+// file until it is renamed or removed.
+func (c *compressedFiles) dropTornBlock(filePath string) error {
+	c.lock.Lock()
+	intact := c.intact[filePath]
+	c.lock.Unlock()
+	if intact {
+		return nil
+	}
+
+	offset, err := c.tornBlockOffset(filePath)
+	if err != nil {
+		return err
+	}
+	if offset >= 0 {
+		slog.Warn("Dropping torn block at the end of the file", "filePath", filePath, "offset", offset)
+		if err := c.truncate(filePath, offset); err != nil {
+			return err
+		}
+	}
+
+	c.lock.Lock()
+	c.intact[filePath] = true
+	c.lock.Unlock()
+	return nil
+}
+
+// truncate rewrites the first size bytes of a file as they are stored.
+func (c *compressedFiles) truncate(filePath string, size int64) error {
+	reader, err := c.FileAccessor.GetFileReader(filePath)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, size))
+	reader.Close()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".truncate"
+	writer, err := c.FileAccessor.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	if err == nil {
+		err = c.FileAccessor.Sync(tmpPath, writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = c.FileAccessor.RenameFile(tmpPath, filePath)
+	}
+	if err != nil {
+		c.FileAccessor.RemoveFile(tmpPath)
+	}
+	return err
+}
+
+// checkTornBlock reports a block cut short at the end of a node file, which readers
+// take for the end of the file, and with repair set truncates the file before it.
+func checkTornBlock(ctx context.Context, f FileAccessor, filePath string, repair bool, report *FileReport) error {
+	c, ok := f.(*compressedFiles)
+	if !ok {
+		return nil
+	}
+	if _, managed := c.nodeType(filePath); !managed {
+		return nil
+	}
+
+	offset, err := c.tornBlockOffset(filePath)
+	if err != nil || offset < 0 {
+		return err
+	}
+	if !repair {
+		report.addProblem(offset, 0, "truncated block")
+		return nil
+	}
+	slog.WarnContext(ctx, "Dropping torn block at the end of the file", "filePath", filePath, "offset", offset)
+	if err := c.truncate(filePath, offset); err != nil {
+		return err
+	}
+	report.addProblem(offset, 0, "truncated block, dropped")
+	return nil
+}
+
+// NewBlockWriter writes data to w in blocks of the format, like a file in blocks.
+// Close writes the last block without closing w.
+func NewBlockWriter(w io.Writer, format BlockFormat) (io.WriteCloser, error) {
+	if _, err := w.Write([]byte(compressedMagic)); err != nil {
+		return nil, err
+	}
+	return &blockWriter{w: nopWriteCloser{w}, format: format}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// NewBlockReader reads the data written by NewBlockWriter.
+func NewBlockReader(r io.Reader, format BlockFormat) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(compressedMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != compressedMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidBlock)
+	}
+	return &blockReader{r: br, format: format, compressed: true}, nil
+}
+
+// FlushWriter writes out the data a writer holds back, such as the last block of a
+// file in blocks, so readers see it. It does not make the data durable.
+func FlushWriter(writer io.Writer) error {
+	if f, ok := writer.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// blockWriter gathers writes into blocks of blockSize bytes, writing the last one
+// short on Flush, Sync and Close.
+type blockWriter struct {
+	w        io.WriteCloser
+	nodeType string
+	format   BlockFormat
+	buf      []byte
+}
+
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	bw.buf = append(bw.buf, p...)
+	for len(bw.buf) >= blockSize {
+		if err := bw.writeBlock(bw.buf[:blockSize]); err != nil {
+			return 0, err
+		}
+		bw.buf = bw.buf[:copy(bw.buf, bw.buf[blockSize:])]
+	}
+	return len(p), nil
+}
+
+func (bw *blockWriter) writeBlock(raw []byte) error {
+	block, err := bw.format.Encode(bw.nodeType, raw)
+	if err != nil {
+		return err
+	}
+	buf := binary.AppendUvarint(make([]byte, 0, len(block)+binary.MaxVarintLen64), uint64(len(block)))
+	_, err = bw.w.Write(append(buf, block...))
+	return err
+}
+
+func (bw *blockWriter) Flush() error {
+	if len(bw.buf) == 0 {
+		return nil
+	}
+	if err := bw.writeBlock(bw.buf); err != nil {
+		return err
+	}
+	bw.buf = bw.buf[:0]
+	return nil
+}
+
+func (bw *blockWriter) Sync() error {
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return syncWriter(bw.w)
+}
+
+func (bw *blockWriter) Close() error {
+	return errors.Join(bw.Flush(), bw.w.Close())
+}
+
+// blockReader streams the decompressed content of a file one block at a time. A block
+// cut short at the end of the file ends it.
+type blockReader struct {
+	r          *bufio.Reader
+	closer     io.Closer
+	filePath   string
+	format     BlockFormat
+	compressed bool
+	block      []byte
+	torn       bool
+}
+
+func (br *blockReader) Read(p []byte) (int, error) {
+	if !br.compressed {
+		return br.r.Read(p)
+	}
+
+	for len(br.block) == 0 {
+		if br.torn {
+			return 0, io.EOF
+		}
+		size, err := binary.ReadUvarint(br.r)
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return 0, br.tornBlock()
+		}
+		if err != nil || size > maxRecordSize {
+			return 0, fmt.Errorf("%w: bad block length", ErrInvalidBlock)
+		}
+		stored := make([]byte, size)
+		if _, err := io.ReadFull(br.r, stored); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, br.tornBlock()
+		} else if err != nil {
+			return 0, err
+		}
+		if br.block, err = br.format.Decode(stored); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, br.block)
+	br.block = br.block[n:]
+	return n, nil
+}
+
+func (br *blockReader) tornBlock() error {
+	slog.Warn("Ignoring torn block at the end of the file", "filePath", br.filePath)
+	br.torn = true
+	return io.EOF
+}
+
+func (br *blockReader) Close() error {
+	return br.closer.Close()
+}
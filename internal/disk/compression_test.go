@@ -0,0 +1,157 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockRoundTrip(t *testing.T) {
+	raw := []byte(strings.Repeat(`"{""trait"":""value""}",`, 100))
+	for _, codec := range []Codec{CodecNone, CodecSnappy, CodecZstd} {
+		stored := EncodeBlock(codec, raw)
+		decoded, err := DecodeBlock(stored)
+		require.NoError(t, err)
+		require.Equal(t, raw, decoded)
+		if codec != CodecNone {
+			require.Less(t, len(stored), len(raw))
+		}
+	}
+
+	_, err := DecodeBlock([]byte{byte(CodecZstd), 1, 2, 3})
+	require.ErrorIs(t, err, ErrInvalidBlock)
+	require.Error(t, Compression{Default: "lz4"}.Validate())
+}
+
+func TestCompressedNodeFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := NewFileAccessor()
+	filePath := filepath.Join(dir, "person.csv")
+
+	// A file written before compression was enabled stays readable and appendable.
+	plain := NewCsvAccessor(f)
+	require.NoError(t, plain.CreateFileWithHeader(ctx, filePath))
+	require.NoError(t, plain.AppendNodesToFile(ctx, filePath, getTwoNodes()[:1]))
+
+	files := NewCompressedFileAccessor(f, dir, ".csv", Compression{Default: CompressionZstd})
+	s := NewCsvAccessor(files)
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()[1:]))
+	nodes, err := s.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, getTwoNodes(), nodes)
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte(CsvFileHeader)))
+
+	// Rewriting the file compresses it.
+	require.NoError(t, s.RewriteNodesToFile(ctx, filePath, getTwoNodes()))
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()[:1]))
+	data, err = os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte(compressedMagic)))
+
+	nodes, err = s.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, append(getTwoNodes(), getTwoNodes()[0]), nodes)
+	report, err := s.CheckFile(ctx, filePath, false)
+	require.NoError(t, err)
+	require.True(t, report.Healthy())
+	require.Equal(t, 3, report.Records)
+
+	// Other files in the folder are left alone.
+	other := filepath.Join(dir, "notes.txt")
+	writer, err := files.GetFileWriter(other, os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("x\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	data, err = os.ReadFile(other)
+	require.NoError(t, err)
+	require.Equal(t, "x\n", string(data))
+}
+
+// countBlocks returns the number of blocks of a compressed file.
+func countBlocks(t *testing.T, data []byte) int {
+	require.True(t, bytes.HasPrefix(data, []byte(compressedMagic)))
+	blocks := 0
+	for data = data[len(compressedMagic):]; len(data) > 0; blocks++ {
+		size, n := binary.Uvarint(data)
+		require.Positive(t, n)
+		data = data[n+int(size):]
+	}
+	return blocks
+}
+
+func TestCompressedFilesGatherWritesIntoBlocks(t *testing.T) {
+	dir := t.TempDir()
+	files := NewCompressedFileAccessor(NewFileAccessor(), dir, ".csv", Compression{Default: CompressionSnappy})
+	filePath := filepath.Join(dir, "person.csv")
+
+	writer, err := files.GetFileWriter(filePath, os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	line := []byte(strings.Repeat("x", 99) + "\n")
+	for range blockSize / len(line) * 3 {
+		_, err = writer.Write(line)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, 3, countBlocks(t, data))
+	content, err := readFile(files, filePath)
+	require.NoError(t, err)
+	require.Equal(t, blockSize/len(line)*3*len(line), len(content))
+}
+
+func TestTornBlockEndsFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	files := NewCompressedFileAccessor(NewFileAccessor(), dir, ".csv", Compression{Default: CompressionZstd})
+	s := NewCsvAccessor(files)
+	filePath := filepath.Join(dir, "person.csv")
+
+	require.NoError(t, s.RewriteNodesToFile(ctx, filePath, getTwoNodes()[:1]))
+	intact, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()[1:]))
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filePath, data[:len(data)-3], 0644))
+
+	// Readers stop at the torn block, and the check reports it.
+	nodes, err := s.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, getTwoNodes()[:1], nodes)
+	report, err := s.CheckFile(ctx, filePath, false)
+	require.NoError(t, err)
+	require.False(t, report.Healthy())
+	require.Equal(t, []RecordProblem{{Offset: int64(len(intact)), Reason: "truncated block"}}, report.Problems)
+
+	// Repairing truncates the file before it.
+	report, err = s.CheckFile(ctx, filePath, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Records)
+	data, err = os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, intact, data)
+
+	// Appending drops a torn block first, so the new blocks stay readable.
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()[1:]))
+	data, err = os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filePath, data[:len(data)-3], 0644))
+	files = NewCompressedFileAccessor(NewFileAccessor(), dir, ".csv", Compression{Default: CompressionZstd})
+	s = NewCsvAccessor(files)
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()[1:]))
+	nodes, err = s.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, getTwoNodes(), nodes)
+}
@@ -1,22 +1,30 @@
 package disk
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/jszwec/csvutil"
 	"github.com/zmjung/jamesdb/graph"
 )
 
+// ReadCsv decodes CSV rows into v. When the header ends with the checksum column,
+// the checksum of every row is verified and the column is dropped.
 func ReadCsv(ctx context.Context, r io.Reader, v any) error {
-	csvReader := csv.NewReader(r)
+	return decodeCsv(ctx, &checksumReader{r: csv.NewReader(r)}, v)
+}
 
+func decodeCsv(ctx context.Context, r csvutil.Reader, v any) error {
 	// Preallocate nodes slice for efficiency if possible
-	dec, err := csvutil.NewDecoder(csvReader)
+	dec, err := csvutil.NewDecoder(r)
 	if err != nil {
 		return err
 	}
@@ -38,11 +46,12 @@ func ReadCsv(ctx context.Context, r io.Reader, v any) error {
 	return nil
 }
 
+// WriteCsv encodes v as CSV rows without a header, appending the checksum column to every row.
 func WriteCsv(ctx context.Context, w io.Writer, v any) error {
 	csvWriter := csv.NewWriter(w)
 
 	// Create an encoder
-	encoder := csvutil.NewEncoder(csvWriter)
+	encoder := csvutil.NewEncoder(&checksumWriter{w: csvWriter})
 	encoder.AutoHeader = false
 
 	encoder.WithMarshalers(
@@ -64,22 +73,69 @@ func WriteCsv(ctx context.Context, w io.Writer, v any) error {
 	return nil
 }
 
-type CsvAccessor interface {
-	ReadNodesFromFile(cxt context.Context, filePath string) ([]graph.Node, error)
-	WriteNodesAsCsv(cxt context.Context, filePath string, nodes []graph.Node) error
-	CreateFileWithHeader(ctx context.Context, filePath string, csvHeader string) error
+type checksumReader struct {
+	r       *csv.Reader
+	started bool
+	checked bool
+}
+
+func (cr *checksumReader) Read() ([]string, error) {
+	record, err := cr.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	n := len(record)
+
+	if !cr.started {
+		cr.started = true
+		cr.checked = n > 0 && record[n-1] == ChecksumColumn
+	} else if cr.checked && record[n-1] != recordChecksum(record[:n-1]) {
+		line, _ := cr.r.FieldPos(0)
+		return nil, fmt.Errorf("%w: line %d", ErrChecksumMismatch, line)
+	}
+
+	if cr.checked {
+		return record[:n-1], nil
+	}
+	return record, nil
+}
+
+type checksumWriter struct {
+	w *csv.Writer
+}
+
+func (cw *checksumWriter) Write(record []string) error {
+	return cw.w.Write(append(record[:len(record):len(record)], recordChecksum(record)))
+}
+
+// recordsReader hands out records that were already read.
+type recordsReader struct {
+	records [][]string
+}
+
+func (r *recordsReader) Read() ([]string, error) {
+	if len(r.records) == 0 {
+		return nil, io.EOF
+	}
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
 }
 
 type csvService struct {
 	f FileAccessor
 }
 
-func NewCsvAccessor(f FileAccessor) CsvAccessor {
+func NewCsvAccessor(f FileAccessor) NodeAccessor {
 	return &csvService{
 		f: f,
 	}
 }
 
+func (s *csvService) FileExtension() string {
+	return ".csv"
+}
+
 func (s *csvService) ReadNodesFromFile(ctx context.Context, filePath string) ([]graph.Node, error) {
 	slog.InfoContext(ctx, "Reading from file", "filePath", filePath)
 
@@ -94,7 +150,7 @@ func (s *csvService) ReadNodesFromFile(ctx context.Context, filePath string) ([]
 	return nodes, err
 }
 
-func (s *csvService) WriteNodesAsCsv(ctx context.Context, filePath string, nodes []graph.Node) error {
+func (s *csvService) AppendNodesToFile(ctx context.Context, filePath string, nodes []graph.Node) error {
 	slog.InfoContext(ctx, "Writing to file", "filePath", filePath)
 
 	// Create a CSV writer
@@ -104,7 +160,46 @@ func (s *csvService) WriteNodesAsCsv(ctx context.Context, filePath string, nodes
 	}
 	defer writer.Close()
 
-	return WriteCsv(ctx, writer, nodes)
+	if err := WriteCsv(ctx, writer, nodes); err != nil {
+		return err
+	}
+	return s.f.Sync(filePath, writer)
+}
+
+// syncWriter flushes the written data to stable storage when the writer supports it.
+func syncWriter(writer io.Writer) error {
+	if s, ok := writer.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (s *csvService) RewriteNodesToFile(ctx context.Context, filePath string, nodes []graph.Node) error {
+	slog.InfoContext(ctx, "Rewriting file", "filePath", filePath, "count", len(nodes))
+
+	// Write the new content next to the file and swap it in, so a failed rewrite
+	// never leaves a half written file behind.
+	tmpPath := filePath + ".tmp"
+	writer, err := s.f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = writer.Write([]byte(CsvFileHeader)); err == nil {
+		err = WriteCsv(ctx, writer, nodes)
+	}
+	// The new content must be durable before it replaces the file.
+	if err == nil {
+		err = s.f.Sync(tmpPath, writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.f.RenameFile(tmpPath, filePath)
 }
 
 func (s *csvService) WriteCsvToFile(ctx context.Context, filePath string, csvLine string) error {
@@ -120,7 +215,7 @@ func (s *csvService) WriteCsvToFile(ctx context.Context, filePath string, csvLin
 	return err
 }
 
-func (s *csvService) CreateFileWithHeader(ctx context.Context, filePath string, csvHeader string) error {
+func (s *csvService) CreateFileWithHeader(ctx context.Context, filePath string) error {
 	isFileEmpty, err := s.f.IsFileEmpty(filePath)
 	if err != nil {
 		return err
@@ -128,8 +223,135 @@ func (s *csvService) CreateFileWithHeader(ctx context.Context, filePath string,
 
 	if isFileEmpty {
 		// if the file is empty, setup header
-		err = s.WriteCsvToFile(ctx, filePath, csvHeader)
+		err = s.WriteCsvToFile(ctx, filePath, CsvFileHeader)
 	}
 
 	return err
 }
+
+// IsLegacyFile reports whether the file was written before nodes were versioned
+// or before rows were checksummed.
+func (s *csvService) IsLegacyFile(ctx context.Context, filePath string) (bool, error) {
+	header, err := s.ReadHeader(ctx, filePath)
+	return header == graph.LegacyNodeCsvHeader || header == graph.NodeCsvHeader, err
+}
+
+func (s *csvService) ReadHeader(ctx context.Context, filePath string) (string, error) {
+	// This function returns the first line of the file including its line break,
+	// or an empty string if the file is empty.
+	reader, err := s.f.GetFileReader(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	header, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return header, nil
+}
+
+// CheckFile verifies the header and every row of a CSV node file. Rows that fail are
+// reported with their offset and line and, with quarantine set, moved into the
+// quarantine file of the node file.
+func (s *csvService) CheckFile(ctx context.Context, filePath string, quarantine bool) (*FileReport, error) {
+	data, err := readFile(s.f, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FileReport{File: filePath}
+	if err := checkTornBlock(ctx, s.f, filePath, quarantine, report); err != nil {
+		return report, err
+	}
+	if len(data) == 0 {
+		return report, nil
+	}
+
+	headerEnd := bytes.IndexByte(data, '\n') + 1
+	if headerEnd == 0 {
+		report.Header = "missing header"
+		return report, nil
+	}
+	header := string(data[:headerEnd])
+	switch header {
+	case CsvFileHeader:
+	case graph.NodeCsvHeader, graph.LegacyNodeCsvHeader:
+		report.Header = "outdated header, rows are not checksummed"
+	default:
+		report.Header = fmt.Sprintf("unexpected header %q", header)
+		return report, nil
+	}
+
+	columns := strings.Split(strings.TrimSuffix(header, "\n"), ",")
+	checked := columns[len(columns)-1] == ChecksumColumn
+
+	r := csv.NewReader(bytes.NewReader(data[headerEnd:]))
+	r.FieldsPerRecord = -1
+
+	var good, bad [][]byte
+	for {
+		start := r.InputOffset()
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		end := r.InputOffset()
+		chunk := data[int64(headerEnd)+start : int64(headerEnd)+end]
+
+		var problem string
+		switch {
+		case err != nil:
+			problem = err.Error()
+		case !bytes.HasSuffix(chunk, []byte("\n")):
+			problem = "truncated record"
+		case len(record) != len(columns):
+			problem = fmt.Sprintf("expected %d fields, got %d", len(columns), len(record))
+		case checked && record[len(record)-1] != recordChecksum(record[:len(record)-1]):
+			problem = ErrChecksumMismatch.Error()
+		default:
+			if checked {
+				record = record[:len(record)-1]
+			}
+			var nodes []graph.Node
+			if err := decodeCsv(ctx, &recordsReader{records: [][]string{columns[:len(record)], record}}, &nodes); err != nil {
+				problem = err.Error()
+			}
+		}
+
+		report.Records++
+		if problem == "" {
+			good = append(good, chunk)
+		} else {
+			line := bytes.Count(data[:int64(headerEnd)+start], []byte("\n")) + 1
+			report.addProblem(int64(headerEnd)+start, line, "%s", problem)
+			if !bytes.HasSuffix(chunk, []byte("\n")) {
+				chunk = append(bytes.Clone(chunk), '\n')
+			}
+			bad = append(bad, chunk)
+		}
+		if end == start {
+			break
+		}
+	}
+
+	if quarantine && len(bad) > 0 {
+		slog.WarnContext(ctx, "Quarantining corrupt records", "filePath", filePath, "count", len(bad))
+		if err := quarantineRecords(s.f, filePath, data[:headerEnd], good, bad); err != nil {
+			return report, err
+		}
+		report.Quarantined = len(bad)
+	}
+	return report, nil
+}
+
+func readFile(f FileAccessor, filePath string) ([]byte, error) {
+	reader, err := f.GetFileReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
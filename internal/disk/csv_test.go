@@ -2,6 +2,8 @@ package disk
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -9,9 +11,14 @@ import (
 	"github.com/zmjung/jamesdb/graph"
 )
 
-const csvTwoNodes = `id,type,name,edges,traits
-1,type1,node1,"[""edge1"",""edge2""]","{""trait1"":""value1""}"
-2,type2,node2,"[""edge3"",""edge4""]","{""trait2"":""value2""}"
+const csvTwoNodes = `id,type,name,edges,traits,version,crc
+1,type1,node1,"[""edge1"",""edge2""]","{""trait1"":""value1""}",1,9a255c5b
+2,type2,node2,"[""edge3"",""edge4""]","{""trait2"":""value2""}",1,efbe83f4
+`
+
+const csvTwoNodesUnchecked = `id,type,name,edges,traits,version
+1,type1,node1,"[""edge1"",""edge2""]","{""trait1"":""value1""}",1
+2,type2,node2,"[""edge3"",""edge4""]","{""trait2"":""value2""}",1
 `
 
 func Test_ReadCsv(t *testing.T) {
@@ -33,13 +40,73 @@ func Test_ReadCsv(t *testing.T) {
 	}
 }
 
+func Test_ReadCsvWithoutChecksums(t *testing.T) {
+	var nodes []graph.Node
+	require.NoError(t, ReadCsv(context.Background(), strings.NewReader(csvTwoNodesUnchecked), &nodes))
+	require.Equal(t, getTwoNodes(), nodes)
+}
+
+func Test_ReadCsvRejectsBadChecksum(t *testing.T) {
+	corrupt := strings.Replace(csvTwoNodes, "node2", "node3", 1)
+
+	var nodes []graph.Node
+	err := ReadCsv(context.Background(), strings.NewReader(corrupt), &nodes)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func Test_CheckCsvFile(t *testing.T) {
+	ctx := context.Background()
+	f := NewFileAccessor()
+	s := NewCsvAccessor(f)
+	filePath := filepath.Join(t.TempDir(), "person.csv")
+
+	goodRow := strings.SplitAfter(csvTwoNodes, "\n")[1]
+	badRow := strings.Replace(strings.SplitAfter(csvTwoNodes, "\n")[2], "node2", "node3", 1)
+	truncated := "3,type3,no"
+	require.NoError(t, os.WriteFile(filePath, []byte(CsvFileHeader+goodRow+badRow+truncated), 0644))
+
+	report, err := s.CheckFile(ctx, filePath, false)
+	require.NoError(t, err)
+	require.False(t, report.Healthy())
+	require.Equal(t, 3, report.Records)
+	require.Len(t, report.Problems, 2)
+	require.Equal(t, RecordProblem{Offset: int64(len(CsvFileHeader + goodRow)), Line: 3, Reason: ErrChecksumMismatch.Error()}, report.Problems[0])
+	require.Equal(t, "truncated record", report.Problems[1].Reason)
+
+	report, err = s.CheckFile(ctx, filePath, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Quarantined)
+
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, CsvFileHeader+goodRow, string(data))
+	quarantined, err := os.ReadFile(filePath + QuarantineExtension)
+	require.NoError(t, err)
+	require.Equal(t, badRow+truncated+"\n", string(quarantined))
+
+	report, err = s.CheckFile(ctx, filePath, false)
+	require.NoError(t, err)
+	require.True(t, report.Healthy())
+}
+
+func Test_CheckCsvFileReportsOutdatedHeader(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "person.csv")
+	require.NoError(t, os.WriteFile(filePath, []byte(csvTwoNodesUnchecked), 0644))
+
+	report, err := NewCsvAccessor(NewFileAccessor()).CheckFile(context.Background(), filePath, false)
+	require.NoError(t, err)
+	require.NotEmpty(t, report.Header)
+	require.Empty(t, report.Problems)
+	require.Equal(t, 2, report.Records)
+}
+
 func Test_writeCsvToWriter(t *testing.T) {
 	ctx := context.Background()
 
 	nodes := getTwoNodes()
 
 	writer := new(strings.Builder)
-	writer.WriteString(graph.NodeCsvHeader)
+	writer.WriteString(CsvFileHeader)
 
 	err := WriteCsv(ctx, writer, &nodes)
 	if err != nil {
@@ -58,18 +125,20 @@ func Test_writeCsvToWriter(t *testing.T) {
 func getTwoNodes() []graph.Node {
 	return []graph.Node{
 		{
-			ID:     "1",
-			Type:   "type1",
-			Name:   "node1",
-			Edges:  []string{"edge1", "edge2"},
-			Traits: map[string]string{"trait1": "value1"},
+			ID:      "1",
+			Type:    "type1",
+			Name:    "node1",
+			Edges:   []string{"edge1", "edge2"},
+			Traits:  map[string]string{"trait1": "value1"},
+			Version: 1,
 		},
 		{
-			ID:     "2",
-			Type:   "type2",
-			Name:   "node2",
-			Edges:  []string{"edge3", "edge4"},
-			Traits: map[string]string{"trait2": "value2"},
+			ID:      "2",
+			Type:    "type2",
+			Name:    "node2",
+			Edges:   []string{"edge3", "edge4"},
+			Traits:  map[string]string{"trait2": "value2"},
+			Version: 1,
 		},
 	}
 }
@@ -1,23 +1,185 @@
 package disk
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Durability modes decide when committed writes reach stable storage.
+const (
+	// DurabilityAlways syncs the file on every commit.
+	DurabilityAlways = "always"
+	// DurabilityInterval syncs written files in the background; a crash can lose
+	// the commits of the last interval.
+	DurabilityInterval = "interval"
+	// DurabilityNone leaves syncing to the operating system.
+	DurabilityNone = "none"
+
+	DefaultSyncInterval = 100 * time.Millisecond
 )
 
 type FileAccessor interface {
 	GetFileReader(filePath string) (io.ReadCloser, error)
 	GetFileWriter(filePath string, flag int, perm os.FileMode) (io.WriteCloser, error)
 	IsFileEmpty(filePath string) (bool, error)
+	FileExists(filePath string) (bool, error)
+	FileSize(filePath string) (int64, error)
 	AddFolder(rootPath string, folderName string) (string, error)
 	GetFilePath(rootPath string, fileName string) string
+	ListFiles(folderPath string, ext string) ([]string, error)
+	RenameFile(oldPath string, newPath string) error
+	RemoveFile(filePath string) error
+	// Sync makes the data written to the file through writer durable, as far as
+	// the durability mode asks for it.
+	Sync(filePath string, writer io.Writer) error
+	Durability() string
+	Close() error
 }
 
-type filer struct{}
+type filer struct {
+	durability string
+
+	lock    sync.Mutex
+	pending map[string]bool
+	// syncing holds the files the background loop is syncing, so a file renamed
+	// meanwhile is synced again under its new name.
+	syncing   map[string]bool
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
 
+// NewFileAccessor returns a file accessor that syncs on every commit.
 func NewFileAccessor() FileAccessor {
-	return &filer{}
+	return &filer{durability: DurabilityAlways}
+}
+
+// NewFileAccessorWithDurability returns a file accessor using the durability mode.
+// With DurabilityInterval, written files are synced every interval until Close.
+func NewFileAccessorWithDurability(durability string, interval time.Duration) (FileAccessor, error) {
+	switch durability {
+	case "", DurabilityAlways:
+		return NewFileAccessor(), nil
+	case DurabilityNone:
+		return &filer{durability: durability}, nil
+	case DurabilityInterval:
+	default:
+		return nil, fmt.Errorf("unknown durability mode %q", durability)
+	}
+
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	f := &filer{
+		durability: durability,
+		pending:    make(map[string]bool),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go f.syncLoop(interval)
+	return f, nil
+}
+
+func (f *filer) Durability() string {
+	return f.durability
+}
+
+func (f *filer) Sync(filePath string, writer io.Writer) error {
+	switch f.durability {
+	case DurabilityAlways:
+		return syncWriter(writer)
+	case DurabilityInterval:
+		f.lock.Lock()
+		f.pending[filePath] = true
+		f.lock.Unlock()
+	}
+	return nil
+}
+
+func (f *filer) syncLoop(interval time.Duration) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.syncPending(); err != nil {
+				slog.Error("Error syncing files", "error", err)
+			}
+		}
+	}
+}
+
+// syncDir makes the entries of a folder, such as a renamed file, durable as far as
+// the durability mode asks for it.
+func (f *filer) syncDir(dirPath string) error {
+	switch f.durability {
+	case DurabilityAlways:
+		dir, err := os.Open(dirPath)
+		if err != nil {
+			return err
+		}
+		return errors.Join(dir.Sync(), dir.Close())
+	case DurabilityInterval:
+		f.lock.Lock()
+		f.pending[dirPath] = true
+		f.lock.Unlock()
+	}
+	return nil
+}
+
+// syncPending syncs the files and folders written since the last run. Syncing a
+// fresh descriptor flushes the data written through any other descriptor of the file.
+func (f *filer) syncPending() error {
+	f.lock.Lock()
+	pending := f.pending
+	f.pending = make(map[string]bool)
+	f.syncing = pending
+	f.lock.Unlock()
+	defer func() {
+		f.lock.Lock()
+		f.syncing = nil
+		f.lock.Unlock()
+	}()
+
+	var errs []error
+	for filePath := range pending {
+		file, err := os.Open(filePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, file.Sync(), file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the background syncing after syncing the files still pending.
+func (f *filer) Close() error {
+	if f.durability != DurabilityInterval {
+		return nil
+	}
+
+	var err error
+	f.closeOnce.Do(func() {
+		close(f.stop)
+		<-f.done
+		err = f.syncPending()
+	})
+	return err
 }
 
 func (f *filer) GetFileReader(filePath string) (io.ReadCloser, error) {
@@ -53,6 +215,24 @@ func (f *filer) IsFileEmpty(filePath string) (bool, error) {
 	return false, err
 }
 
+func (f *filer) FileExists(filePath string) (bool, error) {
+	_, err := os.Stat(filePath)
+	if err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (f *filer) FileSize(filePath string) (int64, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 func (f *filer) AddFolder(rootPath string, folderName string) (string, error) {
 	// Create full path
 	absPath := filepath.Join(rootPath, folderName)
@@ -71,3 +251,46 @@ func (f *filer) GetFilePath(rootPath string, fileName string) string {
 	// It combines the root path with the file name.
 	return filepath.Join(rootPath, fileName)
 }
+
+func (f *filer) ListFiles(folderPath string, ext string) ([]string, error) {
+	// This function returns the names of the files in a folder with the given extension,
+	// without the extension.
+	entries, err := os.ReadDir(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ext {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), ext))
+	}
+	return names, nil
+}
+
+// RenameFile moves a file, then syncs its folder so the move survives a crash. A
+// file waiting for the background sync is synced under its new name.
+func (f *filer) RenameFile(oldPath string, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if f.durability == DurabilityInterval {
+		f.lock.Lock()
+		if f.pending[oldPath] || f.syncing[oldPath] {
+			delete(f.pending, oldPath)
+			f.pending[newPath] = true
+		}
+		f.lock.Unlock()
+	}
+	return f.syncDir(filepath.Dir(newPath))
+}
+
+func (f *filer) RemoveFile(filePath string) error {
+	err := os.Remove(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package disk
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncCounter counts the commits the node accessors ask to be made durable.
+type syncCounter struct {
+	FileAccessor
+	syncs map[string]int
+	// syncedBeforeRename counts the syncs of a renamed file made before its rename.
+	syncedBeforeRename map[string]int
+}
+
+func (s *syncCounter) Sync(filePath string, writer io.Writer) error {
+	s.syncs[filePath]++
+	return s.FileAccessor.Sync(filePath, writer)
+}
+
+func (s *syncCounter) RenameFile(oldPath string, newPath string) error {
+	if s.syncedBeforeRename != nil {
+		s.syncedBeforeRename[oldPath] = s.syncs[oldPath]
+	}
+	return s.FileAccessor.RenameFile(oldPath, newPath)
+}
+
+func TestAppendsSyncThroughFileAccessor(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := &syncCounter{FileAccessor: NewFileAccessor(), syncs: make(map[string]int)}
+
+	for _, s := range []NodeAccessor{NewCsvAccessor(f), NewBinaryAccessor(f)} {
+		filePath := filepath.Join(dir, "type1"+s.FileExtension())
+		require.NoError(t, s.CreateFileWithHeader(ctx, filePath))
+		require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()))
+		require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()))
+		require.Equal(t, 2, f.syncs[filePath])
+	}
+}
+
+func TestRewritesSyncBeforeRename(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := &syncCounter{FileAccessor: NewFileAccessor(), syncs: make(map[string]int), syncedBeforeRename: make(map[string]int)}
+
+	for _, s := range []NodeAccessor{NewCsvAccessor(f), NewBinaryAccessor(f)} {
+		filePath := filepath.Join(dir, "type1"+s.FileExtension())
+		require.NoError(t, s.RewriteNodesToFile(ctx, filePath, getTwoNodes()))
+		require.Equal(t, 1, f.syncedBeforeRename[filePath+".tmp"])
+
+		nodes, err := s.ReadNodesFromFile(ctx, filePath)
+		require.NoError(t, err)
+		require.Len(t, nodes, 2)
+	}
+}
+
+func TestIntervalDurabilitySyncsRenamedFolders(t *testing.T) {
+	dir := t.TempDir()
+	accessor, err := NewFileAccessorWithDurability(DurabilityInterval, time.Hour)
+	require.NoError(t, err)
+	f := accessor.(*filer)
+
+	tmpPath := filepath.Join(dir, "type1.csv.tmp")
+	require.NoError(t, os.WriteFile(tmpPath, []byte(CsvFileHeader), 0644))
+	require.NoError(t, f.RenameFile(tmpPath, filepath.Join(dir, "type1.csv")))
+	f.lock.Lock()
+	require.True(t, f.pending[dir])
+	f.lock.Unlock()
+
+	// Folders are synced like files.
+	require.NoError(t, f.Close())
+	require.Empty(t, f.pending)
+}
+
+func TestIntervalDurabilitySyncsRewrittenFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	accessor, err := NewFileAccessorWithDurability(DurabilityInterval, time.Hour)
+	require.NoError(t, err)
+	f := accessor.(*filer)
+	defer f.Close()
+
+	// The rewritten data is synced under the name it is renamed to.
+	for _, s := range []NodeAccessor{NewCsvAccessor(f), NewBinaryAccessor(f)} {
+		filePath := filepath.Join(dir, "type1"+s.FileExtension())
+		require.NoError(t, s.RewriteNodesToFile(ctx, filePath, getTwoNodes()))
+		f.lock.Lock()
+		require.True(t, f.pending[filePath])
+		require.False(t, f.pending[filePath+".tmp"])
+		f.lock.Unlock()
+	}
+
+	// So is a file renamed while the background loop syncs it.
+	tmpPath := filepath.Join(dir, "type2.csv.tmp")
+	require.NoError(t, os.WriteFile(tmpPath, []byte(CsvFileHeader), 0644))
+	require.NoError(t, f.Sync(tmpPath, nil))
+	f.lock.Lock()
+	f.syncing, f.pending = f.pending, make(map[string]bool)
+	f.lock.Unlock()
+	require.NoError(t, f.RenameFile(tmpPath, filepath.Join(dir, "type2.csv")))
+	f.lock.Lock()
+	require.True(t, f.pending[filepath.Join(dir, "type2.csv")])
+	f.lock.Unlock()
+}
+
+func TestRepairsSyncThroughFileAccessor(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := &syncCounter{FileAccessor: NewFileAccessor(), syncs: make(map[string]int)}
+
+	filePath := filepath.Join(dir, "type1.csv")
+	s := NewCsvAccessor(f)
+	require.NoError(t, os.WriteFile(filePath, []byte(csvTwoNodes+"3,type3,no"), 0644))
+
+	report, err := s.CheckFile(ctx, filePath, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Quarantined)
+	require.Equal(t, 1, f.syncs[filePath+QuarantineExtension])
+	require.Equal(t, 1, f.syncs[filePath+".tmp"])
+
+	require.NoError(t, CheckKey(f, dir, testKeyring(t, "key")))
+	require.Equal(t, 1, f.syncs[filepath.Join(dir, keyCheckFile)+".tmp"])
+}
+
+func TestDurabilityModes(t *testing.T) {
+	for _, durability := range []string{DurabilityAlways, DurabilityInterval, DurabilityNone} {
+		f, err := NewFileAccessorWithDurability(durability, time.Millisecond)
+		require.NoError(t, err)
+		require.Equal(t, durability, f.Durability())
+		require.NoError(t, f.Close())
+	}
+
+	f, err := NewFileAccessorWithDurability("", 0)
+	require.NoError(t, err)
+	require.Equal(t, DurabilityAlways, f.Durability())
+
+	_, err = NewFileAccessorWithDurability("sometimes", 0)
+	require.Error(t, err)
+}
+
+func TestIntervalDurabilitySyncsInBackground(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "type1.csv")
+	accessor, err := NewFileAccessorWithDurability(DurabilityInterval, time.Hour)
+	require.NoError(t, err)
+	f := accessor.(*filer)
+
+	s := NewCsvAccessor(f)
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()))
+	f.lock.Lock()
+	require.True(t, f.pending[filePath])
+	f.lock.Unlock()
+
+	// Close syncs what the background loop has not synced yet.
+	require.NoError(t, f.Close())
+	require.Empty(t, f.pending)
+	require.NoError(t, f.Close())
+}
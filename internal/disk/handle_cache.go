@@ -0,0 +1,355 @@
+package disk
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+const (
+	DefaultMaxOpenFiles = 256
+
+	// appendFlags are the flags of writers that are kept open between writes.
+	appendFlags = os.O_APPEND | os.O_CREATE | os.O_WRONLY
+)
+
+type HandleStats struct {
+	Open      int    `json:"open"`
+	MaxOpen   int    `json:"maxOpen"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+type cachedHandle struct {
+	filePath string
+	writer   io.WriteCloser
+	refs     int
+	evicted  bool
+	elem     *list.Element
+}
+
+// idleReader is an open reader of a file waiting in its pool to be handed out again.
+type idleReader struct {
+	pool   *readerPool
+	reader io.ReadSeekCloser
+	elem   *list.Element
+}
+
+// readerPool holds the idle readers of a file. It is dropped when the file changes
+// in place, so readers handed out before are closed on release instead of pooled.
+type readerPool struct {
+	filePath string
+	idle     []*idleReader
+	out      int
+	dropped  bool
+}
+
+// HandleCache is a FileAccessor keeping append writers and readers open between uses.
+// Concurrent reads of a file each get a reader of their own; released readers are
+// pooled and handed out again from the start of the file. At most maxOpen writers
+// and idle readers stay open; the least recently used one is closed first. A writer
+// still in use when evicted is closed once it is released. Writers opened with any
+// other flags, renames and removals bypass the cache and drop the cached handles of
+// the file first.
+type HandleCache struct {
+	FileAccessor
+	maxOpen int
+
+	lock      sync.Mutex
+	handles   map[string]*cachedHandle
+	pools     map[string]*readerPool
+	idle      int
+	lru       *list.List
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func NewHandleCache(f FileAccessor, maxOpen int) *HandleCache {
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenFiles
+	}
+	return &HandleCache{
+		FileAccessor: f,
+		maxOpen:      maxOpen,
+		handles:      make(map[string]*cachedHandle),
+		pools:        make(map[string]*readerPool),
+		lru:          list.New(),
+	}
+}
+
+func (hc *HandleCache) GetFileWriter(filePath string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	if flag != appendFlags {
+		if err := hc.invalidate(filePath); err != nil {
+			return nil, err
+		}
+		return hc.FileAccessor.GetFileWriter(filePath, flag, perm)
+	}
+
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	h, exists := hc.handles[filePath]
+	if exists {
+		hc.hits++
+		hc.lru.MoveToFront(h.elem)
+	} else {
+		hc.misses++
+		writer, err := hc.FileAccessor.GetFileWriter(filePath, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		h = &cachedHandle{filePath: filePath, writer: writer}
+		h.elem = hc.lru.PushFront(h)
+		hc.handles[filePath] = h
+	}
+	h.refs++
+
+	hc.evictLocked()
+	return &handleWriter{cache: hc, handle: h}, nil
+}
+
+// GetFileReader hands out an idle reader of the file, rewound to its start, or opens
+// a new one. Readers that cannot seek are not pooled.
+func (hc *HandleCache) GetFileReader(filePath string) (io.ReadCloser, error) {
+	hc.lock.Lock()
+	pool := hc.pools[filePath]
+	if pool != nil && len(pool.idle) > 0 {
+		r := pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		hc.lru.Remove(r.elem)
+		hc.idle--
+		pool.out++
+		hc.hits++
+		hc.lock.Unlock()
+
+		if _, err := r.reader.Seek(0, io.SeekStart); err != nil {
+			hc.releasePool(pool)
+			r.reader.Close()
+			return nil, err
+		}
+		return &pooledReader{ReadSeekCloser: r.reader, cache: hc, pool: pool}, nil
+	}
+	if pool == nil {
+		pool = &readerPool{filePath: filePath}
+		hc.pools[filePath] = pool
+	}
+	pool.out++
+	hc.misses++
+	hc.lock.Unlock()
+
+	reader, err := hc.FileAccessor.GetFileReader(filePath)
+	seeker, ok := reader.(io.ReadSeekCloser)
+	if err != nil || !ok {
+		hc.releasePool(pool)
+		return reader, err
+	}
+	return &pooledReader{ReadSeekCloser: seeker, cache: hc, pool: pool}, nil
+}
+
+func (hc *HandleCache) RenameFile(oldPath string, newPath string) error {
+	if err := errors.Join(hc.invalidate(oldPath), hc.invalidate(newPath)); err != nil {
+		return err
+	}
+	return hc.FileAccessor.RenameFile(oldPath, newPath)
+}
+
+func (hc *HandleCache) RemoveFile(filePath string) error {
+	if err := hc.invalidate(filePath); err != nil {
+		return err
+	}
+	return hc.FileAccessor.RemoveFile(filePath)
+}
+
+func (hc *HandleCache) Stats() HandleStats {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	return HandleStats{
+		Open:      len(hc.handles) + hc.idle,
+		MaxOpen:   hc.maxOpen,
+		Hits:      hc.hits,
+		Misses:    hc.misses,
+		Evictions: hc.evictions,
+	}
+}
+
+// Close closes every idle handle and then the underlying accessor. Handles in
+// use are closed when released.
+func (hc *HandleCache) Close() error {
+	hc.lock.Lock()
+	var errs []error
+	for _, h := range hc.handles {
+		errs = append(errs, hc.dropLocked(h))
+	}
+	for _, pool := range hc.pools {
+		errs = append(errs, hc.dropPoolLocked(pool))
+	}
+	hc.lock.Unlock()
+
+	errs = append(errs, hc.FileAccessor.Close())
+	return errors.Join(errs...)
+}
+
+func (hc *HandleCache) invalidate(filePath string) error {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	var errs []error
+	if pool, exists := hc.pools[filePath]; exists {
+		errs = append(errs, hc.dropPoolLocked(pool))
+	}
+	if h, exists := hc.handles[filePath]; exists {
+		errs = append(errs, hc.dropLocked(h))
+	}
+	return errors.Join(errs...)
+}
+
+func (hc *HandleCache) evictLocked() {
+	for elem := hc.lru.Back(); elem != nil && len(hc.handles)+hc.idle > hc.maxOpen; {
+		value := elem.Value
+		elem = elem.Prev()
+
+		var err error
+		switch v := value.(type) {
+		case *cachedHandle:
+			if v.refs > 0 {
+				continue
+			}
+			err = hc.dropLocked(v)
+		case *idleReader:
+			err = hc.removeIdleLocked(v)
+		}
+		hc.evictions++
+		if err != nil {
+			slog.Error("Error closing evicted file handle", "error", err)
+		}
+	}
+}
+
+// removeIdleLocked closes an idle reader and takes it out of its pool.
+func (hc *HandleCache) removeIdleLocked(r *idleReader) error {
+	pool := r.pool
+	for i, idle := range pool.idle {
+		if idle == r {
+			pool.idle = append(pool.idle[:i], pool.idle[i+1:]...)
+			break
+		}
+	}
+	if len(pool.idle) == 0 && pool.out == 0 {
+		delete(hc.pools, pool.filePath)
+	}
+	hc.lru.Remove(r.elem)
+	hc.idle--
+	return r.reader.Close()
+}
+
+// dropPoolLocked closes the idle readers of a file and forgets its pool, so readers
+// still handed out are closed when released.
+func (hc *HandleCache) dropPoolLocked(pool *readerPool) error {
+	var errs []error
+	for _, r := range pool.idle {
+		hc.lru.Remove(r.elem)
+		hc.idle--
+		errs = append(errs, r.reader.Close())
+	}
+	pool.idle = nil
+	pool.dropped = true
+	delete(hc.pools, pool.filePath)
+	return errors.Join(errs...)
+}
+
+// releasePool gives back a reader of the pool that was not handed out after all.
+func (hc *HandleCache) releasePool(pool *readerPool) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	pool.out--
+	if len(pool.idle) == 0 && pool.out == 0 && !pool.dropped {
+		delete(hc.pools, pool.filePath)
+	}
+}
+
+// releaseReader pools a reader handed out by GetFileReader, unless its file changed
+// in place since.
+func (hc *HandleCache) releaseReader(pool *readerPool, reader io.ReadSeekCloser) error {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	pool.out--
+	if pool.dropped {
+		return reader.Close()
+	}
+	r := &idleReader{pool: pool, reader: reader}
+	r.elem = hc.lru.PushFront(r)
+	pool.idle = append(pool.idle, r)
+	hc.idle++
+	hc.evictLocked()
+	return nil
+}
+
+// dropLocked removes the handle from the cache and closes it unless it is still in use.
+func (hc *HandleCache) dropLocked(h *cachedHandle) error {
+	delete(hc.handles, h.filePath)
+	hc.lru.Remove(h.elem)
+	h.evicted = true
+	if h.refs > 0 {
+		return nil
+	}
+	return h.writer.Close()
+}
+
+func (hc *HandleCache) release(h *cachedHandle) error {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	h.refs--
+	if h.evicted && h.refs == 0 {
+		return h.writer.Close()
+	}
+	hc.evictLocked()
+	return nil
+}
+
+// pooledReader is handed out for a pooled reader. Closing it returns it to the pool.
+type pooledReader struct {
+	io.ReadSeekCloser
+	cache    *HandleCache
+	pool     *readerPool
+	released bool
+}
+
+func (r *pooledReader) Close() error {
+	if r.released {
+		return nil
+	}
+	r.released = true
+	return r.cache.releaseReader(r.pool, r.ReadSeekCloser)
+}
+
+// handleWriter is handed out for a cached handle. Closing it only releases the handle.
+type handleWriter struct {
+	cache    *HandleCache
+	handle   *cachedHandle
+	released bool
+}
+
+func (w *handleWriter) Write(p []byte) (int, error) {
+	return w.handle.writer.Write(p)
+}
+
+func (w *handleWriter) Sync() error {
+	return syncWriter(w.handle.writer)
+}
+
+func (w *handleWriter) Close() error {
+	if w.released {
+		return nil
+	}
+	w.released = true
+	return w.cache.release(w.handle)
+}
@@ -0,0 +1,133 @@
+package disk
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func appendLine(t *testing.T, hc *HandleCache, filePath string, line string) {
+	writer, err := hc.GetFileWriter(filePath, appendFlags, 0644)
+	require.NoError(t, err)
+	_, err = io.WriteString(writer, line)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+}
+
+func TestHandleCacheKeepsWritersOpen(t *testing.T) {
+	dir := t.TempDir()
+	hc := NewHandleCache(NewFileAccessor(), 2)
+	defer hc.Close()
+
+	a := filepath.Join(dir, "a.csv")
+	appendLine(t, hc, a, "1\n")
+	appendLine(t, hc, a, "2\n")
+
+	stats := hc.Stats()
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, 1, stats.Open)
+
+	data, err := os.ReadFile(a)
+	require.NoError(t, err)
+	require.Equal(t, "1\n2\n", string(data))
+}
+
+func TestHandleCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	hc := NewHandleCache(NewFileAccessor(), 2)
+	defer hc.Close()
+
+	appendLine(t, hc, filepath.Join(dir, "a.csv"), "a\n")
+	appendLine(t, hc, filepath.Join(dir, "b.csv"), "b\n")
+	appendLine(t, hc, filepath.Join(dir, "a.csv"), "a\n")
+	appendLine(t, hc, filepath.Join(dir, "c.csv"), "c\n")
+
+	stats := hc.Stats()
+	require.Equal(t, 2, stats.Open)
+	require.Equal(t, uint64(1), stats.Evictions)
+	_, cached := hc.handles[filepath.Join(dir, "b.csv")]
+	require.False(t, cached)
+}
+
+func TestHandleCacheDropsRenamedFiles(t *testing.T) {
+	dir := t.TempDir()
+	hc := NewHandleCache(NewFileAccessor(), 2)
+	defer hc.Close()
+
+	a := filepath.Join(dir, "a.csv")
+	tmp := filepath.Join(dir, "a.csv.tmp")
+	appendLine(t, hc, a, "old\n")
+	require.NoError(t, os.WriteFile(tmp, []byte("new\n"), 0644))
+	require.NoError(t, hc.RenameFile(tmp, a))
+	appendLine(t, hc, a, "more\n")
+
+	data, err := os.ReadFile(a)
+	require.NoError(t, err)
+	require.Equal(t, "new\nmore\n", string(data))
+}
+
+func readAll(t *testing.T, hc *HandleCache, filePath string) string {
+	reader, err := hc.GetFileReader(filePath)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	return string(data)
+}
+
+func TestHandleCachePoolsReaders(t *testing.T) {
+	dir := t.TempDir()
+	hc := NewHandleCache(NewFileAccessor(), 2)
+	defer hc.Close()
+
+	a := filepath.Join(dir, "a.csv")
+	require.NoError(t, os.WriteFile(a, []byte("1\n"), 0644))
+	require.Equal(t, "1\n", readAll(t, hc, a))
+	require.Equal(t, "1\n", readAll(t, hc, a))
+
+	stats := hc.Stats()
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, 1, stats.Open)
+
+	// Concurrent readers get handles of their own.
+	first, err := hc.GetFileReader(a)
+	require.NoError(t, err)
+	second, err := hc.GetFileReader(a)
+	require.NoError(t, err)
+	require.NotSame(t, first.(*pooledReader).ReadSeekCloser, second.(*pooledReader).ReadSeekCloser)
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+	require.Equal(t, 2, hc.Stats().Open)
+
+	// Readers of other files evict the least recently used ones.
+	b := filepath.Join(dir, "b.csv")
+	require.NoError(t, os.WriteFile(b, []byte("b\n"), 0644))
+	require.Equal(t, "b\n", readAll(t, hc, b))
+	require.Equal(t, 2, hc.Stats().Open)
+}
+
+func TestHandleCacheDropsReadersOfRenamedFiles(t *testing.T) {
+	dir := t.TempDir()
+	hc := NewHandleCache(NewFileAccessor(), 2)
+	defer hc.Close()
+
+	a := filepath.Join(dir, "a.csv")
+	tmp := filepath.Join(dir, "a.csv.tmp")
+	require.NoError(t, os.WriteFile(a, []byte("old\n"), 0644))
+	require.Equal(t, "old\n", readAll(t, hc, a))
+
+	// A reader handed out before the rename is closed on release, not pooled.
+	reader, err := hc.GetFileReader(a)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(tmp, []byte("new\n"), 0644))
+	require.NoError(t, hc.RenameFile(tmp, a))
+	require.NoError(t, reader.Close())
+	require.Equal(t, 0, hc.Stats().Open)
+
+	require.Equal(t, "new\n", readAll(t, hc, a))
+}
@@ -0,0 +1,245 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// keyCheckFile holds a known value sealed with the active key, so a wrong key is
+// noticed when the database is opened instead of on the first read of a node.
+const (
+	keyCheckFile  = "keycheck"
+	keyCheckValue = "jamesdb key check"
+
+	keyIDSize = 4
+)
+
+var (
+	ErrKeyMismatch = errors.New("encryption key does not match the database")
+	ErrMissingKey  = errors.New("database is encrypted but no encryption key is configured")
+	ErrSealed      = errors.New("cannot open sealed data")
+)
+
+type ringKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+// Keyring seals data with AES-GCM under its active key and opens data sealed with any
+// of its keys. Sealed data starts with the ID of its key, derived from the key itself,
+// so rotating keys only means putting the new key first and keeping the old ones
+// until everything was rewritten.
+type Keyring struct {
+	keys []ringKey
+}
+
+// LoadKeyring reads base64 encoded AES keys from a comma separated list and from a key
+// file with one key per line. The first key is the active one. Without keys it returns nil.
+func LoadKeyring(keys string, keyFile string) (*Keyring, error) {
+	var encoded []string
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			encoded = append(encoded, key)
+		}
+	}
+
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %w", err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				encoded = append(encoded, line)
+			}
+		}
+	}
+
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	k := &Keyring{}
+	for i, key := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d is not base64: %w", i+1, err)
+		}
+		if err := k.add(raw); err != nil {
+			return nil, fmt.Errorf("encryption key %d: %w", i+1, err)
+		}
+	}
+	return k, nil
+}
+
+func (k *Keyring) add(raw []byte) error {
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(raw)
+	key := ringKey{aead: aead}
+	copy(key.id[:], sum[:])
+	k.keys = append(k.keys, key)
+	return nil
+}
+
+// Seal encrypts data with the active key.
+func (k *Keyring) Seal(data []byte) ([]byte, error) {
+	key := k.keys[0]
+	nonceSize := key.aead.NonceSize()
+
+	out := make([]byte, keyIDSize+nonceSize, keyIDSize+nonceSize+len(data)+key.aead.Overhead())
+	copy(out, key.id[:])
+	if _, err := io.ReadFull(rand.Reader, out[keyIDSize:]); err != nil {
+		return nil, fmt.Errorf("reading random nonce: %w", err)
+	}
+	return key.aead.Seal(out, out[keyIDSize:], data, key.id[:]), nil
+}
+
+// Open decrypts data sealed with any key of the keyring.
+func (k *Keyring) Open(sealed []byte) ([]byte, error) {
+	if k == nil {
+		return nil, ErrMissingKey
+	}
+	if len(sealed) < keyIDSize {
+		return nil, fmt.Errorf("%w: too short", ErrSealed)
+	}
+	for _, key := range k.keys {
+		if !bytes.Equal(key.id[:], sealed[:keyIDSize]) {
+			continue
+		}
+		nonceSize := key.aead.NonceSize()
+		if len(sealed) < keyIDSize+nonceSize {
+			return nil, fmt.Errorf("%w: too short", ErrSealed)
+		}
+		data, err := key.aead.Open(nil, sealed[keyIDSize:keyIDSize+nonceSize], sealed[keyIDSize+nonceSize:], key.id[:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSealed, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%w: sealed with an unknown key", ErrSealed)
+}
+
+// isActive reports whether sealed data was sealed with the active key.
+func (k *Keyring) isActive(sealed []byte) bool {
+	return len(sealed) >= keyIDSize && bytes.Equal(k.keys[0].id[:], sealed[:keyIDSize])
+}
+
+// holds reports whether sealed data was sealed with a key of the keyring.
+func (k *Keyring) holds(sealed []byte) bool {
+	if k == nil || len(sealed) < keyIDSize {
+		return false
+	}
+	for _, key := range k.keys {
+		if bytes.Equal(key.id[:], sealed[:keyIDSize]) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSealedFile verifies that the keyring holds the key of every sealed block of a
+// file in blocks, so a key dropped while data sealed with it is left fails the open
+// instead of a later read. The file is read as stored; other files pass, and torn or
+// corrupt blocks are left to the readers of the file.
+func CheckSealedFile(f FileAccessor, filePath string, k *Keyring) error {
+	reader, err := f.GetFileReader(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	r := bufio.NewReader(reader)
+	magic, err := r.Peek(len(compressedMagic))
+	if err != nil || string(magic) != compressedMagic {
+		return nil
+	}
+	r.Discard(len(compressedMagic))
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size > maxRecordSize {
+			return nil
+		}
+		head, err := r.Peek(min(int(size), 1+keyIDSize))
+		if err != nil {
+			return nil
+		}
+		if len(head) > 0 && head[0] == blockSealed && !k.holds(head[1:]) {
+			return fmt.Errorf("%w: %s is sealed with a key missing from the keyring", ErrKeyMismatch, filePath)
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return nil
+		}
+	}
+}
+
+// CheckKey verifies the keyring against the database in rootPath. The first encrypted
+// open records the active key; after a rotation the record moves to the new key.
+func CheckKey(f FileAccessor, rootPath string, k *Keyring) error {
+	filePath := f.GetFilePath(rootPath, keyCheckFile)
+	exists, err := f.FileExists(filePath)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		sealed, err := readFile(f, filePath)
+		if err != nil {
+			return err
+		}
+		if k == nil {
+			return ErrMissingKey
+		}
+		value, err := k.Open(sealed)
+		if err != nil || string(value) != keyCheckValue {
+			return ErrKeyMismatch
+		}
+		if k.isActive(sealed) {
+			return nil
+		}
+	} else if k == nil {
+		return nil
+	}
+
+	sealed, err := k.Seal([]byte(keyCheckValue))
+	if err != nil {
+		return err
+	}
+	tmpPath := filePath + ".tmp"
+	writer, err := f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(sealed)
+	if err == nil {
+		err = f.Sync(tmpPath, writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return f.RenameFile(tmpPath, filePath)
+}
@@ -0,0 +1,197 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func encodedKey(name string) string {
+	key := sha256.Sum256([]byte(name))
+	return base64.StdEncoding.EncodeToString(key[:])
+}
+
+func testKeyring(t *testing.T, names ...string) *Keyring {
+	var keys []string
+	for _, name := range names {
+		keys = append(keys, encodedKey(name))
+	}
+	k, err := LoadKeyring(strings.Join(keys, ","), "")
+	require.NoError(t, err)
+	return k
+}
+
+func seal(t *testing.T, k *Keyring, data []byte) []byte {
+	sealed, err := k.Seal(data)
+	require.NoError(t, err)
+	return sealed
+}
+
+func TestKeyringSealsAndOpens(t *testing.T) {
+	old := testKeyring(t, "old")
+	sealed := seal(t, old, []byte("secret"))
+	require.NotContains(t, string(sealed), "secret")
+
+	data, err := old.Open(sealed)
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(data))
+
+	_, err = testKeyring(t, "other").Open(sealed)
+	require.ErrorIs(t, err, ErrSealed)
+	sealed[len(sealed)-1] ^= 1
+	_, err = old.Open(sealed)
+	require.ErrorIs(t, err, ErrSealed)
+
+	// After a rotation data sealed with the old key stays readable.
+	rotated := testKeyring(t, "new", "old")
+	data, err = rotated.Open(seal(t, old, []byte("secret")))
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(data))
+	require.True(t, rotated.isActive(seal(t, rotated, nil)))
+	require.False(t, rotated.isActive(seal(t, old, nil)))
+}
+
+func TestLoadKeyring(t *testing.T) {
+	k, err := LoadKeyring("", "")
+	require.NoError(t, err)
+	require.Nil(t, k)
+
+	_, err = LoadKeyring("not base64!", "")
+	require.Error(t, err)
+	_, err = LoadKeyring(base64.StdEncoding.EncodeToString([]byte("short")), "")
+	require.Error(t, err)
+
+	// Keys of the file follow the ones given directly.
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte("# rotated keys\n"+encodedKey("old")+"\n\n"), 0600))
+	k, err = LoadKeyring(encodedKey("new"), keyFile)
+	require.NoError(t, err)
+	require.Len(t, k.keys, 2)
+	require.True(t, k.isActive(seal(t, testKeyring(t, "new"), nil)))
+}
+
+func TestCheckKey(t *testing.T) {
+	f := NewFileAccessor()
+	dir := t.TempDir()
+
+	require.NoError(t, CheckKey(f, dir, nil))
+	require.NoError(t, CheckKey(f, dir, testKeyring(t, "old")))
+	require.NoError(t, CheckKey(f, dir, testKeyring(t, "old")))
+
+	require.ErrorIs(t, CheckKey(f, dir, testKeyring(t, "other")), ErrKeyMismatch)
+	require.ErrorIs(t, CheckKey(f, dir, nil), ErrMissingKey)
+
+	// A rotation moves the check to the new key, after which the old key alone no longer matches.
+	require.NoError(t, CheckKey(f, dir, testKeyring(t, "new", "old")))
+	require.NoError(t, CheckKey(f, dir, testKeyring(t, "new")))
+	require.ErrorIs(t, CheckKey(f, dir, testKeyring(t, "old")), ErrKeyMismatch)
+}
+
+func TestCheckSealedFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := NewFileAccessor()
+	filePath := filepath.Join(dir, "person.csv")
+
+	s := NewCsvAccessor(NewBlockFileAccessor(f, dir, ".csv", BlockFormat{Keys: testKeyring(t, "old")}))
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()))
+
+	// The old key is needed until the file is rewritten after a rotation.
+	require.NoError(t, CheckSealedFile(f, filePath, testKeyring(t, "old")))
+	require.NoError(t, CheckSealedFile(f, filePath, testKeyring(t, "new", "old")))
+	require.ErrorIs(t, CheckSealedFile(f, filePath, testKeyring(t, "new")), ErrKeyMismatch)
+	require.NoError(t, CheckSealedFile(f, filepath.Join(dir, "missing.csv"), testKeyring(t, "new")))
+}
+
+func TestSealedNodeFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := NewFileAccessor()
+	filePath := filepath.Join(dir, "person.csv")
+
+	// A plain file is sealed before anything is appended to it.
+	plain := NewCsvAccessor(f)
+	require.NoError(t, plain.CreateFileWithHeader(ctx, filePath))
+	require.NoError(t, plain.AppendNodesToFile(ctx, filePath, getTwoNodes()[:1]))
+
+	files := NewBlockFileAccessor(f, dir, ".csv", BlockFormat{Keys: testKeyring(t, "old")})
+	s := NewCsvAccessor(files)
+	require.NoError(t, s.AppendNodesToFile(ctx, filePath, getTwoNodes()[1:]))
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte(compressedMagic)))
+	require.NotContains(t, string(data), getTwoNodes()[0].Name)
+	require.NotContains(t, string(data), getTwoNodes()[1].Name)
+
+	nodes, err := s.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, getTwoNodes(), nodes)
+
+	// Rewriting after a rotation reseals the file with the new key.
+	rotated := NewCsvAccessor(NewBlockFileAccessor(f, dir, ".csv", BlockFormat{Keys: testKeyring(t, "new", "old")}))
+	require.NoError(t, rotated.RewriteNodesToFile(ctx, filePath, nodes))
+	newFiles := NewBlockFileAccessor(f, dir, ".csv", BlockFormat{Keys: testKeyring(t, "new")})
+	newOnly := NewCsvAccessor(newFiles)
+	nodes, err = newOnly.ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, getTwoNodes(), nodes)
+
+	_, err = s.ReadNodesFromFile(ctx, filePath)
+	require.ErrorIs(t, err, ErrSealed)
+
+	// Quarantined records stay sealed.
+	writer, err := newFiles.GetFileWriter(filePath, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("secret,row\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	report, err := newOnly.CheckFile(ctx, filePath, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Quarantined)
+	data, err = os.ReadFile(filePath + QuarantineExtension)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "secret")
+	quarantined, err := readFile(newFiles, filePath+QuarantineExtension)
+	require.NoError(t, err)
+	require.Equal(t, "secret,row\n", string(quarantined))
+}
+
+func TestSealFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := NewFileAccessor()
+	filePath := filepath.Join(dir, "person.csv")
+	emptyPath := filepath.Join(dir, "empty.csv")
+
+	plain := NewCsvAccessor(f)
+	require.NoError(t, plain.CreateFileWithHeader(ctx, filePath))
+	require.NoError(t, plain.AppendNodesToFile(ctx, filePath, getTwoNodes()))
+	require.NoError(t, os.WriteFile(emptyPath, nil, 0644))
+
+	// Accessors without keys leave plain files alone.
+	require.NoError(t, SealFiles(NewCompressedFileAccessor(f, dir, ".csv", Compression{})))
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Contains(t, string(data), getTwoNodes()[0].Name)
+
+	files := NewBlockFileAccessor(f, dir, ".csv", BlockFormat{Keys: testKeyring(t, "key")})
+	require.NoError(t, SealFiles(files))
+	data, err = os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte(compressedMagic)))
+	require.NotContains(t, string(data), getTwoNodes()[0].Name)
+	data, err = os.ReadFile(emptyPath)
+	require.NoError(t, err)
+	require.Empty(t, data)
+
+	nodes, err := NewCsvAccessor(files).ReadNodesFromFile(ctx, filePath)
+	require.NoError(t, err)
+	require.Equal(t, getTwoNodes(), nodes)
+}
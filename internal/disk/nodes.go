@@ -0,0 +1,80 @@
+package disk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zmjung/jamesdb/graph"
+)
+
+const (
+	FormatCsv    = "csv"
+	FormatBinary = "binary"
+)
+
+// NodeAccessor stores the nodes of a type in a single file using one row format.
+type NodeAccessor interface {
+	FileExtension() string
+	ReadNodesFromFile(ctx context.Context, filePath string) ([]graph.Node, error)
+	AppendNodesToFile(ctx context.Context, filePath string, nodes []graph.Node) error
+	RewriteNodesToFile(ctx context.Context, filePath string, nodes []graph.Node) error
+	CreateFileWithHeader(ctx context.Context, filePath string) error
+	IsLegacyFile(ctx context.Context, filePath string) (bool, error)
+	CheckFile(ctx context.Context, filePath string, quarantine bool) (*FileReport, error)
+}
+
+func NewNodeAccessor(format string, f FileAccessor) (NodeAccessor, error) {
+	switch format {
+	case "", FormatCsv:
+		return NewCsvAccessor(f), nil
+	case FormatBinary:
+		return NewBinaryAccessor(f), nil
+	}
+	return nil, fmt.Errorf("unknown storage format %q", format)
+}
+
+// ConvertFiles rewrites every node file of the source format in the folder with the
+// target format and removes the source files. It must not run while a server uses the folder.
+func ConvertFiles(ctx context.Context, f FileAccessor, folderPath string, from NodeAccessor, to NodeAccessor) (int, error) {
+	names, err := f.ListFiles(folderPath, from.FileExtension())
+	if err != nil {
+		return 0, err
+	}
+
+	for i, name := range names {
+		source := f.GetFilePath(folderPath, name+from.FileExtension())
+		target := f.GetFilePath(folderPath, name+to.FileExtension())
+
+		exists, err := f.FileExists(target)
+		if err != nil {
+			return i, err
+		}
+		if exists {
+			return i, fmt.Errorf("cannot convert %s: %s already exists", source, target)
+		}
+
+		nodes, err := from.ReadNodesFromFile(ctx, source)
+		if err != nil {
+			return i, err
+		}
+		legacy, err := from.IsLegacyFile(ctx, source)
+		if err != nil {
+			return i, err
+		}
+		if legacy {
+			// Nodes written before versioning start at version 1.
+			for j := range nodes {
+				if nodes[j].Version == 0 {
+					nodes[j].Version = 1
+				}
+			}
+		}
+		if err := to.RewriteNodesToFile(ctx, target, nodes); err != nil {
+			return i, err
+		}
+		if err := f.RemoveFile(source); err != nil {
+			return i, err
+		}
+	}
+	return len(names), nil
+}
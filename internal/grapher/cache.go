@@ -0,0 +1,250 @@
+package grapher
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+
+	"github.com/zmjung/jamesdb/graph"
+)
+
+// nodeOverhead approximates the memory a cached node takes besides its strings.
+const nodeOverhead = 64
+
+type CacheStats struct {
+	Size    int64                     `json:"size"`
+	MaxSize int64                     `json:"maxSize"`
+	Types   map[string]TypeCacheStats `json:"types"`
+}
+
+type TypeCacheStats struct {
+	Cached  bool    `json:"cached"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+}
+
+type cacheEntry struct {
+	nodeType string
+	nodes    []graph.Node
+	size     int64
+	elem     *list.Element
+}
+
+// nodeCache keeps every node of recently read types in memory, up to maxSize bytes
+// in total; the least recently used type is dropped first. Workers update the cached
+// nodes of their type under their lock, so a cached type always matches the engine.
+type nodeCache struct {
+	lock    sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[string]*cacheEntry
+	lru     *list.List
+	types   map[string]*TypeCacheStats
+}
+
+// newNodeCache returns nil when maxSize is not positive, which disables caching.
+func newNodeCache(maxSize int64) *nodeCache {
+	if maxSize <= 0 {
+		return nil
+	}
+	return &nodeCache{
+		maxSize: maxSize,
+		entries: make(map[string]*cacheEntry),
+		lru:     list.New(),
+		types:   make(map[string]*TypeCacheStats),
+	}
+}
+
+func nodeSize(node *graph.Node) int64 {
+	size := int64(nodeOverhead + len(node.ID) + len(node.Type) + len(node.Name))
+	for _, edge := range node.Edges {
+		size += int64(len(edge))
+	}
+	for k, v := range node.Traits {
+		size += int64(len(k) + len(v))
+	}
+	return size
+}
+
+func nodesSize(nodes []graph.Node) int64 {
+	var size int64
+	for i := range nodes {
+		size += nodeSize(&nodes[i])
+	}
+	return size
+}
+
+func (c *nodeCache) record(nodeType string, hit bool) {
+	stats, exists := c.types[nodeType]
+	if !exists {
+		stats = &TypeCacheStats{}
+		c.types[nodeType] = stats
+	}
+	if hit {
+		stats.Hits++
+	} else {
+		stats.Misses++
+	}
+}
+
+// get returns the cached nodes of a type. The slice is a copy, but the nodes share
+// their edges and traits with the cache and must not be changed in place.
+func (c *nodeCache) get(nodeType string) ([]graph.Node, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, exists := c.entries[nodeType]
+	c.record(nodeType, exists)
+	if !exists {
+		return nil, false
+	}
+	c.lru.MoveToFront(e.elem)
+	return slices.Clone(e.nodes), true
+}
+
+// getNode looks a node up in the cached nodes of its type. found is only meaningful when cached is set.
+func (c *nodeCache) getNode(nodeType string, id string) (node *graph.Node, found bool, cached bool) {
+	if c == nil {
+		return nil, false, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, exists := c.entries[nodeType]
+	c.record(nodeType, exists)
+	if !exists {
+		return nil, false, false
+	}
+	c.lru.MoveToFront(e.elem)
+
+	i := slices.IndexFunc(e.nodes, func(n graph.Node) bool { return n.ID == id })
+	if i < 0 {
+		return nil, false, true
+	}
+	n := e.nodes[i]
+	return &n, true, true
+}
+
+// put caches every node of a type. Types larger than the whole cache are not cached.
+func (c *nodeCache) put(nodeType string, nodes []graph.Node) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.removeLocked(nodeType)
+	size := nodesSize(nodes)
+	if size > c.maxSize {
+		return
+	}
+
+	e := &cacheEntry{nodeType: nodeType, nodes: slices.Clone(nodes), size: size}
+	e.elem = c.lru.PushFront(e)
+	c.entries[nodeType] = e
+	c.size += size
+	c.evictLocked()
+}
+
+// update applies a change to the cached nodes of a type, if they are cached.
+func (c *nodeCache) update(nodeType string, change func(nodes []graph.Node) []graph.Node) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, exists := c.entries[nodeType]
+	if !exists {
+		return
+	}
+
+	c.size -= e.size
+	e.nodes = change(e.nodes)
+	e.size = nodesSize(e.nodes)
+	c.size += e.size
+	c.evictLocked()
+}
+
+func (c *nodeCache) insert(nodeType string, nodes []graph.Node) {
+	c.update(nodeType, func(cached []graph.Node) []graph.Node {
+		return append(cached, nodes...)
+	})
+}
+
+func (c *nodeCache) replace(nodeType string, nodes []graph.Node) {
+	c.update(nodeType, func(cached []graph.Node) []graph.Node {
+		for _, node := range nodes {
+			i := slices.IndexFunc(cached, func(n graph.Node) bool { return n.ID == node.ID })
+			if i < 0 {
+				cached = append(cached, node)
+			} else {
+				cached[i] = node
+			}
+		}
+		return cached
+	})
+}
+
+func (c *nodeCache) remove(nodeType string, id string) {
+	c.update(nodeType, func(cached []graph.Node) []graph.Node {
+		return slices.DeleteFunc(cached, func(n graph.Node) bool { return n.ID == id })
+	})
+}
+
+// invalidate drops the cached nodes of the given types.
+func (c *nodeCache) invalidate(nodeTypes ...string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, nodeType := range nodeTypes {
+		c.removeLocked(nodeType)
+	}
+}
+
+func (c *nodeCache) removeLocked(nodeType string) {
+	e, exists := c.entries[nodeType]
+	if !exists {
+		return
+	}
+	delete(c.entries, nodeType)
+	c.lru.Remove(e.elem)
+	c.size -= e.size
+}
+
+func (c *nodeCache) evictLocked() {
+	for c.size > c.maxSize {
+		e := c.lru.Back().Value.(*cacheEntry)
+		c.removeLocked(e.nodeType)
+	}
+}
+
+func (c *nodeCache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stats := CacheStats{
+		Size:    c.size,
+		MaxSize: c.maxSize,
+		Types:   make(map[string]TypeCacheStats, len(c.types)),
+	}
+	for nodeType, s := range c.types {
+		ts := *s
+		ts.Cached = c.entries[nodeType] != nil
+		if total := ts.Hits + ts.Misses; total > 0 {
+			ts.HitRate = float64(ts.Hits) / float64(total)
+		}
+		stats.Types[nodeType] = ts
+	}
+	return stats
+}
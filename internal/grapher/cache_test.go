@@ -0,0 +1,62 @@
+package grapher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+func TestNodeCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	node := graph.Node{ID: "a", Type: "person", Name: "alice"}
+	c := newNodeCache(2 * nodeSize(&node))
+
+	c.put("person", []graph.Node{node})
+	c.put("pet", []graph.Node{{ID: "a", Type: "pet", Name: "alice"}})
+	_, cached := c.get("person")
+	require.True(t, cached)
+
+	c.put("robot", []graph.Node{{ID: "a", Type: "robot", Name: "alice"}})
+	_, cached = c.get("pet")
+	require.False(t, cached)
+	_, cached = c.get("person")
+	require.True(t, cached)
+
+	stats := c.stats()
+	require.LessOrEqual(t, stats.Size, stats.MaxSize)
+	require.Equal(t, TypeCacheStats{Cached: true, Hits: 2, HitRate: 1}, stats.Types["person"])
+	require.Equal(t, TypeCacheStats{Misses: 1}, stats.Types["pet"])
+}
+
+func TestWorkerKeepsCacheInSync(t *testing.T) {
+	ctx := context.Background()
+	engine := storage.NewMemoryEngine()
+	cache := newNodeCache(1 << 20)
+	w := newWorker(engine, cache, "person")
+	defer w.Close()
+
+	require.NoError(t, w.WriteNodes(ctx, []graph.Node{{ID: "a", Type: "person", Name: "alice"}}))
+	nodes, err := w.ReadNodes(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+
+	require.NoError(t, w.WriteNodes(ctx, []graph.Node{{ID: "b", Type: "person", Name: "bob"}}))
+	require.NoError(t, w.UpdateNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice smith"}, 1))
+	require.NoError(t, w.DeleteNode(ctx, "b", 1))
+
+	cached, err := w.ReadNodes(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []graph.Node{{ID: "a", Type: "person", Name: "alice smith", Version: 2}}, cached)
+
+	it, err := engine.Scan(ctx, "person")
+	require.NoError(t, err)
+	stored, err := storage.ReadAll(it)
+	require.NoError(t, err)
+	require.Equal(t, stored, cached)
+
+	stats := cache.stats().Types["person"]
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Positive(t, stats.Hits)
+}
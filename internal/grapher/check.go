@@ -0,0 +1,43 @@
+package grapher
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+var ErrCheckUnsupported = errors.New("storage engine does not support checks")
+
+// Check verifies the stored records of every type. Each type is checked while its
+// worker is paused, so quarantining records cannot race with writes.
+func (gs *graphService) Check(ctx context.Context, quarantine bool) ([]disk.FileReport, error) {
+	checker, ok := storage.AsChecker(gs.engine)
+	if !ok {
+		return nil, ErrCheckUnsupported
+	}
+
+	nodeTypes, err := gs.nodeTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]disk.FileReport, 0, len(nodeTypes))
+	for _, nodeType := range nodeTypes {
+		err := gs.workers.withExclusive([]string{nodeType}, func() error {
+			report, err := checker.CheckType(ctx, nodeType, quarantine)
+			if report != nil {
+				reports = append(reports, *report)
+			}
+			if report != nil && report.Quarantined > 0 {
+				gs.cache.invalidate(nodeType)
+			}
+			return err
+		})
+		if err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
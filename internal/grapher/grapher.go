@@ -2,75 +2,376 @@ package grapher
 
 import (
 	"context"
-	"log/slog"
+	"errors"
+	"io"
 	"sync"
 
 	"github.com/zmjung/jamesdb/config"
 	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/backup"
 	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
 )
 
-var instance Grapher
-var grapherOnce sync.Once
+var (
+	ErrNodeNotFound    = errors.New("node not found")
+	ErrUnknownEdge     = errors.New("edge references an unknown node")
+	ErrNodeReferenced  = errors.New("node is referenced by other nodes")
+	ErrVersionMismatch = errors.New("node version does not match")
+	ErrNoChangeLog     = errors.New("change log is not enabled")
+)
 
 type Grapher interface {
 	ReadNodesByType(ctx context.Context, nodeType string) ([]graph.Node, error)
+	ReadNode(ctx context.Context, nodeType string, id string) (*graph.Node, error)
+	ReadEdges(ctx context.Context, nodeType string, id string) ([]graph.Node, error)
+	ScanNodes(ctx context.Context, nodeType string, fn func(node graph.Node) error) error
+	ScanNodesAfter(ctx context.Context, nodeType string, after string, fn func(node graph.Node) error) error
 	WriteNode(ctx context.Context, node *graph.Node) error
+	WriteNodes(ctx context.Context, nodes []graph.Node) []error
+	UpdateNode(ctx context.Context, node *graph.Node, expectedVersion int64) error
+	DeleteNode(ctx context.Context, nodeType string, id string, expectedVersion int64) error
+	ListTypes(ctx context.Context) ([]TypeInfo, error)
+	DescribeType(ctx context.Context, nodeType string) (*TypeInfo, error)
+	RenameType(ctx context.Context, from string, to string) error
+	DropType(ctx context.Context, nodeType string) error
+	Check(ctx context.Context, quarantine bool) ([]disk.FileReport, error)
+	Backup(ctx context.Context, w io.Writer) (*backup.Manifest, error)
+	Commit(ctx context.Context, ops []TxOp) ([]graph.Node, error)
+	FollowChanges(ctx context.Context, after uint64, fn func(c storage.Change) error) error
+	Stats() GrapherStats
+	Close() error
 }
 
-type graphService struct {
-	f                disk.FileAccessor
-	csv              disk.CsvAccessor
-	rootPath         string
-	nodePath         string
-	nodeTypeToWorker map[string]Worker
-	lock             *sync.Mutex
+type GrapherStats struct {
+	Workers RegistryStats `json:"workers"`
+	Cache   CacheStats    `json:"cache"`
+	// ChangeLog tells whether changes are recorded, so backups can be rolled forward.
+	ChangeLog bool `json:"changeLog"`
 }
 
-func GetInstance(cfg *config.Config, f disk.FileAccessor, csv disk.CsvAccessor) Grapher {
-	grapherOnce.Do(func() {
-		instance = newGrapher(cfg, f, csv)
-	})
-	return instance
+type graphService struct {
+	engine    storage.Engine
+	cache     *nodeCache
+	rootPath  string
+	workers   *registry
+	integrity IntegrityMode
+	refLock   *sync.RWMutex
+	// keys seal the backups of an encrypted database.
+	keys *disk.Keyring
 }
 
-func newGrapher(cfg *config.Config, f disk.FileAccessor, csv disk.CsvAccessor) Grapher {
-	nodePath, err := f.AddFolder(cfg.Database.RootPath, "nodes")
+// New creates a grapher over the engine. Every open database has one of its own.
+func New(cfg *config.Config, engine storage.Engine) (Grapher, error) {
+	integrity, err := ParseIntegrityMode(cfg.Database.Integrity)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := disk.LoadKeyring(cfg.Database.EncryptionKey, cfg.Database.EncryptionKeyFile)
 	if err != nil {
-		slog.Error("Error creating nodes folder", "error", err)
-		return nil
+		return nil, err
 	}
 
-	slog.Debug("Set node path", "nodePath", nodePath)
+	cache := newNodeCache(cfg.Database.CacheSize)
+	newTypeWorker := func(nodeType string) Worker {
+		return newWorker(engine, cache, nodeType)
+	}
 
 	return &graphService{
-		f:                f,
-		csv:              csv,
-		rootPath:         cfg.Database.RootPath,
-		nodePath:         nodePath,
-		nodeTypeToWorker: make(map[string]Worker),
-		lock:             &sync.Mutex{},
-	}
+		engine:    engine,
+		cache:     cache,
+		rootPath:  cfg.Database.RootPath,
+		workers:   newRegistry(cfg.Database.MaxWorkers, cfg.Database.WorkerIdleTimeout, newTypeWorker),
+		integrity: integrity,
+		refLock:   &sync.RWMutex{},
+		keys:      keys,
+	}, nil
 }
 
-func (gs *graphService) getWorker(nodeType string) Worker {
-	w, exists := gs.nodeTypeToWorker[nodeType]
-	if exists {
-		return w
+// withWorker runs fn with the worker of the node type, which cannot be evicted meanwhile.
+func (gs *graphService) withWorker(nodeType string, fn func(w Worker) error) error {
+	if err := ValidateNodeType(nodeType); err != nil {
+		return err
+	}
+	w, release, err := gs.workers.acquire(nodeType)
+	if err != nil {
+		return err
 	}
+	defer release()
 
-	gs.lock.Lock()
-	defer gs.lock.Unlock()
+	return fn(w)
+}
 
-	w = newWorker(gs.f, gs.csv, gs.nodePath, nodeType)
-	gs.nodeTypeToWorker[nodeType] = w
-	return w
+// typeExists reports whether the engine stores the node type. Reads of
+// unknown types are answered without starting a worker.
+func (gs *graphService) typeExists(ctx context.Context, nodeType string) (bool, error) {
+	if err := ValidateNodeType(nodeType); err != nil {
+		return false, err
+	}
+	return gs.engine.HasType(ctx, nodeType)
 }
 
 func (gs *graphService) ReadNodesByType(ctx context.Context, nodeType string) ([]graph.Node, error) {
-	return gs.getWorker(nodeType).ReadNodes(ctx)
+	exists, err := gs.typeExists(ctx, nodeType)
+	if err != nil || !exists {
+		return EmptyGraphNodes, err
+	}
+
+	var nodes []graph.Node
+	err = gs.withWorker(nodeType, func(w Worker) error {
+		nodes, err = w.ReadNodes(ctx)
+		return err
+	})
+	return nodes, err
+}
+
+func (gs *graphService) ReadNode(ctx context.Context, nodeType string, id string) (*graph.Node, error) {
+	exists, err := gs.typeExists(ctx, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNodeNotFound
+	}
+
+	var node *graph.Node
+	err = gs.withWorker(nodeType, func(w Worker) error {
+		node, err = w.ReadNode(ctx, id)
+		return err
+	})
+	return node, err
+}
+
+// ReadEdges returns the stored nodes the edges of a node point at, in edge order.
+// Edges are IDs of any type, so every type is asked for them; edges to missing nodes
+// are skipped.
+func (gs *graphService) ReadEdges(ctx context.Context, nodeType string, id string) ([]graph.Node, error) {
+	node, err := gs.ReadNode(ctx, nodeType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, edge := range node.Edges {
+		if edge != NullEdge {
+			wanted[edge] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return EmptyGraphNodes, nil
+	}
+
+	found, err := gs.findNodes(ctx, wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	edges := make([]graph.Node, 0, len(found))
+	for _, edge := range node.Edges {
+		if n, ok := found[edge]; ok {
+			edges = append(edges, n)
+			delete(found, edge)
+		}
+	}
+	return edges, nil
+}
+
+// ScanNodes calls fn for every node of a type, reading them from the engine as they
+// are needed rather than all at once. Writes made during the scan may not be seen.
+func (gs *graphService) ScanNodes(ctx context.Context, nodeType string, fn func(node graph.Node) error) error {
+	return gs.scan(ctx, nodeType, Worker.Scan, fn)
+}
+
+// ScanNodesAfter calls fn for the nodes of a type with IDs after the given one, in
+// ID order, until fn fails. Pages of a type are read this way, so engines keeping
+// nodes sorted start at the page rather than reading the type.
+func (gs *graphService) ScanNodesAfter(ctx context.Context, nodeType string, after string, fn func(node graph.Node) error) error {
+	return gs.scan(ctx, nodeType, func(w Worker, ctx context.Context) (storage.Iterator, error) {
+		return w.ScanAfter(ctx, after)
+	}, fn)
+}
+
+func (gs *graphService) scan(ctx context.Context, nodeType string, open func(w Worker, ctx context.Context) (storage.Iterator, error), fn func(node graph.Node) error) error {
+	exists, err := gs.typeExists(ctx, nodeType)
+	if err != nil || !exists {
+		return err
+	}
+
+	var it storage.Iterator
+	err = gs.withWorker(nodeType, func(w Worker) error {
+		it, err = open(w, ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(it.Node()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
 }
 
 func (gs *graphService) WriteNode(ctx context.Context, node *graph.Node) error {
-	return gs.getWorker(node.Type).WriteNodes(ctx, []graph.Node{*node})
+	nodes := []graph.Node{*node}
+	defer func() { node.Version = nodes[0].Version }()
+
+	if gs.integrity != IntegrityNone {
+		gs.refLock.RLock()
+		defer gs.refLock.RUnlock()
+
+		if err := gs.checkEdges(ctx, nodes); err != nil {
+			return err
+		}
+	}
+
+	return gs.withWorker(node.Type, func(w Worker) error {
+		return w.WriteNodes(ctx, nodes)
+	})
+}
+
+// WriteNodes writes nodes of mixed types with a single write per type and returns
+// one error per node, in the same order. Stored nodes are updated in place. Edges may
+// point at other nodes of the batch.
+func (gs *graphService) WriteNodes(ctx context.Context, nodes []graph.Node) []error {
+	errs := make([]error, len(nodes))
+
+	if gs.integrity != IntegrityNone {
+		gs.refLock.RLock()
+		defer gs.refLock.RUnlock()
+
+		missing, err := gs.missingEdges(ctx, nodes)
+		if err != nil {
+			return fillErrors(errs, err)
+		}
+		// Edges may point at nodes written by the batch, unless those are refused too.
+		written := make(map[string]int, len(nodes))
+		for _, node := range nodes {
+			written[node.ID]++
+		}
+		for refused := true; refused; {
+			refused = false
+			for i, node := range nodes {
+				if errs[i] != nil {
+					continue
+				}
+				unknown := make(map[string]bool)
+				for _, edge := range node.Edges {
+					if missing[edge] && written[edge] == 0 {
+						unknown[edge] = true
+					}
+				}
+				if len(unknown) > 0 {
+					errs[i] = unknownEdgeError(unknown)
+					written[node.ID]--
+					refused = true
+				}
+			}
+		}
+	}
+
+	indexesByType := make(map[string][]int)
+	for i, node := range nodes {
+		if errs[i] == nil {
+			indexesByType[node.Type] = append(indexesByType[node.Type], i)
+		}
+	}
+
+	for nodeType, indexes := range indexesByType {
+		group := make([]graph.Node, len(indexes))
+		for j, i := range indexes {
+			group[j] = nodes[i]
+		}
+
+		err := gs.withWorker(nodeType, func(w Worker) error {
+			return w.WriteNodes(ctx, group)
+		})
+		for j, i := range indexes {
+			nodes[i] = group[j]
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+func fillErrors(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (gs *graphService) UpdateNode(ctx context.Context, node *graph.Node, expectedVersion int64) error {
+	exists, err := gs.typeExists(ctx, node.Type)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNodeNotFound
+	}
+
+	if gs.integrity != IntegrityNone {
+		gs.refLock.RLock()
+		defer gs.refLock.RUnlock()
+
+		if err := gs.checkEdges(ctx, []graph.Node{*node}); err != nil {
+			return err
+		}
+	}
+
+	return gs.withWorker(node.Type, func(w Worker) error {
+		return w.UpdateNode(ctx, node, expectedVersion)
+	})
+}
+
+func (gs *graphService) DeleteNode(ctx context.Context, nodeType string, id string, expectedVersion int64) error {
+	exists, err := gs.typeExists(ctx, nodeType)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNodeNotFound
+	}
+
+	if gs.integrity != IntegrityNone {
+		// Deletes block every other write so references cannot appear while they are resolved.
+		gs.refLock.Lock()
+		defer gs.refLock.Unlock()
+
+		return gs.resolveReferences(ctx, nodeType, id, expectedVersion)
+	}
+
+	return gs.withWorker(nodeType, func(w Worker) error {
+		return w.DeleteNode(ctx, id, expectedVersion)
+	})
+}
+
+// FollowChanges calls fn for every change after the sequence number after, then for
+// every new change until ctx is done. It needs the change log of the database.
+func (gs *graphService) FollowChanges(ctx context.Context, after uint64, fn func(c storage.Change) error) error {
+	logger, ok := storage.AsChangeLogger(gs.engine)
+	if !ok {
+		return ErrNoChangeLog
+	}
+	return logger.FollowChanges(ctx, after, fn)
+}
+
+func (gs *graphService) Stats() GrapherStats {
+	_, logged := storage.AsChangeLogger(gs.engine)
+	return GrapherStats{
+		Workers:   gs.workers.stats(),
+		Cache:     gs.cache.stats(),
+		ChangeLog: logged,
+	}
+}
+
+// Close commits pending writes, stops every worker and closes the engine.
+func (gs *graphService) Close() error {
+	return errors.Join(gs.workers.close(), gs.engine.Close())
 }
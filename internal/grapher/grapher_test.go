@@ -0,0 +1,371 @@
+package grapher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/backup"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+func newTestGrapher(t *testing.T, integrity IntegrityMode) Grapher {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+	cfg.Database.Integrity = string(integrity)
+
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	require.NotNil(t, g)
+	return g
+}
+
+func newConfiguredGrapher(t *testing.T, cfg *config.Config, engine storage.Engine) Grapher {
+	g, err := New(cfg, engine)
+	require.NoError(t, err)
+	return g
+}
+
+func newTestEngine(t *testing.T, cfg *config.Config) storage.Engine {
+	engine, err := storage.Open(cfg, disk.NewFileAccessor())
+	require.NoError(t, err)
+	return engine
+}
+
+func newTestConfig(t *testing.T) *config.Config {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+	return cfg
+}
+
+func TestUpdateNodeIncrementsVersion(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityNone)
+
+	node := &graph.Node{ID: "a", Type: "person", Name: "alice"}
+	require.NoError(t, g.WriteNode(ctx, node))
+	require.Equal(t, int64(1), node.Version)
+
+	update := &graph.Node{ID: "a", Type: "person", Name: "alice smith"}
+	require.NoError(t, g.UpdateNode(ctx, update, 1))
+	require.Equal(t, int64(2), update.Version)
+
+	stale := &graph.Node{ID: "a", Type: "person", Name: "alice jones"}
+	require.ErrorIs(t, g.UpdateNode(ctx, stale, 1), ErrVersionMismatch)
+	require.ErrorIs(t, g.DeleteNode(ctx, "person", "a", 1), ErrVersionMismatch)
+
+	stored, err := g.ReadNode(ctx, "person", "a")
+	require.NoError(t, err)
+	require.Equal(t, "alice smith", stored.Name)
+	require.Equal(t, int64(2), stored.Version)
+
+	require.NoError(t, g.DeleteNode(ctx, "person", "a", 2))
+	_, err = g.ReadNode(ctx, "person", "a")
+	require.ErrorIs(t, err, ErrNodeNotFound)
+}
+
+func TestLegacyFileIsUpgraded(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+
+	nodePath := filepath.Join(cfg.Database.RootPath, "nodes")
+	require.NoError(t, os.MkdirAll(nodePath, os.ModePerm))
+	legacy := graph.LegacyNodeCsvHeader + "a,person,alice,,\n"
+	require.NoError(t, os.WriteFile(filepath.Join(nodePath, "person.csv"), []byte(legacy), 0644))
+
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	nodes, err := g.ReadNodesByType(ctx, "person")
+	require.NoError(t, err)
+	require.Equal(t, []graph.Node{{ID: "a", Type: "person", Name: "alice", Version: 1}}, nodes)
+
+	data, err := os.ReadFile(filepath.Join(nodePath, "person.csv"))
+	require.NoError(t, err)
+	require.Equal(t, disk.CsvFileHeader+"a,person,alice,,,1,ec6a256b\n", string(data))
+}
+
+func TestWriteNodesGroupsByType(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityRestrict)
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice"}))
+
+	nodes := []graph.Node{
+		{ID: "b", Type: "person", Name: "bob", Edges: []string{"a"}},
+		{ID: "c", Type: "pet", Name: "cat", Edges: []string{"missing"}},
+		{ID: "d", Type: "pet", Name: "dog", Edges: []string{"a"}},
+	}
+	errs := g.WriteNodes(ctx, nodes)
+	require.NoError(t, errs[0])
+	require.ErrorIs(t, errs[1], ErrUnknownEdge)
+	require.NoError(t, errs[2])
+	require.Equal(t, int64(1), nodes[0].Version)
+
+	people, err := g.ReadNodesByType(ctx, "person")
+	require.NoError(t, err)
+	require.Len(t, people, 2)
+
+	pets, err := g.ReadNodesByType(ctx, "pet")
+	require.NoError(t, err)
+	require.Len(t, pets, 1)
+	require.Equal(t, "d", pets[0].ID)
+}
+
+func TestWriteNodesResolvesEdgesInBatch(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityRestrict)
+
+	// Edges to nodes of the batch count, unless those nodes are refused themselves.
+	nodes := []graph.Node{
+		{ID: "b", Type: "pet", Name: "bob", Edges: []string{"a"}},
+		{ID: "a", Type: "person", Name: "alice"},
+		{ID: "d", Type: "pet", Name: "dog", Edges: []string{"c"}},
+		{ID: "c", Type: "person", Name: "carol", Edges: []string{"missing"}},
+	}
+	errs := g.WriteNodes(ctx, nodes)
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	require.ErrorIs(t, errs[2], ErrUnknownEdge)
+	require.ErrorIs(t, errs[3], ErrUnknownEdge)
+
+	pet, err := g.ReadNode(ctx, "pet", "b")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, pet.Edges)
+	_, err = g.ReadNode(ctx, "pet", "d")
+	require.ErrorIs(t, err, ErrNodeNotFound)
+}
+
+func TestBinaryFormat(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	cfg.Database.Format = disk.FormatBinary
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	defer g.Close()
+
+	node := &graph.Node{ID: "a", Type: "person", Name: "alice", Traits: map[string]string{"quote": `","`}}
+	require.NoError(t, g.WriteNode(ctx, node))
+	require.NoError(t, g.UpdateNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice smith", Traits: node.Traits}, 1))
+
+	stored, err := g.ReadNode(ctx, "person", "a")
+	require.NoError(t, err)
+	require.Equal(t, &graph.Node{ID: "a", Type: "person", Name: "alice smith", Traits: node.Traits, Version: 2}, stored)
+}
+
+func TestLsmEngine(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	cfg.Database.Engine = storage.EngineLsm
+	cfg.Database.Integrity = string(IntegrityCascade)
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice"}))
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "b", Type: "person", Name: "bob", Edges: []string{"a"}}))
+	require.NoError(t, g.DeleteNode(ctx, "person", "a", 1))
+	require.NoError(t, g.Close())
+
+	g = newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	defer g.Close()
+
+	stored, err := g.ReadNode(ctx, "person", "b")
+	require.NoError(t, err)
+	require.Empty(t, stored.Edges)
+	require.Equal(t, int64(2), stored.Version)
+}
+
+func TestCheckQuarantinesCorruptRecords(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice"}))
+	filePath := filepath.Join(cfg.Database.RootPath, "nodes", "person.csv")
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString("b,person,bob,,,1,00000000\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reports, err := g.Check(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	require.Len(t, reports[0].Problems, 1)
+	require.Equal(t, 1, reports[0].Quarantined)
+
+	nodes, err := g.ReadNodesByType(ctx, "person")
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, "a", nodes[0].ID)
+}
+
+func TestCheckUnsupportedEngine(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Database.Engine = storage.EngineMemory
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+
+	_, err := g.Check(context.Background(), false)
+	require.ErrorIs(t, err, ErrCheckUnsupported)
+}
+
+func TestBackupReadsEveryType(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityNone)
+
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice"}))
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "p", Type: "pet", Name: "rex"}))
+
+	var archive bytes.Buffer
+	manifest, err := g.Backup(ctx, &archive)
+	require.NoError(t, err)
+	require.Len(t, manifest.Types, 2)
+	for _, entry := range manifest.Types {
+		require.Equal(t, 1, entry.Nodes)
+	}
+	verified, err := backup.VerifyArchive(&archive)
+	require.NoError(t, err)
+	require.Equal(t, manifest.Types, verified.Types)
+
+	// Workers resume once their type is read.
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "b", Type: "person", Name: "bob"}))
+}
+
+func TestBackupHoldsOneTypeAtATime(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityNone)
+	gs := g.(*graphService)
+
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice"}))
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "p", Type: "pet", Name: "rex"}))
+
+	// A writer of the person type holds it while the backup waits for the type.
+	_, release, err := gs.workers.acquire("person")
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := g.Backup(ctx, io.Discard)
+		done <- err
+	}()
+
+	// Other types stay writable meanwhile.
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "q", Type: "pet", Name: "tom"}))
+	select {
+	case err := <-done:
+		t.Fatalf("backup finished while its type was in use: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	require.NoError(t, <-done)
+}
+
+func TestReadEdgesResolvesEveryType(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityNone)
+
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "p", Type: "pet", Name: "rex"}))
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "b", Type: "person", Name: "bob"}))
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice", Edges: []string{"p", "gone", "b"}}))
+
+	edges, err := g.ReadEdges(ctx, "person", "a")
+	require.NoError(t, err)
+	require.Len(t, edges, 2)
+	require.Equal(t, "p", edges[0].ID)
+	require.Equal(t, "b", edges[1].ID)
+
+	edges, err = g.ReadEdges(ctx, "pet", "p")
+	require.NoError(t, err)
+	require.Empty(t, edges)
+
+	_, err = g.ReadEdges(ctx, "person", "missing")
+	require.ErrorIs(t, err, ErrNodeNotFound)
+}
+
+func TestScanNodes(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	cfg.Database.Engine = storage.EngineLsm
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	defer g.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: id, Type: "person", Name: id}))
+	}
+
+	var ids []string
+	require.NoError(t, g.ScanNodes(ctx, "person", func(node graph.Node) error {
+		ids = append(ids, node.ID)
+		return nil
+	}))
+	require.ElementsMatch(t, []string{"a", "b", "c"}, ids)
+
+	stop := errors.New("stop")
+	calls := 0
+	err := g.ScanNodes(ctx, "person", func(node graph.Node) error {
+		calls++
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 1, calls)
+
+	require.NoError(t, g.ScanNodes(ctx, "missing", func(node graph.Node) error {
+		t.Fatal("unknown types have no nodes")
+		return nil
+	}))
+}
+
+func TestScanNodesAfter(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	cfg.Database.CacheSize = 1 << 20
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	defer g.Close()
+
+	for _, id := range []string{"c", "a", "d", "b"} {
+		require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: id, Type: "person", Name: id}))
+	}
+
+	// Read from the file, then from the cache the read fills.
+	for range 2 {
+		var ids []string
+		require.NoError(t, g.ScanNodesAfter(ctx, "person", "b", func(node graph.Node) error {
+			ids = append(ids, node.ID)
+			return nil
+		}))
+		require.Equal(t, []string{"c", "d"}, ids)
+		_, err := g.ReadNodesByType(ctx, "person")
+		require.NoError(t, err)
+	}
+}
+
+func TestFollowChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := newTestGrapher(t, IntegrityNone)
+	defer g.Close()
+	require.ErrorIs(t, g.FollowChanges(ctx, 0, func(c storage.Change) error { return nil }), ErrNoChangeLog)
+
+	cfg := newTestConfig(t)
+	cfg.Database.ChangeLog = true
+	g = newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	defer g.Close()
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice"}))
+	require.NoError(t, g.DeleteNode(ctx, "person", "a", AnyVersion))
+
+	var changes []storage.Change
+	stop := errors.New("stop")
+	err := g.FollowChanges(ctx, 0, func(c storage.Change) error {
+		changes = append(changes, c)
+		if len(changes) == 2 {
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, storage.ChangeInsert, changes[0].Op)
+	require.Equal(t, storage.ChangeDelete, changes[1].Op)
+	require.Equal(t, []string{"a"}, changes[1].IDs)
+}
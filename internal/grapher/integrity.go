@@ -0,0 +1,212 @@
+package grapher
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+// IntegrityMode decides how edges pointing at other nodes are guarded.
+// It mirrors the ON DELETE behaviour of foreign keys in relational databases.
+type IntegrityMode string
+
+const (
+	// IntegrityNone performs no checks at all.
+	IntegrityNone IntegrityMode = "none"
+	// IntegrityRestrict rejects deleting a node that is still referenced.
+	IntegrityRestrict IntegrityMode = "restrict"
+	// IntegrityCascade removes the edges pointing at a deleted node.
+	IntegrityCascade IntegrityMode = "cascade"
+	// IntegritySetNull blanks out the edges pointing at a deleted node.
+	IntegritySetNull IntegrityMode = "setnull"
+)
+
+// NullEdge is the value left behind in place of an edge by IntegritySetNull.
+const NullEdge = ""
+
+func ParseIntegrityMode(mode string) (IntegrityMode, error) {
+	switch IntegrityMode(strings.ToLower(mode)) {
+	case "", IntegrityNone:
+		return IntegrityNone, nil
+	case IntegrityRestrict:
+		return IntegrityRestrict, nil
+	case IntegrityCascade:
+		return IntegrityCascade, nil
+	case IntegritySetNull:
+		return IntegritySetNull, nil
+	}
+	return "", fmt.Errorf("unknown integrity mode %q", mode)
+}
+
+func (gs *graphService) nodeTypes(ctx context.Context) ([]string, error) {
+	return gs.engine.Types(ctx)
+}
+
+// checkEdges makes sure every edge of the given nodes points at a stored node.
+func (gs *graphService) checkEdges(ctx context.Context, nodes []graph.Node) error {
+	missing, err := gs.missingEdges(ctx, nodes)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	return unknownEdgeError(missing)
+}
+
+// missingEdges returns the edges of the given nodes that do not point at a stored node.
+// Only the referenced IDs are looked up, in every type until all of them are found.
+func (gs *graphService) missingEdges(ctx context.Context, nodes []graph.Node) (map[string]bool, error) {
+	missing := make(map[string]bool)
+	for _, node := range nodes {
+		for _, edge := range node.Edges {
+			if edge != NullEdge {
+				missing[edge] = true
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return missing, nil
+	}
+
+	found, err := gs.findNodes(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for id := range found {
+		delete(missing, id)
+	}
+	return missing, nil
+}
+
+// findNodes looks the given IDs up in every type and returns the stored nodes by ID.
+// Edges do not say the type they point at, so each type is asked for the IDs not
+// found yet, which its worker answers from its cache or with point reads.
+func (gs *graphService) findNodes(ctx context.Context, ids map[string]bool) (map[string]graph.Node, error) {
+	found := make(map[string]graph.Node, len(ids))
+	nodeTypes, err := gs.nodeTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nodeType := range nodeTypes {
+		var wanted []string
+		for id := range ids {
+			if _, ok := found[id]; !ok {
+				wanted = append(wanted, id)
+			}
+		}
+		if len(wanted) == 0 {
+			break
+		}
+
+		var nodes []graph.Node
+		err := gs.withWorker(nodeType, func(w Worker) error {
+			nodes, err = w.FindNodes(ctx, wanted)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			found[node.ID] = node
+		}
+	}
+	return found, nil
+}
+
+func unknownEdgeError(missing map[string]bool) error {
+	ids := make([]string, 0, len(missing))
+	for id := range missing {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return fmt.Errorf("%w: %s", ErrUnknownEdge, strings.Join(ids, ", "))
+}
+
+// resolveReferences deletes the node along with the changes the integrity mode makes
+// to the nodes pointing at it. The caller must hold the reference lock exclusively.
+func (gs *graphService) resolveReferences(ctx context.Context, nodeType string, id string, expectedVersion int64) error {
+	node, err := gs.ReadNode(ctx, nodeType, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(node, expectedVersion); err != nil {
+		return err
+	}
+
+	drop := storage.Change{Op: storage.ChangeDelete, Type: nodeType, IDs: []string{id}}
+	return gs.dropReferences(ctx, map[string]bool{id: true}, drop)
+}
+
+// dropReferences applies the integrity mode to all nodes pointing at any of the given
+// ids and writes their changes with drop, the change deleting the ids, as one batch,
+// so a crash cannot leave edges dropped from nodes that are still stored. The nodes
+// of a dropped type are left alone. The caller must hold the reference lock exclusively.
+func (gs *graphService) dropReferences(ctx context.Context, ids map[string]bool, drop storage.Change) error {
+	nodeTypes, err := gs.nodeTypes(ctx)
+	if err != nil {
+		return err
+	}
+	slices.Sort(nodeTypes)
+
+	return gs.workers.withExclusive(nodeTypes, func() error {
+		var changes []storage.Change
+		for _, t := range nodeTypes {
+			if drop.Op == storage.ChangeDropType && t == drop.Type {
+				continue
+			}
+			it, err := gs.engine.Scan(ctx, t)
+			if err != nil {
+				return err
+			}
+			nodes, err := storage.ReadAll(it)
+			if err != nil {
+				return err
+			}
+			changed, err := gs.dropEdgesTo(nodes, ids)
+			if err != nil {
+				return err
+			}
+			if len(changed) > 0 {
+				changes = append(changes, storage.Change{Op: storage.ChangePut, Type: t, Nodes: changed})
+			}
+		}
+
+		gs.cache.invalidate(nodeTypes...)
+		return storage.ApplyBatch(ctx, gs.engine, append(changes, drop))
+	})
+}
+
+// dropEdgesTo returns the nodes whose edges changed after dropping the given ids, with
+// their versions incremented, so a client holding an older version cannot overwrite
+// the change and bring the dropped edges back.
+func (gs *graphService) dropEdgesTo(nodes []graph.Node, ids map[string]bool) ([]graph.Node, error) {
+	isDropped := func(edge string) bool { return ids[edge] }
+
+	var changed []graph.Node
+	for _, node := range nodes {
+		if ids[node.ID] || !slices.ContainsFunc(node.Edges, isDropped) {
+			continue
+		}
+
+		switch gs.integrity {
+		case IntegrityRestrict:
+			return nil, fmt.Errorf("%w: %s", ErrNodeReferenced, node.ID)
+		case IntegrityCascade:
+			node.Edges = slices.DeleteFunc(slices.Clone(node.Edges), isDropped)
+		case IntegritySetNull:
+			edges := slices.Clone(node.Edges)
+			for j, edge := range edges {
+				if ids[edge] {
+					edges[j] = NullEdge
+				}
+			}
+			node.Edges = edges
+		}
+		node.Version++
+		changed = append(changed, node)
+	}
+	return changed, nil
+}
@@ -0,0 +1,118 @@
+package grapher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+func writeLinkedNodes(t *testing.T, g Grapher) {
+	ctx := context.Background()
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice"}))
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "b", Type: "pet", Name: "bob", Edges: []string{"a"}}))
+}
+
+func TestIntegrityRejectsUnknownEdge(t *testing.T) {
+	g := newTestGrapher(t, IntegrityRestrict)
+
+	err := g.WriteNode(context.Background(), &graph.Node{ID: "a", Type: "person", Name: "alice", Edges: []string{"missing"}})
+	require.ErrorIs(t, err, ErrUnknownEdge)
+
+	nodes, err := g.ReadNodesByType(context.Background(), "person")
+	require.NoError(t, err)
+	require.Empty(t, nodes)
+}
+
+func TestIntegrityRestrict(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityRestrict)
+	writeLinkedNodes(t, g)
+
+	require.ErrorIs(t, g.DeleteNode(ctx, "person", "a", AnyVersion), ErrNodeReferenced)
+	require.NoError(t, g.DeleteNode(ctx, "pet", "b", AnyVersion))
+	require.NoError(t, g.DeleteNode(ctx, "person", "a", AnyVersion))
+	require.ErrorIs(t, g.DeleteNode(ctx, "person", "a", AnyVersion), ErrNodeNotFound)
+}
+
+func TestIntegrityCascade(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityCascade)
+	writeLinkedNodes(t, g)
+
+	require.NoError(t, g.DeleteNode(ctx, "person", "a", AnyVersion))
+
+	pets, err := g.ReadNodesByType(ctx, "pet")
+	require.NoError(t, err)
+	require.Len(t, pets, 1)
+	require.Empty(t, pets[0].Edges)
+	require.Equal(t, int64(2), pets[0].Version)
+}
+
+func TestIntegrityCascadeBumpsReferrerVersions(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	cfg.Database.Integrity = string(IntegrityCascade)
+	cfg.Database.CacheSize = 1 << 20
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	defer g.Close()
+	writeLinkedNodes(t, g)
+
+	// Reading the referrer first puts it in the cache.
+	pet, err := g.ReadNode(ctx, "pet", "b")
+	require.NoError(t, err)
+	require.NoError(t, g.DeleteNode(ctx, "person", "a", AnyVersion))
+
+	// A client still holding the version read before the cascade cannot overwrite it.
+	stale := &graph.Node{ID: "b", Type: "pet", Name: "rex"}
+	require.ErrorIs(t, g.UpdateNode(ctx, stale, pet.Version), ErrVersionMismatch)
+	stored, err := g.ReadNode(ctx, "pet", "b")
+	require.NoError(t, err)
+	require.Empty(t, stored.Edges)
+	require.Equal(t, pet.Version+1, stored.Version)
+}
+
+func TestIntegritySetNull(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegritySetNull)
+	writeLinkedNodes(t, g)
+
+	require.NoError(t, g.DeleteNode(ctx, "person", "a", AnyVersion))
+
+	pets, err := g.ReadNodesByType(ctx, "pet")
+	require.NoError(t, err)
+	require.Len(t, pets, 1)
+	require.Equal(t, []string{NullEdge}, pets[0].Edges)
+	require.Equal(t, int64(2), pets[0].Version)
+}
+
+func TestIntegrityCascadeIsOneBatch(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	cfg.Database.Integrity = string(IntegrityCascade)
+	stored := storage.NewMemoryEngine()
+	broken := &brokenPutEngine{Engine: stored}
+	engine, err := storage.OpenJournal(ctx, broken, disk.NewFileAccessor(), cfg.Database.RootPath)
+	require.NoError(t, err)
+	g := newConfiguredGrapher(t, cfg, engine)
+	writeLinkedNodes(t, g)
+
+	// The update of the referrer fails, so the delete is held back with it.
+	broken.broken = true
+	require.ErrorIs(t, g.DeleteNode(ctx, "person", "a", AnyVersion), storage.ErrBatchPending)
+	_, err = stored.Get(ctx, "person", "a")
+	require.NoError(t, err)
+
+	// Opened again after a crash, the journal applies both.
+	_, err = storage.OpenJournal(ctx, stored, disk.NewFileAccessor(), cfg.Database.RootPath)
+	require.NoError(t, err)
+	_, err = stored.Get(ctx, "person", "a")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	pet, err := stored.Get(ctx, "pet", "b")
+	require.NoError(t, err)
+	require.Empty(t, pet.Edges)
+	require.Equal(t, int64(2), pet.Version)
+}
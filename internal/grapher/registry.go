@@ -0,0 +1,332 @@
+package grapher
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxWorkers        = 1024
+	DefaultWorkerIdleTimeout = 5 * time.Minute
+)
+
+var ErrTooManyWorkers = errors.New("too many node types in use")
+
+type RegistryStats struct {
+	Workers    int    `json:"workers"`
+	MaxWorkers int    `json:"maxWorkers"`
+	Evictions  uint64 `json:"evictions"`
+}
+
+type registryEntry struct {
+	worker   Worker
+	refs     int
+	lastUsed time.Time
+	// closing is set while the worker is closed outside the lock; its entry stays
+	// registered until then, so no second worker of the type is created meanwhile.
+	closing bool
+}
+
+// registry owns the workers of all node types. Workers are reference counted while
+// in use; idle ones are closed after the idle timeout, or earlier when the number
+// of live workers reaches its cap. Users of a type wait for a closing worker to
+// finish before a new one is created.
+type registry struct {
+	lock      sync.Mutex
+	changed   *sync.Cond
+	entries   map[string]*registryEntry
+	exclusive map[string]bool
+	quiesced  bool
+	// closed refuses new users once close started.
+	closed      bool
+	maxWorkers  int
+	idleTimeout time.Duration
+	evictions   uint64
+	newWorker   func(nodeType string) Worker
+	now         func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newRegistry(maxWorkers int, idleTimeout time.Duration, newWorker func(nodeType string) Worker) *registry {
+	if maxWorkers <= 0 {
+		maxWorkers = DefaultMaxWorkers
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultWorkerIdleTimeout
+	}
+
+	r := &registry{
+		entries:     make(map[string]*registryEntry),
+		exclusive:   make(map[string]bool),
+		maxWorkers:  maxWorkers,
+		idleTimeout: idleTimeout,
+		newWorker:   newWorker,
+		now:         time.Now,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	r.changed = sync.NewCond(&r.lock)
+	go r.run()
+	return r
+}
+
+func (r *registry) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.evictIdle()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// acquire returns the worker of the node type, creating it if needed.
+// The returned release function must be called once the worker is no longer used.
+func (r *registry) acquire(nodeType string) (Worker, func(), error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for {
+		for !r.closed && (r.quiesced || r.exclusive[nodeType] || r.closingLocked(nodeType)) {
+			r.changed.Wait()
+		}
+		if r.closed {
+			return nil, nil, ErrWorkerClosed
+		}
+
+		e, exists := r.entries[nodeType]
+		if !exists && len(r.entries) >= r.maxWorkers {
+			oldestType, oldest := r.oldestIdleLocked()
+			if oldest == nil {
+				return nil, nil, ErrTooManyWorkers
+			}
+			r.evictions++
+			// The state may change while the lock is released, so look again.
+			r.lock.Unlock()
+			r.closeEntry(oldestType, oldest)
+			r.lock.Lock()
+			continue
+		}
+
+		if !exists {
+			w := r.newWorker(nodeType)
+			if w == nil {
+				return nil, nil, errors.New("failed to create worker for node type " + nodeType)
+			}
+			e = &registryEntry{worker: w}
+			r.entries[nodeType] = e
+		}
+
+		e.refs++
+		e.lastUsed = r.now()
+		return e.worker, func() { r.release(e) }, nil
+	}
+}
+
+func (r *registry) release(e *registryEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	e.refs--
+	e.lastUsed = r.now()
+	if e.refs == 0 {
+		r.changed.Broadcast()
+	}
+}
+
+// withExclusive runs fn while no worker of the given node types exists. It waits for
+// in-flight users to finish, closes the workers and holds back new users until fn returns,
+// so fn can safely move or remove the files of those types.
+func (r *registry) withExclusive(nodeTypes []string, fn func() error) error {
+	r.lock.Lock()
+	for !r.closed && (r.quiesced || slices.ContainsFunc(nodeTypes, func(t string) bool { return r.exclusive[t] })) {
+		r.changed.Wait()
+	}
+	if r.closed {
+		r.lock.Unlock()
+		return ErrWorkerClosed
+	}
+	for _, nodeType := range nodeTypes {
+		r.exclusive[nodeType] = true
+	}
+
+	var workers []Worker
+	for _, nodeType := range nodeTypes {
+		for r.entries[nodeType] != nil && (r.entries[nodeType].refs > 0 || r.entries[nodeType].closing) {
+			r.changed.Wait()
+		}
+		if e := r.entries[nodeType]; e != nil {
+			workers = append(workers, e.worker)
+			delete(r.entries, nodeType)
+		}
+	}
+	r.lock.Unlock()
+
+	defer func() {
+		r.lock.Lock()
+		for _, nodeType := range nodeTypes {
+			delete(r.exclusive, nodeType)
+		}
+		r.changed.Broadcast()
+		r.lock.Unlock()
+	}()
+
+	for _, w := range workers {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return fn()
+}
+
+// withQuiesced runs fn while no worker of any node type is in use and holds back new
+// users until fn returns. Unlike withExclusive the workers stay open, as fn must only read.
+func (r *registry) withQuiesced(fn func() error) error {
+	r.lock.Lock()
+	for !r.closed && (r.quiesced || len(r.exclusive) > 0) {
+		r.changed.Wait()
+	}
+	if r.closed {
+		r.lock.Unlock()
+		return ErrWorkerClosed
+	}
+	r.quiesced = true
+	for r.inUseLocked() {
+		r.changed.Wait()
+	}
+	r.lock.Unlock()
+
+	defer func() {
+		r.lock.Lock()
+		r.quiesced = false
+		r.changed.Broadcast()
+		r.lock.Unlock()
+	}()
+	return fn()
+}
+
+func (r *registry) inUseLocked() bool {
+	for _, e := range r.entries {
+		if e.refs > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *registry) closingLocked(nodeType string) bool {
+	e := r.entries[nodeType]
+	return e != nil && e.closing
+}
+
+func (r *registry) evictIdle() {
+	r.lock.Lock()
+	idle := make(map[string]*registryEntry)
+	for nodeType, e := range r.entries {
+		if e.refs == 0 && !e.closing && r.now().Sub(e.lastUsed) >= r.idleTimeout {
+			e.closing = true
+			idle[nodeType] = e
+			r.evictions++
+		}
+	}
+	r.lock.Unlock()
+
+	for nodeType, e := range idle {
+		r.closeEntry(nodeType, e)
+	}
+	if len(idle) > 0 {
+		slog.Debug("Evicted idle workers", "count", len(idle))
+	}
+}
+
+// oldestIdleLocked marks the least recently used idle worker as closing, to make
+// room for a new one. The caller closes it with closeEntry once it released the lock.
+func (r *registry) oldestIdleLocked() (string, *registryEntry) {
+	var oldestType string
+	var oldest *registryEntry
+	for nodeType, e := range r.entries {
+		if e.refs == 0 && !e.closing && (oldest == nil || e.lastUsed.Before(oldest.lastUsed)) {
+			oldestType, oldest = nodeType, e
+		}
+	}
+	if oldest != nil {
+		oldest.closing = true
+	}
+	return oldestType, oldest
+}
+
+// closeEntry closes the worker of an entry marked as closing, then unregisters it and
+// wakes up the users waiting for it. It must be called without holding the lock, as
+// closing drains the queue of the worker.
+func (r *registry) closeEntry(nodeType string, e *registryEntry) {
+	if err := e.worker.Close(); err != nil {
+		slog.Error("Error closing evicted worker", "nodeType", nodeType, "error", err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.entries[nodeType] == e {
+		delete(r.entries, nodeType)
+	}
+	r.changed.Broadcast()
+}
+
+func (r *registry) stats() RegistryStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return RegistryStats{
+		Workers:    len(r.entries),
+		MaxWorkers: r.maxWorkers,
+		Evictions:  r.evictions,
+	}
+}
+
+// close stops the eviction loop and refuses new users, waits for the workers in use
+// to be released, as withExclusive does, then closes every worker.
+func (r *registry) close() error {
+	close(r.stop)
+	<-r.done
+
+	r.lock.Lock()
+	r.closed = true
+	r.changed.Broadcast()
+	for r.inUseLocked() || len(r.exclusive) > 0 || r.quiesced {
+		r.changed.Wait()
+	}
+	idle := make(map[string]*registryEntry)
+	for nodeType, e := range r.entries {
+		if !e.closing {
+			e.closing = true
+			idle[nodeType] = e
+		}
+	}
+	r.lock.Unlock()
+
+	var errs []error
+	for nodeType, e := range idle {
+		errs = append(errs, e.worker.Close())
+		r.lock.Lock()
+		delete(r.entries, nodeType)
+		r.lock.Unlock()
+	}
+
+	// Workers evicted by other callers are closed by them.
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for len(r.entries) > 0 {
+		r.changed.Wait()
+	}
+	return errors.Join(errs...)
+}
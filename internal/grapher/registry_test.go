@@ -0,0 +1,227 @@
+package grapher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+func newTestRegistry(t *testing.T, maxWorkers int) *registry {
+	engine := storage.NewMemoryEngine()
+
+	r := newRegistry(maxWorkers, time.Hour, func(nodeType string) Worker {
+		return newWorker(engine, nil, nodeType)
+	})
+	t.Cleanup(func() { r.close() })
+	return r
+}
+
+func TestRegistryEvictsIdleWorkers(t *testing.T) {
+	r := newTestRegistry(t, 10)
+
+	_, release, err := r.acquire("person")
+	require.NoError(t, err)
+	release()
+	_, inUse, err := r.acquire("pet")
+	require.NoError(t, err)
+	defer inUse()
+
+	now := time.Now()
+	r.now = func() time.Time { return now.Add(2 * time.Hour) }
+	r.evictIdle()
+
+	stats := r.stats()
+	require.Equal(t, 1, stats.Workers)
+	require.Equal(t, uint64(1), stats.Evictions)
+}
+
+func TestRegistryCapsWorkers(t *testing.T) {
+	r := newTestRegistry(t, 1)
+
+	_, release, err := r.acquire("person")
+	require.NoError(t, err)
+
+	_, _, err = r.acquire("pet")
+	require.ErrorIs(t, err, ErrTooManyWorkers)
+
+	release()
+	_, release, err = r.acquire("pet")
+	require.NoError(t, err)
+	defer release()
+	require.Equal(t, 1, r.stats().Workers)
+}
+
+func TestReadUnknownTypeCreatesNothing(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	g := newConfiguredGrapher(t, cfg, newTestEngine(t, cfg))
+	defer g.Close()
+
+	nodes, err := g.ReadNodesByType(ctx, "unknown")
+	require.NoError(t, err)
+	require.Empty(t, nodes)
+
+	_, err = g.ReadNode(ctx, "unknown", "a")
+	require.ErrorIs(t, err, ErrNodeNotFound)
+
+	_, err = os.Stat(filepath.Join(cfg.Database.RootPath, "nodes", "unknown.csv"))
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, 0, g.Stats().Workers.Workers)
+}
+
+func TestRegistryQuiesceWaitsForUsers(t *testing.T) {
+	r := newTestRegistry(t, 10)
+
+	_, release, err := r.acquire("person")
+	require.NoError(t, err)
+
+	quiesced := make(chan struct{})
+	resume := make(chan struct{})
+	go r.withQuiesced(func() error {
+		close(quiesced)
+		<-resume
+		return nil
+	})
+
+	select {
+	case <-quiesced:
+		t.Fatal("quiesced while a worker is in use")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	<-quiesced
+
+	acquired := make(chan struct{})
+	go func() {
+		_, release, err := r.acquire("pet")
+		require.NoError(t, err)
+		release()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a worker while quiesced")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(resume)
+	<-acquired
+}
+
+// slowCloseWorker blocks in Close until released, like a worker draining its queue.
+type slowCloseWorker struct {
+	Worker
+	closing chan struct{}
+	release chan struct{}
+}
+
+func (w *slowCloseWorker) Close() error {
+	close(w.closing)
+	<-w.release
+	return w.Worker.Close()
+}
+
+func TestRegistryWaitsForClosingWorker(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	var workers []*slowCloseWorker
+	r := newRegistry(1, time.Hour, func(nodeType string) Worker {
+		w := &slowCloseWorker{Worker: newWorker(engine, nil, nodeType), closing: make(chan struct{}), release: make(chan struct{})}
+		workers = append(workers, w)
+		return w
+	})
+	defer r.close()
+
+	_, release, err := r.acquire("person")
+	require.NoError(t, err)
+	release()
+
+	now := time.Now()
+	r.now = func() time.Time { return now.Add(2 * time.Hour) }
+	go r.evictIdle()
+	<-workers[0].closing
+
+	// The registry stays usable while the worker closes, but the type is not
+	// recreated until it is closed.
+	require.Equal(t, 1, r.stats().Workers)
+	acquired := make(chan Worker)
+	go func() {
+		w, release, err := r.acquire("person")
+		require.NoError(t, err)
+		release()
+		acquired <- w
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a worker while the previous one is closing")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(workers[0].release)
+	w := <-acquired
+	require.NotSame(t, workers[0], w)
+	close(workers[1].release)
+}
+
+func TestRegistryEvictsOldestOutsideLock(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	var workers []*slowCloseWorker
+	r := newRegistry(1, time.Hour, func(nodeType string) Worker {
+		w := &slowCloseWorker{Worker: newWorker(engine, nil, nodeType), closing: make(chan struct{}), release: make(chan struct{})}
+		workers = append(workers, w)
+		return w
+	})
+	defer r.close()
+
+	_, release, err := r.acquire("person")
+	require.NoError(t, err)
+	release()
+
+	acquired := make(chan struct{})
+	go func() {
+		_, release, err := r.acquire("pet")
+		require.NoError(t, err)
+		release()
+		close(acquired)
+	}()
+	<-workers[0].closing
+
+	// Stats take the lock, so they would block if the eviction held it.
+	require.Equal(t, uint64(1), r.stats().Evictions)
+	close(workers[0].release)
+	<-acquired
+	close(workers[1].release)
+	require.Equal(t, 1, r.stats().Workers)
+}
+
+func TestRegistryCloseWaitsForUsers(t *testing.T) {
+	engine := storage.NewMemoryEngine()
+	r := newRegistry(10, time.Hour, func(nodeType string) Worker {
+		return newWorker(engine, nil, nodeType)
+	})
+
+	_, release, err := r.acquire("person")
+	require.NoError(t, err)
+
+	closed := make(chan struct{})
+	go func() {
+		r.close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("closed while a worker is in use")
+	case <-time.After(20 * time.Millisecond):
+	}
+	_, _, err = r.acquire("pet")
+	require.ErrorIs(t, err, ErrWorkerClosed)
+
+	release()
+	<-closed
+	_, _, err = r.acquire("person")
+	require.ErrorIs(t, err, ErrWorkerClosed)
+}
@@ -0,0 +1,351 @@
+package grapher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+const (
+	TxInsert = "insert"
+	TxUpdate = "update"
+	TxDelete = "delete"
+)
+
+var (
+	ErrInvalidTxOp = errors.New("invalid transaction operation")
+	ErrNodeExists  = errors.New("node already exists")
+	// ErrTxPending tells that a transaction failed after it was journaled. It is
+	// committed all the same: the rest of it is applied before the next write.
+	ErrTxPending = errors.New("transaction is committed in part, the rest is applied before the next write")
+)
+
+// TxOp is one change of a transaction. Deletes only use the type and ID of the node.
+type TxOp struct {
+	Op              string     `json:"op"`
+	Node            graph.Node `json:"node"`
+	ExpectedVersion int64      `json:"expectedVersion,omitempty"`
+}
+
+// Commit applies the operations as a single unit. Every operation is checked against
+// the stored nodes and the changes of the operations before it, and nothing is
+// written unless all of them succeed. The types involved are held exclusively
+// meanwhile, so no request sees a part of the transaction; with an integrity mode,
+// a transaction deleting nodes holds every type, as its deletes may change them.
+// The changes are written as a batch of the engine, which applies the rest of them
+// again after a storage error or a crash while writing; Commit fails with
+// ErrTxPending then. It returns the node of every operation with its new version,
+// also along with ErrTxPending.
+func (gs *graphService) Commit(ctx context.Context, ops []TxOp) ([]graph.Node, error) {
+	var nodeTypes []string
+	for i, op := range ops {
+		if err := validateTxOp(op); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		nodeTypes = append(nodeTypes, op.Node.Type)
+	}
+
+	deletes := slices.ContainsFunc(ops, func(op TxOp) bool { return op.Op == TxDelete })
+	switch {
+	case gs.integrity == IntegrityNone:
+	case deletes:
+		// Like deletes, transactions deleting nodes block every other write that checks references.
+		gs.refLock.Lock()
+		defer gs.refLock.Unlock()
+
+		stored, err := gs.nodeTypes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		nodeTypes = append(nodeTypes, stored...)
+	default:
+		// Other transactions only add references, checked like the edges of single writes.
+		gs.refLock.RLock()
+		defer gs.refLock.RUnlock()
+
+		if err := gs.checkTxEdges(ctx, ops); err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(nodeTypes)
+	nodeTypes = slices.Compact(nodeTypes)
+
+	var results []graph.Node
+	err := gs.workers.withExclusive(nodeTypes, func() error {
+		tx := &txState{gs: gs, nodes: make(map[string]map[string]*graph.Node), existed: make(map[string]map[string]bool), loaded: make(map[string]bool)}
+		var err error
+		if results, err = tx.apply(ctx, ops); err != nil {
+			return err
+		}
+		if gs.integrity != IntegrityNone && deletes {
+			if err := tx.checkReferences(ctx, nodeTypes); err != nil {
+				return err
+			}
+		}
+
+		gs.cache.invalidate(nodeTypes...)
+		return tx.write(ctx)
+	})
+	return results, err
+}
+
+// checkTxEdges fails when an edge of an inserted or updated node points at a node that
+// is neither stored nor written by the transaction.
+func (gs *graphService) checkTxEdges(ctx context.Context, ops []TxOp) error {
+	written := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		written[op.Node.ID] = true
+	}
+	missing := make(map[string]bool)
+	for _, op := range ops {
+		for _, edge := range op.Node.Edges {
+			if edge != NullEdge && !written[edge] {
+				missing[edge] = true
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	found, err := gs.findNodes(ctx, missing)
+	if err != nil {
+		return err
+	}
+	for id := range found {
+		delete(missing, id)
+	}
+	if len(missing) > 0 {
+		return unknownEdgeError(missing)
+	}
+	return nil
+}
+
+func validateTxOp(op TxOp) error {
+	if !slices.Contains([]string{TxInsert, TxUpdate, TxDelete}, op.Op) {
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidTxOp, op.Op)
+	}
+	if err := ValidateNodeType(op.Node.Type); err != nil {
+		return err
+	}
+	if op.Node.ID == "" {
+		return fmt.Errorf("%w: missing node id", ErrInvalidTxOp)
+	}
+	return nil
+}
+
+// txState holds the nodes a transaction has read or changed by type and ID.
+// A nil node does not exist, either in storage or after a delete of the transaction.
+type txState struct {
+	gs      *graphService
+	nodes   map[string]map[string]*graph.Node
+	existed map[string]map[string]bool
+	// loaded holds the types read completely, which need no further lookups.
+	loaded  map[string]bool
+	changed []txKey
+}
+
+type txKey struct {
+	nodeType string
+	id       string
+}
+
+func (tx *txState) lookup(ctx context.Context, nodeType string, id string) (*graph.Node, error) {
+	if node, known := tx.nodes[nodeType][id]; known || tx.loaded[nodeType] {
+		return node, nil
+	}
+
+	node, err := tx.gs.engine.Get(ctx, nodeType, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		node, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tx.remember(nodeType, id, node)
+	return node, nil
+}
+
+func (tx *txState) remember(nodeType string, id string, node *graph.Node) {
+	if tx.nodes[nodeType] == nil {
+		tx.nodes[nodeType] = make(map[string]*graph.Node)
+		tx.existed[nodeType] = make(map[string]bool)
+	}
+	tx.nodes[nodeType][id] = node
+	tx.existed[nodeType][id] = node != nil
+}
+
+func (tx *txState) set(nodeType string, id string, node *graph.Node) {
+	tx.nodes[nodeType][id] = node
+	tx.changed = append(tx.changed, txKey{nodeType: nodeType, id: id})
+}
+
+func (tx *txState) apply(ctx context.Context, ops []TxOp) ([]graph.Node, error) {
+	results := make([]graph.Node, len(ops))
+	for i, op := range ops {
+		node := op.Node
+		stored, err := tx.lookup(ctx, node.Type, node.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case TxInsert:
+			if stored != nil {
+				err = fmt.Errorf("%w: %s", ErrNodeExists, node.ID)
+				break
+			}
+			node.Version = 1
+			tx.set(node.Type, node.ID, &node)
+		case TxUpdate, TxDelete:
+			if stored == nil {
+				err = ErrNodeNotFound
+				break
+			}
+			if err = checkVersion(stored, op.ExpectedVersion); err != nil {
+				break
+			}
+			if op.Op == TxDelete {
+				node = *stored
+				tx.set(node.Type, node.ID, nil)
+				break
+			}
+			node.Version = stored.Version + 1
+			tx.set(node.Type, node.ID, &node)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		results[i] = node
+	}
+	return results, nil
+}
+
+// checkReferences applies the integrity mode to the result of the transaction: edges
+// must point at nodes that still exist, and edges to deleted nodes are handled as the
+// deletes of DeleteNode would.
+func (tx *txState) checkReferences(ctx context.Context, nodeTypes []string) error {
+	for _, nodeType := range nodeTypes {
+		it, err := tx.gs.engine.Scan(ctx, nodeType)
+		if err != nil {
+			return err
+		}
+		stored, err := storage.ReadAll(it)
+		if err != nil {
+			return err
+		}
+		for _, node := range stored {
+			if _, known := tx.nodes[nodeType][node.ID]; !known {
+				tx.remember(nodeType, node.ID, &node)
+			}
+		}
+		tx.loaded[nodeType] = true
+	}
+
+	deleted := make(map[string]bool)
+	for _, key := range tx.changed {
+		if tx.nodes[key.nodeType][key.id] == nil && tx.existed[key.nodeType][key.id] {
+			deleted[key.id] = true
+		}
+	}
+	if len(deleted) > 0 {
+		written := make(map[txKey]bool, len(tx.changed))
+		for _, key := range tx.changed {
+			written[key] = true
+		}
+		for _, nodeType := range nodeTypes {
+			var nodes []graph.Node
+			for _, node := range tx.nodes[nodeType] {
+				if node != nil {
+					nodes = append(nodes, *node)
+				}
+			}
+			changed, err := tx.gs.dropEdgesTo(nodes, deleted)
+			if err != nil {
+				return err
+			}
+			for _, node := range changed {
+				// Nodes the transaction already writes keep the version it gave them.
+				if written[txKey{nodeType: nodeType, id: node.ID}] {
+					node.Version--
+				}
+				tx.set(nodeType, node.ID, &node)
+			}
+		}
+	}
+
+	existing := make(map[string]bool)
+	for _, nodes := range tx.nodes {
+		for id, node := range nodes {
+			if node != nil {
+				existing[id] = true
+			}
+		}
+	}
+	missing := make(map[string]bool)
+	for _, key := range tx.changed {
+		if node := tx.nodes[key.nodeType][key.id]; node != nil {
+			for _, edge := range node.Edges {
+				if edge != NullEdge && !existing[edge] {
+					missing[edge] = true
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return unknownEdgeError(missing)
+	}
+	return nil
+}
+
+// write stores the final state of every changed node as a single batch, type by type.
+func (tx *txState) write(ctx context.Context) error {
+	changed := make(map[string]map[string]bool)
+	for _, key := range tx.changed {
+		if changed[key.nodeType] == nil {
+			changed[key.nodeType] = make(map[string]bool)
+		}
+		changed[key.nodeType][key.id] = true
+	}
+
+	var changes []storage.Change
+	for _, nodeType := range slices.Sorted(maps.Keys(changed)) {
+		var inserts, puts []graph.Node
+		var deletes []string
+		for _, id := range slices.Sorted(maps.Keys(changed[nodeType])) {
+			node, existed := tx.nodes[nodeType][id], tx.existed[nodeType][id]
+			switch {
+			case node == nil && existed:
+				deletes = append(deletes, id)
+			case node == nil:
+			case existed:
+				puts = append(puts, *node)
+			default:
+				inserts = append(inserts, *node)
+			}
+		}
+
+		if len(deletes) > 0 {
+			changes = append(changes, storage.Change{Op: storage.ChangeDelete, Type: nodeType, IDs: deletes})
+		}
+		if len(puts) > 0 {
+			changes = append(changes, storage.Change{Op: storage.ChangePut, Type: nodeType, Nodes: puts})
+		}
+		if len(inserts) > 0 {
+			changes = append(changes, storage.Change{Op: storage.ChangeInsert, Type: nodeType, Nodes: inserts})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	err := storage.ApplyBatch(ctx, tx.gs.engine, changes)
+	if errors.Is(err, storage.ErrBatchPending) {
+		return fmt.Errorf("%w: %w", ErrTxPending, err)
+	}
+	return err
+}
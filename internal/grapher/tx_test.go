@@ -0,0 +1,155 @@
+package grapher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+// brokenPutEngine fails every put while broken is set.
+type brokenPutEngine struct {
+	storage.Engine
+	broken bool
+}
+
+func (e *brokenPutEngine) Put(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	if e.broken {
+		return errors.New("put failed")
+	}
+	return e.Engine.Put(ctx, nodeType, nodes)
+}
+
+func TestCommitAppliesEveryOperation(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityRestrict)
+	defer g.Close()
+	writeLinkedNodes(t, g)
+
+	results, err := g.Commit(ctx, []TxOp{
+		{Op: TxInsert, Node: graph.Node{ID: "c", Type: "person", Name: "carol"}},
+		{Op: TxUpdate, Node: graph.Node{ID: "b", Type: "pet", Name: "bob", Edges: []string{"c"}}, ExpectedVersion: 1},
+		{Op: TxDelete, Node: graph.Node{ID: "a", Type: "person"}, ExpectedVersion: 1},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), results[0].Version)
+	require.Equal(t, int64(2), results[1].Version)
+
+	people, err := g.ReadNodesByType(ctx, "person")
+	require.NoError(t, err)
+	require.Equal(t, []graph.Node{{ID: "c", Type: "person", Name: "carol", Version: 1}}, people)
+	pet, err := g.ReadNode(ctx, "pet", "b")
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, pet.Edges)
+}
+
+func TestCommitWritesNothingOnFailure(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityRestrict)
+	defer g.Close()
+	writeLinkedNodes(t, g)
+
+	_, err := g.Commit(ctx, []TxOp{
+		{Op: TxInsert, Node: graph.Node{ID: "c", Type: "person", Name: "carol"}},
+		{Op: TxUpdate, Node: graph.Node{ID: "a", Type: "person", Name: "alice smith"}, ExpectedVersion: 7},
+	})
+	require.ErrorIs(t, err, ErrVersionMismatch)
+
+	// A delete of a node that is still referenced fails under restrict.
+	_, err = g.Commit(ctx, []TxOp{
+		{Op: TxInsert, Node: graph.Node{ID: "c", Type: "person", Name: "carol"}},
+		{Op: TxDelete, Node: graph.Node{ID: "a", Type: "person"}},
+	})
+	require.ErrorIs(t, err, ErrNodeReferenced)
+
+	_, err = g.Commit(ctx, []TxOp{
+		{Op: TxInsert, Node: graph.Node{ID: "c", Type: "person", Name: "carol", Edges: []string{"missing"}}},
+	})
+	require.ErrorIs(t, err, ErrUnknownEdge)
+	_, err = g.Commit(ctx, []TxOp{{Op: TxInsert, Node: graph.Node{ID: "a", Type: "person", Name: "alice"}}})
+	require.ErrorIs(t, err, ErrNodeExists)
+	_, err = g.Commit(ctx, []TxOp{{Op: "upsert", Node: graph.Node{ID: "a", Type: "person"}}})
+	require.ErrorIs(t, err, ErrInvalidTxOp)
+
+	people, err := g.ReadNodesByType(ctx, "person")
+	require.NoError(t, err)
+	require.Equal(t, []graph.Node{{ID: "a", Type: "person", Name: "alice", Version: 1}}, people)
+}
+
+func TestCommitFailingMidwayIsPending(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	broken := &brokenPutEngine{Engine: storage.NewMemoryEngine()}
+	engine, err := storage.OpenJournal(ctx, broken, disk.NewFileAccessor(), cfg.Database.RootPath)
+	require.NoError(t, err)
+	g := newConfiguredGrapher(t, cfg, engine)
+	defer g.Close()
+	writeLinkedNodes(t, g)
+
+	// The insert is applied, then the put of the update fails.
+	broken.broken = true
+	results, err := g.Commit(ctx, []TxOp{
+		{Op: TxInsert, Node: graph.Node{ID: "c", Type: "person", Name: "carol"}},
+		{Op: TxUpdate, Node: graph.Node{ID: "b", Type: "pet", Name: "bob", Edges: []string{"c"}}, ExpectedVersion: 1},
+	})
+	require.ErrorIs(t, err, ErrTxPending)
+	require.Equal(t, int64(2), results[1].Version)
+
+	// The next write applies the rest first.
+	broken.broken = false
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "d", Type: "person", Name: "dave"}))
+	pet, err := g.ReadNode(ctx, "pet", "b")
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, pet.Edges)
+	require.Equal(t, int64(2), pet.Version)
+}
+
+func TestCommitCascadesDeletes(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityCascade)
+	defer g.Close()
+	writeLinkedNodes(t, g)
+
+	// Later operations see the changes of earlier ones.
+	_, err := g.Commit(ctx, []TxOp{
+		{Op: TxInsert, Node: graph.Node{ID: "c", Type: "person", Name: "carol"}},
+		{Op: TxUpdate, Node: graph.Node{ID: "c", Type: "person", Name: "carol", Edges: []string{"a"}}, ExpectedVersion: 1},
+		{Op: TxDelete, Node: graph.Node{ID: "a", Type: "person"}},
+	})
+	require.NoError(t, err)
+
+	carol, err := g.ReadNode(ctx, "person", "c")
+	require.NoError(t, err)
+	require.Empty(t, carol.Edges)
+	require.Equal(t, int64(2), carol.Version)
+	pet, err := g.ReadNode(ctx, "pet", "b")
+	require.NoError(t, err)
+	require.Empty(t, pet.Edges)
+	require.Equal(t, int64(2), pet.Version)
+}
+
+func TestCommitChecksEdgesOfInserts(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityRestrict)
+	defer g.Close()
+	writeLinkedNodes(t, g)
+
+	// Edges may point at stored nodes of any type and at nodes of the same transaction.
+	_, err := g.Commit(ctx, []TxOp{
+		{Op: TxInsert, Node: graph.Node{ID: "d", Type: "pet", Name: "dot", Edges: []string{"a", "e"}}},
+		{Op: TxInsert, Node: graph.Node{ID: "e", Type: "toy", Name: "ball", Edges: []string{"b"}}},
+	})
+	require.NoError(t, err)
+
+	_, err = g.Commit(ctx, []TxOp{
+		{Op: TxUpdate, Node: graph.Node{ID: "e", Type: "toy", Name: "ball", Edges: []string{"missing"}}, ExpectedVersion: 1},
+	})
+	require.ErrorIs(t, err, ErrUnknownEdge)
+	toy, err := g.ReadNode(ctx, "toy", "e")
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, toy.Edges)
+}
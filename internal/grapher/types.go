@@ -0,0 +1,165 @@
+package grapher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+var (
+	ErrTypeNotFound = errors.New("node type not found")
+	ErrTypeExists   = errors.New("node type already exists")
+	ErrInvalidType  = errors.New("invalid node type name")
+)
+
+var validNodeType = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidateNodeType rejects type names that are not safe to use as file names. The
+// grapher checks every type it is given, so a name can never reach the engine.
+func ValidateNodeType(nodeType string) error {
+	if !validNodeType.MatchString(nodeType) {
+		return fmt.Errorf("%w: %q", ErrInvalidType, nodeType)
+	}
+	return nil
+}
+
+type TypeInfo struct {
+	Name  string `json:"name"`
+	Nodes int    `json:"nodes"`
+	Size  int64  `json:"size"`
+}
+
+func (gs *graphService) ListTypes(ctx context.Context) ([]TypeInfo, error) {
+	nodeTypes, err := gs.nodeTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(nodeTypes)
+
+	infos := make([]TypeInfo, 0, len(nodeTypes))
+	for _, nodeType := range nodeTypes {
+		info, err := gs.DescribeType(ctx, nodeType)
+		if errors.Is(err, ErrTypeNotFound) {
+			// dropped while listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+func (gs *graphService) DescribeType(ctx context.Context, nodeType string) (*TypeInfo, error) {
+	exists, err := gs.typeExists(ctx, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrTypeNotFound
+	}
+
+	nodes, err := gs.ReadNodesByType(ctx, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	size, err := gs.engine.Size(ctx, nodeType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypeInfo{Name: nodeType, Nodes: len(nodes), Size: size}, nil
+}
+
+// RenameType moves every node of a type to a new type. Requests for either type
+// wait until the rename is done, and so do writes checking edges, which would not
+// find the moved nodes meanwhile.
+func (gs *graphService) RenameType(ctx context.Context, from string, to string) error {
+	if err := ValidateNodeType(from); err != nil {
+		return err
+	}
+	if err := ValidateNodeType(to); err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+
+	if gs.integrity != IntegrityNone {
+		gs.refLock.Lock()
+		defer gs.refLock.Unlock()
+	}
+
+	return gs.workers.withExclusive([]string{from, to}, func() error {
+		exists, err := gs.typeExists(ctx, from)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrTypeNotFound
+		}
+		exists, err = gs.typeExists(ctx, to)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrTypeExists
+		}
+
+		it, err := gs.engine.Scan(ctx, from)
+		if err != nil {
+			return err
+		}
+		nodes, err := storage.ReadAll(it)
+		if err != nil {
+			return err
+		}
+		for i := range nodes {
+			nodes[i].Type = to
+		}
+
+		// One batch, so a crash between the copy and the drop does not leave both types.
+		gs.cache.invalidate(from, to)
+		return storage.ApplyBatch(ctx, gs.engine, []storage.Change{
+			{Op: storage.ChangeInsert, Type: to, Nodes: nodes},
+			{Op: storage.ChangeDropType, Type: from},
+		})
+	})
+}
+
+// DropType removes a type and all of its nodes. With an integrity mode, edges
+// pointing at the dropped nodes are handled as if each node was deleted.
+func (gs *graphService) DropType(ctx context.Context, nodeType string) error {
+	exists, err := gs.typeExists(ctx, nodeType)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTypeNotFound
+	}
+
+	if gs.integrity != IntegrityNone {
+		gs.refLock.Lock()
+		defer gs.refLock.Unlock()
+
+		nodes, err := gs.ReadNodesByType(ctx, nodeType)
+		if err != nil {
+			return err
+		}
+		ids := make(map[string]bool, len(nodes))
+		for _, node := range nodes {
+			ids[node.ID] = true
+		}
+		return gs.dropReferences(ctx, ids, storage.Change{Op: storage.ChangeDropType, Type: nodeType})
+	}
+
+	return gs.workers.withExclusive([]string{nodeType}, func() error {
+		gs.cache.invalidate(nodeType)
+		return gs.engine.DropType(ctx, nodeType)
+	})
+}
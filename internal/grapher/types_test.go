@@ -0,0 +1,147 @@
+package grapher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+func TestListAndDescribeTypes(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityNone)
+	writeLinkedNodes(t, g)
+
+	types, err := g.ListTypes(ctx)
+	require.NoError(t, err)
+	require.Len(t, types, 2)
+	require.Equal(t, "person", types[0].Name)
+	require.Equal(t, 1, types[0].Nodes)
+	require.Positive(t, types[0].Size)
+
+	_, err = g.DescribeType(ctx, "unknown")
+	require.ErrorIs(t, err, ErrTypeNotFound)
+}
+
+func TestRenameType(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityNone)
+	writeLinkedNodes(t, g)
+
+	require.ErrorIs(t, g.RenameType(ctx, "person", "pet"), ErrTypeExists)
+	require.ErrorIs(t, g.RenameType(ctx, "person", "../people"), ErrInvalidType)
+	require.ErrorIs(t, g.RenameType(ctx, "unknown", "people"), ErrTypeNotFound)
+	require.NoError(t, g.RenameType(ctx, "person", "people"))
+
+	people, err := g.ReadNodesByType(ctx, "people")
+	require.NoError(t, err)
+	require.Equal(t, []graph.Node{{ID: "a", Type: "people", Name: "alice", Version: 1}}, people)
+
+	persons, err := g.ReadNodesByType(ctx, "person")
+	require.NoError(t, err)
+	require.Empty(t, persons)
+}
+
+// brokenDropEngine fails every drop of a type while broken is set.
+type brokenDropEngine struct {
+	storage.Engine
+	broken bool
+}
+
+func (e *brokenDropEngine) DropType(ctx context.Context, nodeType string) error {
+	if e.broken {
+		return errors.New("drop failed")
+	}
+	return e.Engine.DropType(ctx, nodeType)
+}
+
+func TestRenameTypeCutShort(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t)
+	stored := storage.NewMemoryEngine()
+	broken := &brokenDropEngine{Engine: stored}
+	engine, err := storage.OpenJournal(ctx, broken, disk.NewFileAccessor(), cfg.Database.RootPath)
+	require.NoError(t, err)
+	g := newConfiguredGrapher(t, cfg, engine)
+	writeLinkedNodes(t, g)
+
+	// The nodes are copied, then the drop of the old type fails.
+	broken.broken = true
+	require.ErrorIs(t, g.RenameType(ctx, "person", "people"), storage.ErrBatchPending)
+
+	// Opened again after a crash, the journal finishes the rename.
+	_, err = storage.OpenJournal(ctx, stored, disk.NewFileAccessor(), cfg.Database.RootPath)
+	require.NoError(t, err)
+	types, err := stored.Types(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"people", "pet"}, types)
+	it, err := stored.Scan(ctx, "people")
+	require.NoError(t, err)
+	people, err := storage.ReadAll(it)
+	require.NoError(t, err)
+	require.Equal(t, []graph.Node{{ID: "a", Type: "people", Name: "alice", Version: 1}}, people)
+}
+
+func TestInvalidTypeIsRejectedEverywhere(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityRestrict)
+	writeLinkedNodes(t, g)
+	invalid := graph.Node{ID: "x", Type: "../x", Name: "x"}
+
+	require.ErrorIs(t, g.WriteNode(ctx, &invalid), ErrInvalidType)
+	errs := g.WriteNodes(ctx, []graph.Node{invalid, {ID: "c", Type: "person", Name: "carol"}})
+	require.ErrorIs(t, errs[0], ErrInvalidType)
+	require.NoError(t, errs[1])
+	require.ErrorIs(t, g.UpdateNode(ctx, &invalid, 0), ErrInvalidType)
+	require.ErrorIs(t, g.DeleteNode(ctx, "../x", "x", 0), ErrInvalidType)
+	_, err := g.ReadNode(ctx, "../x", "x")
+	require.ErrorIs(t, err, ErrInvalidType)
+	_, err = g.ReadNodesByType(ctx, "../x")
+	require.ErrorIs(t, err, ErrInvalidType)
+	require.ErrorIs(t, g.ScanNodes(ctx, "../x", func(graph.Node) error { return nil }), ErrInvalidType)
+	require.ErrorIs(t, g.RenameType(ctx, "../x", "people"), ErrInvalidType)
+	require.ErrorIs(t, g.DropType(ctx, "../x"), ErrInvalidType)
+}
+
+func TestRenameTypeWaitsForWrites(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityNone)
+	require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "a", Type: "person", Name: "alice"}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, g.WriteNode(ctx, &graph.Node{ID: "b", Type: "person", Name: "bob"}))
+		}()
+	}
+	require.NoError(t, g.RenameType(ctx, "person", "people"))
+	wg.Wait()
+
+	people, err := g.ReadNodesByType(ctx, "people")
+	require.NoError(t, err)
+	persons, err := g.ReadNodesByType(ctx, "person")
+	require.NoError(t, err)
+	require.Equal(t, 11, len(people)+len(persons))
+}
+
+func TestDropTypeWithIntegrity(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGrapher(t, IntegrityRestrict)
+	writeLinkedNodes(t, g)
+
+	require.ErrorIs(t, g.DropType(ctx, "person"), ErrNodeReferenced)
+	require.NoError(t, g.DropType(ctx, "pet"))
+	require.NoError(t, g.DropType(ctx, "person"))
+	require.ErrorIs(t, g.DropType(ctx, "person"), ErrTypeNotFound)
+
+	types, err := g.ListTypes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, types)
+}
@@ -2,72 +2,161 @@ package grapher
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"sync"
 
 	"github.com/zmjung/jamesdb/graph"
-	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
 )
 
 var EmptyGraphNodes = []graph.Node{}
 
+var ErrWorkerClosed = errors.New("worker is closed")
+
+// AnyVersion skips the version check of an update or delete.
+const AnyVersion int64 = 0
+
+// maxGroupCommit bounds how many queued writes are coalesced into a single append.
+const maxGroupCommit = 1024
+
 type Worker interface {
 	ReadNodes(ctx context.Context) ([]graph.Node, error)
+	ReadNode(ctx context.Context, id string) (*graph.Node, error)
+	FindNodes(ctx context.Context, ids []string) ([]graph.Node, error)
+	Scan(ctx context.Context) (storage.Iterator, error)
+	ScanAfter(ctx context.Context, after string) (storage.Iterator, error)
 	WriteNodes(ctx context.Context, nodes []graph.Node) error
+	UpdateNode(ctx context.Context, node *graph.Node, expectedVersion int64) error
+	DeleteNode(ctx context.Context, id string, expectedVersion int64) error
+	ModifyNodes(ctx context.Context, modify ModifyFunc) error
+	Close() error
+}
+
+// ModifyFunc receives every node stored by a worker and returns the nodes it changed,
+// which are then stored in place of the old ones.
+type ModifyFunc func(nodes []graph.Node) ([]graph.Node, error)
+
+// writeRequest is a queued append waiting for the next group commit.
+type writeRequest struct {
+	ctx   context.Context
+	nodes []graph.Node
+	done  chan error
 }
 
+// worker owns the nodes of a single node type in the storage engine. Inserts go
+// through a queue drained by a long-lived goroutine, which commits everything pending
+// with one engine write. Reads and changes to stored nodes take the same lock as a commit.
 type worker struct {
-	f        disk.FileAccessor
-	csv      disk.CsvAccessor
+	engine   storage.Engine
+	cache    *nodeCache
 	nodeType string
-	filePath string
 	lock     *sync.Mutex
-}
 
-func newWorker(f disk.FileAccessor, csv disk.CsvAccessor, nodePath string, nodeType string) Worker {
-	filePath := f.GetFilePath(nodePath, nodeType+".csv")
-	err := csv.CreateFileWithHeader(context.Background(), filePath, graph.NodeCsvHeader)
-	if err != nil {
-		slog.Error("Error creating headers for nodes file", "error", err)
-		return nil
-	}
+	queue     chan *writeRequest
+	closing   chan struct{}
+	closed    chan struct{}
+	sendLock  sync.RWMutex
+	isClosing bool
+}
 
-	return &worker{
-		f:        f,
-		csv:      csv,
+// newWorker starts the worker of a node type. Nothing is stored before the first write.
+// The cache may be nil.
+func newWorker(engine storage.Engine, cache *nodeCache, nodeType string) Worker {
+	w := &worker{
+		engine:   engine,
+		cache:    cache,
 		nodeType: nodeType,
-		filePath: filePath,
 		lock:     &sync.Mutex{},
+		queue:    make(chan *writeRequest, maxGroupCommit),
+		closing:  make(chan struct{}),
+		closed:   make(chan struct{}),
 	}
+
+	go w.run()
+	return w
 }
 
-func (w *worker) initFile(ctx context.Context) error {
-	isEmpty, err := w.f.IsFileEmpty(w.filePath)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error creating headers for nodes file", "error", err)
-		return err
+func (w *worker) run() {
+	defer close(w.closed)
+
+	for {
+		select {
+		case req := <-w.queue:
+			w.commit(w.collect(req))
+		case <-w.closing:
+			// Commit whatever was queued before closing.
+			for {
+				select {
+				case req := <-w.queue:
+					w.commit(w.collect(req))
+				default:
+					return
+				}
+			}
+		}
 	}
-	if !isEmpty {
-		return nil
+}
+
+// collect gathers the writes already waiting behind the first one into a single group.
+func (w *worker) collect(first *writeRequest) []*writeRequest {
+	group := []*writeRequest{first}
+	for len(group) < maxGroupCommit {
+		select {
+		case req := <-w.queue:
+			group = append(group, req)
+		default:
+			return group
+		}
+	}
+	return group
+}
+
+func (w *worker) commit(group []*writeRequest) {
+	var nodes []graph.Node
+	for _, req := range group {
+		nodes = append(nodes, req.nodes...)
 	}
 
 	w.lock.Lock()
-	defer w.lock.Unlock()
+	err := w.engine.Insert(group[0].ctx, w.nodeType, nodes)
+	if err == nil {
+		w.cache.insert(w.nodeType, nodes)
+	} else {
+		w.cache.invalidate(w.nodeType)
+	}
+	w.lock.Unlock()
+
+	if err != nil {
+		slog.ErrorContext(group[0].ctx, "Error writing nodes", "nodeType", w.nodeType, "error", err)
+	}
+	slog.DebugContext(group[0].ctx, "Committed node group", "nodeType", w.nodeType, "writes", len(group), "nodes", len(nodes))
 
-	return w.csv.CreateFileWithHeader(ctx, w.filePath, graph.NodeCsvHeader)
+	for _, req := range group {
+		req.done <- err
+	}
 }
 
-func (w *worker) ReadNodes(ctx context.Context) ([]graph.Node, error) {
-	if err := w.initFile(ctx); err != nil {
-		return nil, err
+// Close stops accepting writes, commits the ones already queued and waits for the worker to stop.
+func (w *worker) Close() error {
+	w.sendLock.Lock()
+	if !w.isClosing {
+		w.isClosing = true
+		close(w.closing)
 	}
+	w.sendLock.Unlock()
 
+	<-w.closed
+	return nil
+}
+
+func (w *worker) ReadNodes(ctx context.Context) ([]graph.Node, error) {
 	w.lock.Lock()
-	nodes, err := w.csv.ReadNodesFromFile(ctx, w.filePath)
+	nodes, err := w.readNodesLocked(ctx)
 	w.lock.Unlock()
 
 	if err != nil {
-		slog.ErrorContext(ctx, "Error reading nodes from CSV file", "filePath", w.filePath, "error", err)
 		return nil, err
 	}
 
@@ -78,17 +167,197 @@ func (w *worker) ReadNodes(ctx context.Context) ([]graph.Node, error) {
 	return nodes, nil
 }
 
+func (w *worker) readNodesLocked(ctx context.Context) ([]graph.Node, error) {
+	if nodes, cached := w.cache.get(w.nodeType); cached {
+		return nodes, nil
+	}
+
+	it, err := w.engine.Scan(ctx, w.nodeType)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := storage.ReadAll(it)
+	if err != nil {
+		return nil, err
+	}
+	w.cache.put(w.nodeType, nodes)
+	return nodes, nil
+}
+
+// Scan opens an iterator over the stored nodes without reading them all at once.
+// The iterator is opened under the worker lock, so it sees every committed write,
+// and is walked without holding it.
+func (w *worker) Scan(ctx context.Context) (storage.Iterator, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if nodes, cached := w.cache.get(w.nodeType); cached {
+		return storage.NewSliceIterator(nodes), nil
+	}
+	return w.engine.Scan(ctx, w.nodeType)
+}
+
+// ScanAfter walks over the nodes with IDs after the given one in ID order, sorting the
+// cached nodes of the type when it is cached.
+func (w *worker) ScanAfter(ctx context.Context, after string) (storage.Iterator, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if nodes, cached := w.cache.get(w.nodeType); cached {
+		return storage.NewSliceIterator(storage.NodesAfter(nodes, after)), nil
+	}
+	return w.engine.ScanAfter(ctx, w.nodeType, after)
+}
+
+func (w *worker) ReadNode(ctx context.Context, id string) (*graph.Node, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.getLocked(ctx, id)
+}
+
+// FindNodes returns the stored nodes among the given IDs, looking each of them up
+// instead of reading the whole type.
+func (w *worker) FindNodes(ctx context.Context, ids []string) ([]graph.Node, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var found []graph.Node
+	for _, id := range ids {
+		node, err := w.getLocked(ctx, id)
+		if errors.Is(err, ErrNodeNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, *node)
+	}
+	return found, nil
+}
+
+func (w *worker) getLocked(ctx context.Context, id string) (*graph.Node, error) {
+	if node, found, cached := w.cache.getNode(w.nodeType, id); cached {
+		if !found {
+			return nil, ErrNodeNotFound
+		}
+		return node, nil
+	}
+
+	node, err := w.engine.Get(ctx, w.nodeType, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNodeNotFound
+	}
+	return node, err
+}
+
+// WriteNodes stores new nodes, setting each of their versions to 1.
 func (w *worker) WriteNodes(ctx context.Context, nodes []graph.Node) error {
-	if err := w.initFile(ctx); err != nil {
+	for i := range nodes {
+		nodes[i].Version = 1
+	}
+
+	// A queued write is committed even if the caller gives up, so it is committed
+	// without the caller's cancellation, and the caller waits for the outcome rather
+	// than report a failure for a write that may still succeed. Waiting also keeps
+	// the worker in use until the write is stored.
+	req := &writeRequest{ctx: context.WithoutCancel(ctx), nodes: nodes, done: make(chan error, 1)}
+	if err := w.enqueue(ctx, req); err != nil {
 		return err
 	}
+	return <-req.done
+}
+
+func (w *worker) enqueue(ctx context.Context, req *writeRequest) error {
+	// Holding the send lock guarantees Close cannot finish draining the queue
+	// between the closing check and the send.
+	w.sendLock.RLock()
+	defer w.sendLock.RUnlock()
+
+	if w.isClosing {
+		return ErrWorkerClosed
+	}
+	select {
+	case w.queue <- req:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
+// UpdateNode replaces a stored node and increments its version. The version check
+// runs under the worker lock, so concurrent updates cannot both pass it.
+func (w *worker) UpdateNode(ctx context.Context, node *graph.Node, expectedVersion int64) error {
 	w.lock.Lock()
-	err := w.csv.WriteNodesAsCsv(ctx, w.filePath, nodes)
-	w.lock.Unlock()
+	defer w.lock.Unlock()
 
+	stored, err := w.getLocked(ctx, node.ID)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error writing nodes to CSV file", "filePath", w.filePath, "error", err)
+		return err
+	}
+	if err := checkVersion(stored, expectedVersion); err != nil {
+		return err
+	}
+
+	updated := *node
+	updated.Version = stored.Version + 1
+	if err := w.engine.Put(ctx, w.nodeType, []graph.Node{updated}); err != nil {
+		slog.ErrorContext(ctx, "Error updating node", "nodeType", w.nodeType, "error", err)
+		w.cache.invalidate(w.nodeType)
+		return err
+	}
+	w.cache.replace(w.nodeType, []graph.Node{updated})
+	node.Version = updated.Version
+	return nil
+}
+
+func (w *worker) DeleteNode(ctx context.Context, id string, expectedVersion int64) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	stored, err := w.getLocked(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(stored, expectedVersion); err != nil {
+		return err
+	}
+
+	if err := w.engine.Delete(ctx, w.nodeType, []string{id}); err != nil {
+		slog.ErrorContext(ctx, "Error deleting node", "nodeType", w.nodeType, "error", err)
+		w.cache.invalidate(w.nodeType)
+		return err
+	}
+	w.cache.remove(w.nodeType, id)
+	return nil
+}
+
+func checkVersion(node *graph.Node, expectedVersion int64) error {
+	if expectedVersion != AnyVersion && node.Version != expectedVersion {
+		return fmt.Errorf("%w: node %s is at version %d", ErrVersionMismatch, node.ID, node.Version)
+	}
+	return nil
+}
+
+func (w *worker) ModifyNodes(ctx context.Context, modify ModifyFunc) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	nodes, err := w.readNodesLocked(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed, err := modify(nodes)
+	if err != nil || len(changed) == 0 {
+		return err
+	}
+
+	if err := w.engine.Put(ctx, w.nodeType, changed); err != nil {
+		slog.ErrorContext(ctx, "Error storing modified nodes", "nodeType", w.nodeType, "error", err)
+		w.cache.invalidate(w.nodeType)
+		return err
 	}
-	return err
+	w.cache.replace(w.nodeType, changed)
+	return nil
 }
@@ -5,16 +5,20 @@ import (
 	"context"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/zmjung/jamesdb/graph"
 	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/storage"
 )
 
 const (
-	TwoNodesCsv = `1,type1,node1,"[""edge1"",""edge2""]","{""trait1"":""value1""}"
-2,type2,node2,"[""edge3"",""edge4""]","{""trait2"":""value2""}"
+	TwoNodesCsv = `1,type1,node1,"[""edge1"",""edge2""]","{""trait1"":""value1""}",1,9a255c5b
+2,type2,node2,"[""edge3"",""edge4""]","{""trait2"":""value2""}",1,efbe83f4
 `
 )
 
@@ -70,6 +74,14 @@ func (m *MockFileAccessor) IsFileEmpty(filePath string) (bool, error) {
 	return m.reader == nil, nil
 }
 
+func (m *MockFileAccessor) FileExists(filePath string) (bool, error) {
+	return true, nil
+}
+
+func (m *MockFileAccessor) FileSize(filePath string) (int64, error) {
+	return 0, nil
+}
+
 func (m *MockFileAccessor) AddFolder(rootPath string, folderName string) (string, error) {
 	return "", nil
 }
@@ -78,15 +90,41 @@ func (m *MockFileAccessor) GetFilePath(rootPath string, fileName string) string
 	return m.f.GetFilePath(rootPath, fileName)
 }
 
+func (m *MockFileAccessor) ListFiles(folderPath string, ext string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *MockFileAccessor) RenameFile(oldPath string, newPath string) error {
+	return nil
+}
+
+func (m *MockFileAccessor) RemoveFile(filePath string) error {
+	return nil
+}
+
+func (m *MockFileAccessor) Sync(filePath string, writer io.Writer) error {
+	return nil
+}
+
+func (m *MockFileAccessor) Durability() string {
+	return disk.DurabilityNone
+}
+
+func (m *MockFileAccessor) Close() error {
+	return nil
+}
+
 func TestWriteNodes(t *testing.T) {
 	ctx := context.Background()
 
 	reader := new(bytes.Buffer)
 	writer := new(bytes.Buffer)
 	f := GetFileAccessor(reader, writer)
-	csv := disk.NewCsvAccessor(f)
+	engine, err := storage.NewFileEngine(f, disk.NewCsvAccessor(f), "nodePath")
+	require.NoError(t, err)
 
-	w := newWorker(f, csv, "nodePath", "nodeType")
+	w := newWorker(engine, nil, "nodeType")
+	defer w.Close()
 
 	nodes := getTwoNodes()
 	w.WriteNodes(ctx, nodes)
@@ -96,6 +134,95 @@ func TestWriteNodes(t *testing.T) {
 	require.Equal(t, TwoNodesCsv, string(bytes))
 }
 
+type countingEngine struct {
+	storage.Engine
+	writes atomic.Int32
+}
+
+func (c *countingEngine) Insert(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	c.writes.Add(1)
+	// Give concurrent writers time to queue up behind this commit.
+	time.Sleep(10 * time.Millisecond)
+	return c.Engine.Insert(ctx, nodeType, nodes)
+}
+
+// blockingEngine holds every insert until release is closed.
+type blockingEngine struct {
+	storage.Engine
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingEngine) Insert(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return b.Engine.Insert(ctx, nodeType, nodes)
+}
+
+func TestWriteNodesGroupCommit(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	fileEngine, err := storage.NewFileEngine(f, disk.NewCsvAccessor(f), t.TempDir())
+	require.NoError(t, err)
+	engine := &countingEngine{Engine: fileEngine}
+
+	w := newWorker(engine, nil, "nodeType")
+	defer w.Close()
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.WriteNodes(ctx, getTwoNodes())
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	nodes, err := w.ReadNodes(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2*writers)
+	require.Less(t, int(engine.writes.Load()), writers)
+}
+
+func TestWriteNodesWaitsForQueuedWrite(t *testing.T) {
+	engine := &blockingEngine{Engine: storage.NewMemoryEngine(), started: make(chan struct{}), release: make(chan struct{})}
+	w := newWorker(engine, nil, "nodeType")
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.WriteNodes(ctx, getTwoNodes()) }()
+
+	// Cancelling once the write is queued still reports the outcome of its commit.
+	<-engine.started
+	cancel()
+	select {
+	case err := <-done:
+		t.Fatalf("write returned %v before it was committed", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(engine.release)
+	require.NoError(t, <-done)
+
+	nodes, err := w.ReadNodes(context.Background())
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+}
+
+func TestWriteNodesAfterClose(t *testing.T) {
+	w := newWorker(storage.NewMemoryEngine(), nil, "nodeType")
+	require.NoError(t, w.Close())
+
+	require.ErrorIs(t, w.WriteNodes(context.Background(), getTwoNodes()), ErrWorkerClosed)
+}
+
 func getTwoNodes() []graph.Node {
 	return []graph.Node{
 		{
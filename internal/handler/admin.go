@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/grapher"
+	"github.com/zmjung/jamesdb/internal/log"
+)
+
+type AdminHandler struct {
+	Handles *disk.HandleCache
+	Grapher grapher.Grapher
+}
+
+func NewAdminHandler(handles *disk.HandleCache, g grapher.Grapher) *AdminHandler {
+	return &AdminHandler{
+		Handles: handles,
+		Grapher: g,
+	}
+}
+
+func (ah *AdminHandler) GetStats(c *gin.Context) {
+	// This function reports runtime statistics of the storage layer.
+	c.JSON(200, gin.H{
+		"fileHandles": ah.Handles.Stats(),
+		"graph":       ah.Grapher.Stats(),
+	})
+}
+
+func (ah *AdminHandler) PostFsck(c *gin.Context) {
+	// This function verifies every node file and optionally quarantines corrupt records.
+	ctx := log.ConvertContext(c)
+
+	quarantine, err := strconv.ParseBool(c.DefaultQuery("quarantine", "false"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid quarantine parameter"})
+		return
+	}
+
+	reports, err := ah.Grapher.Check(ctx, quarantine)
+	if errors.Is(err, grapher.ErrCheckUnsupported) {
+		c.JSON(501, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to check node files: %v", err)})
+		return
+	}
+
+	corrupt := 0
+	for _, report := range reports {
+		if !report.Healthy() {
+			corrupt++
+		}
+	}
+	c.JSON(200, gin.H{
+		"files":   reports,
+		"corrupt": corrupt,
+	})
+}
+
+func (ah *AdminHandler) PostBackup(c *gin.Context) {
+	// This function streams a snapshot of every node type as a tar.gz archive, a type at a time.
+	ctx := log.ConvertContext(c)
+
+	name := fmt.Sprintf("jamesdb-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	c.Header("Content-Type", "application/gzip")
+	if !ah.Grapher.Stats().ChangeLog {
+		slog.WarnContext(ctx, "Backing up without a change log, so restores cannot replay the changes made after the backup")
+	}
+
+	_, err := ah.Grapher.Backup(ctx, c.Writer)
+	if err != nil && !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to back up: %v", err)})
+		return
+	}
+	if err != nil {
+		// The status is already sent, so the client only sees a truncated archive.
+		slog.ErrorContext(ctx, "Error writing backup archive", "error", err)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zmjung/jamesdb/internal/database"
+)
+
+type DatabaseInfo struct {
+	Name     string            `json:"name"`
+	Settings database.Settings `json:"settings"`
+}
+
+type DatabaseHandler struct {
+	Databases *database.Manager
+}
+
+func NewDatabaseHandler(databases *database.Manager) *DatabaseHandler {
+	return &DatabaseHandler{
+		Databases: databases,
+	}
+}
+
+func (dh *DatabaseHandler) GetDatabases(c *gin.Context) {
+	// This function lists the named databases with their settings.
+	databases := dh.Databases.List()
+	infos := make([]DatabaseInfo, 0, len(databases))
+	for _, db := range databases {
+		infos = append(infos, DatabaseInfo{Name: db.Name, Settings: db.Settings})
+	}
+	c.JSON(200, infos)
+}
+
+func (dh *DatabaseHandler) GetDatabase(c *gin.Context) {
+	// This function describes a single named database.
+	name := c.Param("name")
+	db, release, err := dh.Databases.Acquire(name)
+	if err != nil {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Database %s not found", name)})
+		return
+	}
+	defer release()
+
+	c.JSON(200, gin.H{
+		"name":     db.Name,
+		"settings": db.Settings,
+		"graph":    db.DB.Grapher.Stats(),
+	})
+}
+
+func (dh *DatabaseHandler) CreateDatabase(c *gin.Context) {
+	// This function creates a named database, with the server configuration
+	// overridden by the optional settings in the body.
+	name := c.Param("name")
+
+	settings := database.Settings{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&settings); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	db, err := dh.Databases.Create(name, settings)
+	if errors.Is(err, database.ErrInvalidName) || errors.Is(err, database.ErrInvalidSettings) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, database.ErrDatabaseExists) {
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to create database %s: %v", name, err)})
+		return
+	}
+	c.JSON(200, DatabaseInfo{Name: db.Name, Settings: db.Settings})
+}
+
+func (dh *DatabaseHandler) DropDatabase(c *gin.Context) {
+	// This function drops a named database with all of its data.
+	name := c.Param("name")
+
+	err := dh.Databases.Drop(name)
+	if errors.Is(err, database.ErrDatabaseNotFound) {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Database %s not found", name)})
+		return
+	}
+	if err != nil {
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to drop database %s: %v", name, err)})
+		return
+	}
+	c.JSON(200, gin.H{"message": fmt.Sprintf("Database %s dropped", name)})
+}
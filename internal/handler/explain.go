@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+// Plan describes how the server answers a read. Reads requested with explain=true
+// return their plan instead of their result, without reading any node.
+type Plan struct {
+	Steps []string `json:"steps"`
+}
+
+func explainRequested(c *gin.Context) bool {
+	return c.Query("explain") == "true"
+}
+
+// cached reports whether every node of the type is held by the node cache, which
+// answers reads of the type in place of the engine.
+func (gh *GraphHandler) cached(nodeType string) bool {
+	return gh.Grapher.Stats().Cache.Types[nodeType].Cached
+}
+
+// explainNodes describes how a listing of nodes is read and filtered.
+func (gh *GraphHandler) explainNodes(nodeType string, query *NodeQuery) *Plan {
+	var steps []string
+	if query.paged() {
+		after := "from the first ID"
+		if query.PageToken != "" {
+			after = fmt.Sprintf("after ID %q", query.PageToken)
+		}
+		switch {
+		case gh.cached(nodeType):
+			steps = append(steps, fmt.Sprintf("sort the cached nodes of type %q by ID and scan them %s", nodeType, after))
+		case gh.Engine == storage.EngineLsm:
+			steps = append(steps, fmt.Sprintf("scan the keys of type %q in ID order %s, reading each node as the scan reaches it", nodeType, after))
+		case gh.Engine == storage.EngineMemory:
+			steps = append(steps, fmt.Sprintf("sort the nodes of type %q held in memory by ID and scan them %s", nodeType, after))
+		default:
+			steps = append(steps, fmt.Sprintf("read the whole file of type %q, sort its nodes by ID and scan them %s", nodeType, after))
+		}
+	} else if gh.cached(nodeType) {
+		steps = append(steps, fmt.Sprintf("read every cached node of type %q in stored order", nodeType))
+	} else {
+		steps = append(steps, fmt.Sprintf("read every node of type %q from the %s engine in stored order and cache them if they fit", nodeType, gh.Engine))
+	}
+
+	var filters []string
+	if query.Name != "" {
+		filters = append(filters, fmt.Sprintf("name = %q", query.Name))
+	}
+	for _, key := range slices.Sorted(maps.Keys(query.Traits)) {
+		filters = append(filters, fmt.Sprintf("trait.%s = %q", key, query.Traits[key]))
+	}
+	if len(filters) > 0 {
+		steps = append(steps, "keep the nodes matching "+strings.Join(filters, " and "))
+	}
+
+	if query.paged() {
+		steps = append(steps, fmt.Sprintf("stop after %d matching nodes, returning the last ID as the next page token when more follow", cmp.Or(query.Limit, MaxPageSize)))
+	} else {
+		steps = append(steps, "return every matching node in one response")
+	}
+	return &Plan{Steps: steps}
+}
+
+// explainNode describes how a node is looked up by ID.
+func (gh *GraphHandler) explainNode(nodeType string, id string) string {
+	if gh.cached(nodeType) {
+		return fmt.Sprintf("look node %q up in the cached nodes of type %q", id, nodeType)
+	}
+	return fmt.Sprintf("look node %q of type %q up by ID in the %s engine", id, nodeType, gh.Engine)
+}
@@ -1,24 +1,50 @@
 package handler
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/zmjung/jamesdb/config"
 	"github.com/zmjung/jamesdb/graph"
 	"github.com/zmjung/jamesdb/internal/grapher"
 	"github.com/zmjung/jamesdb/internal/log"
+	"github.com/zmjung/jamesdb/internal/storage"
 	"github.com/zmjung/jamesdb/internal/uuid"
 )
 
+// MaxBatchSize is the largest number of nodes accepted by a single batch request.
+const MaxBatchSize = 1000
+
+type BatchRequest struct {
+	Nodes []graph.Node `json:"nodes"`
+}
+
+type BatchResult struct {
+	Status int         `json:"status"`
+	Node   *graph.Node `json:"node,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type TxRequest struct {
+	Ops []grapher.TxOp `json:"ops"`
+}
+
 type GraphHandler struct {
 	StorageRootPath string
-	Grapher         grapher.Grapher
+	// Engine names the storage engine, for the plans of explained reads.
+	Engine  string
+	Grapher grapher.Grapher
 }
 
 func NewGraphHandler(cfg *config.Config, g grapher.Grapher) *GraphHandler {
 	return &GraphHandler{
 		StorageRootPath: cfg.Database.RootPath,
+		Engine:          cmp.Or(cfg.Database.Engine, storage.EngineFile),
 		Grapher:         g,
 	}
 }
@@ -28,12 +54,39 @@ func (gh *GraphHandler) GetGraphNodes(c *gin.Context) {
 	ctx := log.ConvertContext(c)
 	// TODO: sanitize type input
 	nodeType := c.Param("type")
-	nodes, err := gh.Grapher.ReadNodesByType(ctx, nodeType)
+	query, err := parseNodeQuery(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid query"})
+		return
+	}
+	if explainRequested(c) {
+		c.JSON(200, gh.explainNodes(nodeType, query))
+		return
+	}
 
+	var nodes []graph.Node
+	var next string
+	if query.paged() {
+		// Pages are read from an ID ordered scan starting after the page token.
+		nodes, next, err = query.page(func(after string, fn func(node graph.Node) error) error {
+			return gh.Grapher.ScanNodesAfter(ctx, nodeType, after, fn)
+		})
+	} else {
+		nodes, err = gh.Grapher.ReadNodesByType(ctx, nodeType)
+		nodes = query.filter(nodes)
+	}
+	if errors.Is(err, grapher.ErrInvalidType) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to retrieve nodes of type %s: %v", nodeType, err)})
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to retrieve nodes of type %s: %v", nodeType, err)})
 		return
 	}
+
+	if next != "" {
+		c.Header(NextPageTokenHeader, next)
+	}
 	c.JSON(200, nodes)
 }
 
@@ -56,11 +109,294 @@ func (gh *GraphHandler) CreateGraphNode(c *gin.Context) {
 	node.ID = id
 
 	err = gh.Grapher.WriteNode(ctx, node)
+	if errors.Is(err, grapher.ErrInvalidType) {
+		c.JSON(400, gin.H{"error": err.Error(), "node": node})
+		return
+	}
+	if errors.Is(err, grapher.ErrUnknownEdge) {
+		c.JSON(422, gin.H{"error": err.Error(), "node": node})
+		return
+	}
 	if err != nil {
 		fmt.Printf("Error writing node data: %v\n", err)
-		c.JSON(500, gin.H{"error": "Failed to write node data", "node": node})
+		c.JSON(serverErrorStatus(err), gin.H{"error": "Failed to write node data", "node": node})
 		return
 	}
 
+	c.Header("ETag", formatETag(node.Version))
 	c.JSON(200, gin.H{"message": "Node data written successfully", "node": node})
 }
+
+func (gh *GraphHandler) PostGraphNodes(c *gin.Context) {
+	// gin matches "/nodes:batch" as the "/nodes" prefix followed by a parameter,
+	// so custom methods on the node collection are dispatched here.
+	switch c.Param("action") {
+	case ":batch":
+		gh.BatchCreateGraphNodes(c)
+	default:
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Unknown action %s", c.Param("action"))})
+	}
+}
+
+func (gh *GraphHandler) BatchCreateGraphNodes(c *gin.Context) {
+	// This function writes many nodes of mixed types and reports a status per node.
+	ctx := log.ConvertContext(c)
+
+	request := &BatchRequest{}
+	if err := c.ShouldBindJSON(request); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input"})
+		return
+	}
+	if len(request.Nodes) > MaxBatchSize {
+		c.JSON(413, gin.H{"error": fmt.Sprintf("Batch is limited to %d nodes", MaxBatchSize)})
+		return
+	}
+
+	results := make([]BatchResult, len(request.Nodes))
+	valid := make([]graph.Node, 0, len(request.Nodes))
+	validIndexes := make([]int, 0, len(request.Nodes))
+	for i := range request.Nodes {
+		node := request.Nodes[i]
+		if err := binding.Validator.ValidateStruct(&node); err != nil {
+			results[i] = BatchResult{Status: 400, Node: &node, Error: "Invalid input"}
+			continue
+		}
+
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			results[i] = BatchResult{Status: 500, Node: &node, Error: "Failed to generate UUID"}
+			continue
+		}
+		node.ID = id
+		valid = append(valid, node)
+		validIndexes = append(validIndexes, i)
+	}
+
+	errs := gh.Grapher.WriteNodes(ctx, valid)
+	for j, i := range validIndexes {
+		node := valid[j]
+		switch {
+		case errors.Is(errs[j], grapher.ErrInvalidType):
+			results[i] = BatchResult{Status: 400, Node: &node, Error: errs[j].Error()}
+		case errors.Is(errs[j], grapher.ErrUnknownEdge):
+			results[i] = BatchResult{Status: 422, Node: &node, Error: errs[j].Error()}
+		case errs[j] != nil:
+			results[i] = BatchResult{Status: serverErrorStatus(errs[j]), Node: &node, Error: "Failed to write node data"}
+		default:
+			results[i] = BatchResult{Status: 200, Node: &node}
+		}
+	}
+
+	c.JSON(200, gin.H{"results": results})
+}
+
+func (gh *GraphHandler) CommitTransaction(c *gin.Context) {
+	// This function applies inserts, updates and deletes of mixed types all together or not at all.
+	ctx := log.ConvertContext(c)
+
+	request := &TxRequest{}
+	if err := c.ShouldBindJSON(request); err != nil || len(request.Ops) == 0 {
+		c.JSON(400, gin.H{"error": "Invalid input"})
+		return
+	}
+	if len(request.Ops) > MaxBatchSize {
+		c.JSON(413, gin.H{"error": fmt.Sprintf("Transaction is limited to %d operations", MaxBatchSize)})
+		return
+	}
+
+	for i := range request.Ops {
+		op := &request.Ops[i]
+		if op.Op == grapher.TxDelete {
+			continue
+		}
+		if err := binding.Validator.ValidateStruct(&op.Node); err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid node in operation %d", i)})
+			return
+		}
+		if op.Op == grapher.TxInsert && op.Node.ID == "" {
+			id, err := uuid.GenerateUUID()
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to generate UUID"})
+				return
+			}
+			op.Node.ID = id
+		}
+	}
+
+	nodes, err := gh.Grapher.Commit(ctx, request.Ops)
+	switch {
+	case errors.Is(err, grapher.ErrInvalidTxOp), errors.Is(err, grapher.ErrInvalidType):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrNodeNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrVersionMismatch):
+		c.JSON(412, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrNodeExists), errors.Is(err, grapher.ErrNodeReferenced):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrUnknownEdge):
+		c.JSON(422, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrTxPending):
+		// The transaction is committed, but reads may not see all of it before the next write.
+		c.JSON(202, gin.H{"message": "Transaction committed in part", "error": err.Error(), "pending": true, "nodes": nodes})
+		return
+	case err != nil:
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to commit transaction: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Transaction committed successfully", "nodes": nodes})
+}
+
+func (gh *GraphHandler) GetGraphNode(c *gin.Context) {
+	// This function gets a single graph node and returns its version as the ETag.
+	ctx := log.ConvertContext(c)
+	nodeType := c.Param("type")
+	id := c.Param("id")
+	if explainRequested(c) {
+		c.JSON(200, &Plan{Steps: []string{gh.explainNode(nodeType, id)}})
+		return
+	}
+
+	node, err := gh.Grapher.ReadNode(ctx, nodeType, id)
+	if errors.Is(err, grapher.ErrInvalidType) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, grapher.ErrNodeNotFound) {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Node %s of type %s not found", id, nodeType)})
+		return
+	}
+	if err != nil {
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to retrieve node %s: %v", id, err)})
+		return
+	}
+
+	c.Header("ETag", formatETag(node.Version))
+	c.JSON(200, node)
+}
+
+func (gh *GraphHandler) GetGraphNodeEdges(c *gin.Context) {
+	// This function gets the nodes the edges of a graph node point at.
+	ctx := log.ConvertContext(c)
+	nodeType := c.Param("type")
+	id := c.Param("id")
+	if explainRequested(c) {
+		c.JSON(200, &Plan{Steps: []string{
+			gh.explainNode(nodeType, id),
+			"look the nodes its edges point at up by ID in one node type after another, until every one is found",
+			"return them in the order of the edges, skipping the ones not found",
+		}})
+		return
+	}
+
+	nodes, err := gh.Grapher.ReadEdges(ctx, nodeType, id)
+	if errors.Is(err, grapher.ErrInvalidType) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, grapher.ErrNodeNotFound) {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Node %s of type %s not found", id, nodeType)})
+		return
+	}
+	if err != nil {
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to retrieve edges of node %s: %v", id, err)})
+		return
+	}
+	c.JSON(200, nodes)
+}
+
+func (gh *GraphHandler) UpdateGraphNode(c *gin.Context) {
+	// This function replaces a graph node, honoring If-Match against the node version.
+	ctx := log.ConvertContext(c)
+	nodeType := c.Param("type")
+	id := c.Param("id")
+
+	node := &graph.Node{}
+	if err := c.ShouldBindJSON(node); err != nil || node.Type != nodeType {
+		c.JSON(400, gin.H{"error": "Invalid input", "node": node})
+		return
+	}
+	node.ID = id
+
+	err := gh.Grapher.UpdateNode(ctx, node, parseIfMatch(c))
+	switch {
+	case errors.Is(err, grapher.ErrInvalidType):
+		c.JSON(400, gin.H{"error": err.Error(), "node": node})
+		return
+	case errors.Is(err, grapher.ErrNodeNotFound):
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Node %s of type %s not found", id, nodeType)})
+		return
+	case errors.Is(err, grapher.ErrVersionMismatch):
+		c.JSON(412, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrUnknownEdge):
+		c.JSON(422, gin.H{"error": err.Error(), "node": node})
+		return
+	case err != nil:
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to update node %s: %v", id, err)})
+		return
+	}
+
+	c.Header("ETag", formatETag(node.Version))
+	c.JSON(200, gin.H{"message": "Node updated successfully", "node": node})
+}
+
+func (gh *GraphHandler) DeleteGraphNode(c *gin.Context) {
+	// This function deletes a graph node, applying the configured integrity mode to its referrers.
+	ctx := log.ConvertContext(c)
+	nodeType := c.Param("type")
+	id := c.Param("id")
+
+	err := gh.Grapher.DeleteNode(ctx, nodeType, id, parseIfMatch(c))
+	switch {
+	case errors.Is(err, grapher.ErrInvalidType):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrNodeNotFound):
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Node %s of type %s not found", id, nodeType)})
+		return
+	case errors.Is(err, grapher.ErrVersionMismatch):
+		c.JSON(412, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrNodeReferenced):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to delete node %s: %v", id, err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Node deleted successfully", "id": id})
+}
+
+// serverErrorStatus tells apart failures worth retrying later from internal errors.
+func serverErrorStatus(err error) int {
+	if errors.Is(err, grapher.ErrTooManyWorkers) {
+		return 503
+	}
+	return 500
+}
+
+func formatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseIfMatch returns the version required by the If-Match header.
+// A missing header or "*" matches any version; a value that is not a version never matches.
+func parseIfMatch(c *gin.Context) int64 {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return grapher.AnyVersion
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return -1
+	}
+	return version
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zmjung/jamesdb/graph"
+)
+
+// NextPageTokenHeader carries the token of the next page of a paged listing. It is
+// not set on the last page.
+const NextPageTokenHeader = "Next-Page-Token"
+
+// MaxPageSize is the largest number of nodes returned by a single page.
+const MaxPageSize = 1000
+
+var (
+	errInvalidQuery = errors.New("invalid query")
+	// errPageFull stops the scan of a page.
+	errPageFull = errors.New("page is full")
+)
+
+// NodeQuery filters and pages the nodes of a type. Nodes match when they have the
+// name, if set, and every trait. Pages are ordered by node ID and start after the
+// ID given as the page token.
+type NodeQuery struct {
+	Name      string
+	Traits    map[string]string
+	Limit     int
+	PageToken string
+}
+
+// parseNodeQuery reads the name, trait (key:value, repeatable), limit and
+// pageToken query parameters.
+func parseNodeQuery(c *gin.Context) (*NodeQuery, error) {
+	query := &NodeQuery{
+		Name:      c.Query("name"),
+		Traits:    make(map[string]string),
+		PageToken: c.Query("pageToken"),
+	}
+
+	for _, trait := range c.QueryArray("trait") {
+		key, value, ok := strings.Cut(trait, ":")
+		if !ok || key == "" {
+			return nil, errInvalidQuery
+		}
+		query.Traits[key] = value
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > MaxPageSize {
+			return nil, errInvalidQuery
+		}
+		query.Limit = n
+	}
+	return query, nil
+}
+
+func (q *NodeQuery) paged() bool {
+	return q.Limit > 0 || q.PageToken != ""
+}
+
+func (q *NodeQuery) matches(node *graph.Node) bool {
+	if q.Name != "" && node.Name != q.Name {
+		return false
+	}
+	for key, value := range q.Traits {
+		if v, ok := node.Traits[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the matching nodes in the stored order, for unpaged queries.
+func (q *NodeQuery) filter(nodes []graph.Node) []graph.Node {
+	matched := make([]graph.Node, 0, len(nodes))
+	for i := range nodes {
+		if q.matches(&nodes[i]) {
+			matched = append(matched, nodes[i])
+		}
+	}
+	return matched
+}
+
+// page returns the matching nodes of the requested page and the token of the next
+// page, empty on the last one. scan walks the nodes after an ID in ID order; it is
+// stopped as soon as the node following the page is found.
+func (q *NodeQuery) page(scan func(after string, fn func(node graph.Node) error) error) ([]graph.Node, string, error) {
+	limit := cmp.Or(q.Limit, MaxPageSize)
+	page := make([]graph.Node, 0)
+	more := false
+	err := scan(q.PageToken, func(node graph.Node) error {
+		if !q.matches(&node) {
+			return nil
+		}
+		if len(page) == limit {
+			more = true
+			return errPageFull
+		}
+		page = append(page, node)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		return nil, "", err
+	}
+	if !more {
+		return page, "", nil
+	}
+	return page, page[limit-1].ID, nil
+}
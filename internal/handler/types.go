@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zmjung/jamesdb/internal/grapher"
+	"github.com/zmjung/jamesdb/internal/log"
+)
+
+type RenameTypeRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+func (gh *GraphHandler) GetNodeTypes(c *gin.Context) {
+	// This function lists every stored node type with its node count and file size.
+	ctx := log.ConvertContext(c)
+	if explainRequested(c) {
+		c.JSON(200, &Plan{Steps: []string{
+			fmt.Sprintf("list the node types stored by the %s engine", gh.Engine),
+			"read every node of each type to count them, from the node cache when the type is cached",
+			"ask the engine for the stored size of each type",
+		}})
+		return
+	}
+
+	types, err := gh.Grapher.ListTypes(ctx)
+	if err != nil {
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to list node types: %v", err)})
+		return
+	}
+	c.JSON(200, types)
+}
+
+func (gh *GraphHandler) GetNodeType(c *gin.Context) {
+	// This function describes a single node type.
+	ctx := log.ConvertContext(c)
+	nodeType := c.Param("type")
+
+	info, err := gh.Grapher.DescribeType(ctx, nodeType)
+	if errors.Is(err, grapher.ErrInvalidType) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, grapher.ErrTypeNotFound) {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Node type %s not found", nodeType)})
+		return
+	}
+	if err != nil {
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to describe node type %s: %v", nodeType, err)})
+		return
+	}
+	c.JSON(200, info)
+}
+
+func (gh *GraphHandler) RenameNodeType(c *gin.Context) {
+	// This function renames a node type, moving all of its nodes.
+	ctx := log.ConvertContext(c)
+	nodeType := c.Param("type")
+
+	request := &RenameTypeRequest{}
+	if err := c.ShouldBindJSON(request); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	err := gh.Grapher.RenameType(ctx, nodeType, request.Name)
+	switch {
+	case errors.Is(err, grapher.ErrInvalidType):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrTypeNotFound):
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Node type %s not found", nodeType)})
+		return
+	case errors.Is(err, grapher.ErrTypeExists):
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Node type %s already exists", request.Name)})
+		return
+	case err != nil:
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to rename node type %s: %v", nodeType, err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Node type renamed successfully", "type": request.Name})
+}
+
+func (gh *GraphHandler) DeleteNodeType(c *gin.Context) {
+	// This function drops a node type and all of its nodes.
+	ctx := log.ConvertContext(c)
+	nodeType := c.Param("type")
+
+	err := gh.Grapher.DropType(ctx, nodeType)
+	switch {
+	case errors.Is(err, grapher.ErrInvalidType):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, grapher.ErrTypeNotFound):
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Node type %s not found", nodeType)})
+		return
+	case errors.Is(err, grapher.ErrNodeReferenced):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(serverErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to drop node type %s: %v", nodeType, err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Node type dropped successfully", "type": nodeType})
+}
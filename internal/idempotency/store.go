@@ -0,0 +1,223 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+const (
+	DefaultWindow = 24 * time.Hour
+
+	recordExt = ".json"
+)
+
+// Record is the response stored for an idempotency key.
+type Record struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// RequestHash identifies the query and body of the request, so a key reused for
+	// another payload is told apart from a retry.
+	RequestHash string    `json:"requestHash"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"contentType"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Store keeps one file per idempotency key under the database root path.
+// Records older than the window are ignored and purged lazily.
+type Store struct {
+	f          disk.FileAccessor
+	folderPath string
+	window     time.Duration
+
+	inFlightLock sync.Mutex
+	inFlight     map[string]chan struct{}
+
+	purgeLock sync.Mutex
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+// NewStore opens the records under rootPath, sealing them with the keys when the
+// database is encrypted, as the responses they hold carry node data.
+func NewStore(f disk.FileAccessor, rootPath string, window time.Duration, keys *disk.Keyring) (*Store, error) {
+	folderPath, err := f.AddFolder(rootPath, "idempotency")
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		f = disk.NewBlockFileAccessor(f, folderPath, recordExt, disk.BlockFormat{Keys: keys})
+	}
+	if window <= 0 {
+		window = DefaultWindow
+	}
+
+	s := &Store{
+		f:          f,
+		folderPath: folderPath,
+		window:     window,
+		inFlight:   make(map[string]chan struct{}),
+		now:        time.Now,
+	}
+	s.Purge()
+	return s, nil
+}
+
+// Begin marks the key as in flight until done is called. A retry arriving while the
+// original request is still running waits for it to finish, so it replays its
+// response instead of writing again; requests with other keys never wait. It fails
+// when ctx is done first.
+func (s *Store) Begin(ctx context.Context, key string) (done func(), err error) {
+	s.inFlightLock.Lock()
+	for {
+		running, busy := s.inFlight[key]
+		if !busy {
+			break
+		}
+		s.inFlightLock.Unlock()
+		select {
+		case <-running:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.inFlightLock.Lock()
+	}
+
+	finished := make(chan struct{})
+	s.inFlight[key] = finished
+	s.inFlightLock.Unlock()
+
+	return func() {
+		s.inFlightLock.Lock()
+		delete(s.inFlight, key)
+		s.inFlightLock.Unlock()
+		close(finished)
+	}, nil
+}
+
+// HashRequest returns the RequestHash of a request with the given query and body.
+func HashRequest(query string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(query))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the record stored for the key, or nil if there is none within the window.
+func (s *Store) Get(key string) (*Record, error) {
+	record, err := s.readRecord(s.filePath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.isExpired(record) {
+		return nil, nil
+	}
+	return record, nil
+}
+
+func (s *Store) Save(key string, record *Record) error {
+	record.CreatedAt = s.now()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	filePath := s.filePath(key)
+	tmpPath := filePath + ".tmp"
+	writer, err := s.f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.f.RenameFile(tmpPath, filePath); err != nil {
+		return err
+	}
+
+	s.purgeIfDue()
+	return nil
+}
+
+// Purge removes every record older than the window.
+func (s *Store) Purge() {
+	s.purgeLock.Lock()
+	defer s.purgeLock.Unlock()
+	s.lastPurge = s.now()
+
+	names, err := s.f.ListFiles(s.folderPath, recordExt)
+	if err != nil {
+		slog.Error("Error listing idempotency records", "error", err)
+		return
+	}
+
+	for _, name := range names {
+		filePath := s.f.GetFilePath(s.folderPath, name+recordExt)
+		if s.isFileExpired(filePath) {
+			if err := s.f.RemoveFile(filePath); err != nil {
+				slog.Error("Error removing idempotency record", "filePath", filePath, "error", err)
+			}
+		}
+	}
+}
+
+func (s *Store) purgeIfDue() {
+	s.purgeLock.Lock()
+	due := s.now().Sub(s.lastPurge) > s.window
+	s.purgeLock.Unlock()
+
+	if due {
+		s.Purge()
+	}
+}
+
+func (s *Store) readRecord(filePath string) (*Record, error) {
+	reader, err := s.f.GetFileReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	record := &Record{}
+	if err := json.NewDecoder(reader).Decode(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *Store) isFileExpired(filePath string) bool {
+	record, err := s.readRecord(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	// Unreadable records can never be replayed, so drop them too.
+	return err != nil || s.isExpired(record)
+}
+
+func (s *Store) isExpired(record *Record) bool {
+	return s.now().Sub(record.CreatedAt) > s.window
+}
+
+func (s *Store) filePath(key string) string {
+	// Keys are client supplied, so never use them as file names directly.
+	sum := sha256.Sum256([]byte(key))
+	return s.f.GetFilePath(s.folderPath, hex.EncodeToString(sum[:])+recordExt)
+}
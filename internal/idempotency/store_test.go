@@ -0,0 +1,108 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+func TestStoreReplaysWithinWindow(t *testing.T) {
+	s, err := NewStore(disk.NewFileAccessor(), t.TempDir(), time.Hour, nil)
+	require.NoError(t, err)
+
+	record, err := s.Get("key")
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	err = s.Save("key", &Record{Method: "POST", Path: "/api/v1/graph/node", Status: 200, Body: []byte(`{"id":"1"}`)})
+	require.NoError(t, err)
+
+	record, err = s.Get("key")
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	require.Equal(t, 200, record.Status)
+	require.Equal(t, `{"id":"1"}`, string(record.Body))
+}
+
+func TestSealedStore(t *testing.T) {
+	dir := t.TempDir()
+	key := sha256.Sum256([]byte("idempotency"))
+	keys, err := disk.LoadKeyring(base64.StdEncoding.EncodeToString(key[:]), "")
+	require.NoError(t, err)
+	s, err := NewStore(disk.NewFileAccessor(), dir, time.Hour, keys)
+	require.NoError(t, err)
+
+	body := `{"id":"1","type":"person","traits":{"ssn":"123-45-6789"}}`
+	require.NoError(t, s.Save("key", &Record{Method: "POST", Path: "/api/v1/graph/node", Status: 200, Body: []byte(body)}))
+	data, err := os.ReadFile(s.filePath("key"))
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "123-45-6789")
+
+	reopened, err := NewStore(disk.NewFileAccessor(), dir, time.Hour, keys)
+	require.NoError(t, err)
+	record, err := reopened.Get("key")
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	require.Equal(t, body, string(record.Body))
+}
+
+func TestStoreExpiresRecords(t *testing.T) {
+	s, err := NewStore(disk.NewFileAccessor(), t.TempDir(), time.Hour, nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	require.NoError(t, s.Save("key", &Record{Method: "POST", Path: "/", Status: 200}))
+
+	s.now = func() time.Time { return now.Add(2 * time.Hour) }
+	record, err := s.Get("key")
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	s.Purge()
+	names, err := s.f.ListFiles(s.folderPath, recordExt)
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
+
+func TestBeginWaitsForSameKey(t *testing.T) {
+	s, err := NewStore(disk.NewFileAccessor(), t.TempDir(), time.Hour, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	done, err := s.Begin(ctx, "key")
+	require.NoError(t, err)
+
+	// Other keys go ahead while the key is in flight.
+	other, err := s.Begin(ctx, "other")
+	require.NoError(t, err)
+	other()
+
+	// A retry gives up when its context ends first.
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = s.Begin(timeout, "key")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	started := make(chan struct{})
+	go func() {
+		retry, err := s.Begin(ctx, "key")
+		if err == nil {
+			retry()
+		}
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("retry must wait for the request in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+	done()
+	<-started
+}
@@ -0,0 +1,48 @@
+// Package instance opens the storage and grapher of a database. The server and the
+// named databases use it directly; package jamesdb wraps it in its public API.
+package instance
+
+import (
+	"errors"
+
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/grapher"
+	"github.com/zmjung/jamesdb/internal/storage"
+)
+
+// Instance is an open database with the parts the server needs.
+type Instance struct {
+	Config  *config.Config
+	Handles *disk.HandleCache
+	Grapher grapher.Grapher
+	// Keys seal the data stored beside the engine; nil when the database is not encrypted.
+	Keys *disk.Keyring
+}
+
+// Open opens the database of a configuration.
+func Open(cfg *config.Config) (*Instance, error) {
+	files, err := disk.NewFileAccessorWithDurability(cfg.Database.Durability, cfg.Database.SyncInterval)
+	if err != nil {
+		return nil, err
+	}
+	handles := disk.NewHandleCache(files, cfg.Database.MaxOpenFiles)
+	engine, err := storage.Open(cfg, handles)
+	if err != nil {
+		return nil, errors.Join(err, handles.Close())
+	}
+	keys, err := disk.LoadKeyring(cfg.Database.EncryptionKey, cfg.Database.EncryptionKeyFile)
+	if err != nil {
+		return nil, errors.Join(err, engine.Close(), handles.Close())
+	}
+	g, err := grapher.New(cfg, engine)
+	if err != nil {
+		return nil, errors.Join(err, engine.Close(), handles.Close())
+	}
+	return &Instance{Config: cfg, Handles: handles, Grapher: g, Keys: keys}, nil
+}
+
+// Close commits pending writes and closes the files of the database.
+func (i *Instance) Close() error {
+	return errors.Join(i.Grapher.Close(), i.Handles.Close())
+}
@@ -1,10 +1,16 @@
 package middleware
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zmjung/jamesdb/internal/database"
+	"github.com/zmjung/jamesdb/internal/idempotency"
 	"github.com/zmjung/jamesdb/internal/log"
 )
 
@@ -28,3 +34,148 @@ func GetRecovery() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// maxIdempotencyKeyLength bounds client supplied keys.
+	maxIdempotencyKeyLength = 255
+	// maxRecordedBody bounds the responses stored for replay. Larger ones, like backup
+	// archives, are not stored, so a retry runs the request again.
+	maxRecordedBody = 1 << 20
+)
+
+type recordingWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *recordingWriter) record(size int) bool {
+	if w.body.Len()+size > maxRecordedBody {
+		w.overflow = true
+		w.body.Reset()
+	}
+	return !w.overflow
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if w.record(len(data)) {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	if w.record(len(s)) {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// GetIdempotency replays the stored response of a mutating request when its
+// Idempotency-Key header was already seen, instead of running the handler again.
+func GetIdempotency(store *idempotency.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Idempotency key is too long"})
+			return
+		}
+
+		ctx := log.ConvertContext(c)
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		requestHash := idempotency.HashRequest(c.Request.URL.RawQuery, body)
+
+		done, err := store.Begin(c.Request.Context(), key)
+		if err != nil {
+			c.AbortWithStatusJSON(503, gin.H{"error": "Gave up waiting for the request with the same idempotency key"})
+			return
+		}
+		defer done()
+
+		record, err := store.Get(key)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error reading idempotency record", "error", err)
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to read idempotency record"})
+			return
+		}
+		if record != nil {
+			if record.Method != c.Request.Method || record.Path != c.Request.URL.Path || record.RequestHash != requestHash {
+				c.AbortWithStatusJSON(422, gin.H{"error": "Idempotency key was already used for a different request"})
+				return
+			}
+			slog.InfoContext(ctx, "Replaying idempotent response", "status", record.Status)
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.Status, record.ContentType, record.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// Server errors are not stored so a retry can still succeed.
+		if writer.Status() >= 500 || writer.overflow {
+			return
+		}
+		err = store.Save(key, &idempotency.Record{
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			RequestHash: requestHash,
+			Status:      writer.Status(),
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error saving idempotency record", "error", err)
+		}
+	}
+}
+
+// DatabaseKey is the context key of the named database a request is routed to.
+const DatabaseKey = "database"
+
+// GetDatabase resolves the named database of the request, which cannot be dropped
+// until the request completes.
+func GetDatabase(databases *database.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		db, release, err := databases.Acquire(name)
+		if err != nil {
+			c.AbortWithStatusJSON(404, gin.H{"error": fmt.Sprintf("Database %s not found", name)})
+			return
+		}
+		defer release()
+
+		c.Set(DatabaseKey, db)
+		c.Next()
+	}
+}
+
+// GetDatabaseIdempotency replays responses from the idempotency records of the named
+// database resolved by GetDatabase.
+func GetDatabaseIdempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet(DatabaseKey).(*database.Database)
+		GetIdempotency(db.Store)(c)
+	}
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
@@ -2,16 +2,33 @@ package router
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/zmjung/jamesdb/internal/database"
 	"github.com/zmjung/jamesdb/internal/handler"
+	"github.com/zmjung/jamesdb/internal/idempotency"
+	"github.com/zmjung/jamesdb/internal/middleware"
 )
 
 type Router struct {
-	GraphHandler *handler.GraphHandler
+	GraphHandler     *handler.GraphHandler
+	AdminHandler     *handler.AdminHandler
+	DatabaseHandler  *handler.DatabaseHandler
+	IdempotencyStore *idempotency.Store
 }
 
-func NewRouter(gh *handler.GraphHandler) *Router {
+func NewRouter(gh *handler.GraphHandler, ah *handler.AdminHandler, dh *handler.DatabaseHandler, store *idempotency.Store) *Router {
 	return &Router{
-		GraphHandler: gh,
+		GraphHandler:     gh,
+		AdminHandler:     ah,
+		DatabaseHandler:  dh,
+		IdempotencyStore: store,
+	}
+}
+
+// inDatabase serves a graph route from the named database resolved by middleware.GetDatabase.
+func inDatabase(handle func(gh *handler.GraphHandler, c *gin.Context)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet(middleware.DatabaseKey).(*database.Database)
+		handle(handler.NewGraphHandler(db.DB.Config, db.DB.Grapher), c)
 	}
 }
 
@@ -22,10 +39,58 @@ func (r *Router) SetupRoutes(engine *gin.Engine) {
 	}
 
 	graphRouter := engine.Group("/api/v1/graph")
+	graphRouter.Use(middleware.GetIdempotency(r.IdempotencyStore))
 	{
 		graphRouter.GET("/node/:type", r.GraphHandler.GetGraphNodes)
-		// readRouter.GET("/node/:nodeid", handler.GetGraphNode)
+		graphRouter.GET("/node/:type/:id", r.GraphHandler.GetGraphNode)
+		graphRouter.GET("/node/:type/:id/edges", r.GraphHandler.GetGraphNodeEdges)
 
 		graphRouter.POST("/node", r.GraphHandler.CreateGraphNode)
+		graphRouter.POST("/nodes:action", r.GraphHandler.PostGraphNodes)
+		graphRouter.PUT("/node/:type/:id", r.GraphHandler.UpdateGraphNode)
+		graphRouter.DELETE("/node/:type/:id", r.GraphHandler.DeleteGraphNode)
+		graphRouter.POST("/tx", r.GraphHandler.CommitTransaction)
+
+		graphRouter.GET("/types", r.GraphHandler.GetNodeTypes)
+		graphRouter.GET("/types/:type", r.GraphHandler.GetNodeType)
+		graphRouter.POST("/types/:type/rename", r.GraphHandler.RenameNodeType)
+		graphRouter.DELETE("/types/:type", r.GraphHandler.DeleteNodeType)
+	}
+
+	dbRouter := engine.Group("/api/v1/db")
+	dbRouter.Use(middleware.GetIdempotency(r.IdempotencyStore))
+	{
+		dbRouter.GET("", r.DatabaseHandler.GetDatabases)
+		dbRouter.GET("/:name", r.DatabaseHandler.GetDatabase)
+		dbRouter.POST("/:name", r.DatabaseHandler.CreateDatabase)
+		dbRouter.DELETE("/:name", r.DatabaseHandler.DropDatabase)
+	}
+
+	// Every named database serves the graph routes of its own data.
+	namedRouter := engine.Group("/api/v1/db/:name/graph")
+	namedRouter.Use(middleware.GetDatabase(r.DatabaseHandler.Databases), middleware.GetDatabaseIdempotency())
+	{
+		namedRouter.GET("/node/:type", inDatabase((*handler.GraphHandler).GetGraphNodes))
+		namedRouter.GET("/node/:type/:id", inDatabase((*handler.GraphHandler).GetGraphNode))
+		namedRouter.GET("/node/:type/:id/edges", inDatabase((*handler.GraphHandler).GetGraphNodeEdges))
+
+		namedRouter.POST("/node", inDatabase((*handler.GraphHandler).CreateGraphNode))
+		namedRouter.POST("/nodes:action", inDatabase((*handler.GraphHandler).PostGraphNodes))
+		namedRouter.PUT("/node/:type/:id", inDatabase((*handler.GraphHandler).UpdateGraphNode))
+		namedRouter.DELETE("/node/:type/:id", inDatabase((*handler.GraphHandler).DeleteGraphNode))
+		namedRouter.POST("/tx", inDatabase((*handler.GraphHandler).CommitTransaction))
+
+		namedRouter.GET("/types", inDatabase((*handler.GraphHandler).GetNodeTypes))
+		namedRouter.GET("/types/:type", inDatabase((*handler.GraphHandler).GetNodeType))
+		namedRouter.POST("/types/:type/rename", inDatabase((*handler.GraphHandler).RenameNodeType))
+		namedRouter.DELETE("/types/:type", inDatabase((*handler.GraphHandler).DeleteNodeType))
+	}
+
+	adminRouter := engine.Group("/api/v1/admin")
+	adminRouter.Use(middleware.GetIdempotency(r.IdempotencyStore))
+	{
+		adminRouter.GET("/stats", r.AdminHandler.GetStats)
+		adminRouter.POST("/fsck", r.AdminHandler.PostFsck)
+		adminRouter.POST("/backup", r.AdminHandler.PostBackup)
 	}
 }
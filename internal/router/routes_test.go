@@ -0,0 +1,241 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/internal/backup"
+	"github.com/zmjung/jamesdb/internal/database"
+	"github.com/zmjung/jamesdb/internal/handler"
+	"github.com/zmjung/jamesdb/internal/idempotency"
+	"github.com/zmjung/jamesdb/internal/instance"
+	"github.com/zmjung/jamesdb/internal/middleware"
+)
+
+// newTestEngine sets up the routes over a database in a temporary folder, after
+// applying the given changes to its configuration.
+func newTestEngine(t *testing.T, configure ...func(cfg *config.Config)) *gin.Engine {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+	cfg.Server.IdempotencyWindow = time.Hour
+	for _, change := range configure {
+		change(cfg)
+	}
+
+	db, err := instance.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := idempotency.NewStore(db.Handles, cfg.Database.RootPath, cfg.Server.IdempotencyWindow, db.Keys)
+	require.NoError(t, err)
+	databases, err := database.NewManager(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { databases.Close() })
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	NewRouter(
+		handler.NewGraphHandler(cfg, db.Grapher),
+		handler.NewAdminHandler(db.Handles, db.Grapher),
+		handler.NewDatabaseHandler(databases),
+		store,
+	).SetupRoutes(engine)
+	return engine
+}
+
+// serve sends a request with an optional JSON body; headers come in name, value pairs.
+func serve(engine *gin.Engine, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyKeyOnEveryMutatingRoute(t *testing.T) {
+	engine := newTestEngine(t)
+	key := func(k string) []string { return []string{middleware.IdempotencyKeyHeader, k} }
+
+	first := serve(engine, http.MethodPost, "/api/v1/graph/node", `{"type":"person","name":"alice"}`, key("create")...)
+	require.Equal(t, 200, first.Code)
+	retry := serve(engine, http.MethodPost, "/api/v1/graph/node", `{"type":"person","name":"alice"}`, key("create")...)
+	require.Equal(t, 200, retry.Code)
+	require.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	require.Equal(t, first.Body.String(), retry.Body.String())
+
+	// The same key with another payload is rejected rather than replayed.
+	other := serve(engine, http.MethodPost, "/api/v1/graph/node", `{"type":"person","name":"bob"}`, key("create")...)
+	require.Equal(t, 422, other.Code)
+
+	// Named databases and admin routes are covered too.
+	require.Equal(t, 200, serve(engine, http.MethodPost, "/api/v1/db/sales", "", key("db")...).Code)
+	retry = serve(engine, http.MethodPost, "/api/v1/db/sales", "", key("db")...)
+	require.Equal(t, 200, retry.Code)
+	require.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+
+	require.Equal(t, 200, serve(engine, http.MethodPost, "/api/v1/admin/fsck", "", key("fsck")...).Code)
+	require.Equal(t, "true", serve(engine, http.MethodPost, "/api/v1/admin/fsck", "", key("fsck")...).Header().Get("Idempotent-Replayed"))
+	require.Equal(t, 422, serve(engine, http.MethodPost, "/api/v1/admin/fsck?quarantine=true", "", key("fsck")...).Code)
+}
+
+func TestBatchAction(t *testing.T) {
+	engine := newTestEngine(t, func(cfg *config.Config) { cfg.Database.Integrity = "restrict" })
+
+	w := serve(engine, http.MethodPost, "/api/v1/graph/nodes:batch", `{"nodes":[
+		{"type":"person","name":"alice"},
+		{"type":"person"},
+		{"type":"pet","name":"rex","edges":["missing"]}
+	]}`)
+	require.Equal(t, 200, w.Code)
+	var response struct {
+		Results []handler.BatchResult `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Results, 3)
+	require.Equal(t, 200, response.Results[0].Status)
+	require.NotEmpty(t, response.Results[0].Node.ID)
+	require.Equal(t, int64(1), response.Results[0].Node.Version)
+	require.Equal(t, 400, response.Results[1].Status)
+	require.Equal(t, 422, response.Results[2].Status)
+
+	w = serve(engine, http.MethodGet, "/api/v1/graph/node/person", "")
+	require.Equal(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), `"alice"`)
+	w = serve(engine, http.MethodGet, "/api/v1/graph/node/pet", "")
+	require.Equal(t, "[]", w.Body.String())
+}
+
+func TestInvalidNodeType(t *testing.T) {
+	engine := newTestEngine(t)
+
+	w := serve(engine, http.MethodPost, "/api/v1/graph/node", `{"type":"../x","name":"x"}`)
+	require.Equal(t, 400, w.Code)
+	require.Contains(t, w.Body.String(), "invalid node type")
+	w = serve(engine, http.MethodPost, "/api/v1/graph/nodes:batch", `{"nodes":[{"type":"../x","name":"x"},{"type":"person","name":"alice"}]}`)
+	require.Equal(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), `"status":400`)
+	require.Contains(t, w.Body.String(), `"status":200`)
+	require.Equal(t, 400, serve(engine, http.MethodPut, "/api/v1/graph/node/..x/a", `{"type":"..x","name":"x"}`).Code)
+	require.Equal(t, 400, serve(engine, http.MethodGet, "/api/v1/graph/node/..x", "").Code)
+}
+
+func TestExplainedReads(t *testing.T) {
+	engine := newTestEngine(t, func(cfg *config.Config) {
+		cfg.Database.Engine = "lsm"
+		cfg.Database.CacheSize = 1 << 20
+	})
+	require.Equal(t, 200, serve(engine, http.MethodPost, "/api/v1/graph/node", `{"type":"person","name":"alice"}`).Code)
+	plan := func(path string) []string {
+		w := serve(engine, http.MethodGet, path, "")
+		require.Equal(t, 200, w.Code, path)
+		var p handler.Plan
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		return p.Steps
+	}
+
+	// Nothing is read, so the type stays uncached until a listing reads it whole.
+	require.Equal(t, []string{
+		`scan the keys of type "person" in ID order after ID "a", reading each node as the scan reaches it`,
+		`keep the nodes matching name = "alice" and trait.team = "db"`,
+		"stop after 10 matching nodes, returning the last ID as the next page token when more follow",
+	}, plan("/api/v1/graph/node/person?explain=true&limit=10&pageToken=a&name=alice&trait=team:db"))
+	require.Equal(t, []string{
+		`read every node of type "person" from the lsm engine in stored order and cache them if they fit`,
+		"return every matching node in one response",
+	}, plan("/api/v1/graph/node/person?explain=true"))
+
+	require.Equal(t, 200, serve(engine, http.MethodGet, "/api/v1/graph/node/person", "").Code)
+	require.Equal(t, []string{
+		`sort the cached nodes of type "person" by ID and scan them from the first ID`,
+		"stop after 5 matching nodes, returning the last ID as the next page token when more follow",
+	}, plan("/api/v1/graph/node/person?explain=true&limit=5"))
+	require.Equal(t, []string{`look node "x" up in the cached nodes of type "person"`}, plan("/api/v1/graph/node/person/x?explain=true"))
+	require.Len(t, plan("/api/v1/graph/node/person/x/edges?explain=true"), 3)
+	require.Contains(t, plan("/api/v1/graph/types?explain=true")[0], "lsm engine")
+}
+
+func TestPostBackup(t *testing.T) {
+	engine := newTestEngine(t)
+	require.Equal(t, 200, serve(engine, http.MethodPost, "/api/v1/graph/node", `{"type":"person","name":"alice"}`).Code)
+	require.Equal(t, 200, serve(engine, http.MethodPost, "/api/v1/graph/node", `{"type":"pet","name":"rex"}`).Code)
+
+	w := serve(engine, http.MethodPost, "/api/v1/admin/backup", "")
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Header().Get("Content-Disposition"), ".tar.gz")
+
+	manifest, err := backup.VerifyArchive(w.Body)
+	require.NoError(t, err)
+	require.Len(t, manifest.Types, 2)
+	for _, entry := range manifest.Types {
+		require.Equal(t, 1, entry.Nodes)
+	}
+}
+
+func TestBatchActionErrors(t *testing.T) {
+	engine := newTestEngine(t)
+
+	require.Equal(t, 400, serve(engine, http.MethodPost, "/api/v1/graph/nodes:batch", `{"nodes":`).Code)
+	require.Equal(t, 404, serve(engine, http.MethodPost, "/api/v1/graph/nodes:purge", `{}`).Code)
+
+	nodes := make([]string, handler.MaxBatchSize+1)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf(`{"type":"person","name":"p%d"}`, i)
+	}
+	w := serve(engine, http.MethodPost, "/api/v1/graph/nodes:batch", `{"nodes":[`+strings.Join(nodes, ",")+`]}`)
+	require.Equal(t, 413, w.Code)
+
+	// The named database routes dispatch the same actions.
+	require.Equal(t, 200, serve(engine, http.MethodPost, "/api/v1/db/sales", "").Code)
+	w = serve(engine, http.MethodPost, "/api/v1/db/sales/graph/nodes:batch", `{"nodes":[{"type":"person","name":"alice"}]}`)
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, 404, serve(engine, http.MethodPost, "/api/v1/db/sales/graph/nodes:purge", `{}`).Code)
+	require.Equal(t, 404, serve(engine, http.MethodPost, "/api/v1/db/missing/graph/nodes:batch", `{"nodes":[]}`).Code)
+}
+
+func TestNamedDatabaseRoutes(t *testing.T) {
+	engine := newTestEngine(t)
+	require.Equal(t, 200, serve(engine, http.MethodPost, "/api/v1/db/sales", "").Code)
+
+	w := serve(engine, http.MethodPost, "/api/v1/db/sales/graph/node", `{"type":"person","name":"alice"}`)
+	require.Equal(t, 200, w.Code)
+	var created struct {
+		Node struct {
+			ID string `json:"id"`
+		} `json:"node"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	require.NotEmpty(t, created.Node.ID)
+
+	// The node is stored in the named database only.
+	w = serve(engine, http.MethodGet, "/api/v1/db/sales/graph/node/person/"+created.Node.ID, "")
+	require.Equal(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), `"alice"`)
+	w = serve(engine, http.MethodGet, "/api/v1/db/sales/graph/node/person", "")
+	require.Equal(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), `"alice"`)
+	require.Contains(t, serve(engine, http.MethodGet, "/api/v1/db/sales/graph/types", "").Body.String(), "person")
+	require.Equal(t, 404, serve(engine, http.MethodGet, "/api/v1/graph/node/person/"+created.Node.ID, "").Code)
+
+	w = serve(engine, http.MethodPut, "/api/v1/db/sales/graph/node/person/"+created.Node.ID, `{"type":"person","name":"alice smith"}`)
+	require.Equal(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), `"alice smith"`)
+	require.Equal(t, 200, serve(engine, http.MethodDelete, "/api/v1/db/sales/graph/node/person/"+created.Node.ID, "").Code)
+	require.Equal(t, 404, serve(engine, http.MethodGet, "/api/v1/db/sales/graph/node/person/"+created.Node.ID, "").Code)
+
+	// A missing or dropped database is not found.
+	require.Equal(t, 404, serve(engine, http.MethodGet, "/api/v1/db/missing/graph/types", "").Code)
+	require.Equal(t, 200, serve(engine, http.MethodDelete, "/api/v1/db/sales", "").Code)
+	require.Equal(t, 404, serve(engine, http.MethodGet, "/api/v1/db/sales/graph/node/person", "").Code)
+}
@@ -0,0 +1,391 @@
+// Package rpcserver serves the gRPC API described by package rpc over a grapher.
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/grapher"
+	"github.com/zmjung/jamesdb/internal/log"
+	"github.com/zmjung/jamesdb/internal/storage"
+	"github.com/zmjung/jamesdb/internal/uuid"
+	"github.com/zmjung/jamesdb/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ImportBatchSize is the number of imported nodes committed together.
+const ImportBatchSize = 1000
+
+// maxEdgeRetries bounds the attempts of an edge change without an expected version,
+// which starts over whenever the node is updated meanwhile.
+const maxEdgeRetries = 10
+
+type GraphServer struct {
+	rpc.UnimplementedGraphServer
+	Grapher grapher.Grapher
+}
+
+func NewGraphServer(g grapher.Grapher) *GraphServer {
+	return &GraphServer{Grapher: g}
+}
+
+// NewServer creates a gRPC server for the graph service over the grapher, logging
+// every call like the REST routes do.
+func NewServer(g grapher.Grapher, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryLogging),
+		grpc.ChainStreamInterceptor(streamLogging),
+	}, opts...)
+	server := grpc.NewServer(opts...)
+	rpc.RegisterGraphServer(server, NewGraphServer(g))
+	return server
+}
+
+// nodeMessage converts a stored node to its message.
+func nodeMessage(node *graph.Node) *rpc.Node {
+	return &rpc.Node{Id: node.ID, Type: node.Type, Name: node.Name, Edges: node.Edges, Traits: node.Traits, Version: node.Version}
+}
+
+func nodeMessages(nodes []graph.Node) []*rpc.Node {
+	messages := make([]*rpc.Node, len(nodes))
+	for i := range nodes {
+		messages[i] = nodeMessage(&nodes[i])
+	}
+	return messages
+}
+
+// graphNode converts a node message to the node to store.
+func graphNode(node *rpc.Node) graph.Node {
+	return graph.Node{
+		ID:      node.GetId(),
+		Type:    node.GetType(),
+		Name:    node.GetName(),
+		Edges:   node.GetEdges(),
+		Traits:  node.GetTraits(),
+		Version: node.GetVersion(),
+	}
+}
+
+func (gs *GraphServer) GetNode(ctx context.Context, req *rpc.NodeRequest) (*rpc.Node, error) {
+	node, err := gs.Grapher.ReadNode(ctx, req.Type, req.Id)
+	if err != nil {
+		return nil, statusError(err, "Failed to retrieve node %s", req.Id)
+	}
+	return nodeMessage(node), nil
+}
+
+func (gs *GraphServer) CreateNode(ctx context.Context, req *rpc.Node) (*rpc.Node, error) {
+	node := graphNode(req)
+	if err := validateNode(&node); err != nil {
+		return nil, err
+	}
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to generate UUID")
+	}
+	node.ID = id
+
+	if err := gs.Grapher.WriteNode(ctx, &node); err != nil {
+		return nil, statusError(err, "Failed to write node data")
+	}
+	return nodeMessage(&node), nil
+}
+
+func (gs *GraphServer) UpdateNode(ctx context.Context, req *rpc.UpdateNodeRequest) (*rpc.Node, error) {
+	node := graphNode(req.Node)
+	if err := validateNode(&node); err != nil {
+		return nil, err
+	}
+	if err := gs.Grapher.UpdateNode(ctx, &node, req.ExpectedVersion); err != nil {
+		return nil, statusError(err, "Failed to update node %s", node.ID)
+	}
+	return nodeMessage(&node), nil
+}
+
+func (gs *GraphServer) DeleteNode(ctx context.Context, req *rpc.DeleteNodeRequest) (*rpc.DeleteNodeResponse, error) {
+	if err := gs.Grapher.DeleteNode(ctx, req.Type, req.Id, req.ExpectedVersion); err != nil {
+		return nil, statusError(err, "Failed to delete node %s", req.Id)
+	}
+	return &rpc.DeleteNodeResponse{}, nil
+}
+
+func (gs *GraphServer) GetEdges(ctx context.Context, req *rpc.NodeRequest) (*rpc.NodeList, error) {
+	nodes, err := gs.Grapher.ReadEdges(ctx, req.Type, req.Id)
+	if err != nil {
+		return nil, statusError(err, "Failed to retrieve edges of node %s", req.Id)
+	}
+	return &rpc.NodeList{Nodes: nodeMessages(nodes)}, nil
+}
+
+// AddEdge adds an edge to a node unless it already has it.
+func (gs *GraphServer) AddEdge(ctx context.Context, req *rpc.EdgeRequest) (*rpc.Node, error) {
+	return gs.modifyEdges(ctx, req, func(edges []string) []string {
+		if slices.Contains(edges, req.To) {
+			return nil
+		}
+		return append(edges, req.To)
+	})
+}
+
+// RemoveEdge removes an edge from a node if it has it.
+func (gs *GraphServer) RemoveEdge(ctx context.Context, req *rpc.EdgeRequest) (*rpc.Node, error) {
+	return gs.modifyEdges(ctx, req, func(edges []string) []string {
+		if !slices.Contains(edges, req.To) {
+			return nil
+		}
+		return slices.DeleteFunc(edges, func(edge string) bool { return edge == req.To })
+	})
+}
+
+// modifyEdges replaces the edges of a node with the ones returned by change, or
+// leaves it as it is when change returns nil. Without an expected version, a node
+// updated concurrently is read again and changed once more, up to maxEdgeRetries
+// times before the call is aborted.
+func (gs *GraphServer) modifyEdges(ctx context.Context, req *rpc.EdgeRequest, change func(edges []string) []string) (*rpc.Node, error) {
+	if req.To == "" {
+		return nil, status.Error(codes.InvalidArgument, "Edge target is required")
+	}
+
+	for attempt := 1; ; attempt++ {
+		node, err := gs.Grapher.ReadNode(ctx, req.Type, req.Id)
+		if err != nil {
+			return nil, statusError(err, "Failed to retrieve node %s", req.Id)
+		}
+		expectedVersion := req.ExpectedVersion
+		if expectedVersion == rpc.AnyVersion {
+			expectedVersion = node.Version
+		} else if node.Version != expectedVersion {
+			return nil, status.Errorf(codes.FailedPrecondition, "%v: node %s is at version %d", grapher.ErrVersionMismatch, node.ID, node.Version)
+		}
+
+		edges := change(slices.Clone(node.Edges))
+		if edges == nil {
+			return nodeMessage(node), nil
+		}
+		node.Edges = edges
+
+		err = gs.Grapher.UpdateNode(ctx, node, expectedVersion)
+		if errors.Is(err, grapher.ErrVersionMismatch) && req.ExpectedVersion == rpc.AnyVersion {
+			if attempt < maxEdgeRetries {
+				continue
+			}
+			return nil, status.Errorf(codes.Aborted, "Node %s changed %d times while its edges were set", req.Id, maxEdgeRetries)
+		}
+		if err != nil {
+			return nil, statusError(err, "Failed to update node %s", req.Id)
+		}
+		return nodeMessage(node), nil
+	}
+}
+
+// ListNodes streams the nodes of a type from the scan of its worker. The lsm engine
+// reads them as the stream advances, while the file engine and a cached type hold
+// every node of the type until the stream ends.
+func (gs *GraphServer) ListNodes(req *rpc.ListNodesRequest, stream grpc.ServerStreamingServer[rpc.Node]) error {
+	ctx := stream.Context()
+	err := gs.Grapher.ScanNodes(ctx, req.Type, func(node graph.Node) error {
+		return stream.Send(nodeMessage(&node))
+	})
+	if err != nil {
+		return statusError(err, "Failed to retrieve nodes of type %s", req.Type)
+	}
+	return nil
+}
+
+// ImportNodes stores the streamed nodes with their IDs, generating the missing ones.
+// The nodes are inserted without their edges, a transaction per batch, so a failing
+// node only rolls back its own batch. Once every node is stored, the edges are set
+// by updating the nodes at version 1, so they may point at nodes streamed later even
+// with an integrity mode. The error tells how many nodes were imported before it.
+func (gs *GraphServer) ImportNodes(stream grpc.ClientStreamingServer[rpc.Node, rpc.ImportNodesResponse]) error {
+	ctx := stream.Context()
+	imported := 0
+	// linked holds the nodes with edges, which are set after the import.
+	var linked []graph.Node
+	batch := make([]grapher.TxOp, 0, ImportBatchSize)
+
+	// commit applies the batch and counts its operations in done.
+	commit := func(done *int) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := gs.Grapher.Commit(ctx, batch); err != nil {
+			return err
+		}
+		*done += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		node := graphNode(msg)
+		if err := validateNode(&node); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid node %d after %d imported", imported+len(batch), imported)
+		}
+		if node.ID == "" {
+			if node.ID, err = uuid.GenerateUUID(); err != nil {
+				return status.Error(codes.Internal, "Failed to generate UUID")
+			}
+		}
+		if len(node.Edges) > 0 {
+			linked = append(linked, node)
+			node.Edges = nil
+		}
+
+		batch = append(batch, grapher.TxOp{Op: grapher.TxInsert, Node: node})
+		if len(batch) == ImportBatchSize {
+			if err := commit(&imported); err != nil {
+				return statusError(err, "Failed to import nodes after %d imported", imported)
+			}
+		}
+	}
+	if err := commit(&imported); err != nil {
+		return statusError(err, "Failed to import nodes after %d imported", imported)
+	}
+
+	linkedCount := 0
+	for _, node := range linked {
+		batch = append(batch, grapher.TxOp{Op: grapher.TxUpdate, Node: node, ExpectedVersion: 1})
+		if len(batch) == ImportBatchSize {
+			if err := commit(&linkedCount); err != nil {
+				return statusError(err, "Imported %d nodes, failed to set the edges of %d after %d", imported, len(linked), linkedCount)
+			}
+		}
+	}
+	if err := commit(&linkedCount); err != nil {
+		return statusError(err, "Imported %d nodes, failed to set the edges of %d after %d", imported, len(linked), linkedCount)
+	}
+	return stream.SendAndClose(&rpc.ImportNodesResponse{Imported: int64(imported)})
+}
+
+// WatchChanges streams the change log until the client goes away. A client falling
+// too far behind is disconnected, and may watch again from the last change it got.
+func (gs *GraphServer) WatchChanges(req *rpc.WatchChangesRequest, stream grpc.ServerStreamingServer[rpc.Change]) error {
+	ctx := stream.Context()
+	err := gs.Grapher.FollowChanges(ctx, req.After, func(c storage.Change) error {
+		return stream.Send(&rpc.Change{
+			Seq:   c.Seq,
+			Time:  timestamppb.New(c.Time),
+			Op:    c.Op,
+			Type:  c.Type,
+			Nodes: nodeMessages(c.Nodes),
+			Ids:   c.IDs,
+		})
+	})
+	if err != nil {
+		return statusError(err, "Failed to follow changes")
+	}
+	return nil
+}
+
+func validateNode(node *graph.Node) error {
+	if node.Type == "" || node.Name == "" {
+		return status.Error(codes.InvalidArgument, "Invalid input: type and name are required")
+	}
+	if err := grapher.ValidateNodeType(node.Type); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// statusError gives an error the gRPC code matching the REST status of the same
+// failure. Unexpected errors are described by the message.
+func statusError(err error, format string, args ...any) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var code codes.Code
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, grapher.ErrNodeNotFound):
+		code = codes.NotFound
+	case errors.Is(err, grapher.ErrVersionMismatch), errors.Is(err, grapher.ErrNodeReferenced):
+		code = codes.FailedPrecondition
+	case errors.Is(err, grapher.ErrNodeExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, grapher.ErrUnknownEdge), errors.Is(err, grapher.ErrInvalidTxOp), errors.Is(err, grapher.ErrInvalidType):
+		code = codes.InvalidArgument
+	case errors.Is(err, grapher.ErrNoChangeLog):
+		code = codes.FailedPrecondition
+	case errors.Is(err, storage.ErrFollowerLagged):
+		code = codes.ResourceExhausted
+	case errors.Is(err, storage.ErrChangesPruned):
+		code = codes.OutOfRange
+	case errors.Is(err, grapher.ErrTooManyWorkers), errors.Is(err, storage.ErrChangeLogClosed):
+		code = codes.Unavailable
+	default:
+		return status.Errorf(codes.Internal, "%s: %v", fmt.Sprintf(format, args...), err)
+	}
+	return status.Error(code, err.Error())
+}
+
+// requestContext tags the context of a call for the logger, as the REST routes do.
+func requestContext(ctx context.Context, method string) context.Context {
+	requestId, err := uuid.GenerateShortID()
+	if err != nil {
+		slog.Error("Error generating thread ID", "error", err)
+		requestId = "unknown"
+	}
+	return log.NewRequestContext(ctx, map[string]string{
+		"id":     requestId,
+		"method": method,
+	})
+}
+
+func unaryLogging(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res any, err error) {
+	start := time.Now()
+	ctx = requestContext(ctx, info.FullMethod)
+	slog.InfoContext(ctx, "Started request")
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "Recovered from panic", "panic", r)
+			err = status.Error(codes.Internal, "Internal server error")
+		}
+		slog.InfoContext(ctx, "Completed request", "time", time.Since(start), "code", status.Code(err).String())
+	}()
+	return handler(ctx, req)
+}
+
+// contextStream replaces the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func streamLogging(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	start := time.Now()
+	ctx := requestContext(stream.Context(), info.FullMethod)
+	slog.InfoContext(ctx, "Started request")
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "Recovered from panic", "panic", r)
+			err = status.Error(codes.Internal, "Internal server error")
+		}
+		slog.InfoContext(ctx, "Completed request", "time", time.Since(start), "code", status.Code(err).String())
+	}()
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
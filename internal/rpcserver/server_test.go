@@ -0,0 +1,263 @@
+package rpcserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/grapher"
+	"github.com/zmjung/jamesdb/internal/instance"
+	"github.com/zmjung/jamesdb/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestClient serves the graph service over a database in a temporary folder, after
+// applying the given changes to its configuration, and returns a client connected to
+// it in memory.
+func newTestClient(t *testing.T, configure ...func(cfg *config.Config)) rpc.GraphClient {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+	for _, change := range configure {
+		change(cfg)
+	}
+
+	db, err := instance.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewServer(db.Grapher)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return rpc.NewGraphClient(conn)
+}
+
+func withChangeLog(cfg *config.Config) {
+	cfg.Database.ChangeLog = true
+}
+
+func requireCode(t *testing.T, code codes.Code, err error) {
+	t.Helper()
+	require.Error(t, err)
+	require.Equal(t, code, status.Code(err), err.Error())
+}
+
+func TestNodeAndEdgeCrud(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	rex, err := client.CreateNode(ctx, &rpc.Node{Type: "pet", Name: "rex"})
+	require.NoError(t, err)
+	require.NotEmpty(t, rex.Id)
+	require.Equal(t, int64(1), rex.Version)
+	alice, err := client.CreateNode(ctx, &rpc.Node{Type: "person", Name: "alice", Traits: map[string]string{"team": "db"}})
+	require.NoError(t, err)
+
+	_, err = client.CreateNode(ctx, &rpc.Node{Type: "person"})
+	requireCode(t, codes.InvalidArgument, err)
+
+	stored, err := client.GetNode(ctx, &rpc.NodeRequest{Type: "person", Id: alice.Id})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(alice, stored), stored.String())
+	_, err = client.GetNode(ctx, &rpc.NodeRequest{Type: "person", Id: "missing"})
+	requireCode(t, codes.NotFound, err)
+
+	alice.Name = "alice smith"
+	updated, err := client.UpdateNode(ctx, &rpc.UpdateNodeRequest{Node: alice, ExpectedVersion: 1})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), updated.Version)
+	_, err = client.UpdateNode(ctx, &rpc.UpdateNodeRequest{Node: alice, ExpectedVersion: 1})
+	requireCode(t, codes.FailedPrecondition, err)
+
+	// Adding an edge twice or removing a missing one leaves the node as it is.
+	for range 2 {
+		updated, err = client.AddEdge(ctx, &rpc.EdgeRequest{Type: "person", Id: alice.Id, To: rex.Id})
+		require.NoError(t, err)
+		require.Equal(t, []string{rex.Id}, updated.Edges)
+		require.Equal(t, int64(3), updated.Version)
+	}
+	_, err = client.AddEdge(ctx, &rpc.EdgeRequest{Type: "person", Id: alice.Id, To: "other", ExpectedVersion: 2})
+	requireCode(t, codes.FailedPrecondition, err)
+
+	edges, err := client.GetEdges(ctx, &rpc.NodeRequest{Type: "person", Id: alice.Id})
+	require.NoError(t, err)
+	require.Len(t, edges.Nodes, 1)
+	require.Equal(t, "rex", edges.Nodes[0].Name)
+
+	updated, err = client.RemoveEdge(ctx, &rpc.EdgeRequest{Type: "person", Id: alice.Id, To: rex.Id, ExpectedVersion: 3})
+	require.NoError(t, err)
+	require.Empty(t, updated.Edges)
+	require.Equal(t, int64(4), updated.Version)
+	updated, err = client.RemoveEdge(ctx, &rpc.EdgeRequest{Type: "person", Id: alice.Id, To: rex.Id})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), updated.Version)
+
+	_, err = client.DeleteNode(ctx, &rpc.DeleteNodeRequest{Type: "person", Id: alice.Id, ExpectedVersion: 1})
+	requireCode(t, codes.FailedPrecondition, err)
+	_, err = client.DeleteNode(ctx, &rpc.DeleteNodeRequest{Type: "person", Id: alice.Id})
+	require.NoError(t, err)
+	_, err = client.GetNode(ctx, &rpc.NodeRequest{Type: "person", Id: alice.Id})
+	requireCode(t, codes.NotFound, err)
+}
+
+func TestImportAndListNodes(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	// More nodes than a batch, to commit several of them.
+	count := ImportBatchSize + 10
+	stream, err := client.ImportNodes(ctx)
+	require.NoError(t, err)
+	for i := range count {
+		require.NoError(t, stream.Send(&rpc.Node{Id: fmt.Sprintf("p%04d", i), Type: "person", Name: fmt.Sprint("person ", i)}))
+	}
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.Equal(t, int64(count), res.Imported)
+
+	list, err := client.ListNodes(ctx, &rpc.ListNodesRequest{Type: "person"})
+	require.NoError(t, err)
+	ids := make(map[string]bool)
+	for {
+		node, err := list.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		ids[node.Id] = true
+	}
+	require.Len(t, ids, count)
+	require.True(t, ids["p0000"])
+
+	// Importing a taken ID fails its batch.
+	stream, err = client.ImportNodes(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&rpc.Node{Type: "person", Name: "new"}))
+	require.NoError(t, stream.Send(&rpc.Node{Id: "p0000", Type: "person", Name: "taken"}))
+	_, err = stream.CloseAndRecv()
+	requireCode(t, codes.AlreadyExists, err)
+
+	list, err = client.ListNodes(ctx, &rpc.ListNodesRequest{Type: "unknown"})
+	require.NoError(t, err)
+	_, err = list.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestImportEdgesToLaterBatches(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, func(cfg *config.Config) { cfg.Database.Integrity = "restrict" })
+
+	// The first node points at the last one, which is committed in the next batch.
+	last := fmt.Sprintf("p%04d", ImportBatchSize)
+	stream, err := client.ImportNodes(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&rpc.Node{Id: "p0000", Type: "person", Name: "first", Edges: []string{last}}))
+	for i := 1; i <= ImportBatchSize; i++ {
+		require.NoError(t, stream.Send(&rpc.Node{Id: fmt.Sprintf("p%04d", i), Type: "person", Name: fmt.Sprint("person ", i)}))
+	}
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.Equal(t, int64(ImportBatchSize+1), res.Imported)
+
+	first, err := client.GetNode(ctx, &rpc.NodeRequest{Type: "person", Id: "p0000"})
+	require.NoError(t, err)
+	require.Equal(t, []string{last}, first.Edges)
+	require.Equal(t, int64(2), first.Version)
+
+	// Edges to nodes that were never streamed still fail.
+	stream, err = client.ImportNodes(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&rpc.Node{Type: "person", Name: "lost", Edges: []string{"missing"}}))
+	_, err = stream.CloseAndRecv()
+	requireCode(t, codes.InvalidArgument, err)
+}
+
+// conflictingGrapher fails every update as if the node had just changed.
+type conflictingGrapher struct {
+	grapher.Grapher
+	updates int
+}
+
+func (g *conflictingGrapher) UpdateNode(ctx context.Context, node *graph.Node, expectedVersion int64) error {
+	g.updates++
+	return grapher.ErrVersionMismatch
+}
+
+func TestAddEdgeRetriesAreBounded(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+	db, err := instance.Open(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	alice := &graph.Node{Type: "person", Name: "alice", ID: "a"}
+	require.NoError(t, db.Grapher.WriteNode(ctx, alice))
+
+	g := &conflictingGrapher{Grapher: db.Grapher}
+	_, err = NewGraphServer(g).AddEdge(ctx, &rpc.EdgeRequest{Type: "person", Id: "a", To: "b"})
+	requireCode(t, codes.Aborted, err)
+	require.Equal(t, maxEdgeRetries, g.updates)
+
+	// An expected version is tried once.
+	g.updates = 0
+	_, err = NewGraphServer(g).AddEdge(ctx, &rpc.EdgeRequest{Type: "person", Id: "a", To: "b", ExpectedVersion: 1})
+	requireCode(t, codes.FailedPrecondition, err)
+	require.Equal(t, 1, g.updates)
+}
+
+func TestWatchChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch, err := newTestClient(t).WatchChanges(ctx, &rpc.WatchChangesRequest{})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	requireCode(t, codes.FailedPrecondition, err)
+
+	client := newTestClient(t, withChangeLog)
+	rex, err := client.CreateNode(ctx, &rpc.Node{Type: "pet", Name: "rex"})
+	require.NoError(t, err)
+
+	watch, err = client.WatchChanges(ctx, &rpc.WatchChangesRequest{})
+	require.NoError(t, err)
+	change, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), change.Seq)
+	require.Equal(t, rpc.ChangeInsert, change.Op)
+	require.Equal(t, rex.Id, change.Nodes[0].Id)
+
+	// Changes made while watching arrive as they happen.
+	_, err = client.DeleteNode(ctx, &rpc.DeleteNodeRequest{Type: "pet", Id: rex.Id})
+	require.NoError(t, err)
+	change, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), change.Seq)
+	require.Equal(t, rpc.ChangeDelete, change.Op)
+	require.Equal(t, []string{rex.Id}, change.Ids)
+
+	// Watching again after a change skips it.
+	watch, err = client.WatchChanges(ctx, &rpc.WatchChangesRequest{After: 1})
+	require.NoError(t, err)
+	change, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), change.Seq)
+}
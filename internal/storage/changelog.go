@@ -0,0 +1,712 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// The change log archives every mutation applied to an engine as one JSON line,
+// numbered by a sequence that never restarts. A change is written before it is
+// applied and followed by a marker line with its outcome, so a crash in between
+// leaves it in doubt rather than lost; changes in doubt are applied again when
+// the log is opened. The log is split into segments named by their number, and a
+// new segment is started on every open, so a line torn by a crash is always the
+// last line of its segment.
+const (
+	ChangeInsert   = "insert"
+	ChangePut      = "put"
+	ChangeDelete   = "delete"
+	ChangeDropType = "dropType"
+
+	// Markers tell the outcome of the change with the same sequence number.
+	changeCommit = "commit"
+	changeAbort  = "abort"
+
+	changeLogExtension   = ".log"
+	changeLogSegmentSize = 64 << 20
+	// keptChangeLogSegments is the number of newest segments never pruned, which
+	// hold every change that may still be in doubt.
+	keptChangeLogSegments = 3
+
+	// followerBuffer is the number of changes a follower may fall behind the log
+	// before it is dropped.
+	followerBuffer = 1024
+)
+
+var (
+	ErrInvalidChangeLog = errors.New("invalid change log")
+	ErrChangeLogClosed  = errors.New("change log is closed")
+	ErrFollowerLagged   = errors.New("change log follower fell behind")
+	ErrChangesPruned    = errors.New("changes are no longer in the change log")
+)
+
+type Change struct {
+	Seq   uint64       `json:"seq"`
+	Time  time.Time    `json:"time"`
+	Op    string       `json:"op"`
+	Type  string       `json:"type"`
+	Nodes []graph.Node `json:"nodes,omitempty"`
+	IDs   []string     `json:"ids,omitempty"`
+}
+
+type ChangeLog struct {
+	f    disk.FileAccessor
+	path string
+	// retention is how long segments are kept once newer ones exist; 0 keeps them all.
+	retention time.Duration
+
+	lock    sync.Mutex
+	writer  io.WriteCloser
+	segment int
+	size    int64
+	now     func() time.Time
+	// seq is the last recorded change and applied the last one whose outcome is
+	// known along with the outcome of every change before it.
+	seq      uint64
+	applied  uint64
+	inFlight map[uint64]*pendingChange
+	resolved *sync.Cond
+	// inDoubt holds the changes found without an outcome when the log was opened.
+	inDoubt []Change
+
+	followers map[*follower]struct{}
+	closed    bool
+}
+
+// pendingChange is a recorded change being applied.
+type pendingChange struct {
+	change  Change
+	done    bool
+	aborted bool
+}
+
+// follower receives the changes appended while it follows the log. Its channel is
+// closed with err set when it is dropped.
+type follower struct {
+	changes chan Change
+	err     error
+}
+
+// OpenChangeLog opens the change log in path and continues its sequence. Changes
+// left in doubt by a crash must be settled with Reconcile before new ones are
+// recorded. Segments older than retention are pruned as new ones are started.
+func OpenChangeLog(f disk.FileAccessor, path string, retention time.Duration) (*ChangeLog, error) {
+	l := &ChangeLog{
+		f:         f,
+		path:      path,
+		retention: retention,
+		now:       time.Now,
+		inFlight:  make(map[uint64]*pendingChange),
+		followers: make(map[*follower]struct{}),
+	}
+	l.resolved = sync.NewCond(&l.lock)
+
+	segments, err := listChangeLogSegments(f, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.findInDoubt(segments); err != nil {
+		return nil, err
+	}
+	l.applied = l.seq
+	if len(segments) > 0 {
+		l.segment = segments[len(segments)-1]
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.rotateLocked(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// findInDoubt reads the newest segments holding records, newest first, for the last
+// sequence number and the changes without an outcome. Every open settles the changes
+// in doubt before recording new ones, so they are found in the last two of them.
+func (l *ChangeLog) findInDoubt(segments []int) error {
+	outcomes := make(map[uint64]bool)
+	for i, read := len(segments)-1, 0; i >= 0 && read < 2; i-- {
+		var changes []Change
+		records := 0
+		err := readChangeLogSegment(l.f, changeLogSegmentPath(l.f, l.path, segments[i]), func(c Change) error {
+			records++
+			if isChangeMarker(c) {
+				outcomes[c.Seq] = true
+			} else {
+				changes = append(changes, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if records == 0 {
+			continue
+		}
+		read++
+
+		for j := len(changes) - 1; j >= 0; j-- {
+			c := changes[j]
+			l.seq = max(l.seq, c.Seq)
+			if !outcomes[c.Seq] {
+				l.inDoubt = append(l.inDoubt, c)
+			}
+		}
+	}
+	slices.Reverse(l.inDoubt)
+	return nil
+}
+
+// Reconcile applies the changes left in doubt by a crash to the engine again, in
+// sequence order, and records that they were applied. Engines apply every change
+// idempotently, so a change that was applied before the crash is not harmed.
+func (l *ChangeLog) Reconcile(ctx context.Context, engine Engine) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.inDoubt) == 0 {
+		return nil
+	}
+	for len(l.inDoubt) > 0 {
+		c := l.inDoubt[0]
+		if err := redoChange(ctx, engine, c); err != nil {
+			return fmt.Errorf("reapplying change %d: %w", c.Seq, err)
+		}
+		if err := l.writeLocked(Change{Seq: c.Seq, Time: l.now().UTC(), Op: changeCommit}); err != nil {
+			return err
+		}
+		l.inDoubt = l.inDoubt[1:]
+	}
+	return l.f.Sync(changeLogSegmentPath(l.f, l.path, l.segment), l.writer)
+}
+
+// ChangeLogFiles returns the file accessor of the change log in path, sealing its
+// segments when keys are set.
+func ChangeLogFiles(f disk.FileAccessor, path string, keys *disk.Keyring) disk.FileAccessor {
+	if keys == nil {
+		return f
+	}
+	return disk.NewBlockFileAccessor(f, path, changeLogExtension, disk.BlockFormat{Keys: keys})
+}
+
+func changeLogSegmentPath(f disk.FileAccessor, path string, segment int) string {
+	return f.GetFilePath(path, fmt.Sprintf("%06d%s", segment, changeLogExtension))
+}
+
+func (l *ChangeLog) rotateLocked() error {
+	if l.writer != nil {
+		if err := l.writer.Close(); err != nil {
+			return err
+		}
+	}
+
+	l.segment++
+	writer, err := l.f.GetFileWriter(changeLogSegmentPath(l.f, l.path, l.segment), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.writer, l.size = writer, 0
+
+	if err := l.pruneLocked(); err != nil {
+		slog.Error("Error pruning change log", "path", l.path, "error", err)
+	}
+	return nil
+}
+
+// pruneLocked removes the oldest segments while the segment after them starts before
+// the retention period.
+func (l *ChangeLog) pruneLocked() error {
+	if l.retention <= 0 {
+		return nil
+	}
+	segments, err := listChangeLogSegments(l.f, l.path)
+	if err != nil {
+		return err
+	}
+
+	cutoff := l.now().Add(-l.retention)
+	for i := 0; i+keptChangeLogSegments < len(segments); i++ {
+		started, err := firstChangeTime(l.f, changeLogSegmentPath(l.f, l.path, segments[i+1]))
+		if err != nil || started.IsZero() || !started.Before(cutoff) {
+			return err
+		}
+		if err := l.f.RemoveFile(changeLogSegmentPath(l.f, l.path, segments[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstChangeTime returns the time of the first record of a segment, or the zero
+// time for a segment without any.
+func firstChangeTime(f disk.FileAccessor, filePath string) (time.Time, error) {
+	var first time.Time
+	err := readChangeLogSegment(f, filePath, func(c Change) error {
+		first = c.Time
+		return errReadDone
+	})
+	if errors.Is(err, errReadDone) {
+		err = nil
+	}
+	return first, err
+}
+
+var errReadDone = errors.New("read done")
+
+func (l *ChangeLog) writeLocked(c Change) error {
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := l.writer.Write(line); err != nil {
+		return err
+	}
+	l.size += int64(len(line))
+	return nil
+}
+
+// Append records a mutation, makes the record durable as far as the durability mode
+// of the file accessor asks for it, then applies the mutation with apply and records
+// its outcome. A mutation that fails is not part of the log. A nil apply records a
+// mutation applied already.
+func (l *ChangeLog) Append(op string, nodeType string, nodes []graph.Node, ids []string, apply func() error) (err error) {
+	p, err := l.record(Change{Op: op, Type: nodeType, Nodes: nodes, IDs: ids})
+	if err != nil {
+		return err
+	}
+
+	// A panicking apply is recorded as failed, so later changes are not held up.
+	err = errChangeNotApplied
+	defer func() {
+		if resolveErr := l.resolve(p, err != nil); resolveErr != nil {
+			err = errors.Join(err, resolveErr)
+		}
+	}()
+	if apply != nil {
+		return apply()
+	}
+	return nil
+}
+
+var errChangeNotApplied = errors.New("change was not applied")
+
+func (l *ChangeLog) record(change Change) (*pendingChange, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.closed {
+		return nil, ErrChangeLogClosed
+	}
+	change.Seq, change.Time = l.seq+1, l.now().UTC()
+	if err := l.writeLocked(change); err != nil {
+		return nil, err
+	}
+	// Followers read the change from the file once it is applied.
+	if err := disk.FlushWriter(l.writer); err != nil {
+		return nil, err
+	}
+	if err := l.f.Sync(changeLogSegmentPath(l.f, l.path, l.segment), l.writer); err != nil {
+		return nil, err
+	}
+	l.seq = change.Seq
+	p := &pendingChange{change: change}
+	l.inFlight[change.Seq] = p
+
+	if l.size >= changeLogSegmentSize {
+		return p, l.rotateLocked()
+	}
+	return p, nil
+}
+
+// resolve records the outcome of a change, then hands the changes whose outcome is
+// known to the followers in sequence order. The marker of an aborted change must be
+// durable, or the change would be applied again after a crash; losing the marker of
+// an applied change only applies it once more.
+func (l *ChangeLog) resolve(p *pendingChange, aborted bool) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var err error
+	if aborted {
+		err = l.writeLocked(Change{Seq: p.change.Seq, Time: l.now().UTC(), Op: changeAbort})
+		if err == nil {
+			err = disk.FlushWriter(l.writer)
+		}
+		if err == nil {
+			err = l.f.Sync(changeLogSegmentPath(l.f, l.path, l.segment), l.writer)
+		}
+	} else if markErr := l.writeLocked(Change{Seq: p.change.Seq, Time: l.now().UTC(), Op: changeCommit}); markErr != nil {
+		slog.Error("Error recording applied change", "seq", p.change.Seq, "error", markErr)
+	}
+	p.done, p.aborted = true, aborted
+
+	for next := l.inFlight[l.applied+1]; next != nil && next.done; next = l.inFlight[l.applied+1] {
+		delete(l.inFlight, next.change.Seq)
+		l.applied = next.change.Seq
+		if !next.aborted {
+			l.notifyLocked(next.change)
+		}
+	}
+	l.resolved.Broadcast()
+	return err
+}
+
+// Seq waits for the changes being applied and returns the sequence number of the
+// last recorded change. Every change up to it is applied or known to have failed.
+func (l *ChangeLog) Seq() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	seq := l.seq
+	for l.applied < seq {
+		l.resolved.Wait()
+	}
+	return seq
+}
+
+// Close waits for the changes being applied and closes the log.
+func (l *ChangeLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.closed = true
+	for l.applied < l.seq {
+		l.resolved.Wait()
+	}
+	for f := range l.followers {
+		l.dropLocked(f, ErrChangeLogClosed)
+	}
+	return l.writer.Close()
+}
+
+// notifyLocked hands a change to every follower, dropping the ones whose buffer is full
+// rather than holding up the write.
+func (l *ChangeLog) notifyLocked(c Change) {
+	for f := range l.followers {
+		select {
+		case f.changes <- c:
+		default:
+			l.dropLocked(f, fmt.Errorf("%w at change %d", ErrFollowerLagged, c.Seq))
+		}
+	}
+}
+
+func (l *ChangeLog) dropLocked(f *follower, err error) {
+	if _, ok := l.followers[f]; !ok {
+		return
+	}
+	delete(l.followers, f)
+	f.err = err
+	close(f.changes)
+}
+
+// Follow calls fn for every change after the sequence number after, first reading
+// the recorded ones and then waiting for new ones, until ctx is done, fn fails or
+// the log is closed. A follower falling more than followerBuffer changes behind
+// the writes gets ErrFollowerLagged, and may follow again from its last change.
+func (l *ChangeLog) Follow(ctx context.Context, after uint64, fn func(c Change) error) error {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return ErrChangeLogClosed
+	}
+	f := &follower{changes: make(chan Change, followerBuffer)}
+	l.followers[f] = struct{}{}
+	current := l.applied
+	l.lock.Unlock()
+
+	defer func() {
+		l.lock.Lock()
+		l.dropLocked(f, nil)
+		l.lock.Unlock()
+	}()
+
+	last := after
+	if after < current {
+		read, err := readChangesAfter(l.f, l.path, after, func(c Change) error {
+			if c.Seq > current {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fn(c)
+		})
+		if err != nil {
+			return err
+		}
+		if read < current {
+			return fmt.Errorf("%w: change %d is missing", ErrInvalidChangeLog, read+1)
+		}
+		last = current
+	}
+
+	for {
+		select {
+		case c, ok := <-f.changes:
+			if !ok {
+				return f.err
+			}
+			// Changes recorded while the log was read arrive twice.
+			if c.Seq <= last {
+				continue
+			}
+			last = c.Seq
+			if err := fn(c); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ReadChanges calls fn for every applied change in the change log in path, in
+// sequence order.
+func ReadChanges(f disk.FileAccessor, path string, fn func(c Change) error) error {
+	_, err := readChangesAfter(f, path, 0, fn)
+	return err
+}
+
+// ReadChangesAfter calls fn for every applied change after the sequence number after,
+// in sequence order. It fails with ErrChangesPruned when the log no longer holds the
+// change following after.
+func ReadChangesAfter(f disk.FileAccessor, path string, after uint64, fn func(c Change) error) error {
+	_, err := readChangesAfter(f, path, after, fn)
+	return err
+}
+
+// readChangesAfter returns the sequence number of the last change read, applied or not.
+func readChangesAfter(f disk.FileAccessor, path string, after uint64, fn func(c Change) error) (uint64, error) {
+	segments, err := listChangeLogSegments(f, path)
+	if err != nil {
+		return 0, err
+	}
+
+	r := &changeReader{after: after, last: after, fn: fn, outcomes: make(map[uint64]string)}
+	for _, segment := range segments {
+		err := readChangeLogSegment(f, changeLogSegmentPath(f, path, segment), r.add)
+		// A segment pruned since it was listed held only older changes.
+		if errors.Is(err, os.ErrNotExist) && !r.started {
+			continue
+		}
+		if err != nil {
+			return r.last, err
+		}
+	}
+	return r.last, r.flush()
+}
+
+// changeReader hands the changes read from a log to fn in sequence order once their
+// outcome is read, skipping the ones that failed.
+type changeReader struct {
+	after   uint64
+	last    uint64
+	fn      func(c Change) error
+	started bool
+	// pending holds the changes read without an outcome, in sequence order.
+	pending  []Change
+	outcomes map[uint64]string
+}
+
+func (r *changeReader) add(c Change) error {
+	if isChangeMarker(c) {
+		if len(r.pending) > 0 && c.Seq >= r.pending[0].Seq {
+			r.outcomes[c.Seq] = c.Op
+		}
+		return r.emit()
+	}
+
+	if !r.started {
+		r.started = true
+		if c.Seq > r.after+1 {
+			return fmt.Errorf("%w: change %d is the first one kept", ErrChangesPruned, c.Seq)
+		}
+	}
+	if c.Seq > r.after {
+		r.pending = append(r.pending, c)
+	}
+	return nil
+}
+
+func (r *changeReader) emit() error {
+	for len(r.pending) > 0 {
+		c := r.pending[0]
+		op, ok := r.outcomes[c.Seq]
+		if !ok {
+			return nil
+		}
+		delete(r.outcomes, c.Seq)
+		r.pending = r.pending[1:]
+		r.last = c.Seq
+		if op == changeCommit {
+			if err := r.fn(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush hands over the changes read without an outcome. They are being applied, or
+// were left in doubt by a crash and are applied again when the log is opened.
+func (r *changeReader) flush() error {
+	for _, c := range r.pending {
+		if _, ok := r.outcomes[c.Seq]; ok {
+			continue
+		}
+		r.last = c.Seq
+		if err := r.fn(c); err != nil {
+			return err
+		}
+	}
+	r.pending = nil
+	return nil
+}
+
+func isChangeMarker(c Change) bool {
+	return c.Op == changeCommit || c.Op == changeAbort
+}
+
+func listChangeLogSegments(f disk.FileAccessor, path string) ([]int, error) {
+	names, err := f.ListFiles(path, changeLogExtension)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]int, 0, len(names))
+	for _, name := range names {
+		segment, err := strconv.Atoi(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unexpected file %s%s", ErrInvalidChangeLog, name, changeLogExtension)
+		}
+		segments = append(segments, segment)
+	}
+	slices.Sort(segments)
+	return segments, nil
+}
+
+// readChangeLogSegment skips a torn last line or block, which is a change that was
+// never acknowledged.
+func readChangeLogSegment(f disk.FileAccessor, filePath string, fn func(c Change) error) error {
+	reader, err := f.GetFileReader(filePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	r := bufio.NewReader(reader)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var c Change
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("%w: %s line %d: %v", ErrInvalidChangeLog, filePath, line, err)
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+}
+
+// ApplyChange applies a recorded change to an engine.
+func ApplyChange(ctx context.Context, engine Engine, c Change) error {
+	switch c.Op {
+	case ChangeInsert:
+		return engine.Insert(ctx, c.Type, c.Nodes)
+	case ChangePut:
+		return engine.Put(ctx, c.Type, c.Nodes)
+	case ChangeDelete:
+		return engine.Delete(ctx, c.Type, c.IDs)
+	case ChangeDropType:
+		return engine.DropType(ctx, c.Type)
+	}
+	return fmt.Errorf("%w: unknown operation %q in change %d", ErrInvalidChangeLog, c.Op, c.Seq)
+}
+
+// redoChange applies a change that may have been applied already. Inserts are put,
+// so nodes stored already are not refused.
+func redoChange(ctx context.Context, engine Engine, c Change) error {
+	switch c.Op {
+	case ChangeInsert, ChangePut:
+		return engine.Put(ctx, c.Type, c.Nodes)
+	case ChangeDelete, ChangeDropType:
+		if ok, err := engine.HasType(ctx, c.Type); err != nil || !ok {
+			return err
+		}
+	}
+	return ApplyChange(ctx, engine, c)
+}
+
+// loggingEngine records every mutation of the engine it wraps in a change log before
+// applying it, and its outcome after.
+type loggingEngine struct {
+	Engine
+	log *ChangeLog
+}
+
+func NewLoggingEngine(engine Engine, log *ChangeLog) Engine {
+	return &loggingEngine{Engine: engine, log: log}
+}
+
+func (e *loggingEngine) Insert(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	return e.log.Append(ChangeInsert, nodeType, nodes, nil, func() error {
+		return e.Engine.Insert(ctx, nodeType, nodes)
+	})
+}
+
+func (e *loggingEngine) Put(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	return e.log.Append(ChangePut, nodeType, nodes, nil, func() error {
+		return e.Engine.Put(ctx, nodeType, nodes)
+	})
+}
+
+func (e *loggingEngine) Delete(ctx context.Context, nodeType string, ids []string) error {
+	return e.log.Append(ChangeDelete, nodeType, nil, ids, func() error {
+		return e.Engine.Delete(ctx, nodeType, ids)
+	})
+}
+
+func (e *loggingEngine) DropType(ctx context.Context, nodeType string) error {
+	return e.log.Append(ChangeDropType, nodeType, nil, nil, func() error {
+		return e.Engine.DropType(ctx, nodeType)
+	})
+}
+
+// ChangeSeq returns the sequence number of the last logged change.
+func (e *loggingEngine) ChangeSeq() uint64 {
+	return e.log.Seq()
+}
+
+func (e *loggingEngine) FollowChanges(ctx context.Context, after uint64, fn func(c Change) error) error {
+	return e.log.Follow(ctx, after, fn)
+}
+
+func (e *loggingEngine) Unwrap() Engine {
+	return e.Engine
+}
+
+func (e *loggingEngine) Close() error {
+	return errors.Join(e.Engine.Close(), e.log.Close())
+}
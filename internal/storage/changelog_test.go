@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+func TestLoggingEngineRecordsChanges(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	dir := t.TempDir()
+
+	log, err := OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	engine := NewLoggingEngine(NewMemoryEngine(), log)
+
+	alice := graph.Node{ID: "a", Type: "person", Name: "alice", Version: 1}
+	require.NoError(t, engine.Insert(ctx, "person", []graph.Node{alice}))
+	alice.Name, alice.Version = "alice smith", 2
+	require.NoError(t, engine.Put(ctx, "person", []graph.Node{alice}))
+	require.NoError(t, engine.Insert(ctx, "pet", []graph.Node{{ID: "p", Type: "pet", Name: "rex", Version: 1}}))
+	require.NoError(t, engine.Delete(ctx, "pet", []string{"p"}))
+	require.Equal(t, uint64(4), engine.(ChangeLogger).ChangeSeq())
+	require.NoError(t, engine.Close())
+
+	// A torn line left by a crash is skipped, and the sequence continues after reopening.
+	segment := filepath.Join(dir, "000001.log")
+	file, err := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"seq":5,"op":"ins`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	log, err = OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), log.Seq())
+	require.NoError(t, log.Append(ChangeDropType, "pet", nil, nil, nil))
+	require.NoError(t, log.Close())
+
+	replayed := NewMemoryEngine()
+	var seqs []uint64
+	require.NoError(t, ReadChanges(f, dir, func(c Change) error {
+		seqs = append(seqs, c.Seq)
+		return ApplyChange(ctx, replayed, c)
+	}))
+	require.Equal(t, []uint64{1, 2, 3, 4, 5}, seqs)
+
+	node, err := replayed.Get(ctx, "person", "a")
+	require.NoError(t, err)
+	require.Equal(t, alice, *node)
+	exists, err := replayed.HasType(ctx, "pet")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestChangeLogRotatesSegments(t *testing.T) {
+	f := disk.NewFileAccessor()
+	dir := t.TempDir()
+
+	log, err := OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	log.now = func() time.Time { return now }
+	log.size = changeLogSegmentSize
+	require.NoError(t, log.Append(ChangeDelete, "person", nil, []string{"a"}, nil))
+	require.NoError(t, log.Close())
+
+	segments, err := listChangeLogSegments(f, dir)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, segments)
+
+	var changes []Change
+	require.NoError(t, ReadChanges(f, dir, func(c Change) error {
+		changes = append(changes, c)
+		return nil
+	}))
+	require.Equal(t, []Change{{Seq: 1, Time: now, Op: ChangeDelete, Type: "person", IDs: []string{"a"}}}, changes)
+}
+
+func TestSealedChangeLog(t *testing.T) {
+	dir := t.TempDir()
+	f := ChangeLogFiles(disk.NewFileAccessor(), dir, testKeyring(t, "key"))
+
+	log, err := OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, log.Append(ChangeDelete, "person", nil, []string{"alice"}, nil))
+	require.NoError(t, log.Append(ChangeDelete, "person", nil, []string{"bob"}, nil))
+	require.NoError(t, log.Close())
+
+	segment := filepath.Join(dir, "000001.log")
+	data, err := os.ReadFile(segment)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "alice")
+
+	// A torn block left by a crash is skipped like a torn line. It held the outcome
+	// of the last change, which is left in doubt.
+	require.NoError(t, os.WriteFile(segment, data[:len(data)-3], 0644))
+	log, err = OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), log.Seq())
+	require.Len(t, log.inDoubt, 1)
+	require.Equal(t, []string{"bob"}, log.inDoubt[0].IDs)
+	require.NoError(t, log.Close())
+
+	// Without the key the log cannot be read.
+	err = ReadChanges(ChangeLogFiles(disk.NewFileAccessor(), dir, testKeyring(t, "other")), dir, func(c Change) error { return nil })
+	require.ErrorIs(t, err, disk.ErrSealed)
+}
+
+func TestChangeLogFollow(t *testing.T) {
+	f := disk.NewFileAccessor()
+	log, err := OpenChangeLog(f, t.TempDir(), 0)
+	require.NoError(t, err)
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, log.Append(ChangeDelete, "person", nil, []string{id}, nil))
+	}
+
+	// Recorded changes after the given one are read first, then new ones as they come.
+	ctx, cancel := context.WithCancel(context.Background())
+	seqs := make(chan uint64)
+	done := make(chan error)
+	go func() {
+		done <- log.Follow(ctx, 1, func(c Change) error {
+			seqs <- c.Seq
+			return nil
+		})
+	}()
+	require.Equal(t, uint64(2), <-seqs)
+	require.Equal(t, uint64(3), <-seqs)
+	require.NoError(t, log.Append(ChangeDelete, "person", nil, []string{"d"}, nil))
+	require.Equal(t, uint64(4), <-seqs)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	// A follower that stops reading is dropped instead of holding up writes.
+	blocked := make(chan struct{})
+	go func() {
+		done <- log.Follow(context.Background(), log.Seq(), func(c Change) error {
+			<-blocked
+			return nil
+		})
+	}()
+	require.Eventually(t, func() bool {
+		log.lock.Lock()
+		defer log.lock.Unlock()
+		return len(log.followers) == 1
+	}, time.Second, time.Millisecond)
+	for range followerBuffer + 2 {
+		require.NoError(t, log.Append(ChangeDropType, "pet", nil, nil, nil))
+	}
+	close(blocked)
+	require.ErrorIs(t, <-done, ErrFollowerLagged)
+
+	require.NoError(t, log.Close())
+	require.ErrorIs(t, log.Follow(context.Background(), 0, func(c Change) error { return nil }), ErrChangeLogClosed)
+}
+
+type failingPutEngine struct {
+	Engine
+}
+
+func (e *failingPutEngine) Put(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	return errors.New("put failed")
+}
+
+func TestChangeLogSkipsFailedChanges(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	dir := t.TempDir()
+
+	log, err := OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	engine := NewLoggingEngine(&failingPutEngine{Engine: NewMemoryEngine()}, log)
+	rex := graph.Node{ID: "p", Type: "pet", Name: "rex", Version: 1}
+	require.NoError(t, engine.Insert(ctx, "pet", []graph.Node{rex}))
+	require.Error(t, engine.Put(ctx, "pet", []graph.Node{rex}))
+	require.NoError(t, engine.Delete(ctx, "pet", []string{"p"}))
+	require.NoError(t, engine.Close())
+
+	var ops []string
+	require.NoError(t, ReadChanges(f, dir, func(c Change) error {
+		ops = append(ops, fmt.Sprint(c.Seq, c.Op))
+		return nil
+	}))
+	require.Equal(t, []string{"1insert", "3delete"}, ops)
+}
+
+func TestChangeLogReconcilesChangesInDoubt(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	dir := t.TempDir()
+
+	// A crash between recording a change and applying it leaves it without an outcome.
+	log, err := OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	rex := graph.Node{ID: "p", Type: "pet", Name: "rex", Version: 1}
+	_, err = log.record(Change{Op: ChangeInsert, Type: "pet", Nodes: []graph.Node{rex}})
+	require.NoError(t, err)
+	require.NoError(t, log.writer.Close())
+
+	log, err = OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	engine := NewMemoryEngine()
+	require.NoError(t, log.Reconcile(ctx, engine))
+	node, err := engine.Get(ctx, "pet", "p")
+	require.NoError(t, err)
+	require.Equal(t, rex, *node)
+	require.NoError(t, log.Close())
+
+	// Once reconciled, the change is no longer in doubt.
+	log, err = OpenChangeLog(f, dir, 0)
+	require.NoError(t, err)
+	require.Empty(t, log.inDoubt)
+	require.Equal(t, uint64(1), log.Seq())
+	require.NoError(t, log.Close())
+}
+
+func TestChangeLogPrunesOldSegments(t *testing.T) {
+	f := disk.NewFileAccessor()
+	dir := t.TempDir()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	log, err := OpenChangeLog(f, dir, time.Hour)
+	require.NoError(t, err)
+	log.now = func() time.Time { return now }
+	for i := range 5 {
+		log.size = changeLogSegmentSize
+		require.NoError(t, log.Append(ChangeDelete, "person", nil, []string{fmt.Sprint(i)}, nil))
+		now = now.Add(time.Hour)
+	}
+	require.NoError(t, log.Close())
+
+	// A segment is pruned once the segment after it starts before the retention, and
+	// the newest ones are always kept.
+	segments, err := listChangeLogSegments(f, dir)
+	require.NoError(t, err)
+	require.Equal(t, []int{4, 5, 6}, segments)
+
+	var seqs []uint64
+	require.NoError(t, ReadChangesAfter(f, dir, 3, func(c Change) error {
+		seqs = append(seqs, c.Seq)
+		return nil
+	}))
+	require.Equal(t, []uint64{4, 5}, seqs)
+	require.ErrorIs(t, ReadChangesAfter(f, dir, 2, func(c Change) error { return nil }), ErrChangesPruned)
+}
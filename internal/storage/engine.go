@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+const (
+	EngineFile   = "file"
+	EngineLsm    = "lsm"
+	EngineMemory = "memory"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Engine stores nodes by type and ID.
+//
+// Engines do not serialize writes to the same type; the grapher runs all writes
+// of a type through its worker. Insert is only used for nodes that are not stored
+// yet, which lets append based engines skip looking for an existing copy.
+type Engine interface {
+	Insert(ctx context.Context, nodeType string, nodes []graph.Node) error
+	Put(ctx context.Context, nodeType string, nodes []graph.Node) error
+	Get(ctx context.Context, nodeType string, id string) (*graph.Node, error)
+	Scan(ctx context.Context, nodeType string) (Iterator, error)
+	// ScanAfter walks over the nodes of a type with IDs after the given one, in ID order.
+	ScanAfter(ctx context.Context, nodeType string, after string) (Iterator, error)
+	Delete(ctx context.Context, nodeType string, ids []string) error
+
+	Types(ctx context.Context) ([]string, error)
+	HasType(ctx context.Context, nodeType string) (bool, error)
+	// Size returns the number of bytes a type takes up in storage.
+	Size(ctx context.Context, nodeType string) (int64, error)
+	DropType(ctx context.Context, nodeType string) error
+
+	Close() error
+}
+
+// Checker is implemented by engines that can verify the records they store.
+type Checker interface {
+	// CheckType verifies the stored records of a type and, with quarantine set,
+	// moves corrupt records out of the way.
+	CheckType(ctx context.Context, nodeType string, quarantine bool) (*disk.FileReport, error)
+}
+
+// ChangeLogger is implemented by engines recording their changes in a change log.
+type ChangeLogger interface {
+	ChangeSeq() uint64
+	// FollowChanges calls fn for every change after the sequence number after, as
+	// ChangeLog.Follow does.
+	FollowChanges(ctx context.Context, after uint64, fn func(c Change) error) error
+}
+
+// AsChangeLogger returns the ChangeLogger of an engine, looking through engines wrapping another.
+func AsChangeLogger(engine Engine) (ChangeLogger, bool) {
+	for {
+		if logger, ok := engine.(ChangeLogger); ok {
+			return logger, true
+		}
+		wrapper, ok := engine.(interface{ Unwrap() Engine })
+		if !ok {
+			return nil, false
+		}
+		engine = wrapper.Unwrap()
+	}
+}
+
+// AsChecker returns the Checker of an engine, looking through engines wrapping another.
+func AsChecker(engine Engine) (Checker, bool) {
+	for {
+		if checker, ok := engine.(Checker); ok {
+			return checker, true
+		}
+		wrapper, ok := engine.(interface{ Unwrap() Engine })
+		if !ok {
+			return nil, false
+		}
+		engine = wrapper.Unwrap()
+	}
+}
+
+// Iterator walks over the nodes of a type. Next must be called before the first Node.
+type Iterator interface {
+	Next() bool
+	Node() graph.Node
+	Err() error
+	Close() error
+}
+
+// Open creates the engine configured for the database under its root path, after
+// migrating its stored data to the current format, recording its changes in the
+// change log when it is enabled. Changes the log holds in doubt are applied again.
+func Open(cfg *config.Config, f disk.FileAccessor) (Engine, error) {
+	format, err := BlockFormatOf(cfg, f)
+	if err != nil {
+		return nil, err
+	}
+	if format.Keys != nil {
+		if err := checkKeysInUse(f, cmp.Or(cfg.Database.RootPath, "."), format.Keys); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Database.Engine != EngineMemory {
+		if _, err := migrate(context.Background(), &migrator{cfg: cfg, f: f, format: format}, false); err != nil {
+			return nil, err
+		}
+	}
+	engine, err := openEngine(cfg, f, format)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Database.ChangeLog {
+		if engine, err = openChangeLog(cfg, f, format, engine); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Database.Engine == EngineMemory {
+		return engine, nil
+	}
+
+	// Batches go through the journal ahead of the change log, which records their changes.
+	journalPath, err := f.AddFolder(cfg.Database.RootPath, "journal")
+	if err == nil {
+		var journaled Engine
+		if journaled, err = OpenJournal(context.Background(), engine, JournalFiles(f, journalPath, format.Keys), journalPath); err == nil {
+			return journaled, nil
+		}
+	}
+	return nil, errors.Join(err, engine.Close())
+}
+
+func openChangeLog(cfg *config.Config, f disk.FileAccessor, format disk.BlockFormat, engine Engine) (Engine, error) {
+	logPath, err := f.AddFolder(cfg.Database.RootPath, "changelog")
+	if err == nil {
+		var log *ChangeLog
+		if log, err = OpenChangeLog(ChangeLogFiles(f, logPath, format.Keys), logPath, cfg.Database.ChangeLogRetention); err == nil {
+			if err = log.Reconcile(context.Background(), engine); err == nil {
+				return NewLoggingEngine(engine, log), nil
+			}
+			err = errors.Join(err, log.Close())
+		}
+	}
+	return nil, errors.Join(err, engine.Close())
+}
+
+func openEngine(cfg *config.Config, f disk.FileAccessor, format disk.BlockFormat) (Engine, error) {
+	switch cfg.Database.Engine {
+	case "", EngineFile:
+		codec, files, nodePath, err := openNodeFiles(cfg, f, cfg.Database.Format, format)
+		if err != nil {
+			return nil, err
+		}
+		// Node files written before the database was encrypted are sealed right away.
+		if err := disk.SealFiles(files); err != nil {
+			return nil, err
+		}
+		return NewFileEngine(files, codec, nodePath)
+	case EngineLsm:
+		lsmPath, err := f.AddFolder(cfg.Database.RootPath, "lsm")
+		if err != nil {
+			return nil, err
+		}
+		return OpenLsmEngine(f, lsmPath, format)
+	case EngineMemory:
+		return NewMemoryEngine(), nil
+	}
+	return nil, fmt.Errorf("unknown storage engine %q", cfg.Database.Engine)
+}
+
+// CompressionOf returns the configured compression of stored nodes.
+func CompressionOf(cfg *config.Config) disk.Compression {
+	return disk.Compression{Default: cfg.Database.Compression, Types: cfg.Database.TypeCompression}
+}
+
+// BlockFormatOf returns the configured compression and encryption keys of stored data,
+// after checking that the keys match the ones the database was encrypted with.
+func BlockFormatOf(cfg *config.Config, f disk.FileAccessor) (disk.BlockFormat, error) {
+	format := disk.BlockFormat{Compression: CompressionOf(cfg)}
+	if err := format.Compression.Validate(); err != nil {
+		return format, err
+	}
+
+	keys, err := disk.LoadKeyring(cfg.Database.EncryptionKey, cfg.Database.EncryptionKeyFile)
+	if err != nil {
+		return format, err
+	}
+	rootPath := cmp.Or(cfg.Database.RootPath, ".")
+	if keys != nil {
+		if _, err := f.AddFolder(rootPath, ""); err != nil {
+			return format, err
+		}
+	}
+	if err := disk.CheckKey(f, rootPath, keys); err != nil {
+		return format, err
+	}
+	format.Keys = keys
+	return format, nil
+}
+
+// sealedFiles are the folders and extensions of the files stored in sealed blocks. Lsm
+// segments are left out, as loading their index fails with an unknown key already.
+var sealedFiles = []struct {
+	folder string
+	ext    string
+}{
+	{"nodes", ".csv"},
+	{"nodes", ".jdb"},
+	{"nodes", disk.QuarantineExtension},
+	{"changelog", changeLogExtension},
+	{"lsm", walExtension},
+	{"journal", journalExtension},
+}
+
+// checkKeysInUse verifies that the keyring holds every key sealing stored data. After
+// a rotation the old key is needed until everything sealed with it was rewritten.
+func checkKeysInUse(f disk.FileAccessor, rootPath string, keys *disk.Keyring) error {
+	for _, files := range sealedFiles {
+		folder := f.GetFilePath(rootPath, files.folder)
+		names, err := f.ListFiles(folder, files.ext)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := disk.CheckSealedFile(f, f.GetFilePath(folder, name+files.ext), keys); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OpenNodeFiles returns the node accessor of the file engine for the format, the file
+// accessor compressing and sealing its node files as configured, and the folder holding them.
+func OpenNodeFiles(cfg *config.Config, f disk.FileAccessor, format string) (disk.NodeAccessor, disk.FileAccessor, string, error) {
+	if err := CheckFormat(f, cfg.Database.RootPath); err != nil {
+		return nil, nil, "", err
+	}
+	blocks, err := BlockFormatOf(cfg, f)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return openNodeFiles(cfg, f, format, blocks)
+}
+
+func openNodeFiles(cfg *config.Config, f disk.FileAccessor, format string, blocks disk.BlockFormat) (disk.NodeAccessor, disk.FileAccessor, string, error) {
+	nodePath, err := f.AddFolder(cfg.Database.RootPath, "nodes")
+	if err != nil {
+		return nil, nil, "", err
+	}
+	plain, err := disk.NewNodeAccessor(format, f)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	files := disk.NewBlockFileAccessor(f, nodePath, plain.FileExtension(), blocks)
+	codec, err := disk.NewNodeAccessor(format, files)
+	return codec, files, nodePath, err
+}
+
+// ReadAll drains an iterator into a slice.
+func ReadAll(it Iterator) ([]graph.Node, error) {
+	defer it.Close()
+
+	var nodes []graph.Node
+	for it.Next() {
+		nodes = append(nodes, it.Node())
+	}
+	return nodes, it.Err()
+}
+
+// NodesAfter returns the nodes with IDs after the given one, sorted by ID.
+func NodesAfter(nodes []graph.Node, after string) []graph.Node {
+	kept := make([]graph.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.ID > after {
+			kept = append(kept, node)
+		}
+	}
+	slices.SortFunc(kept, func(a, b graph.Node) int { return strings.Compare(a.ID, b.ID) })
+	return kept
+}
+
+type sliceIterator struct {
+	nodes []graph.Node
+	pos   int
+}
+
+// NewSliceIterator iterates over nodes already held in memory.
+func NewSliceIterator(nodes []graph.Node) Iterator {
+	return &sliceIterator{nodes: nodes, pos: -1}
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.pos+1 >= len(it.nodes) {
+		it.pos = len(it.nodes)
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *sliceIterator) Node() graph.Node {
+	return it.nodes[it.pos]
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
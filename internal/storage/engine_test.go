@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+func openTestEngines(t *testing.T) map[string]Engine {
+	f := disk.NewFileAccessor()
+
+	file, err := NewFileEngine(f, disk.NewCsvAccessor(f), t.TempDir())
+	require.NoError(t, err)
+	lsm, err := OpenLsmEngine(f, t.TempDir(), disk.BlockFormat{})
+	require.NoError(t, err)
+
+	zstd := disk.Compression{Default: disk.CompressionZstd, Types: map[string]string{"pet": disk.CompressionSnappy}}
+	nodePath := t.TempDir()
+	files := disk.NewCompressedFileAccessor(f, nodePath, ".jdb", zstd)
+	compressedFile, err := NewFileEngine(files, disk.NewBinaryAccessor(files), nodePath)
+	require.NoError(t, err)
+	compressedLsm, err := OpenLsmEngine(f, t.TempDir(), disk.BlockFormat{Compression: zstd})
+	require.NoError(t, err)
+
+	sealed := disk.BlockFormat{Compression: zstd, Keys: testKeyring(t, "key")}
+	sealedPath := t.TempDir()
+	sealedFiles := disk.NewBlockFileAccessor(f, sealedPath, ".csv", sealed)
+	encryptedFile, err := NewFileEngine(sealedFiles, disk.NewCsvAccessor(sealedFiles), sealedPath)
+	require.NoError(t, err)
+	encryptedLsm, err := OpenLsmEngine(f, t.TempDir(), sealed)
+	require.NoError(t, err)
+
+	engines := map[string]Engine{
+		EngineFile:                 file,
+		EngineLsm:                  lsm,
+		EngineMemory:               NewMemoryEngine(),
+		"compressed-" + EngineFile: compressedFile,
+		"compressed-" + EngineLsm:  compressedLsm,
+		"encrypted-" + EngineFile:  encryptedFile,
+		"encrypted-" + EngineLsm:   encryptedLsm,
+	}
+	t.Cleanup(func() {
+		for _, e := range engines {
+			e.Close()
+		}
+	})
+	return engines
+}
+
+// testKeyring derives a keyring from key names, the first one active.
+func testKeyring(t *testing.T, names ...string) *disk.Keyring {
+	var keys []string
+	for _, name := range names {
+		key := sha256.Sum256([]byte(name))
+		keys = append(keys, base64.StdEncoding.EncodeToString(key[:]))
+	}
+	k, err := disk.LoadKeyring(strings.Join(keys, ","), "")
+	require.NoError(t, err)
+	return k
+}
+
+func TestEngines(t *testing.T) {
+	ctx := context.Background()
+
+	for name, e := range openTestEngines(t) {
+		t.Run(name, func(t *testing.T) {
+			alice := graph.Node{ID: "a", Type: "person", Name: "alice", Version: 1}
+			bob := graph.Node{ID: "b", Type: "person", Name: "bob", Edges: []string{"a"}, Version: 1}
+			cat := graph.Node{ID: "c", Type: "pet", Name: "cat", Traits: map[string]string{"color": "black"}, Version: 1}
+
+			require.NoError(t, e.Insert(ctx, "person", []graph.Node{alice, bob}))
+			require.NoError(t, e.Insert(ctx, "pet", []graph.Node{cat}))
+
+			node, err := e.Get(ctx, "pet", "c")
+			require.NoError(t, err)
+			require.Equal(t, cat, *node)
+			_, err = e.Get(ctx, "pet", "a")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			alice.Name = "alice smith"
+			alice.Version = 2
+			require.NoError(t, e.Put(ctx, "person", []graph.Node{alice}))
+
+			it, err := e.Scan(ctx, "person")
+			require.NoError(t, err)
+			nodes, err := ReadAll(it)
+			require.NoError(t, err)
+			require.Equal(t, []graph.Node{alice, bob}, nodes)
+
+			require.NoError(t, e.Delete(ctx, "person", []string{"b"}))
+			_, err = e.Get(ctx, "person", "b")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			nodeTypes, err := e.Types(ctx)
+			require.NoError(t, err)
+			slices.Sort(nodeTypes)
+			require.Equal(t, []string{"person", "pet"}, nodeTypes)
+
+			size, err := e.Size(ctx, "pet")
+			require.NoError(t, err)
+			require.Positive(t, size)
+
+			require.NoError(t, e.DropType(ctx, "pet"))
+			exists, err := e.HasType(ctx, "pet")
+			require.NoError(t, err)
+			require.False(t, exists)
+		})
+	}
+}
+
+func TestEnginesScanAfter(t *testing.T) {
+	ctx := context.Background()
+
+	for name, e := range openTestEngines(t) {
+		t.Run(name, func(t *testing.T) {
+			// Written out of order, next to a type sharing the prefix of the name.
+			require.NoError(t, e.Insert(ctx, "person", []graph.Node{
+				{ID: "d", Type: "person", Name: "dan", Version: 1},
+				{ID: "b", Type: "person", Name: "bob", Version: 1},
+			}))
+			require.NoError(t, e.Insert(ctx, "person", []graph.Node{
+				{ID: "c", Type: "person", Name: "cid", Version: 1},
+				{ID: "a", Type: "person", Name: "alice", Version: 1},
+			}))
+			require.NoError(t, e.Insert(ctx, "personal", []graph.Node{{ID: "e", Type: "personal", Name: "eve", Version: 1}}))
+
+			for after, want := range map[string][]string{"": {"a", "b", "c", "d"}, "b": {"c", "d"}, "bb": {"c", "d"}, "d": nil} {
+				it, err := e.ScanAfter(ctx, "person", after)
+				require.NoError(t, err)
+				nodes, err := ReadAll(it)
+				require.NoError(t, err)
+				var ids []string
+				for _, node := range nodes {
+					ids = append(ids, node.ID)
+				}
+				require.Equal(t, want, ids, "after %q", after)
+			}
+		})
+	}
+}
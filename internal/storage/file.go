@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// fileEngine keeps every type in its own file under the node path, in the row
+// format of its node accessor. Inserts are appended; any other change rewrites
+// the whole file of the type.
+type fileEngine struct {
+	f        disk.FileAccessor
+	codec    disk.NodeAccessor
+	nodePath string
+}
+
+func NewFileEngine(f disk.FileAccessor, codec disk.NodeAccessor, nodePath string) (Engine, error) {
+	e := &fileEngine{
+		f:        f,
+		codec:    codec,
+		nodePath: nodePath,
+	}
+	return e, nil
+}
+
+// upgradeLegacyFiles rewrites files created before nodes were versioned or
+// checksummed. Unversioned nodes read from such files start at version 1. It is
+// the migration to format version 1.
+func (e *fileEngine) upgradeLegacyFiles(ctx context.Context) error {
+	nodeTypes, err := e.Types(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, nodeType := range nodeTypes {
+		legacy, err := e.codec.IsLegacyFile(ctx, e.filePath(nodeType))
+		if err != nil {
+			return err
+		}
+		if !legacy {
+			continue
+		}
+
+		nodes, err := e.readNodes(ctx, nodeType)
+		if err != nil {
+			return err
+		}
+		for i := range nodes {
+			if nodes[i].Version == 0 {
+				nodes[i].Version = 1
+			}
+		}
+		slog.InfoContext(ctx, "Upgrading legacy nodes file", "nodeType", nodeType)
+		if err := e.codec.RewriteNodesToFile(ctx, e.filePath(nodeType), nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *fileEngine) filePath(nodeType string) string {
+	return e.f.GetFilePath(e.nodePath, nodeType+e.codec.FileExtension())
+}
+
+// readNodes reads every stored node of a type, treating a missing file as empty.
+func (e *fileEngine) readNodes(ctx context.Context, nodeType string) ([]graph.Node, error) {
+	exists, err := e.f.FileExists(e.filePath(nodeType))
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	nodes, err := e.codec.ReadNodesFromFile(ctx, e.filePath(nodeType))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading nodes from file", "filePath", e.filePath(nodeType), "error", err)
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (e *fileEngine) rewriteNodes(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	err := e.codec.RewriteNodesToFile(ctx, e.filePath(nodeType), nodes)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rewriting nodes to file", "filePath", e.filePath(nodeType), "error", err)
+	}
+	return err
+}
+
+func (e *fileEngine) Insert(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	filePath := e.filePath(nodeType)
+	if err := e.codec.CreateFileWithHeader(ctx, filePath); err != nil {
+		slog.ErrorContext(ctx, "Error creating headers for nodes file", "error", err)
+		return err
+	}
+
+	err := e.codec.AppendNodesToFile(ctx, filePath, nodes)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error writing nodes to file", "filePath", filePath, "error", err)
+	}
+	return err
+}
+
+func (e *fileEngine) Put(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	stored, err := e.readNodes(ctx, nodeType)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		i := slices.IndexFunc(stored, func(n graph.Node) bool { return n.ID == node.ID })
+		if i < 0 {
+			stored = append(stored, node)
+		} else {
+			stored[i] = node
+		}
+	}
+	return e.rewriteNodes(ctx, nodeType, stored)
+}
+
+func (e *fileEngine) Get(ctx context.Context, nodeType string, id string) (*graph.Node, error) {
+	nodes, err := e.readNodes(ctx, nodeType)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range nodes {
+		if nodes[i].ID == id {
+			return &nodes[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Scan reads the whole file of the type up front; the row decoders are not incremental.
+func (e *fileEngine) Scan(ctx context.Context, nodeType string) (Iterator, error) {
+	nodes, err := e.readNodes(ctx, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	return NewSliceIterator(nodes), nil
+}
+
+// ScanAfter reads the whole file of the type as Scan does, then sorts the nodes after
+// the ID; rows are stored in the order they were written.
+func (e *fileEngine) ScanAfter(ctx context.Context, nodeType string, after string) (Iterator, error) {
+	nodes, err := e.readNodes(ctx, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	return NewSliceIterator(NodesAfter(nodes, after)), nil
+}
+
+func (e *fileEngine) Delete(ctx context.Context, nodeType string, ids []string) error {
+	stored, err := e.readNodes(ctx, nodeType)
+	if err != nil {
+		return err
+	}
+
+	kept := slices.DeleteFunc(stored, func(n graph.Node) bool { return slices.Contains(ids, n.ID) })
+	return e.rewriteNodes(ctx, nodeType, kept)
+}
+
+func (e *fileEngine) Types(ctx context.Context) ([]string, error) {
+	return e.f.ListFiles(e.nodePath, e.codec.FileExtension())
+}
+
+func (e *fileEngine) HasType(ctx context.Context, nodeType string) (bool, error) {
+	return e.f.FileExists(e.filePath(nodeType))
+}
+
+func (e *fileEngine) Size(ctx context.Context, nodeType string) (int64, error) {
+	size, err := e.f.FileSize(e.filePath(nodeType))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (e *fileEngine) DropType(ctx context.Context, nodeType string) error {
+	return e.f.RemoveFile(e.filePath(nodeType))
+}
+
+func (e *fileEngine) CheckType(ctx context.Context, nodeType string, quarantine bool) (*disk.FileReport, error) {
+	return e.codec.CheckFile(ctx, e.filePath(nodeType), quarantine)
+}
+
+func (e *fileEngine) Close() error {
+	return nil
+}
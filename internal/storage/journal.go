@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// The journal holds the changes of a batch spanning several types while they are
+// applied. It is written whole and synced before the first change is applied, and
+// removed once the last one is, so a batch cut short by a crash or a storage error
+// is applied again from the start; the changes are put rather than inserted then.
+const (
+	journalExtension = ".jnl"
+	journalName      = "batch"
+)
+
+var (
+	ErrInvalidJournal = errors.New("invalid batch journal")
+	// ErrBatchPending tells that a batch was journaled but failed partway; the rest of
+	// it is applied before the next write, so it is committed all the same.
+	ErrBatchPending = errors.New("batch is applied in part, the rest is applied before the next write")
+)
+
+// Batcher is implemented by engines applying a batch of changes as a unit.
+type Batcher interface {
+	// ApplyBatch applies the changes in order. Changes left unapplied by a failure
+	// are applied before the next write, or when the database is opened again; the
+	// error then wraps ErrBatchPending.
+	ApplyBatch(ctx context.Context, changes []Change) error
+}
+
+// ApplyBatch applies the changes through the Batcher of the engine, or one at a time
+// when it has none, as the memory engine, which does not outlive the process.
+func ApplyBatch(ctx context.Context, engine Engine, changes []Change) error {
+	if batcher, ok := engine.(Batcher); ok {
+		return batcher.ApplyBatch(ctx, changes)
+	}
+	for _, c := range changes {
+		if err := ApplyChange(ctx, engine, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JournalFiles returns the accessor of the journal in path, sealing it with the keys
+// when the database is encrypted.
+func JournalFiles(f disk.FileAccessor, path string, keys *disk.Keyring) disk.FileAccessor {
+	if keys == nil {
+		return f
+	}
+	return disk.NewBlockFileAccessor(f, path, journalExtension, disk.BlockFormat{Keys: keys})
+}
+
+// journalEngine applies batches of changes to the engine it wraps through the journal.
+type journalEngine struct {
+	Engine
+	f        disk.FileAccessor
+	filePath string
+
+	lock sync.Mutex
+	// pending holds a batch that failed halfway; it is applied again before any other
+	// write, so no write lands between its changes.
+	pending    []Change
+	hasPending atomic.Bool
+}
+
+// OpenJournal wraps the engine to apply batches through the journal in path, after
+// applying the batch a crash left in the journal.
+func OpenJournal(ctx context.Context, engine Engine, f disk.FileAccessor, path string) (Engine, error) {
+	e := &journalEngine{Engine: engine, f: f, filePath: f.GetFilePath(path, journalName+journalExtension)}
+	changes, err := e.read()
+	if err != nil {
+		return nil, err
+	}
+	if changes != nil {
+		e.pending = changes
+		e.hasPending.Store(true)
+		if err := e.finish(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *journalEngine) read() ([]Change, error) {
+	reader, err := e.f.GetFileReader(e.filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	changes := []Change{}
+	if err := json.NewDecoder(reader).Decode(&changes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJournal, err)
+	}
+	return changes, nil
+}
+
+// write replaces the journal with the changes, durably.
+func (e *journalEngine) write(changes []Change) error {
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	tmpPath := e.filePath + ".tmp"
+	writer, err := e.f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	if err == nil {
+		err = e.f.Sync(tmpPath, writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return e.f.RenameFile(tmpPath, e.filePath)
+}
+
+// finish applies the pending batch again and removes the journal. The lock is held.
+func (e *journalEngine) finish(ctx context.Context) error {
+	for _, c := range e.pending {
+		if err := redoChange(ctx, e.Engine, c); err != nil {
+			return err
+		}
+	}
+	if err := e.f.RemoveFile(e.filePath); err != nil {
+		return err
+	}
+	e.pending = nil
+	e.hasPending.Store(false)
+	return nil
+}
+
+// settle applies a pending batch before a write, so the write lands after it.
+func (e *journalEngine) settle(ctx context.Context) error {
+	if !e.hasPending.Load() {
+		return nil
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.pending == nil {
+		return nil
+	}
+	return e.finish(ctx)
+}
+
+func (e *journalEngine) ApplyBatch(ctx context.Context, changes []Change) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.pending != nil {
+		if err := e.finish(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := e.write(changes); err != nil {
+		return err
+	}
+	err := func() error {
+		for _, c := range changes {
+			if err := ApplyChange(ctx, e.Engine, c); err != nil {
+				return err
+			}
+		}
+		return e.f.RemoveFile(e.filePath)
+	}()
+	if err != nil {
+		e.pending = changes
+		e.hasPending.Store(true)
+		return fmt.Errorf("%w: %w", ErrBatchPending, err)
+	}
+	return nil
+}
+
+func (e *journalEngine) Insert(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	if err := e.settle(ctx); err != nil {
+		return err
+	}
+	return e.Engine.Insert(ctx, nodeType, nodes)
+}
+
+func (e *journalEngine) Put(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	if err := e.settle(ctx); err != nil {
+		return err
+	}
+	return e.Engine.Put(ctx, nodeType, nodes)
+}
+
+func (e *journalEngine) Delete(ctx context.Context, nodeType string, ids []string) error {
+	if err := e.settle(ctx); err != nil {
+		return err
+	}
+	return e.Engine.Delete(ctx, nodeType, ids)
+}
+
+func (e *journalEngine) DropType(ctx context.Context, nodeType string) error {
+	if err := e.settle(ctx); err != nil {
+		return err
+	}
+	return e.Engine.DropType(ctx, nodeType)
+}
+
+func (e *journalEngine) Unwrap() Engine {
+	return e.Engine
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// brokenPutEngine fails every put while broken is set.
+type brokenPutEngine struct {
+	Engine
+	broken bool
+}
+
+func (e *brokenPutEngine) Put(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	if e.broken {
+		return errors.New("put failed")
+	}
+	return e.Engine.Put(ctx, nodeType, nodes)
+}
+
+func failedBatch(t *testing.T, f disk.FileAccessor, dir string) (*brokenPutEngine, Engine) {
+	ctx := context.Background()
+	broken := &brokenPutEngine{Engine: NewMemoryEngine(), broken: true}
+	engine, err := OpenJournal(ctx, broken, f, dir)
+	require.NoError(t, err)
+
+	batch := []Change{
+		{Op: ChangeInsert, Type: "person", Nodes: []graph.Node{{ID: "a", Type: "person", Name: "alice", Version: 1}}},
+		{Op: ChangePut, Type: "pet", Nodes: []graph.Node{{ID: "p", Type: "pet", Name: "rex", Version: 1}}},
+	}
+	require.ErrorIs(t, ApplyBatch(ctx, engine, batch), ErrBatchPending)
+	_, err = os.Stat(filepath.Join(dir, journalName+journalExtension))
+	require.NoError(t, err)
+	return broken, engine
+}
+
+func requireBatchApplied(t *testing.T, engine Engine, dir string) {
+	ctx := context.Background()
+	_, err := engine.Get(ctx, "person", "a")
+	require.NoError(t, err)
+	_, err = engine.Get(ctx, "pet", "p")
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, journalName+journalExtension))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestJournalAppliesFailedBatchAgain(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+
+	// The next write applies the rest of the batch first.
+	dir := t.TempDir()
+	broken, engine := failedBatch(t, f, dir)
+	broken.broken = false
+	require.NoError(t, engine.Insert(ctx, "person", []graph.Node{{ID: "b", Type: "person", Name: "bob", Version: 1}}))
+	requireBatchApplied(t, broken, dir)
+
+	// A crash leaves the journal, which the next open applies in full.
+	dir = t.TempDir()
+	failedBatch(t, f, dir)
+	reopened := NewMemoryEngine()
+	_, err := OpenJournal(ctx, reopened, f, dir)
+	require.NoError(t, err)
+	requireBatchApplied(t, reopened, dir)
+}
+
+func TestSealedJournal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key := sha256.Sum256([]byte("journal"))
+	keys, err := disk.LoadKeyring(base64.StdEncoding.EncodeToString(key[:]), "")
+	require.NoError(t, err)
+	f := JournalFiles(disk.NewFileAccessor(), dir, keys)
+
+	failedBatch(t, f, dir)
+	data, err := os.ReadFile(filepath.Join(dir, journalName+journalExtension))
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "alice")
+
+	reopened := NewMemoryEngine()
+	_, err = OpenJournal(ctx, reopened, f, dir)
+	require.NoError(t, err)
+	requireBatchApplied(t, reopened, dir)
+}
@@ -0,0 +1,627 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// The LSM engine keeps every node under the key "<type>\x00<id>". Writes go to a
+// write ahead log and an in-memory table. A full memtable is frozen and flushed to a
+// level 0 segment in the background while writes continue in a fresh one. Segments
+// are merged down into deeper levels by a leveled compaction; below level 0 the
+// segments of a level never overlap, so a lookup reads at most one segment per level.
+// Deletes are tombstones that are dropped once they reach the deepest level.
+//
+// WAL records and segment records share the same layout: the record length as an
+// uvarint, then a flag byte, the key as a length prefixed string and, unless the
+// record is a tombstone, the binary encoded node.
+const (
+	segmentExtension = ".sst"
+	walExtension     = ".wal"
+	tmpExtension     = ".tmp"
+	levelsFile       = "levels.json"
+
+	defaultMemtableLimit     = 4 << 20
+	defaultTargetSegmentSize = 2 << 20
+	defaultLevelBaseSize     = 10 << 20
+
+	numLevels           = 7
+	levelSizeMultiplier = 10
+	l0CompactionTrigger = 4
+
+	recordPut       byte = 1
+	recordTombstone byte = 2
+
+	keySeparator = "\x00"
+)
+
+var (
+	ErrInvalidSegment = errors.New("invalid lsm segment")
+	ErrEngineClosed   = errors.New("storage engine is closed")
+)
+
+type lsmEntry struct {
+	value   []byte
+	deleted bool
+}
+
+type lsmEngine struct {
+	f    disk.FileAccessor
+	path string
+	// format applies to new segments; compactions recompress and reseal older ones,
+	// and segments left sealed with an older key are resealed once nothing is to merge.
+	format disk.BlockFormat
+
+	// walLock makes writers take turns at the wal, so they reach the memtable in the
+	// order they were logged; lock is only held to update the memtable, so readers do
+	// not wait for the wal to be synced.
+	walLock sync.Mutex
+	lock    sync.RWMutex
+	// flushed is signalled whenever the frozen memtable was flushed or failed to.
+	flushed      *sync.Cond
+	memtable     map[string]lsmEntry
+	memtableSize int
+	// imm is the frozen memtable being flushed, logged in the wal numbered immWal.
+	imm    map[string]lsmEntry
+	immWal int
+	wal    io.WriteCloser
+	walSeq int
+	// levels[0] is ordered from oldest to newest, deeper levels by key.
+	levels [][]*segment
+	// types holds the types that may have live nodes, with a count of their writes.
+	// Types drops the ones found empty that were not written meanwhile.
+	types    map[string]int
+	nextSeq  int
+	flushErr error
+	closed   bool
+
+	memtableLimit     int
+	targetSegmentSize int64
+	levelBaseSize     int64
+
+	work chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenLsmEngine opens the engine stored in path. Logs left behind by an unclean
+// shutdown are replayed and flushed before the engine accepts writes. With keys in
+// the format the logs are sealed as well.
+func OpenLsmEngine(f disk.FileAccessor, path string, format disk.BlockFormat) (Engine, error) {
+	if format.Keys != nil {
+		f = disk.NewBlockFileAccessor(f, path, walExtension, disk.BlockFormat{Keys: format.Keys})
+	}
+	e := &lsmEngine{
+		f:                 f,
+		path:              path,
+		format:            format,
+		memtable:          make(map[string]lsmEntry),
+		types:             make(map[string]int),
+		levels:            make([][]*segment, numLevels),
+		memtableLimit:     defaultMemtableLimit,
+		targetSegmentSize: defaultTargetSegmentSize,
+		levelBaseSize:     defaultLevelBaseSize,
+		work:              make(chan struct{}, 1),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	e.flushed = sync.NewCond(&e.lock)
+
+	if err := e.loadLevels(); err != nil {
+		return nil, err
+	}
+	if err := e.removeLeftovers(); err != nil {
+		return nil, err
+	}
+	if err := e.recover(); err != nil {
+		return nil, err
+	}
+	if err := e.loadTypes(); err != nil {
+		return nil, err
+	}
+
+	e.walSeq = e.allocSeq()
+	wal, err := f.GetFileWriter(e.walPath(e.walSeq), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	e.wal = wal
+
+	go e.run()
+	// Segments sealed with an older key are rewritten in the background.
+	if e.pickCompaction() != nil {
+		e.work <- struct{}{}
+	}
+	return e, nil
+}
+
+func lsmKey(nodeType string, id string) string {
+	return nodeType + keySeparator + id
+}
+
+// lsmRange returns the key range [start, end) holding the nodes of a type.
+func lsmRange(nodeType string) (string, string) {
+	return nodeType + keySeparator, nodeType + "\x01"
+}
+
+func (e *lsmEngine) walPath(seq int) string {
+	return e.f.GetFilePath(e.path, fmt.Sprintf("%06d%s", seq, walExtension))
+}
+
+func (e *lsmEngine) segmentPath(seq int) string {
+	return e.f.GetFilePath(e.path, fmt.Sprintf("%06d%s", seq, segmentExtension))
+}
+
+// allocSeq hands out the number of the next wal or segment file.
+func (e *lsmEngine) allocSeq() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.nextSeq++
+	return e.nextSeq
+}
+
+// recover replays the logs of memtables that were never flushed and flushes them
+// into a single level 0 segment.
+func (e *lsmEngine) recover() error {
+	seqs, err := e.listSeqs(walExtension)
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range seqs {
+		if err := e.replayWal(seq); err != nil {
+			return err
+		}
+		e.nextSeq = max(e.nextSeq, seq)
+	}
+
+	if len(e.memtable) > 0 {
+		slog.Info("Recovered lsm memtable from wal", "logs", len(seqs), "keys", len(e.memtable))
+		e.imm, e.memtable, e.memtableSize = e.memtable, make(map[string]lsmEntry), 0
+		if err := e.flushImmutable(); err != nil {
+			return err
+		}
+	}
+
+	for _, seq := range seqs {
+		if err := e.f.RemoveFile(e.walPath(seq)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTypes finds the stored types by seeking from each type to the next, so only the
+// first key of every type is read.
+func (e *lsmEngine) loadTypes() error {
+	start := ""
+	for {
+		it, err := e.entries(start, "")
+		if err != nil {
+			return err
+		}
+		found := it.next()
+		key := ""
+		if found {
+			key = it.key()
+		}
+		if err := errors.Join(it.err(), it.close()); err != nil || !found {
+			return err
+		}
+		nodeType, _, _ := strings.Cut(key, keySeparator)
+		e.types[nodeType]++
+		_, start = lsmRange(nodeType)
+	}
+}
+
+// replayWal loads the records of a write ahead log into the memtable. A torn record
+// at the end of a log is the tail of a write that never returned, so it is dropped.
+func (e *lsmEngine) replayWal(seq int) error {
+	reader, err := e.f.GetFileReader(e.walPath(seq))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	r := bufio.NewReader(reader)
+	for {
+		key, entry, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			slog.Warn("Dropping torn record at the end of the wal", "wal", seq, "error", err)
+			return nil
+		}
+		e.setLocked(key, entry)
+	}
+}
+
+func (e *lsmEngine) setLocked(key string, entry lsmEntry) {
+	e.memtable[key] = entry
+	e.memtableSize += len(key) + len(entry.value)
+}
+
+// apply logs the entries and adds them to the memtable. A full memtable is frozen
+// and handed to the background flush, waiting for the previous flush if needed.
+func (e *lsmEngine) apply(entries map[string]lsmEntry) error {
+	e.walLock.Lock()
+	defer e.walLock.Unlock()
+
+	e.lock.RLock()
+	closed, flushErr := e.closed, e.flushErr
+	e.lock.RUnlock()
+	if closed {
+		return ErrEngineClosed
+	}
+	if flushErr != nil {
+		return flushErr
+	}
+
+	var buf []byte
+	for key, entry := range entries {
+		buf = appendRecord(buf, key, entry)
+	}
+	if _, err := e.wal.Write(buf); err != nil {
+		return err
+	}
+	// A sealed wal holds back its last block until it is flushed.
+	if err := disk.FlushWriter(e.wal); err != nil {
+		return err
+	}
+	if err := e.f.Sync(e.walPath(e.walSeq), e.wal); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for key, entry := range entries {
+		e.setLocked(key, entry)
+		if !entry.deleted {
+			nodeType, _, _ := strings.Cut(key, keySeparator)
+			e.types[nodeType]++
+		}
+	}
+
+	if e.memtableSize < e.memtableLimit {
+		return nil
+	}
+	for e.imm != nil && e.flushErr == nil {
+		e.flushed.Wait()
+	}
+	if e.flushErr != nil {
+		// The entries are logged and will be replayed by the next open.
+		return e.flushErr
+	}
+	return e.freezeLocked()
+}
+
+// freezeLocked hands the memtable to the background flush and starts a new log. Both
+// locks are held.
+func (e *lsmEngine) freezeLocked() error {
+	if err := e.wal.Close(); err != nil {
+		return err
+	}
+
+	e.nextSeq++
+	wal, err := e.f.GetFileWriter(e.walPath(e.nextSeq), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	e.imm, e.immWal = e.memtable, e.walSeq
+	e.memtable, e.memtableSize = make(map[string]lsmEntry), 0
+	e.wal, e.walSeq = wal, e.nextSeq
+
+	select {
+	case e.work <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (e *lsmEngine) Insert(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	return e.Put(ctx, nodeType, nodes)
+}
+
+func (e *lsmEngine) Put(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	entries := make(map[string]lsmEntry, len(nodes))
+	for i := range nodes {
+		entries[lsmKey(nodeType, nodes[i].ID)] = lsmEntry{value: disk.EncodeNode(&nodes[i])}
+	}
+	return e.apply(entries)
+}
+
+// Get checks the memtables, then every level 0 segment from newest to oldest and
+// at most one segment of every deeper level. Segments are skipped by key range and
+// bloom filter first, and a segment that may hold the key costs one block read.
+func (e *lsmEngine) Get(ctx context.Context, nodeType string, id string) (*graph.Node, error) {
+	entry, found, err := e.get(lsmKey(nodeType, id))
+	if err != nil {
+		return nil, err
+	}
+	if !found || entry.deleted {
+		return nil, ErrNotFound
+	}
+
+	node, err := disk.DecodeNode(entry.value)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (e *lsmEngine) get(key string) (lsmEntry, bool, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if entry, found := e.memtable[key]; found {
+		return entry, true, nil
+	}
+	if entry, found := e.imm[key]; found {
+		return entry, true, nil
+	}
+
+	for i := len(e.levels[0]) - 1; i >= 0; i-- {
+		entry, found, err := e.levels[0][i].get(e.f, key)
+		if err != nil || found {
+			return entry, found, err
+		}
+	}
+
+	for _, level := range e.levels[1:] {
+		i := sort.Search(len(level), func(i int) bool { return level[i].maxKey >= key })
+		if i == len(level) {
+			continue
+		}
+		entry, found, err := level[i].get(e.f, key)
+		if err != nil || found {
+			return entry, found, err
+		}
+	}
+	return lsmEntry{}, false, nil
+}
+
+func (e *lsmEngine) Scan(ctx context.Context, nodeType string) (Iterator, error) {
+	entries, err := e.entries(lsmRange(nodeType))
+	if err != nil {
+		return nil, err
+	}
+	return &lsmIterator{entries: entries}, nil
+}
+
+// ScanAfter starts the merge at the first key after the ID, so reading a page only
+// reads the blocks holding it.
+func (e *lsmEngine) ScanAfter(ctx context.Context, nodeType string, after string) (Iterator, error) {
+	start, end := lsmRange(nodeType)
+	if after != "" {
+		start = lsmKey(nodeType, after) + keySeparator
+	}
+	entries, err := e.entries(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &lsmIterator{entries: entries}, nil
+}
+
+// entries merges the entries of every memtable and segment in [start, end), tombstones included.
+func (e *lsmEngine) entries(start string, end string) (entryIterator, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	sources := []entryIterator{
+		newMemIterator(e.memtable, start, end),
+		newMemIterator(e.imm, start, end),
+	}
+
+	var segments []*segment
+	for i := len(e.levels[0]) - 1; i >= 0; i-- {
+		segments = append(segments, e.levels[0][i])
+	}
+	for _, level := range e.levels[1:] {
+		segments = append(segments, level...)
+	}
+
+	for _, s := range segments {
+		if !s.overlaps(start, end) {
+			continue
+		}
+		it, err := newSegmentIterator(e.f, s, start, end)
+		if err != nil {
+			newMergeIterator(sources).close()
+			return nil, err
+		}
+		sources = append(sources, it)
+	}
+	return newMergeIterator(sources), nil
+}
+
+func (e *lsmEngine) Delete(ctx context.Context, nodeType string, ids []string) error {
+	entries := make(map[string]lsmEntry, len(ids))
+	for _, id := range ids {
+		entries[lsmKey(nodeType, id)] = lsmEntry{deleted: true}
+	}
+	return e.apply(entries)
+}
+
+// Types lists the types with at least one live node. Only the types written since
+// the open or found then are looked at, each with a seek to its first live node.
+func (e *lsmEngine) Types(ctx context.Context) ([]string, error) {
+	e.lock.RLock()
+	writes := maps.Clone(e.types)
+	e.lock.RUnlock()
+
+	var nodeTypes []string
+	for _, nodeType := range slices.Sorted(maps.Keys(writes)) {
+		exists, err := e.HasType(ctx, nodeType)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			nodeTypes = append(nodeTypes, nodeType)
+			continue
+		}
+		e.lock.Lock()
+		if e.types[nodeType] == writes[nodeType] {
+			delete(e.types, nodeType)
+		}
+		e.lock.Unlock()
+	}
+	return nodeTypes, nil
+}
+
+func (e *lsmEngine) HasType(ctx context.Context, nodeType string) (bool, error) {
+	it, err := e.Scan(ctx, nodeType)
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+
+	return it.Next(), it.Err()
+}
+
+// Size is the size of the encoded live nodes of the type. Overwritten values
+// still held by older segments are not counted.
+func (e *lsmEngine) Size(ctx context.Context, nodeType string) (int64, error) {
+	it, err := e.entries(lsmRange(nodeType))
+	if err != nil {
+		return 0, err
+	}
+	defer it.close()
+
+	var size int64
+	for it.next() {
+		size += int64(len(it.entry().value))
+	}
+	return size, it.err()
+}
+
+func (e *lsmEngine) DropType(ctx context.Context, nodeType string) error {
+	it, err := e.entries(lsmRange(nodeType))
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]lsmEntry)
+	for it.next() {
+		if !it.entry().deleted {
+			entries[it.key()] = lsmEntry{deleted: true}
+		}
+	}
+	if err := errors.Join(it.err(), it.close()); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return e.apply(entries)
+}
+
+// Close stops the background work and flushes the memtables, so the next open
+// does not have to replay any log.
+func (e *lsmEngine) Close() error {
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.lock.Unlock()
+
+	close(e.stop)
+	<-e.done
+
+	err := e.flushImmutable()
+
+	// Writers that passed the closed check finish before the wal is closed.
+	e.walLock.Lock()
+	e.lock.Lock()
+	walErr := e.wal.Close()
+	if err == nil && len(e.memtable) > 0 {
+		e.imm, e.immWal = e.memtable, e.walSeq
+		e.memtable, e.memtableSize = make(map[string]lsmEntry), 0
+	}
+	e.lock.Unlock()
+	e.walLock.Unlock()
+
+	if err == nil {
+		err = e.flushImmutable()
+	}
+	if err == nil {
+		err = e.f.RemoveFile(e.walPath(e.walSeq))
+	}
+	return errors.Join(err, walErr)
+}
+
+func appendRecord(buf []byte, key string, entry lsmEntry) []byte {
+	var record []byte
+	if entry.deleted {
+		record = append(record, recordTombstone)
+	} else {
+		record = append(record, recordPut)
+	}
+	record = binary.AppendUvarint(record, uint64(len(key)))
+	record = append(record, key...)
+	record = append(record, entry.value...)
+
+	buf = binary.AppendUvarint(buf, uint64(len(record)))
+	return append(buf, record...)
+}
+
+func readRecord(r *bufio.Reader) (string, lsmEntry, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", lsmEntry{}, err
+	}
+	if size < 2 || size > maxRecordSize {
+		return "", lsmEntry{}, fmt.Errorf("bad record length %d", size)
+	}
+
+	record := make([]byte, size)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return "", lsmEntry{}, err
+	}
+
+	flag := record[0]
+	keySize, n := binary.Uvarint(record[1:])
+	if n <= 0 || keySize > uint64(len(record)-1-n) {
+		return "", lsmEntry{}, errors.New("bad key length")
+	}
+	start := 1 + n
+	key := string(record[start : start+int(keySize)])
+	value := record[start+int(keySize):]
+
+	switch flag {
+	case recordPut:
+		return key, lsmEntry{value: value}, nil
+	case recordTombstone:
+		return key, lsmEntry{deleted: true}, nil
+	}
+	return "", lsmEntry{}, fmt.Errorf("bad record flag %d", flag)
+}
+
+// syncWriter flushes the writer to stable storage when it is backed by a file.
+func syncWriter(w io.Writer) error {
+	if s, ok := w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// maxRecordSize guards against allocating huge buffers for a corrupt length prefix.
+const maxRecordSize = 64 << 20
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// levelsState is the content of the levels file, which lists the live segments of
+// every level. Segment files it does not list are leftovers of an interrupted flush
+// or compaction.
+type levelsState struct {
+	NextSeq int     `json:"nextSeq"`
+	Levels  [][]int `json:"levels"`
+}
+
+// compaction merges segments of one level with the overlapping segments of the next,
+// or rewrites a segment in its own level to reseal it.
+type compaction struct {
+	level    int
+	inputs   []*segment
+	overlaps []*segment
+	// bottom is set when no deeper level holds data, so tombstones can be dropped.
+	bottom bool
+	reseal bool
+}
+
+// run flushes frozen memtables and compacts levels in the background.
+func (e *lsmEngine) run() {
+	defer close(e.done)
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-e.work:
+		}
+
+		if err := e.flushImmutable(); err != nil {
+			slog.Error("Error flushing lsm memtable", "error", err)
+			e.lock.Lock()
+			e.flushErr = err
+			e.flushed.Broadcast()
+			e.lock.Unlock()
+			return
+		}
+
+		for c := e.pickCompaction(); c != nil; c = e.pickCompaction() {
+			if err := e.compact(c); err != nil {
+				slog.Error("Error compacting lsm segments", "level", c.level, "error", err)
+				break
+			}
+			select {
+			case <-e.stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// flushImmutable writes the frozen memtable to a new level 0 segment and drops its log.
+func (e *lsmEngine) flushImmutable() error {
+	e.lock.RLock()
+	imm, immWal := e.imm, e.immWal
+	e.lock.RUnlock()
+	if imm == nil {
+		return nil
+	}
+
+	var s *segment
+	if len(imm) > 0 {
+		seq := e.allocSeq()
+		sw, err := newSegmentWriter(e.f, e.segmentPath(seq), seq, e.format)
+		if err != nil {
+			return err
+		}
+		it := newMemIterator(imm, "", "")
+		for it.next() {
+			sw.add(it.key(), it.entry())
+		}
+		if s, err = sw.finish(); err != nil {
+			sw.abort()
+			return err
+		}
+	}
+
+	e.lock.Lock()
+	if s != nil {
+		e.levels[0] = append(e.levels[0], s)
+	}
+	e.imm = nil
+	err := e.saveLevelsLocked()
+	e.flushed.Broadcast()
+	e.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if s != nil {
+		slog.Debug("Flushed lsm memtable", "segment", s.seq, "keys", s.entries)
+	}
+	return e.f.RemoveFile(e.walPath(immWal))
+}
+
+func (e *lsmEngine) maxLevelSize(level int) int64 {
+	size := e.levelBaseSize
+	for i := 1; i < level; i++ {
+		size *= levelSizeMultiplier
+	}
+	return size
+}
+
+// pickCompaction returns the next compaction to run. Level 0 is compacted once it
+// holds enough segments; deeper levels once they outgrow their size, one segment at a
+// time. With nothing to merge, a segment sealed with an older key is resealed, so a
+// key rotation completes without waiting for the data to be compacted.
+func (e *lsmEngine) pickCompaction() *compaction {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	var c *compaction
+	if len(e.levels[0]) >= l0CompactionTrigger {
+		c = &compaction{level: 0, inputs: slices.Clone(e.levels[0])}
+	}
+	for level := 1; c == nil && level < numLevels-1; level++ {
+		var size int64
+		for _, s := range e.levels[level] {
+			size += s.size
+		}
+		if size <= e.maxLevelSize(level) {
+			continue
+		}
+
+		oldest := slices.MinFunc(e.levels[level], func(a, b *segment) int { return a.seq - b.seq })
+		c = &compaction{level: level, inputs: []*segment{oldest}}
+	}
+	if c == nil {
+		for level, segments := range e.levels {
+			for _, s := range segments {
+				if s.stale {
+					return &compaction{level: level, inputs: []*segment{s}, reseal: true}
+				}
+			}
+		}
+		return nil
+	}
+
+	minKey, maxKey := c.inputs[0].minKey, c.inputs[0].maxKey
+	for _, s := range c.inputs[1:] {
+		minKey, maxKey = min(minKey, s.minKey), max(maxKey, s.maxKey)
+	}
+	for _, s := range e.levels[c.level+1] {
+		if s.maxKey >= minKey && s.minKey <= maxKey {
+			c.overlaps = append(c.overlaps, s)
+		}
+	}
+
+	c.bottom = true
+	for _, level := range e.levels[c.level+2:] {
+		if len(level) > 0 {
+			c.bottom = false
+		}
+	}
+	return c
+}
+
+// compact merges the segments of a compaction into new segments of the next level.
+// The segments are immutable, so only the final swap holds the engine lock.
+func (e *lsmEngine) compact(c *compaction) error {
+	// Newer sources come first: level 0 segments from newest to oldest, then the next level.
+	var sources []entryIterator
+	inputs := slices.Clone(c.inputs)
+	slices.Reverse(inputs)
+	for _, s := range append(inputs, c.overlaps...) {
+		it, err := newSegmentIterator(e.f, s, "", "")
+		if err != nil {
+			newMergeIterator(sources).close()
+			return err
+		}
+		sources = append(sources, it)
+	}
+	merged := newMergeIterator(sources)
+	defer merged.close()
+
+	var outputs []*segment
+	var sw *segmentWriter
+	fail := func(err error) error {
+		if sw != nil {
+			sw.abort()
+		}
+		for _, s := range outputs {
+			e.f.RemoveFile(s.path)
+		}
+		return err
+	}
+
+	for merged.next() {
+		if c.bottom && merged.entry().deleted {
+			continue
+		}
+		if sw == nil {
+			seq := e.allocSeq()
+			var err error
+			if sw, err = newSegmentWriter(e.f, e.segmentPath(seq), seq, e.format); err != nil {
+				return fail(err)
+			}
+		}
+		sw.add(merged.key(), merged.entry())
+
+		if sw.size() >= e.targetSegmentSize {
+			s, err := sw.finish()
+			if err != nil {
+				return fail(err)
+			}
+			outputs, sw = append(outputs, s), nil
+		}
+	}
+	if err := merged.err(); err != nil {
+		return fail(err)
+	}
+	if sw != nil {
+		s, err := sw.finish()
+		if err != nil {
+			return fail(err)
+		}
+		outputs, sw = append(outputs, s), nil
+	}
+
+	e.lock.Lock()
+	if c.reseal {
+		// The outputs cover the keys of the input, so they take its place in the level.
+		i := slices.Index(e.levels[c.level], c.inputs[0])
+		e.levels[c.level] = slices.Insert(slices.Delete(e.levels[c.level], i, i+1), i, outputs...)
+	} else {
+		isInput := func(s *segment) bool { return slices.Contains(c.inputs, s) || slices.Contains(c.overlaps, s) }
+		e.levels[c.level] = slices.DeleteFunc(e.levels[c.level], isInput)
+		next := append(slices.DeleteFunc(e.levels[c.level+1], isInput), outputs...)
+		slices.SortFunc(next, func(a, b *segment) int { return strings.Compare(a.minKey, b.minKey) })
+		e.levels[c.level+1] = next
+	}
+	err := e.saveLevelsLocked()
+	e.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Readers holding the engine lock finished before the swap; iterators keep their files open.
+	for _, s := range append(c.inputs, c.overlaps...) {
+		if err := e.f.RemoveFile(s.path); err != nil {
+			return err
+		}
+	}
+	slog.Debug("Compacted lsm segments", "level", c.level, "inputs", len(c.inputs)+len(c.overlaps), "outputs", len(outputs))
+	return nil
+}
+
+func (e *lsmEngine) levelsPath() string {
+	return e.f.GetFilePath(e.path, levelsFile)
+}
+
+func (e *lsmEngine) loadLevels() error {
+	exists, err := e.f.FileExists(e.levelsPath())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		segments, err := e.listSeqs(segmentExtension)
+		if err != nil {
+			return err
+		}
+		if len(segments) > 0 {
+			return fmt.Errorf("%w: segments without %s", ErrInvalidSegment, levelsFile)
+		}
+		return nil
+	}
+
+	reader, err := e.f.GetFileReader(e.levelsPath())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	var state levelsState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	e.nextSeq = state.NextSeq
+	for level, seqs := range state.Levels {
+		if level >= numLevels {
+			return fmt.Errorf("%w: level %d out of range", ErrInvalidSegment, level)
+		}
+		for _, seq := range seqs {
+			s, err := openSegment(e.f, e.segmentPath(seq), seq, e.format)
+			if err != nil {
+				return err
+			}
+			e.levels[level] = append(e.levels[level], s)
+		}
+	}
+	return nil
+}
+
+// saveLevelsLocked replaces the levels file, which makes a flush or compaction visible after a restart.
+func (e *lsmEngine) saveLevelsLocked() error {
+	state := levelsState{NextSeq: e.nextSeq, Levels: make([][]int, numLevels)}
+	for level, segments := range e.levels {
+		state.Levels[level] = []int{}
+		for _, s := range segments {
+			state.Levels[level] = append(state.Levels[level], s.seq)
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := e.levelsPath() + tmpExtension
+	writer, err := e.f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	if err == nil {
+		err = syncWriter(writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return e.f.RenameFile(tmpPath, e.levelsPath())
+}
+
+// removeLeftovers deletes temporary files and segments no level refers to.
+func (e *lsmEngine) removeLeftovers() error {
+	tmpFiles, err := e.f.ListFiles(e.path, tmpExtension)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, name := range tmpFiles {
+		errs = append(errs, e.f.RemoveFile(e.f.GetFilePath(e.path, name+tmpExtension)))
+	}
+
+	seqs, err := e.listSeqs(segmentExtension)
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		live := slices.ContainsFunc(e.levels, func(level []*segment) bool {
+			return slices.ContainsFunc(level, func(s *segment) bool { return s.seq == seq })
+		})
+		if !live {
+			slog.Info("Removing unreferenced lsm segment", "segment", seq)
+			errs = append(errs, e.f.RemoveFile(e.segmentPath(seq)))
+		}
+		e.nextSeq = max(e.nextSeq, seq)
+	}
+	return errors.Join(errs...)
+}
+
+// listSeqs returns the numbers of the files with the extension in ascending order.
+func (e *lsmEngine) listSeqs(ext string) ([]int, error) {
+	names, err := e.f.ListFiles(e.path, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := make([]int, 0, len(names))
+	for _, name := range names {
+		seq, err := strconv.Atoi(name)
+		if err != nil {
+			slog.Warn("Ignoring unknown file in lsm folder", "name", name+ext)
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	return seqs, nil
+}
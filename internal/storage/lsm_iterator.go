@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// entryIterator walks over the entries of one memtable or segment in key order.
+type entryIterator interface {
+	next() bool
+	key() string
+	entry() lsmEntry
+	err() error
+	close() error
+}
+
+type keyedEntry struct {
+	key   string
+	entry lsmEntry
+}
+
+type memIterator struct {
+	entries []keyedEntry
+	pos     int
+}
+
+// newMemIterator snapshots the entries of a memtable in [start, end).
+func newMemIterator(memtable map[string]lsmEntry, start string, end string) *memIterator {
+	var entries []keyedEntry
+	for key, entry := range memtable {
+		if key >= start && (end == "" || key < end) {
+			entries = append(entries, keyedEntry{key, entry})
+		}
+	}
+	slices.SortFunc(entries, func(a, b keyedEntry) int { return strings.Compare(a.key, b.key) })
+	return &memIterator{entries: entries, pos: -1}
+}
+
+func (it *memIterator) next() bool {
+	it.pos++
+	return it.pos < len(it.entries)
+}
+
+func (it *memIterator) key() string     { return it.entries[it.pos].key }
+func (it *memIterator) entry() lsmEntry { return it.entries[it.pos].entry }
+func (it *memIterator) err() error      { return nil }
+func (it *memIterator) close() error    { return nil }
+
+type segmentIterator struct {
+	s         *segment
+	file      io.Closer
+	r         *bufio.Reader
+	nextIndex int
+	block     *bufio.Reader
+	start     string
+	end       string
+
+	k       string
+	e       lsmEntry
+	readErr error
+}
+
+// newSegmentIterator opens the segment right away, so the iterator keeps working
+// even if a compaction removes the file meanwhile.
+func newSegmentIterator(f disk.FileAccessor, s *segment, start string, end string) (*segmentIterator, error) {
+	reader, err := openSeeker(f, s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := max(sort.Search(len(s.index), func(i int) bool { return s.index[i].firstKey > start })-1, 0)
+	offset := s.index[i].offset
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return &segmentIterator{
+		s:         s,
+		file:      reader,
+		r:         bufio.NewReader(io.LimitReader(reader, s.dataEnd-offset)),
+		nextIndex: i,
+		start:     start,
+		end:       end,
+	}, nil
+}
+
+// nextBlock reads and decodes the following block of the segment.
+func (it *segmentIterator) nextBlock() bool {
+	if it.nextIndex >= len(it.s.index) {
+		return false
+	}
+	stored := make([]byte, it.s.index[it.nextIndex].size)
+	if _, err := io.ReadFull(it.r, stored); err != nil {
+		it.readErr = fmt.Errorf("%w: %d: %v", ErrInvalidSegment, it.s.seq, err)
+		return false
+	}
+	raw, err := it.s.decodeBlock(stored)
+	if err != nil {
+		it.readErr = err
+		return false
+	}
+	it.block = bufio.NewReader(bytes.NewReader(raw))
+	it.nextIndex++
+	return true
+}
+
+func (it *segmentIterator) next() bool {
+	for it.readErr == nil {
+		if it.block == nil && !it.nextBlock() {
+			return false
+		}
+		key, entry, err := readRecord(it.block)
+		if err == io.EOF {
+			it.block = nil
+			continue
+		}
+		if err != nil {
+			it.readErr = fmt.Errorf("%w: %d: %v", ErrInvalidSegment, it.s.seq, err)
+			return false
+		}
+		if key < it.start {
+			continue
+		}
+		if it.end != "" && key >= it.end {
+			return false
+		}
+		it.k, it.e = key, entry
+		return true
+	}
+	return false
+}
+
+func (it *segmentIterator) key() string     { return it.k }
+func (it *segmentIterator) entry() lsmEntry { return it.e }
+func (it *segmentIterator) err() error      { return it.readErr }
+func (it *segmentIterator) close() error    { return it.file.Close() }
+
+// mergeIterator merges sources ordered from newest to oldest. Of several entries
+// with the same key only the one of the newest source is returned.
+type mergeIterator struct {
+	sources  []entryIterator
+	heap     mergeHeap
+	k        string
+	e        lsmEntry
+	mergeErr error
+}
+
+func newMergeIterator(sources []entryIterator) *mergeIterator {
+	it := &mergeIterator{sources: sources}
+	for rank := range sources {
+		it.push(rank)
+	}
+	heap.Init(&it.heap)
+	return it
+}
+
+func (it *mergeIterator) push(rank int) {
+	source := it.sources[rank]
+	if source.next() {
+		it.heap = append(it.heap, mergeItem{key: source.key(), rank: rank})
+	} else if err := source.err(); err != nil && it.mergeErr == nil {
+		it.mergeErr = err
+	}
+}
+
+func (it *mergeIterator) next() bool {
+	if it.mergeErr != nil || len(it.heap) == 0 {
+		return false
+	}
+
+	top := heap.Pop(&it.heap).(mergeItem)
+	it.k, it.e = top.key, it.sources[top.rank].entry()
+	it.advance(top.rank)
+
+	// Skip older copies of the same key.
+	for len(it.heap) > 0 && it.heap[0].key == it.k {
+		older := heap.Pop(&it.heap).(mergeItem)
+		it.advance(older.rank)
+	}
+	return it.mergeErr == nil
+}
+
+func (it *mergeIterator) advance(rank int) {
+	source := it.sources[rank]
+	if source.next() {
+		heap.Push(&it.heap, mergeItem{key: source.key(), rank: rank})
+	} else if err := source.err(); err != nil && it.mergeErr == nil {
+		it.mergeErr = err
+	}
+}
+
+func (it *mergeIterator) key() string     { return it.k }
+func (it *mergeIterator) entry() lsmEntry { return it.e }
+func (it *mergeIterator) err() error      { return it.mergeErr }
+
+func (it *mergeIterator) close() error {
+	var err error
+	for _, source := range it.sources {
+		if closeErr := source.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+type mergeItem struct {
+	key  string
+	rank int
+}
+
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].rank < h[j].rank
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(mergeItem)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// lsmIterator turns the live entries of a merge into nodes.
+type lsmIterator struct {
+	entries entryIterator
+	node    graph.Node
+	decErr  error
+}
+
+func (it *lsmIterator) Next() bool {
+	for it.decErr == nil && it.entries.next() {
+		entry := it.entries.entry()
+		if entry.deleted {
+			continue
+		}
+		it.node, it.decErr = disk.DecodeNode(entry.value)
+		return it.decErr == nil
+	}
+	return false
+}
+
+func (it *lsmIterator) Node() graph.Node {
+	return it.node
+}
+
+func (it *lsmIterator) Err() error {
+	if it.decErr != nil {
+		return it.decErr
+	}
+	return it.entries.err()
+}
+
+func (it *lsmIterator) Close() error {
+	return it.entries.close()
+}
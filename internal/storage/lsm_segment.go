@@ -0,0 +1,484 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// A segment file starts with a magic string and a format version byte, followed by
+// data blocks of records sorted by key. After the blocks come the block index, with
+// the first key, offset and size of every block and the last key of the segment,
+// and the bloom filter of its keys. The file ends with a fixed size footer holding
+// the offsets of the index and the bloom filter and the number of records.
+//
+// Since format version 3 every block is stored encoded by disk.EncodeBlock, compressed
+// with the codec of the type of its first key; index sizes are the stored sizes. Since
+// version 4 blocks and the index are encoded by a disk.BlockFormat, sealed when the
+// database is encrypted, as the index holds node keys.
+//
+// The index and the bloom filter are kept in memory while the segment is live, so a
+// point lookup reads at most one block from disk.
+const (
+	segmentMagic         = "JDBS"
+	segmentFormatVersion = 4
+
+	segmentHeaderSize = len(segmentMagic) + 1
+	segmentFooterSize = 24
+
+	blockSize = 4 << 10
+
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+type blockHandle struct {
+	firstKey string
+	offset   int64
+	size     int64
+}
+
+type segment struct {
+	seq     int
+	version byte
+	path    string
+	size    int64
+	entries int64
+	minKey  string
+	maxKey  string
+	index   []blockHandle
+	dataEnd int64
+	bloom   *bloomFilter
+	format  disk.BlockFormat
+	// stale is set when the segment is not sealed with the active key; its blocks
+	// share the key of its index.
+	stale bool
+}
+
+// overlaps reports whether the segment may hold keys in [start, end). An empty end is unbounded.
+func (s *segment) overlaps(start string, end string) bool {
+	return s.maxKey >= start && (end == "" || s.minKey < end)
+}
+
+// mayContain checks the key range and the bloom filter without touching the disk.
+func (s *segment) mayContain(key string) bool {
+	return key >= s.minKey && key <= s.maxKey && s.bloom.mayContain(key)
+}
+
+// get looks a key up, reading the single block that may hold it.
+func (s *segment) get(f disk.FileAccessor, key string) (lsmEntry, bool, error) {
+	if !s.mayContain(key) {
+		return lsmEntry{}, false, nil
+	}
+
+	i := sort.Search(len(s.index), func(i int) bool { return s.index[i].firstKey > key }) - 1
+	if i < 0 {
+		return lsmEntry{}, false, nil
+	}
+
+	block, err := s.readBlock(f, s.index[i])
+	if err != nil {
+		return lsmEntry{}, false, err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(block))
+	for {
+		k, entry, err := readRecord(r)
+		if err == io.EOF || (err == nil && k > key) {
+			return lsmEntry{}, false, nil
+		}
+		if err != nil {
+			return lsmEntry{}, false, fmt.Errorf("%w: %d: %v", ErrInvalidSegment, s.seq, err)
+		}
+		if k == key {
+			return entry, true, nil
+		}
+	}
+}
+
+func (s *segment) readBlock(f disk.FileAccessor, block blockHandle) ([]byte, error) {
+	reader, err := openSeeker(f, s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if _, err := reader.Seek(block.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, block.size)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, fmt.Errorf("%w: %d: %v", ErrInvalidSegment, s.seq, err)
+	}
+	return s.decodeBlock(buf)
+}
+
+func (s *segment) decodeBlock(stored []byte) ([]byte, error) {
+	if s.version < 3 {
+		return stored, nil
+	}
+	raw, err := s.format.Decode(stored)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %d: %v", ErrInvalidSegment, s.seq, err)
+	}
+	return raw, nil
+}
+
+// openSeeker opens a file for random access. The accessor must hand out seekable readers.
+func openSeeker(f disk.FileAccessor, filePath string) (io.ReadSeekCloser, error) {
+	reader, err := f.GetFileReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	seeker, ok := reader.(io.ReadSeekCloser)
+	if !ok {
+		reader.Close()
+		return nil, fmt.Errorf("reader of %s does not support seeking", filePath)
+	}
+	return seeker, nil
+}
+
+// openSegment loads the index and bloom filter of a segment file.
+func openSegment(f disk.FileAccessor, filePath string, seq int, format disk.BlockFormat) (*segment, error) {
+	size, err := f.FileSize(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if size < int64(segmentHeaderSize+segmentFooterSize) {
+		return nil, fmt.Errorf("%w: %d: too short", ErrInvalidSegment, seq)
+	}
+
+	reader, err := openSeeker(f, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	header := make([]byte, segmentHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("%w: %d: %v", ErrInvalidSegment, seq, err)
+	}
+	if string(header[:len(segmentMagic)]) != segmentMagic {
+		return nil, fmt.Errorf("%w: %d: bad magic", ErrInvalidSegment, seq)
+	}
+	version := header[len(segmentMagic)]
+	if version < 2 || version > segmentFormatVersion {
+		return nil, fmt.Errorf("%w: %d: unsupported format version %d", ErrInvalidSegment, seq, version)
+	}
+
+	footerOffset := size - segmentFooterSize
+	if _, err := reader.Seek(footerOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	footer := make([]byte, segmentFooterSize)
+	if _, err := io.ReadFull(reader, footer); err != nil {
+		return nil, fmt.Errorf("%w: %d: %v", ErrInvalidSegment, seq, err)
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(footer[0:8]))
+	bloomOffset := int64(binary.BigEndian.Uint64(footer[8:16]))
+	entries := int64(binary.BigEndian.Uint64(footer[16:24]))
+	if indexOffset < int64(segmentHeaderSize) || bloomOffset < indexOffset || footerOffset < bloomOffset {
+		return nil, fmt.Errorf("%w: %d: bad footer", ErrInvalidSegment, seq)
+	}
+
+	if _, err := reader.Seek(indexOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	meta := make([]byte, footerOffset-indexOffset)
+	if _, err := io.ReadFull(reader, meta); err != nil {
+		return nil, fmt.Errorf("%w: %d: %v", ErrInvalidSegment, seq, err)
+	}
+
+	s := &segment{
+		seq:     seq,
+		version: version,
+		path:    filePath,
+		size:    size,
+		entries: entries,
+		dataEnd: indexOffset,
+		format:  format,
+	}
+	index := meta[:bloomOffset-indexOffset]
+	s.stale = format.Keys != nil
+	if version >= 4 {
+		s.stale = format.Stale(index)
+		if index, err = s.decodeBlock(index); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.decodeIndex(index); err != nil {
+		return nil, fmt.Errorf("%w: %d: %v", ErrInvalidSegment, seq, err)
+	}
+	if s.bloom, err = decodeBloom(meta[bloomOffset-indexOffset:]); err != nil {
+		return nil, fmt.Errorf("%w: %d: %v", ErrInvalidSegment, seq, err)
+	}
+	return s, nil
+}
+
+func (s *segment) decodeIndex(buf []byte) error {
+	r := bytes.NewReader(buf)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if count == 0 || count > uint64(len(buf)) {
+		return fmt.Errorf("bad block count %d", count)
+	}
+
+	s.index = make([]blockHandle, 0, count)
+	for i := uint64(0); i < count; i++ {
+		key, err := readString(r)
+		if err != nil {
+			return err
+		}
+		offset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		s.index = append(s.index, blockHandle{firstKey: key, offset: int64(offset), size: int64(size)})
+	}
+
+	s.minKey = s.index[0].firstKey
+	s.maxKey, err = readString(r)
+	return err
+}
+
+// segmentWriter writes the records of a new segment in key order.
+type segmentWriter struct {
+	f       disk.FileAccessor
+	format  disk.BlockFormat
+	seq     int
+	path    string
+	tmpPath string
+	file    io.WriteCloser
+	w       *bufio.Writer
+
+	offset  int64
+	block   []byte
+	index   []blockHandle
+	hashes  []uint64
+	lastKey string
+	// err is the first failure to encode a block, returned by finish.
+	err error
+}
+
+func newSegmentWriter(f disk.FileAccessor, filePath string, seq int, format disk.BlockFormat) (*segmentWriter, error) {
+	tmpPath := filePath + ".tmp"
+	file, err := f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	sw := &segmentWriter{
+		f:       f,
+		format:  format,
+		seq:     seq,
+		path:    filePath,
+		tmpPath: tmpPath,
+		file:    file,
+		w:       bufio.NewWriter(file),
+	}
+	sw.write(append([]byte(segmentMagic), segmentFormatVersion))
+	return sw, nil
+}
+
+func (sw *segmentWriter) write(buf []byte) {
+	// Errors are sticky in the bufio writer and surface on Flush.
+	n, _ := sw.w.Write(buf)
+	sw.offset += int64(n)
+}
+
+// size is the number of bytes written so far, the pending block included.
+func (sw *segmentWriter) size() int64 {
+	return sw.offset + int64(len(sw.block))
+}
+
+func (sw *segmentWriter) add(key string, entry lsmEntry) {
+	record := appendRecord(nil, key, entry)
+	if len(sw.block) > 0 && len(sw.block)+len(record) > blockSize {
+		sw.flushBlock()
+	}
+	if len(sw.block) == 0 {
+		sw.index = append(sw.index, blockHandle{firstKey: key, offset: sw.offset})
+	}
+
+	sw.block = append(sw.block, record...)
+	sw.hashes = append(sw.hashes, bloomHash(key))
+	sw.lastKey = key
+}
+
+func (sw *segmentWriter) flushBlock() {
+	handle := &sw.index[len(sw.index)-1]
+	nodeType, _, _ := strings.Cut(handle.firstKey, keySeparator)
+	stored, err := sw.format.Encode(nodeType, sw.block)
+	if err != nil && sw.err == nil {
+		sw.err = err
+	}
+	handle.size = int64(len(stored))
+	sw.write(stored)
+	sw.block = sw.block[:0]
+}
+
+func (sw *segmentWriter) empty() bool {
+	return len(sw.hashes) == 0
+}
+
+// finish writes the index, bloom filter and footer and moves the segment into place.
+func (sw *segmentWriter) finish() (*segment, error) {
+	if len(sw.block) > 0 {
+		sw.flushBlock()
+	}
+
+	indexOffset := sw.offset
+	index := binary.AppendUvarint(nil, uint64(len(sw.index)))
+	for _, block := range sw.index {
+		index = appendString(index, block.firstKey)
+		index = binary.AppendUvarint(index, uint64(block.offset))
+		index = binary.AppendUvarint(index, uint64(block.size))
+	}
+	index = appendString(index, sw.lastKey)
+	stored, err := sw.format.Encode("", index)
+	if err != nil {
+		return nil, err
+	}
+	if sw.err != nil {
+		return nil, sw.err
+	}
+	sw.write(stored)
+
+	bloomOffset := sw.offset
+	bloom := newBloomFilter(sw.hashes)
+	sw.write(bloom.encode())
+
+	footer := make([]byte, segmentFooterSize)
+	binary.BigEndian.PutUint64(footer[0:8], uint64(indexOffset))
+	binary.BigEndian.PutUint64(footer[8:16], uint64(bloomOffset))
+	binary.BigEndian.PutUint64(footer[16:24], uint64(len(sw.hashes)))
+	sw.write(footer)
+
+	err = sw.w.Flush()
+	if err == nil {
+		err = syncWriter(sw.file)
+	}
+	if closeErr := sw.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := sw.f.RenameFile(sw.tmpPath, sw.path); err != nil {
+		return nil, err
+	}
+
+	return &segment{
+		seq:     sw.seq,
+		version: segmentFormatVersion,
+		path:    sw.path,
+		size:    sw.offset,
+		entries: int64(len(sw.hashes)),
+		minKey:  sw.index[0].firstKey,
+		maxKey:  sw.lastKey,
+		index:   sw.index,
+		dataEnd: indexOffset,
+		bloom:   bloom,
+		format:  sw.format,
+	}, nil
+}
+
+// abort drops a segment that is not going to be finished.
+func (sw *segmentWriter) abort() error {
+	sw.file.Close()
+	return sw.f.RemoveFile(sw.tmpPath)
+}
+
+// bloomFilter uses double hashing of a single 64 bit hash to derive its probes.
+type bloomFilter struct {
+	bits   []byte
+	hashes uint32
+}
+
+func bloomHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func newBloomFilter(keyHashes []uint64) *bloomFilter {
+	nbits := max(len(keyHashes)*bloomBitsPerKey, 64)
+	b := &bloomFilter{
+		bits:   make([]byte, (nbits+7)/8),
+		hashes: bloomHashes,
+	}
+	for _, h := range keyHashes {
+		b.add(h)
+	}
+	return b
+}
+
+func (b *bloomFilter) probes(h uint64, fn func(bit uint64) bool) bool {
+	nbits := uint64(len(b.bits)) * 8
+	delta := h>>33 | h<<31
+	for i := uint32(0); i < b.hashes; i++ {
+		if !fn(h % nbits) {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+func (b *bloomFilter) add(h uint64) {
+	b.probes(h, func(bit uint64) bool {
+		b.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+func (b *bloomFilter) mayContain(key string) bool {
+	return b.probes(bloomHash(key), func(bit uint64) bool {
+		return b.bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+func (b *bloomFilter) encode() []byte {
+	buf := binary.AppendUvarint(nil, uint64(b.hashes))
+	return append(buf, b.bits...)
+}
+
+func decodeBloom(buf []byte) (*bloomFilter, error) {
+	hashes, n := binary.Uvarint(buf)
+	if n <= 0 || hashes == 0 || hashes > 32 || len(buf) == n {
+		return nil, fmt.Errorf("bad bloom filter")
+	}
+	return &bloomFilter{bits: buf[n:], hashes: uint32(hashes)}, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, size)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
@@ -0,0 +1,394 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+func openSmallLsmEngine(t *testing.T, path string) *lsmEngine {
+	return openSmallLsmEngineWithFormat(t, path, disk.BlockFormat{})
+}
+
+func openSmallLsmEngineWithFormat(t *testing.T, path string, format disk.BlockFormat) *lsmEngine {
+	e, err := OpenLsmEngine(disk.NewFileAccessor(), path, format)
+	require.NoError(t, err)
+
+	lsm := e.(*lsmEngine)
+	lsm.memtableLimit = 16 << 10
+	lsm.targetSegmentSize = 8 << 10
+	lsm.levelBaseSize = 64 << 10
+	return lsm
+}
+
+// waitForCompaction waits until the background work is done with everything it was handed.
+func waitForCompaction(t *testing.T, e *lsmEngine) {
+	require.Eventually(t, func() bool {
+		e.lock.RLock()
+		pending := e.imm != nil
+		e.lock.RUnlock()
+		return !pending && e.pickCompaction() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func personNodes(from int, to int) []graph.Node {
+	nodes := make([]graph.Node, 0, to-from)
+	for i := from; i < to; i++ {
+		nodes = append(nodes, graph.Node{ID: fmt.Sprintf("%05d", i), Type: "person", Name: fmt.Sprintf("person %d", i), Version: 1})
+	}
+	return nodes
+}
+
+func TestLsmEngineCompactsIntoLevels(t *testing.T) {
+	ctx := context.Background()
+	e := openSmallLsmEngine(t, t.TempDir())
+	defer e.Close()
+
+	const count = 5000
+	for i := 0; i < count; i += 50 {
+		require.NoError(t, e.Insert(ctx, "person", personNodes(i, i+50)))
+	}
+	for i := 0; i < count; i += 2 {
+		require.NoError(t, e.Delete(ctx, "person", []string{fmt.Sprintf("%05d", i)}))
+	}
+	waitForCompaction(t, e)
+
+	e.lock.RLock()
+	require.Less(t, len(e.levels[0]), l0CompactionTrigger)
+	deeper := 0
+	for level := 1; level < numLevels; level++ {
+		deeper += len(e.levels[level])
+		for i := 1; i < len(e.levels[level]); i++ {
+			require.Less(t, e.levels[level][i-1].maxKey, e.levels[level][i].minKey, "level %d overlaps", level)
+		}
+	}
+	e.lock.RUnlock()
+	require.Positive(t, deeper)
+
+	it, err := e.Scan(ctx, "person")
+	require.NoError(t, err)
+	nodes, err := ReadAll(it)
+	require.NoError(t, err)
+	require.Len(t, nodes, count/2)
+
+	_, err = e.Get(ctx, "person", "00042")
+	require.ErrorIs(t, err, ErrNotFound)
+	node, err := e.Get(ctx, "person", "04243")
+	require.NoError(t, err)
+	require.Equal(t, "person 4243", node.Name)
+}
+
+func TestLsmEngineScansAfterAnID(t *testing.T) {
+	ctx := context.Background()
+	e := openSmallLsmEngine(t, t.TempDir())
+	defer e.Close()
+
+	// Spread over segments of several levels and the memtable.
+	const count = 3000
+	for i := count - 50; i >= 0; i -= 50 {
+		require.NoError(t, e.Insert(ctx, "person", personNodes(i, i+50)))
+	}
+	waitForCompaction(t, e)
+	require.NoError(t, e.Delete(ctx, "person", []string{"01001"}))
+
+	it, err := e.ScanAfter(ctx, "person", "01000")
+	require.NoError(t, err)
+	defer it.Close()
+	for _, want := range []string{"01002", "01003", "01004"} {
+		require.True(t, it.Next())
+		require.Equal(t, want, it.Node().ID)
+	}
+	require.NoError(t, it.Err())
+}
+
+func TestLsmEngineRecoversWal(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	e := openSmallLsmEngine(t, path)
+
+	require.NoError(t, e.Insert(ctx, "person", personNodes(0, 2000)))
+	require.NoError(t, e.Delete(ctx, "person", []string{"00042"}))
+	require.NoError(t, e.Insert(ctx, "pet", []graph.Node{{ID: "c", Type: "pet", Name: "cat", Version: 1}}))
+
+	// Stop the engine without flushing, as if the process died.
+	close(e.stop)
+	<-e.done
+	require.NoError(t, e.wal.Close())
+
+	reopened, err := OpenLsmEngine(disk.NewFileAccessor(), path, disk.BlockFormat{})
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	it, err := reopened.Scan(ctx, "person")
+	require.NoError(t, err)
+	nodes, err := ReadAll(it)
+	require.NoError(t, err)
+	require.Len(t, nodes, 1999)
+
+	_, err = reopened.Get(ctx, "person", "00042")
+	require.ErrorIs(t, err, ErrNotFound)
+	node, err := reopened.Get(ctx, "pet", "c")
+	require.NoError(t, err)
+	require.Equal(t, "cat", node.Name)
+}
+
+func TestLsmEngineReopen(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	e := openSmallLsmEngine(t, path)
+
+	// Enough writes to flush and compact segments along the way.
+	const count = 20000
+	for i := 0; i < count; i += 100 {
+		nodes := make([]graph.Node, 0, 100)
+		for j := i; j < i+100; j++ {
+			nodes = append(nodes, graph.Node{ID: fmt.Sprintf("%05d", j), Type: "person", Name: fmt.Sprintf("person %d with a long name to fill the memtable", j), Version: 1})
+		}
+		require.NoError(t, e.Insert(ctx, "person", nodes))
+	}
+	require.NoError(t, e.Delete(ctx, "person", []string{"00042"}))
+
+	// Drop the engine without closing it, as if the process died.
+	close(e.stop)
+	<-e.done
+	e.lock.RLock()
+	segments := 0
+	for _, level := range e.levels {
+		segments += len(level)
+	}
+	e.lock.RUnlock()
+	require.Positive(t, segments)
+	require.NoError(t, e.wal.Close())
+
+	reopened, err := OpenLsmEngine(disk.NewFileAccessor(), path, disk.BlockFormat{})
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	it, err := reopened.Scan(ctx, "person")
+	require.NoError(t, err)
+	nodes, err := ReadAll(it)
+	require.NoError(t, err)
+	require.Len(t, nodes, count-1)
+
+	_, err = reopened.Get(ctx, "person", "00042")
+	require.ErrorIs(t, err, ErrNotFound)
+	node, err := reopened.Get(ctx, "person", "12345")
+	require.NoError(t, err)
+	require.Equal(t, "person 12345 with a long name to fill the memtable", node.Name)
+}
+
+func TestLsmEngineTypes(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	e := openSmallLsmEngine(t, path)
+
+	require.NoError(t, e.Insert(ctx, "person", personNodes(0, 2000)))
+	require.NoError(t, e.Insert(ctx, "pet", []graph.Node{{ID: "p", Type: "pet", Name: "rex", Version: 1}}))
+	require.NoError(t, e.Insert(ctx, "robot", []graph.Node{{ID: "r", Type: "robot", Name: "hal", Version: 1}}))
+	require.NoError(t, e.Delete(ctx, "pet", []string{"p"}))
+	require.NoError(t, e.DropType(ctx, "robot"))
+	waitForCompaction(t, e)
+
+	nodeTypes, err := e.Types(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"person"}, nodeTypes)
+	e.lock.RLock()
+	require.Len(t, e.types, 1)
+	e.lock.RUnlock()
+
+	// The types are found again from the stored keys.
+	require.NoError(t, e.Insert(ctx, "pet", []graph.Node{{ID: "q", Type: "pet", Name: "tom", Version: 1}}))
+	require.NoError(t, e.Close())
+	e = openSmallLsmEngine(t, path)
+	defer e.Close()
+	nodeTypes, err = e.Types(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"person", "pet"}, nodeTypes)
+}
+
+// blockingSync holds the syncs of the wal until release is closed, once syncing is set.
+type blockingSync struct {
+	disk.FileAccessor
+	syncing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSync) Sync(filePath string, writer io.Writer) error {
+	if b.syncing != nil && strings.HasSuffix(filePath, walExtension) {
+		b.syncing <- struct{}{}
+		<-b.release
+	}
+	return b.FileAccessor.Sync(filePath, writer)
+}
+
+func TestLsmEngineReadsWhileWalSyncs(t *testing.T) {
+	ctx := context.Background()
+	f := &blockingSync{FileAccessor: disk.NewFileAccessor()}
+	e, err := OpenLsmEngine(f, t.TempDir(), disk.BlockFormat{})
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Insert(ctx, "person", personNodes(0, 1)))
+
+	f.syncing, f.release = make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() { done <- e.Insert(ctx, "person", personNodes(1, 2)) }()
+	<-f.syncing
+
+	// Reads go on while the write waits for its sync, and do not see it yet.
+	node, err := e.Get(ctx, "person", "00000")
+	require.NoError(t, err)
+	require.Equal(t, "person 0", node.Name)
+	_, err = e.Get(ctx, "person", "00001")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	close(f.release)
+	require.NoError(t, <-done)
+	_, err = e.Get(ctx, "person", "00001")
+	require.NoError(t, err)
+}
+
+func TestLsmEngineRecompressesOnCompaction(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	e := openSmallLsmEngine(t, path)
+	require.NoError(t, e.Insert(ctx, "person", personNodes(0, 1000)))
+	require.NoError(t, e.Close())
+
+	e = openSmallLsmEngine(t, path)
+	defer e.Close()
+	e.format = disk.BlockFormat{Compression: disk.Compression{Default: disk.CompressionZstd}}
+	for i := 1000; i < 3000; i += 50 {
+		require.NoError(t, e.Insert(ctx, "person", personNodes(i, i+50)))
+	}
+	waitForCompaction(t, e)
+
+	// Every segment written since compression was enabled has compressed blocks,
+	// and the merged segments no longer hold uncompressed ones.
+	e.lock.RLock()
+	deeper := 0
+	for level := 1; level < numLevels; level++ {
+		for _, s := range e.levels[level] {
+			deeper++
+			block, err := s.readBlock(e.f, s.index[0])
+			require.NoError(t, err)
+			require.NotEmpty(t, block)
+
+			reader, err := openSeeker(e.f, s.path)
+			require.NoError(t, err)
+			_, err = reader.Seek(s.index[0].offset, 0)
+			require.NoError(t, err)
+			codec := make([]byte, 1)
+			_, err = reader.Read(codec)
+			require.NoError(t, err)
+			reader.Close()
+			require.Equal(t, byte(disk.CodecZstd), codec[0])
+		}
+	}
+	e.lock.RUnlock()
+	require.Positive(t, deeper)
+
+	it, err := e.Scan(ctx, "person")
+	require.NoError(t, err)
+	nodes, err := ReadAll(it)
+	require.NoError(t, err)
+	require.Len(t, nodes, 3000)
+}
+
+func TestLsmEngineReencryptsOnCompaction(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	e := openSmallLsmEngineWithFormat(t, path, disk.BlockFormat{Keys: testKeyring(t, "old")})
+	require.NoError(t, e.Insert(ctx, "person", personNodes(0, 1000)))
+	require.NoError(t, e.Close())
+
+	// Rotating puts the new key first and keeps the old one to read older segments.
+	e = openSmallLsmEngineWithFormat(t, path, disk.BlockFormat{Keys: testKeyring(t, "new", "old")})
+	defer e.Close()
+	for i := 1000; i < 3000; i += 50 {
+		require.NoError(t, e.Insert(ctx, "person", personNodes(i, i+50)))
+	}
+	waitForCompaction(t, e)
+
+	// The merged segments are sealed with the new key only.
+	newOnly := disk.BlockFormat{Keys: testKeyring(t, "new")}
+	e.lock.RLock()
+	deeper := 0
+	for level := 1; level < numLevels; level++ {
+		for _, s := range e.levels[level] {
+			deeper++
+			reopened, err := openSegment(e.f, s.path, s.seq, newOnly)
+			require.NoError(t, err)
+			_, err = reopened.readBlock(e.f, reopened.index[0])
+			require.NoError(t, err)
+		}
+	}
+	e.lock.RUnlock()
+	require.Positive(t, deeper)
+
+	it, err := e.Scan(ctx, "person")
+	require.NoError(t, err)
+	nodes, err := ReadAll(it)
+	require.NoError(t, err)
+	require.Len(t, nodes, 3000)
+}
+
+func TestLsmEngineResealsAfterRotation(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	e := openSmallLsmEngineWithFormat(t, path, disk.BlockFormat{Keys: testKeyring(t, "old")})
+	require.NoError(t, e.Insert(ctx, "person", personNodes(0, 1000)))
+	waitForCompaction(t, e)
+	require.NoError(t, e.Close())
+
+	// Without any writes, every segment is resealed with the new key.
+	e = openSmallLsmEngineWithFormat(t, path, disk.BlockFormat{Keys: testKeyring(t, "new", "old")})
+	defer e.Close()
+	waitForCompaction(t, e)
+
+	newOnly := disk.BlockFormat{Keys: testKeyring(t, "new")}
+	e.lock.RLock()
+	segments := 0
+	for _, level := range e.levels {
+		for _, s := range level {
+			segments++
+			require.False(t, s.stale)
+			reopened, err := openSegment(e.f, s.path, s.seq, newOnly)
+			require.NoError(t, err)
+			_, err = reopened.readBlock(e.f, reopened.index[0])
+			require.NoError(t, err)
+		}
+	}
+	e.lock.RUnlock()
+	require.Positive(t, segments)
+
+	it, err := e.Scan(ctx, "person")
+	require.NoError(t, err)
+	nodes, err := ReadAll(it)
+	require.NoError(t, err)
+	require.Len(t, nodes, 1000)
+}
+
+func TestBloomFilter(t *testing.T) {
+	var hashes []uint64
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, bloomHash(fmt.Sprintf("key%d", i)))
+	}
+	bloom, err := decodeBloom(newBloomFilter(hashes).encode())
+	require.NoError(t, err)
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		require.True(t, bloom.mayContain(fmt.Sprintf("key%d", i)))
+		if bloom.mayContain(fmt.Sprintf("other%d", i)) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 50)
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// memoryEngine keeps everything in maps and loses it on Close. It is meant for tests.
+type memoryEngine struct {
+	lock  sync.RWMutex
+	types map[string]map[string]graph.Node
+}
+
+func NewMemoryEngine() Engine {
+	return &memoryEngine{
+		types: make(map[string]map[string]graph.Node),
+	}
+}
+
+func (e *memoryEngine) Insert(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	return e.Put(ctx, nodeType, nodes)
+}
+
+func (e *memoryEngine) Put(ctx context.Context, nodeType string, nodes []graph.Node) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	stored, exists := e.types[nodeType]
+	if !exists {
+		stored = make(map[string]graph.Node)
+		e.types[nodeType] = stored
+	}
+	for _, node := range nodes {
+		stored[node.ID] = cloneNode(node)
+	}
+	return nil
+}
+
+func (e *memoryEngine) Get(ctx context.Context, nodeType string, id string) (*graph.Node, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	node, exists := e.types[nodeType][id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	node = cloneNode(node)
+	return &node, nil
+}
+
+// Scan returns a snapshot of the type sorted by ID.
+func (e *memoryEngine) Scan(ctx context.Context, nodeType string) (Iterator, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	stored := e.types[nodeType]
+	nodes := make([]graph.Node, 0, len(stored))
+	for _, node := range stored {
+		nodes = append(nodes, cloneNode(node))
+	}
+	slices.SortFunc(nodes, func(a, b graph.Node) int { return strings.Compare(a.ID, b.ID) })
+	return NewSliceIterator(nodes), nil
+}
+
+func (e *memoryEngine) ScanAfter(ctx context.Context, nodeType string, after string) (Iterator, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	var nodes []graph.Node
+	for id, node := range e.types[nodeType] {
+		if id > after {
+			nodes = append(nodes, cloneNode(node))
+		}
+	}
+	slices.SortFunc(nodes, func(a, b graph.Node) int { return strings.Compare(a.ID, b.ID) })
+	return NewSliceIterator(nodes), nil
+}
+
+func (e *memoryEngine) Delete(ctx context.Context, nodeType string, ids []string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, id := range ids {
+		delete(e.types[nodeType], id)
+	}
+	return nil
+}
+
+func (e *memoryEngine) Types(ctx context.Context) ([]string, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	nodeTypes := make([]string, 0, len(e.types))
+	for nodeType := range e.types {
+		nodeTypes = append(nodeTypes, nodeType)
+	}
+	return nodeTypes, nil
+}
+
+func (e *memoryEngine) HasType(ctx context.Context, nodeType string) (bool, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	_, exists := e.types[nodeType]
+	return exists, nil
+}
+
+// Size is the size the nodes of the type would take in the binary row format.
+func (e *memoryEngine) Size(ctx context.Context, nodeType string) (int64, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	var size int64
+	for _, node := range e.types[nodeType] {
+		size += int64(len(disk.EncodeNode(&node)))
+	}
+	return size, nil
+}
+
+func (e *memoryEngine) DropType(ctx context.Context, nodeType string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	delete(e.types, nodeType)
+	return nil
+}
+
+func (e *memoryEngine) Close() error {
+	return nil
+}
+
+// cloneNode copies the edges and traits so callers cannot change stored nodes.
+func cloneNode(node graph.Node) graph.Node {
+	node.Edges = slices.Clone(node.Edges)
+	if node.Traits != nil {
+		traits := make(map[string]string, len(node.Traits))
+		for k, v := range node.Traits {
+			traits[k] = v
+		}
+		node.Traits = traits
+	}
+	return node
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+// ManifestFile records the storage format version of the database in its root path.
+// Databases created before it existed have no manifest and format version 0.
+const ManifestFile = "MANIFEST"
+
+var ErrNewerFormat = errors.New("database was written by a newer version of jamesdb")
+
+type Manifest struct {
+	FormatVersion int       `json:"formatVersion"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// Migration upgrades the stored data of a database to its format version. Steps run in
+// order and the manifest is updated after each one, so a step interrupted by a crash
+// runs again on the next start and must be safe to repeat.
+type Migration struct {
+	Version     int
+	Description string
+	run         func(ctx context.Context, m *migrator) error
+}
+
+// migrations are the upgrade steps in order, the last one being the current format.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "rewrite node files written before nodes were versioned or checksummed",
+		run:         upgradeLegacyNodeFiles,
+	},
+}
+
+// FormatVersion is the storage format version written by this binary.
+var FormatVersion = migrations[len(migrations)-1].Version
+
+func (m *Manifest) check() error {
+	if m.FormatVersion > FormatVersion {
+		return fmt.Errorf("%w: format version %d, this binary supports up to %d", ErrNewerFormat, m.FormatVersion, FormatVersion)
+	}
+	return nil
+}
+
+type migrator struct {
+	cfg    *config.Config
+	f      disk.FileAccessor
+	format disk.BlockFormat
+}
+
+// ReadManifest reads the manifest of the database, returning nil when it has none.
+func ReadManifest(f disk.FileAccessor, rootPath string) (*Manifest, error) {
+	filePath := f.GetFilePath(cmp.Or(rootPath, "."), ManifestFile)
+	exists, err := f.FileExists(filePath)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	reader, err := f.GetFileReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(reader).Decode(&m); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", ManifestFile, err)
+	}
+	return &m, nil
+}
+
+func writeManifest(f disk.FileAccessor, rootPath string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filePath := f.GetFilePath(rootPath, ManifestFile)
+	tmpPath := filePath + ".tmp"
+	writer, err := f.GetFileWriter(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync(tmpPath, writer)
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return f.RenameFile(tmpPath, filePath)
+}
+
+// hasData reports whether the root path holds data of any storage engine.
+func hasData(f disk.FileAccessor, rootPath string) (bool, error) {
+	for _, folder := range []string{"nodes", "lsm"} {
+		exists, err := f.FileExists(f.GetFilePath(rootPath, folder))
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// Migrate upgrades the database to the current storage format and returns the steps
+// it ran. With dryRun it only returns the steps that are pending.
+func Migrate(ctx context.Context, cfg *config.Config, f disk.FileAccessor, dryRun bool) ([]Migration, error) {
+	format, err := BlockFormatOf(cfg, f)
+	if err != nil {
+		return nil, err
+	}
+	return migrate(ctx, &migrator{cfg: cfg, f: f, format: format}, dryRun)
+}
+
+func migrate(ctx context.Context, m *migrator, dryRun bool) ([]Migration, error) {
+	rootPath := cmp.Or(m.cfg.Database.RootPath, ".")
+	manifest, err := ReadManifest(m.f, rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	found := manifest != nil
+	if !found {
+		manifest = &Manifest{}
+		// A new database starts at the current format; one created before the
+		// manifest existed is at version 0 and runs every step.
+		existing, err := hasData(m.f, rootPath)
+		if err != nil {
+			return nil, err
+		}
+		if !existing {
+			manifest.FormatVersion = FormatVersion
+		}
+	}
+	if err := manifest.check(); err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, step := range migrations {
+		if step.Version > manifest.FormatVersion {
+			pending = append(pending, step)
+		}
+	}
+	if dryRun {
+		return pending, nil
+	}
+
+	if _, err := m.f.AddFolder(rootPath, ""); err != nil {
+		return nil, err
+	}
+	for i, step := range pending {
+		slog.InfoContext(ctx, "Migrating storage format", "version", step.Version, "description", step.Description)
+		if err := step.run(ctx, m); err != nil {
+			return pending[:i], fmt.Errorf("migrating to format version %d: %w", step.Version, err)
+		}
+		manifest.FormatVersion = step.Version
+		manifest.UpdatedAt = time.Now().UTC()
+		if err := writeManifest(m.f, rootPath, *manifest); err != nil {
+			return pending[:i], err
+		}
+	}
+	if !found && len(pending) == 0 {
+		manifest.UpdatedAt = time.Now().UTC()
+		if err := writeManifest(m.f, rootPath, *manifest); err != nil {
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// CheckFormat refuses a database written in a newer storage format, for tools that
+// read the data without migrating it.
+func CheckFormat(f disk.FileAccessor, rootPath string) error {
+	manifest, err := ReadManifest(f, rootPath)
+	if err != nil || manifest == nil {
+		return err
+	}
+	return manifest.check()
+}
+
+func upgradeLegacyNodeFiles(ctx context.Context, m *migrator) error {
+	if engine := m.cfg.Database.Engine; engine != "" && engine != EngineFile {
+		return nil
+	}
+	codec, files, nodePath, err := openNodeFiles(m.cfg, m.f, m.cfg.Database.Format, m.format)
+	if err != nil {
+		return err
+	}
+	e := &fileEngine{f: files, codec: codec, nodePath: nodePath}
+	return e.upgradeLegacyFiles(ctx)
+}
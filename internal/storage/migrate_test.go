@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+)
+
+func TestMigrateNewDatabase(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+
+	steps, err := Migrate(ctx, cfg, f, false)
+	require.NoError(t, err)
+	require.Empty(t, steps)
+
+	manifest, err := ReadManifest(f, cfg.Database.RootPath)
+	require.NoError(t, err)
+	require.Equal(t, FormatVersion, manifest.FormatVersion)
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	ctx := context.Background()
+	f := disk.NewFileAccessor()
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+
+	nodePath := filepath.Join(cfg.Database.RootPath, "nodes")
+	require.NoError(t, os.MkdirAll(nodePath, os.ModePerm))
+	legacy := graph.LegacyNodeCsvHeader + "a,person,alice,,\n"
+	require.NoError(t, os.WriteFile(filepath.Join(nodePath, "person.csv"), []byte(legacy), 0644))
+
+	// A dry run lists the steps and leaves the data alone.
+	steps, err := Migrate(ctx, cfg, f, true)
+	require.NoError(t, err)
+	require.Len(t, steps, len(migrations))
+	data, err := os.ReadFile(filepath.Join(nodePath, "person.csv"))
+	require.NoError(t, err)
+	require.Equal(t, legacy, string(data))
+	manifest, err := ReadManifest(f, cfg.Database.RootPath)
+	require.NoError(t, err)
+	require.Nil(t, manifest)
+
+	steps, err = Migrate(ctx, cfg, f, false)
+	require.NoError(t, err)
+	require.Len(t, steps, len(migrations))
+	data, err = os.ReadFile(filepath.Join(nodePath, "person.csv"))
+	require.NoError(t, err)
+	require.Equal(t, disk.CsvFileHeader+"a,person,alice,,,1,ec6a256b\n", string(data))
+
+	steps, err = Migrate(ctx, cfg, f, true)
+	require.NoError(t, err)
+	require.Empty(t, steps)
+}
+
+func TestOpenRefusesNewerFormat(t *testing.T) {
+	f := disk.NewFileAccessor()
+	cfg := &config.Config{}
+	cfg.Database.RootPath = t.TempDir()
+	require.NoError(t, writeManifest(f, cfg.Database.RootPath, Manifest{FormatVersion: FormatVersion + 1}))
+
+	_, err := Open(cfg, f)
+	require.ErrorIs(t, err, ErrNewerFormat)
+	_, _, _, err = OpenNodeFiles(cfg, f, disk.FormatCsv)
+	require.ErrorIs(t, err, ErrNewerFormat)
+}
@@ -0,0 +1,226 @@
+// Package jamesdb runs a jamesdb database inside the process that opens it. It needs
+// neither the HTTP server nor its logging setup; log records go to the default slog
+// logger of the process.
+package jamesdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/zmjung/jamesdb/config"
+	"github.com/zmjung/jamesdb/graph"
+	"github.com/zmjung/jamesdb/internal/disk"
+	"github.com/zmjung/jamesdb/internal/grapher"
+	"github.com/zmjung/jamesdb/internal/instance"
+	"github.com/zmjung/jamesdb/internal/uuid"
+)
+
+type (
+	Node       = graph.Node
+	TypeInfo   = grapher.TypeInfo
+	FileReport = disk.FileReport
+	Stats      = grapher.GrapherStats
+)
+
+// AnyVersion skips the version check of updates and deletes.
+const AnyVersion = grapher.AnyVersion
+
+var (
+	ErrNotFound        = grapher.ErrNodeNotFound
+	ErrNodeExists      = grapher.ErrNodeExists
+	ErrVersionMismatch = grapher.ErrVersionMismatch
+	ErrUnknownEdge     = grapher.ErrUnknownEdge
+	ErrNodeReferenced  = grapher.ErrNodeReferenced
+	ErrTypeNotFound    = grapher.ErrTypeNotFound
+	ErrTypeExists      = grapher.ErrTypeExists
+	ErrInvalidType     = grapher.ErrInvalidType
+	ErrInvalidNode     = errors.New("node needs a type and a name")
+	ErrInvalidPath     = errors.New("database path is required")
+)
+
+// Options configure an opened database. The zero value stores nodes as csv files
+// in the file engine, syncing every write, without integrity checks.
+type Options struct {
+	// Engine is file, lsm or memory.
+	Engine string
+	// Format is csv or binary, for the file engine.
+	Format string
+	// Integrity is none, restrict, cascade or setnull.
+	Integrity string
+	// Durability is always, interval or none; SyncInterval applies to interval.
+	Durability   string
+	SyncInterval time.Duration
+	// Compression is none, snappy or zstd; TypeCompression overrides it per node type.
+	Compression     string
+	TypeCompression map[string]string
+	// EncryptionKey holds comma separated base64 AES keys, the first one active.
+	EncryptionKey     string
+	EncryptionKeyFile string
+	// ChangeLog archives every change for point-in-time recovery.
+	ChangeLog bool
+
+	MaxOpenFiles      int
+	MaxWorkers        int
+	WorkerIdleTimeout time.Duration
+	// CacheSize is the number of bytes of nodes kept in memory; 0 disables the cache.
+	CacheSize int64
+}
+
+func (o *Options) config(path string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Database.RootPath = path
+	if o == nil {
+		return cfg
+	}
+	cfg.Database.Engine = o.Engine
+	cfg.Database.Format = o.Format
+	cfg.Database.Integrity = o.Integrity
+	cfg.Database.Durability = o.Durability
+	cfg.Database.SyncInterval = o.SyncInterval
+	cfg.Database.Compression = o.Compression
+	cfg.Database.TypeCompression = o.TypeCompression
+	cfg.Database.EncryptionKey = o.EncryptionKey
+	cfg.Database.EncryptionKeyFile = o.EncryptionKeyFile
+	cfg.Database.ChangeLog = o.ChangeLog
+	cfg.Database.MaxOpenFiles = o.MaxOpenFiles
+	cfg.Database.MaxWorkers = o.MaxWorkers
+	cfg.Database.WorkerIdleTimeout = o.WorkerIdleTimeout
+	cfg.Database.CacheSize = o.CacheSize
+	return cfg
+}
+
+// DB is an open database. It is safe for concurrent use; every database in a
+// process needs its own path.
+type DB struct {
+	inst *instance.Instance
+}
+
+// Open opens the database stored in path, creating it when the path is empty.
+func Open(path string, opts *Options) (*DB, error) {
+	if path == "" {
+		return nil, ErrInvalidPath
+	}
+	return OpenConfig(opts.config(path))
+}
+
+// OpenConfig opens the database of a server configuration.
+func OpenConfig(cfg *config.Config) (*DB, error) {
+	inst, err := instance.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{inst: inst}, nil
+}
+
+// Config returns the configuration the database was opened with.
+func (db *DB) Config() *config.Config {
+	return db.inst.Config
+}
+
+// Get returns a node, or ErrNotFound.
+func (db *DB) Get(ctx context.Context, nodeType string, id string) (*Node, error) {
+	return db.inst.Grapher.ReadNode(ctx, nodeType, id)
+}
+
+// List returns every node of a type.
+func (db *DB) List(ctx context.Context, nodeType string) ([]Node, error) {
+	return db.inst.Grapher.ReadNodesByType(ctx, nodeType)
+}
+
+// Edges returns the stored nodes the edges of a node point at, or ErrNotFound.
+func (db *DB) Edges(ctx context.Context, nodeType string, id string) ([]Node, error) {
+	return db.inst.Grapher.ReadEdges(ctx, nodeType, id)
+}
+
+// Insert stores a new node, generating its ID when it has none, and sets its version.
+func (db *DB) Insert(ctx context.Context, node *Node) error {
+	if err := prepareInsert(node); err != nil {
+		return err
+	}
+	return db.inst.Grapher.WriteNode(ctx, node)
+}
+
+// InsertMany stores new nodes of mixed types and returns an error per node, in order.
+func (db *DB) InsertMany(ctx context.Context, nodes []Node) []error {
+	errs := make([]error, len(nodes))
+	for i := range nodes {
+		if err := prepareInsert(&nodes[i]); err != nil {
+			return fillErrors(errs, err)
+		}
+	}
+	return db.inst.Grapher.WriteNodes(ctx, nodes)
+}
+
+func fillErrors(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func prepareInsert(node *Node) error {
+	if node.Type == "" || node.Name == "" {
+		return ErrInvalidNode
+	}
+	if node.ID != "" {
+		return nil
+	}
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	node.ID = id
+	return nil
+}
+
+// Update replaces a node when it is at the expected version and sets its new version.
+func (db *DB) Update(ctx context.Context, node *Node, expectedVersion int64) error {
+	if node.Type == "" || node.Name == "" {
+		return ErrInvalidNode
+	}
+	return db.inst.Grapher.UpdateNode(ctx, node, expectedVersion)
+}
+
+// Delete removes a node when it is at the expected version.
+func (db *DB) Delete(ctx context.Context, nodeType string, id string, expectedVersion int64) error {
+	return db.inst.Grapher.DeleteNode(ctx, nodeType, id, expectedVersion)
+}
+
+func (db *DB) Types(ctx context.Context) ([]TypeInfo, error) {
+	return db.inst.Grapher.ListTypes(ctx)
+}
+
+func (db *DB) DescribeType(ctx context.Context, nodeType string) (*TypeInfo, error) {
+	return db.inst.Grapher.DescribeType(ctx, nodeType)
+}
+
+func (db *DB) RenameType(ctx context.Context, from string, to string) error {
+	return db.inst.Grapher.RenameType(ctx, from, to)
+}
+
+func (db *DB) DropType(ctx context.Context, nodeType string) error {
+	return db.inst.Grapher.DropType(ctx, nodeType)
+}
+
+// Check verifies the stored records, moving corrupt ones aside with quarantine.
+func (db *DB) Check(ctx context.Context, quarantine bool) ([]FileReport, error) {
+	return db.inst.Grapher.Check(ctx, quarantine)
+}
+
+// Backup writes a snapshot of every node type as a tar.gz archive. Each type is
+// consistent on its own; a replay of the change log lines up writes spanning types.
+func (db *DB) Backup(ctx context.Context, w io.Writer) error {
+	_, err := db.inst.Grapher.Backup(ctx, w)
+	return err
+}
+
+func (db *DB) Stats() Stats {
+	return db.inst.Grapher.Stats()
+}
+
+// Close commits pending writes and closes the files of the database.
+func (db *DB) Close() error {
+	return db.inst.Close()
+}
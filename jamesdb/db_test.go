@@ -0,0 +1,73 @@
+package jamesdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAndReopen(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+
+	db, err := Open(path, &Options{Format: "binary", Integrity: "restrict"})
+	require.NoError(t, err)
+	alice := &Node{Type: "person", Name: "alice"}
+	require.NoError(t, db.Insert(ctx, alice))
+	require.NotEmpty(t, alice.ID)
+	require.Equal(t, int64(1), alice.Version)
+	require.ErrorIs(t, db.Insert(ctx, &Node{Type: "pet", Name: "rex", Edges: []string{"missing"}}), ErrUnknownEdge)
+	require.ErrorIs(t, db.Insert(ctx, &Node{Type: "pet"}), ErrInvalidNode)
+	require.NoError(t, db.Close())
+
+	db, err = Open(path, &Options{Format: "binary"})
+	require.NoError(t, err)
+	defer db.Close()
+	stored, err := db.Get(ctx, "person", alice.ID)
+	require.NoError(t, err)
+	require.Equal(t, alice, stored)
+
+	var archive bytes.Buffer
+	require.NoError(t, db.Backup(ctx, &archive))
+	require.NotZero(t, archive.Len())
+
+	_, err = Open("", nil)
+	require.ErrorIs(t, err, ErrInvalidPath)
+}
+
+func TestTransactions(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	alice := &Node{ID: "a", Type: "person", Name: "alice"}
+	rex := &Node{Type: "pet", Name: "rex", Edges: []string{"a"}}
+	require.NoError(t, db.Transact(ctx, func(tx *Tx) error {
+		require.NoError(t, tx.Insert(alice))
+		return tx.Insert(rex)
+	}))
+	require.Equal(t, int64(1), rex.Version)
+
+	// A failed check rolls back every change of the transaction.
+	tx := db.Begin()
+	require.NoError(t, tx.Update(&Node{ID: "a", Type: "person", Name: "alice smith"}, 1))
+	require.NoError(t, tx.Delete("pet", rex.ID, 2))
+	require.ErrorIs(t, tx.Commit(ctx), ErrVersionMismatch)
+	require.ErrorIs(t, tx.Commit(ctx), ErrTxDone)
+	stored, err := db.Get(ctx, "person", "a")
+	require.NoError(t, err)
+	require.Equal(t, "alice", stored.Name)
+
+	// An error of the function drops the transaction.
+	failed := errors.New("failed")
+	require.ErrorIs(t, db.Transact(ctx, func(tx *Tx) error {
+		require.NoError(t, tx.Delete("person", "a", AnyVersion))
+		return failed
+	}), failed)
+	_, err = db.Get(ctx, "person", "a")
+	require.NoError(t, err)
+}
@@ -0,0 +1,92 @@
+package jamesdb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zmjung/jamesdb/internal/grapher"
+)
+
+var ErrTxDone = errors.New("transaction is already committed or rolled back")
+
+// Tx collects changes that are committed together: either all of them are applied or,
+// when any of them fails its checks, none. Nothing is read or written before Commit,
+// so expected versions guard against changes made since the nodes were read.
+type Tx struct {
+	db    *DB
+	ops   []grapher.TxOp
+	nodes []*Node
+	done  bool
+}
+
+// Begin starts a transaction.
+func (db *DB) Begin() *Tx {
+	return &Tx{db: db}
+}
+
+// Transact runs fn in a transaction and commits it when fn returns no error.
+func (db *DB) Transact(ctx context.Context, fn func(tx *Tx) error) error {
+	tx := db.Begin()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (tx *Tx) add(op string, node *Node, expectedVersion int64) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.ops = append(tx.ops, grapher.TxOp{Op: op, Node: *node, ExpectedVersion: expectedVersion})
+	tx.nodes = append(tx.nodes, node)
+	return nil
+}
+
+// Insert adds a new node, generating its ID when it has none.
+func (tx *Tx) Insert(node *Node) error {
+	if err := prepareInsert(node); err != nil {
+		return err
+	}
+	return tx.add(grapher.TxInsert, node, AnyVersion)
+}
+
+// Update replaces a node that must be at the expected version.
+func (tx *Tx) Update(node *Node, expectedVersion int64) error {
+	if node.Type == "" || node.Name == "" {
+		return ErrInvalidNode
+	}
+	return tx.add(grapher.TxUpdate, node, expectedVersion)
+}
+
+// Delete removes a node that must be at the expected version.
+func (tx *Tx) Delete(nodeType string, id string, expectedVersion int64) error {
+	return tx.add(grapher.TxDelete, &Node{ID: id, Type: nodeType}, expectedVersion)
+}
+
+// Commit applies the changes and sets the new versions of inserted and updated nodes.
+func (tx *Tx) Commit(ctx context.Context) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	results, err := tx.db.inst.Grapher.Commit(ctx, tx.ops)
+	if err != nil {
+		return err
+	}
+	for i, op := range tx.ops {
+		if op.Op != grapher.TxDelete {
+			tx.nodes[i].Version = results[i].Version
+		}
+	}
+	return nil
+}
+
+// Rollback drops the changes. It does nothing after Commit.
+func (tx *Tx) Rollback() {
+	tx.done = true
+}
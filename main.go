@@ -3,16 +3,19 @@ package main
 import (
 	"flag"
 	"log/slog"
+	"net"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zmjung/jamesdb/config"
-	"github.com/zmjung/jamesdb/internal/disk"
-	"github.com/zmjung/jamesdb/internal/grapher"
+	"github.com/zmjung/jamesdb/internal/database"
 	"github.com/zmjung/jamesdb/internal/handler"
+	"github.com/zmjung/jamesdb/internal/idempotency"
+	"github.com/zmjung/jamesdb/internal/instance"
 	"github.com/zmjung/jamesdb/internal/log"
 	"github.com/zmjung/jamesdb/internal/middleware"
 	"github.com/zmjung/jamesdb/internal/router"
+	"github.com/zmjung/jamesdb/internal/rpcserver"
 )
 
 func loadConfig() *config.Config {
@@ -32,21 +35,54 @@ func main() {
 	log.SetDefaultLogger(cfg)
 	slog.Debug("Using config file", "config", cfg)
 
+	if flag.NArg() > 0 {
+		if err := runCommand(cfg, flag.Args()); err != nil {
+			panic("Failed to run command: " + err.Error())
+		}
+		return
+	}
+
 	engine := gin.Default()
 	engine.Use(middleware.GetLogging())
 	engine.Use(middleware.GetRecovery())
 
-	f := disk.NewFileAccessor()
-	csv := disk.NewCsvAccessor(f)
+	db, err := instance.Open(cfg)
+	if err != nil {
+		panic("Failed to open database: " + err.Error())
+	}
+	defer db.Close()
+	store, err := idempotency.NewStore(db.Handles, cfg.Database.RootPath, cfg.Server.IdempotencyWindow, db.Keys)
+	if err != nil {
+		panic("Failed to create idempotency store: " + err.Error())
+	}
 
-	g := grapher.GetInstance(cfg, f, csv)
-	if g == nil {
-		panic("Failed to create grapher")
+	databases, err := database.NewManager(cfg)
+	if err != nil {
+		panic("Failed to open named databases: " + err.Error())
 	}
-	graphHandler := handler.NewGraphHandler(cfg, g)
-	router := router.NewRouter(graphHandler)
+	defer databases.Close()
+
+	graphHandler := handler.NewGraphHandler(cfg, db.Grapher)
+	adminHandler := handler.NewAdminHandler(db.Handles, db.Grapher)
+	databaseHandler := handler.NewDatabaseHandler(databases)
+	router := router.NewRouter(graphHandler, adminHandler, databaseHandler, store)
 	router.SetupRoutes(engine)
 
+	if cfg.Server.GrpcPort > 0 {
+		listener, err := net.Listen("tcp", cfg.Server.Host+":"+strconv.Itoa(cfg.Server.GrpcPort))
+		if err != nil {
+			panic("Failed to listen for gRPC: " + err.Error())
+		}
+		grpcServer := rpcserver.NewServer(db.Grapher)
+		defer grpcServer.Stop()
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				slog.Error("gRPC server stopped", "error", err)
+			}
+		}()
+		slog.Info("Serving gRPC", "port", cfg.Server.GrpcPort)
+	}
+
 	if engine.Run(cfg.Server.Host+":"+strconv.Itoa(cfg.Server.Port)) != nil {
 		panic("Failed to start gin engine")
 	}
@@ -0,0 +1,792 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: jamesdb/v1/graph.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Node is a graph node as stored, with its version.
+type Node struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Id     string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type   string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Name   string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Edges  []string               `protobuf:"bytes,4,rep,name=edges,proto3" json:"edges,omitempty"`
+	Traits map[string]string      `protobuf:"bytes,5,rep,name=traits,proto3" json:"traits,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// version starts at 1 and is incremented by every update of the node.
+	Version       int64 `protobuf:"varint,6,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Node) Reset() {
+	*x = Node{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Node) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Node) ProtoMessage() {}
+
+func (x *Node) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Node.ProtoReflect.Descriptor instead.
+func (*Node) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Node) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Node) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Node) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Node) GetEdges() []string {
+	if x != nil {
+		return x.Edges
+	}
+	return nil
+}
+
+func (x *Node) GetTraits() map[string]string {
+	if x != nil {
+		return x.Traits
+	}
+	return nil
+}
+
+func (x *Node) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+type NodeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NodeRequest) Reset() {
+	*x = NodeRequest{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodeRequest) ProtoMessage() {}
+
+func (x *NodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodeRequest.ProtoReflect.Descriptor instead.
+func (*NodeRequest) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *NodeRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *NodeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type UpdateNodeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Node  *Node                  `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
+	// expected_version fails the update when the stored node is at another version;
+	// 0 skips the check.
+	ExpectedVersion int64 `protobuf:"varint,2,opt,name=expected_version,json=expectedVersion,proto3" json:"expected_version,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *UpdateNodeRequest) Reset() {
+	*x = UpdateNodeRequest{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateNodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateNodeRequest) ProtoMessage() {}
+
+func (x *UpdateNodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateNodeRequest.ProtoReflect.Descriptor instead.
+func (*UpdateNodeRequest) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UpdateNodeRequest) GetNode() *Node {
+	if x != nil {
+		return x.Node
+	}
+	return nil
+}
+
+func (x *UpdateNodeRequest) GetExpectedVersion() int64 {
+	if x != nil {
+		return x.ExpectedVersion
+	}
+	return 0
+}
+
+type DeleteNodeRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Type            string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Id              string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	ExpectedVersion int64                  `protobuf:"varint,3,opt,name=expected_version,json=expectedVersion,proto3" json:"expected_version,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *DeleteNodeRequest) Reset() {
+	*x = DeleteNodeRequest{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteNodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteNodeRequest) ProtoMessage() {}
+
+func (x *DeleteNodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteNodeRequest.ProtoReflect.Descriptor instead.
+func (*DeleteNodeRequest) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DeleteNodeRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *DeleteNodeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DeleteNodeRequest) GetExpectedVersion() int64 {
+	if x != nil {
+		return x.ExpectedVersion
+	}
+	return 0
+}
+
+type DeleteNodeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteNodeResponse) Reset() {
+	*x = DeleteNodeResponse{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteNodeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteNodeResponse) ProtoMessage() {}
+
+func (x *DeleteNodeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteNodeResponse.ProtoReflect.Descriptor instead.
+func (*DeleteNodeResponse) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{4}
+}
+
+// EdgeRequest adds or removes the edge from a node to the node with ID to.
+type EdgeRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Type            string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Id              string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	To              string                 `protobuf:"bytes,3,opt,name=to,proto3" json:"to,omitempty"`
+	ExpectedVersion int64                  `protobuf:"varint,4,opt,name=expected_version,json=expectedVersion,proto3" json:"expected_version,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *EdgeRequest) Reset() {
+	*x = EdgeRequest{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EdgeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EdgeRequest) ProtoMessage() {}
+
+func (x *EdgeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EdgeRequest.ProtoReflect.Descriptor instead.
+func (*EdgeRequest) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *EdgeRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *EdgeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *EdgeRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *EdgeRequest) GetExpectedVersion() int64 {
+	if x != nil {
+		return x.ExpectedVersion
+	}
+	return 0
+}
+
+type NodeList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Nodes         []*Node                `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NodeList) Reset() {
+	*x = NodeList{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NodeList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodeList) ProtoMessage() {}
+
+func (x *NodeList) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodeList.ProtoReflect.Descriptor instead.
+func (*NodeList) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *NodeList) GetNodes() []*Node {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+type ListNodesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListNodesRequest) Reset() {
+	*x = ListNodesRequest{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListNodesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListNodesRequest) ProtoMessage() {}
+
+func (x *ListNodesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListNodesRequest.ProtoReflect.Descriptor instead.
+func (*ListNodesRequest) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListNodesRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+type ImportNodesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Imported      int64                  `protobuf:"varint,1,opt,name=imported,proto3" json:"imported,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImportNodesResponse) Reset() {
+	*x = ImportNodesResponse{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImportNodesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImportNodesResponse) ProtoMessage() {}
+
+func (x *ImportNodesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImportNodesResponse.ProtoReflect.Descriptor instead.
+func (*ImportNodesResponse) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ImportNodesResponse) GetImported() int64 {
+	if x != nil {
+		return x.Imported
+	}
+	return 0
+}
+
+type WatchChangesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// after is the sequence number of the last change already seen; 0 starts from the
+	// first recorded change.
+	After         uint64 `protobuf:"varint,1,opt,name=after,proto3" json:"after,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchChangesRequest) Reset() {
+	*x = WatchChangesRequest{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchChangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchChangesRequest) ProtoMessage() {}
+
+func (x *WatchChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchChangesRequest.ProtoReflect.Descriptor instead.
+func (*WatchChangesRequest) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *WatchChangesRequest) GetAfter() uint64 {
+	if x != nil {
+		return x.After
+	}
+	return 0
+}
+
+// Change is a mutation recorded in the change log of the database.
+type Change struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Seq   uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Time  *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	// op is insert, put, delete or dropType.
+	Op            string   `protobuf:"bytes,3,opt,name=op,proto3" json:"op,omitempty"`
+	Type          string   `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
+	Nodes         []*Node  `protobuf:"bytes,5,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	Ids           []string `protobuf:"bytes,6,rep,name=ids,proto3" json:"ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Change) Reset() {
+	*x = Change{}
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Change) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Change) ProtoMessage() {}
+
+func (x *Change) ProtoReflect() protoreflect.Message {
+	mi := &file_jamesdb_v1_graph_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Change.ProtoReflect.Descriptor instead.
+func (*Change) Descriptor() ([]byte, []int) {
+	return file_jamesdb_v1_graph_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Change) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Change) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Change) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *Change) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Change) GetNodes() []*Node {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *Change) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+var File_jamesdb_v1_graph_proto protoreflect.FileDescriptor
+
+const file_jamesdb_v1_graph_proto_rawDesc = "" +
+	"\n" +
+	"\x16jamesdb/v1/graph.proto\x12\n" +
+	"jamesdb.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xdf\x01\n" +
+	"\x04Node\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\x12\x14\n" +
+	"\x05edges\x18\x04 \x03(\tR\x05edges\x124\n" +
+	"\x06traits\x18\x05 \x03(\v2\x1c.jamesdb.v1.Node.TraitsEntryR\x06traits\x12\x18\n" +
+	"\aversion\x18\x06 \x01(\x03R\aversion\x1a9\n" +
+	"\vTraitsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"1\n" +
+	"\vNodeRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\"d\n" +
+	"\x11UpdateNodeRequest\x12$\n" +
+	"\x04node\x18\x01 \x01(\v2\x10.jamesdb.v1.NodeR\x04node\x12)\n" +
+	"\x10expected_version\x18\x02 \x01(\x03R\x0fexpectedVersion\"b\n" +
+	"\x11DeleteNodeRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12)\n" +
+	"\x10expected_version\x18\x03 \x01(\x03R\x0fexpectedVersion\"\x14\n" +
+	"\x12DeleteNodeResponse\"l\n" +
+	"\vEdgeRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x0e\n" +
+	"\x02to\x18\x03 \x01(\tR\x02to\x12)\n" +
+	"\x10expected_version\x18\x04 \x01(\x03R\x0fexpectedVersion\"2\n" +
+	"\bNodeList\x12&\n" +
+	"\x05nodes\x18\x01 \x03(\v2\x10.jamesdb.v1.NodeR\x05nodes\"&\n" +
+	"\x10ListNodesRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\"1\n" +
+	"\x13ImportNodesResponse\x12\x1a\n" +
+	"\bimported\x18\x01 \x01(\x03R\bimported\"+\n" +
+	"\x13WatchChangesRequest\x12\x14\n" +
+	"\x05after\x18\x01 \x01(\x04R\x05after\"\xa8\x01\n" +
+	"\x06Change\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x0e\n" +
+	"\x02op\x18\x03 \x01(\tR\x02op\x12\x12\n" +
+	"\x04type\x18\x04 \x01(\tR\x04type\x12&\n" +
+	"\x05nodes\x18\x05 \x03(\v2\x10.jamesdb.v1.NodeR\x05nodes\x12\x10\n" +
+	"\x03ids\x18\x06 \x03(\tR\x03ids2\xef\x04\n" +
+	"\x05Graph\x124\n" +
+	"\aGetNode\x12\x17.jamesdb.v1.NodeRequest\x1a\x10.jamesdb.v1.Node\x120\n" +
+	"\n" +
+	"CreateNode\x12\x10.jamesdb.v1.Node\x1a\x10.jamesdb.v1.Node\x12=\n" +
+	"\n" +
+	"UpdateNode\x12\x1d.jamesdb.v1.UpdateNodeRequest\x1a\x10.jamesdb.v1.Node\x12K\n" +
+	"\n" +
+	"DeleteNode\x12\x1d.jamesdb.v1.DeleteNodeRequest\x1a\x1e.jamesdb.v1.DeleteNodeResponse\x129\n" +
+	"\bGetEdges\x12\x17.jamesdb.v1.NodeRequest\x1a\x14.jamesdb.v1.NodeList\x124\n" +
+	"\aAddEdge\x12\x17.jamesdb.v1.EdgeRequest\x1a\x10.jamesdb.v1.Node\x127\n" +
+	"\n" +
+	"RemoveEdge\x12\x17.jamesdb.v1.EdgeRequest\x1a\x10.jamesdb.v1.Node\x12=\n" +
+	"\tListNodes\x12\x1c.jamesdb.v1.ListNodesRequest\x1a\x10.jamesdb.v1.Node0\x01\x12B\n" +
+	"\vImportNodes\x12\x10.jamesdb.v1.Node\x1a\x1f.jamesdb.v1.ImportNodesResponse(\x01\x12E\n" +
+	"\fWatchChanges\x12\x1f.jamesdb.v1.WatchChangesRequest\x1a\x12.jamesdb.v1.Change0\x01B\x1fZ\x1dgithub.com/zmjung/jamesdb/rpcb\x06proto3"
+
+var (
+	file_jamesdb_v1_graph_proto_rawDescOnce sync.Once
+	file_jamesdb_v1_graph_proto_rawDescData []byte
+)
+
+func file_jamesdb_v1_graph_proto_rawDescGZIP() []byte {
+	file_jamesdb_v1_graph_proto_rawDescOnce.Do(func() {
+		file_jamesdb_v1_graph_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_jamesdb_v1_graph_proto_rawDesc), len(file_jamesdb_v1_graph_proto_rawDesc)))
+	})
+	return file_jamesdb_v1_graph_proto_rawDescData
+}
+
+var file_jamesdb_v1_graph_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_jamesdb_v1_graph_proto_goTypes = []any{
+	(*Node)(nil),                  // 0: jamesdb.v1.Node
+	(*NodeRequest)(nil),           // 1: jamesdb.v1.NodeRequest
+	(*UpdateNodeRequest)(nil),     // 2: jamesdb.v1.UpdateNodeRequest
+	(*DeleteNodeRequest)(nil),     // 3: jamesdb.v1.DeleteNodeRequest
+	(*DeleteNodeResponse)(nil),    // 4: jamesdb.v1.DeleteNodeResponse
+	(*EdgeRequest)(nil),           // 5: jamesdb.v1.EdgeRequest
+	(*NodeList)(nil),              // 6: jamesdb.v1.NodeList
+	(*ListNodesRequest)(nil),      // 7: jamesdb.v1.ListNodesRequest
+	(*ImportNodesResponse)(nil),   // 8: jamesdb.v1.ImportNodesResponse
+	(*WatchChangesRequest)(nil),   // 9: jamesdb.v1.WatchChangesRequest
+	(*Change)(nil),                // 10: jamesdb.v1.Change
+	nil,                           // 11: jamesdb.v1.Node.TraitsEntry
+	(*timestamppb.Timestamp)(nil), // 12: google.protobuf.Timestamp
+}
+var file_jamesdb_v1_graph_proto_depIdxs = []int32{
+	11, // 0: jamesdb.v1.Node.traits:type_name -> jamesdb.v1.Node.TraitsEntry
+	0,  // 1: jamesdb.v1.UpdateNodeRequest.node:type_name -> jamesdb.v1.Node
+	0,  // 2: jamesdb.v1.NodeList.nodes:type_name -> jamesdb.v1.Node
+	12, // 3: jamesdb.v1.Change.time:type_name -> google.protobuf.Timestamp
+	0,  // 4: jamesdb.v1.Change.nodes:type_name -> jamesdb.v1.Node
+	1,  // 5: jamesdb.v1.Graph.GetNode:input_type -> jamesdb.v1.NodeRequest
+	0,  // 6: jamesdb.v1.Graph.CreateNode:input_type -> jamesdb.v1.Node
+	2,  // 7: jamesdb.v1.Graph.UpdateNode:input_type -> jamesdb.v1.UpdateNodeRequest
+	3,  // 8: jamesdb.v1.Graph.DeleteNode:input_type -> jamesdb.v1.DeleteNodeRequest
+	1,  // 9: jamesdb.v1.Graph.GetEdges:input_type -> jamesdb.v1.NodeRequest
+	5,  // 10: jamesdb.v1.Graph.AddEdge:input_type -> jamesdb.v1.EdgeRequest
+	5,  // 11: jamesdb.v1.Graph.RemoveEdge:input_type -> jamesdb.v1.EdgeRequest
+	7,  // 12: jamesdb.v1.Graph.ListNodes:input_type -> jamesdb.v1.ListNodesRequest
+	0,  // 13: jamesdb.v1.Graph.ImportNodes:input_type -> jamesdb.v1.Node
+	9,  // 14: jamesdb.v1.Graph.WatchChanges:input_type -> jamesdb.v1.WatchChangesRequest
+	0,  // 15: jamesdb.v1.Graph.GetNode:output_type -> jamesdb.v1.Node
+	0,  // 16: jamesdb.v1.Graph.CreateNode:output_type -> jamesdb.v1.Node
+	0,  // 17: jamesdb.v1.Graph.UpdateNode:output_type -> jamesdb.v1.Node
+	4,  // 18: jamesdb.v1.Graph.DeleteNode:output_type -> jamesdb.v1.DeleteNodeResponse
+	6,  // 19: jamesdb.v1.Graph.GetEdges:output_type -> jamesdb.v1.NodeList
+	0,  // 20: jamesdb.v1.Graph.AddEdge:output_type -> jamesdb.v1.Node
+	0,  // 21: jamesdb.v1.Graph.RemoveEdge:output_type -> jamesdb.v1.Node
+	0,  // 22: jamesdb.v1.Graph.ListNodes:output_type -> jamesdb.v1.Node
+	8,  // 23: jamesdb.v1.Graph.ImportNodes:output_type -> jamesdb.v1.ImportNodesResponse
+	10, // 24: jamesdb.v1.Graph.WatchChanges:output_type -> jamesdb.v1.Change
+	15, // [15:25] is the sub-list for method output_type
+	5,  // [5:15] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_jamesdb_v1_graph_proto_init() }
+func file_jamesdb_v1_graph_proto_init() {
+	if File_jamesdb_v1_graph_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_jamesdb_v1_graph_proto_rawDesc), len(file_jamesdb_v1_graph_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_jamesdb_v1_graph_proto_goTypes,
+		DependencyIndexes: file_jamesdb_v1_graph_proto_depIdxs,
+		MessageInfos:      file_jamesdb_v1_graph_proto_msgTypes,
+	}.Build()
+	File_jamesdb_v1_graph_proto = out.File
+	file_jamesdb_v1_graph_proto_goTypes = nil
+	file_jamesdb_v1_graph_proto_depIdxs = nil
+}
@@ -0,0 +1,15 @@
+// Package rpc holds the gRPC API of jamesdb: the messages and the client and server
+// of the graph service, generated from proto/jamesdb/v1/graph.proto by protoc-gen-go
+// and protoc-gen-go-grpc (make proto), and the constants of its fields.
+package rpc
+
+// AnyVersion skips the version check of an update or delete.
+const AnyVersion int64 = 0
+
+// Change kinds reported by the change feed.
+const (
+	ChangeInsert   = "insert"
+	ChangePut      = "put"
+	ChangeDelete   = "delete"
+	ChangeDropType = "dropType"
+)